@@ -0,0 +1,103 @@
+package sidebar
+
+import (
+	"io"
+	"strconv"
+	"time"
+)
+
+// limits on uploaded files
+const (
+	MaxAttachmentSize = 25 << 20
+	MaxImageSize      = 5 << 20
+	MaxImagePixels    = 50000000
+
+	// animated GIFs are decoded a frame at a time, so the frames and the
+	// pixels in all of them together are limited too
+	MaxGIFFrames = 1000
+	MaxGIFPixels = 100000000
+)
+
+// ThumbnailSizes are the sizes, in pixels, that uploaded images are
+// scaled down to. Images for a user, channel, or workspace are cropped
+// to squares first.
+var ThumbnailSizes = []int{32, 64, 128, 512}
+
+// what an upload is for
+const (
+	PurposeFile      = "file"
+	PurposeAvatar    = "avatar"
+	PurposeChannel   = "channel"
+	PurposeWorkspace = "workspace"
+)
+
+// AttachmentTypes are the content types, as sniffed from the file
+// itself, that can be uploaded. Images are limited to the formats
+// that can be decoded so their metadata can be stripped.
+var AttachmentTypes = map[string]bool{
+	"image/png":          true,
+	"image/jpeg":         true,
+	"image/gif":          true,
+	"application/pdf":    true,
+	"application/zip":    true,
+	"application/x-gzip": true,
+	"text/plain":         true,
+}
+
+// Attachment is an uploaded file. Files are attached to a message
+// after they're uploaded, while images for a user, channel, or
+// workspace are used as soon as they're uploaded. Target is the
+// channel an image is for.
+type Attachment struct {
+	ID          string    `json:"id"`
+	UploaderID  string    `json:"uploader_id"`
+	WorkspaceID string    `json:"workspace_id"`
+	MessageID   string    `json:"message_id,omitempty"`
+	Purpose     string    `json:"purpose"`
+	Target      string    `json:"target,omitempty"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	Width       int       `json:"width,omitempty"`
+	Height      int       `json:"height,omitempty"`
+	URL         string    `json:"url"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	Thumbnails []*Thumbnail `json:"thumbnails,omitempty"`
+}
+
+// Thumbnail is a smaller copy of an uploaded image. Size is the entry
+// in ThumbnailSizes it was made for.
+type Thumbnail struct {
+	Size        int    `json:"size"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	ContentType string `json:"content_type"`
+	Bytes       int64  `json:"bytes"`
+	URL         string `json:"url"`
+}
+
+// AttachmentURL is where the attachment can be downloaded.
+func AttachmentURL(id string) string {
+	return "/api/attachments/" + id
+}
+
+// ThumbnailURL is where a thumbnail of the attachment can be downloaded.
+func ThumbnailURL(id string, size int) string {
+	return AttachmentURL(id) + "?size=" + strconv.Itoa(size)
+}
+
+// IdenticonURL is where the generated default image for an id can be
+// downloaded.
+func IdenticonURL(seed string) string {
+	return "/avatars/" + seed
+}
+
+// BlobStore saves the contents of uploaded files. Keys are chosen
+// by the caller and only use letters, numbers, dashes, and slashes.
+// Deleting a key that doesn't exist isn't an error.
+type BlobStore interface {
+	Put(string, io.Reader, int64, string) error
+	Get(string) (io.ReadCloser, error)
+	Delete(string) error
+}
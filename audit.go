@@ -0,0 +1,85 @@
+package sidebar
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Audit log actions. The audit log covers changes to what everyone in a
+// workspace shares: the workspace and its settings, accounts and logins,
+// channels and who is in them, edits and deletions of messages, pins and
+// bookmarks, tokens, and bots. Sending messages, reacting, and changes to
+// a user's own notification preferences, saved items, reminders, read
+// markers, and scheduled messages aren't recorded.
+const (
+	ActionWorkspaceCreate = "workspace.create"
+	ActionWorkspaceJoin   = "workspace.join"
+	ActionWorkspaceUpdate = "workspace.update"
+	ActionUserCreate      = "user.create"
+	ActionUserUpdate      = "user.update"
+	ActionUserPassword    = "user.password"
+	ActionUserDelete      = "user.delete"
+	ActionChannelCreate   = "channel.create"
+	ActionChannelUpdate   = "channel.update"
+	ActionChannelDelete   = "channel.delete"
+	ActionChannelResolve  = "channel.resolve"
+	ActionChannelJoin     = "channel.join"
+	ActionChannelLeave    = "channel.leave"
+	ActionChannelArchive  = "channel.archive"
+	ActionChannelRestore  = "channel.restore"
+	ActionChannelPurge    = "channel.purge"
+	ActionMessageUpdate   = "message.update"
+	ActionMessageDelete   = "message.delete"
+	ActionThreadPromote   = "thread.promote"
+	ActionMessagePin      = "message.pin"
+	ActionMessageUnpin    = "message.unpin"
+	ActionPinReorder      = "pin.reorder"
+	ActionBookmarkCreate  = "bookmark.create"
+	ActionBookmarkDelete  = "bookmark.delete"
+	ActionBookmarkReorder = "bookmark.reorder"
+	ActionSettingsUpdate  = "settings.update"
+	ActionLoginSuccess    = "login.success"
+	ActionLoginFailure    = "login.failure"
+	ActionAccountUnlock   = "account.unlock"
+	ActionSSOUpdate       = "sso.update"
+	ActionSSOLink         = "sso.link"
+	ActionTokenCreate     = "token.create"
+	ActionTokenRevoke     = "token.revoke"
+	ActionBotCreate       = "bot.create"
+	ActionFieldCreate     = "field.create"
+	ActionFieldDelete     = "field.delete"
+)
+
+// AuditEntry records who changed what in a workspace along with
+// snapshots of the target before and after the change.
+type AuditEntry struct {
+	ID          string          `json:"id"`
+	Actor       string          `json:"actor"`
+	Action      string          `json:"action"`
+	Target      string          `json:"target"`
+	WorkspaceID string          `json:"workspace_id"`
+	CreatedAt   time.Time       `json:"created_at"`
+	Before      json.RawMessage `json:"before,omitempty"`
+	After       json.RawMessage `json:"after,omitempty"`
+}
+
+// AuditFilter narrows down the entries read from the audit log. Empty
+// fields aren't used for filtering. Entries are returned newest first,
+// starting after Cursor if it is set.
+type AuditFilter struct {
+	WorkspaceID string
+	Actor       string
+	Action      string
+	Target      string
+	Since       time.Time
+	Until       time.Time
+	Cursor      string
+	Limit       int
+}
+
+// AuditPage is one page of the audit log. NextCursor is empty when
+// there are no more entries.
+type AuditPage struct {
+	Entries    []*AuditEntry `json:"entries"`
+	NextCursor string        `json:"next_cursor"`
+}
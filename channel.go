@@ -1,13 +1,69 @@
 package sidebar
 
+import "time"
+
+// MaxDirectMembers is the most users a direct conversation can have,
+// including the user who started it.
+const MaxDirectMembers = 9
+
+// MaxTopicLength is the most characters a channel's topic or purpose
+// can have.
+const MaxTopicLength = 250
+
+// channel fields that are changed on their own and keep a history
+const (
+	FieldTopic   = "topic"
+	FieldPurpose = "purpose"
+)
+
+// ChannelRetention is how long a deleted channel is kept, archived, before
+// it's purged. Admins can restore the channel until then.
+const ChannelRetention = 30 * 24 * time.Hour
+
 // Channel contains a chat centered around a specific topic.
+// Slug is unique in the workspace and used in links to the channel.
+// Topic and Purpose can only be changed with a ChannelChange.
+// Archived channels are read-only and DeleteAfter is set once an admin
+// deletes one.
+// Direct and private channels are only listed for their members.
+// Unread and UnreadMentions are only set when getting the
+// channels for a user.
 type Channel struct {
 	ID        string `json:"id"`
 	Name      string `json:"name"`
+	Slug      string `json:"slug"`
 	Details   string `json:"details"`
+	Topic     string `json:"topic"`
+	Purpose   string `json:"purpose"`
 	Image     string `json:"display_image"`
 	IsSidebar bool   `json:"is_sidebar"`
 	Parent    string `json:"parent"`
 	Direct    bool   `json:"direct"`
+	Private   bool   `json:"private"`
 	Resolved  bool   `json:"resolved"`
+
+	ArchivedAt  *time.Time `json:"archived_at,omitempty"`
+	DeleteAfter *time.Time `json:"delete_after,omitempty"`
+
+	Unread         int `json:"unread,omitempty"`
+	UnreadMentions int `json:"unread_mentions,omitempty"`
+}
+
+// ReadMarker is how far a user has read in a channel. Messages
+// created after ReadAt are unread.
+type ReadMarker struct {
+	UserID    string    `json:"user_id"`
+	Channel   string    `json:"channel"`
+	MessageID string    `json:"message_id"`
+	ReadAt    time.Time `json:"read_at"`
+}
+
+// ChannelChange records a change to a channel's topic or purpose.
+type ChannelChange struct {
+	ID        string    `json:"id"`
+	Channel   string    `json:"channel"`
+	Field     string    `json:"field"`
+	Value     string    `json:"value"`
+	ChangedBy string    `json:"changed_by"`
+	ChangedAt time.Time `json:"changed_at"`
 }
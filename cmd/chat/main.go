@@ -38,8 +38,15 @@ func main() {
 	}
 	defer db.Close()
 
+	// failed logins are tracked in memory unless multiple
+	// instances need to share them
+	attempts := services.NewMemoryAttempts()
+	if os.Getenv("SHARED_LOCKOUTS") == "true" {
+		attempts = db
+	}
+
 	// setup all services
-	auth, err := services.NewAuthenticater(db)
+	auth, err := services.NewAuthenticater(db, attempts)
 	if err != nil {
 		logrus.Fatal(err)
 	}
@@ -49,42 +56,134 @@ func main() {
 		logrus.Fatal(err)
 	}
 
-	delete, err := services.NewDeleter(db)
+	add, err := services.NewAdder(db)
 	if err != nil {
 		logrus.Fatal(err)
 	}
 
-	add, err := services.NewAdder(db)
+	get, err := services.NewGetter(db)
 	if err != nil {
 		logrus.Fatal(err)
 	}
 
-	get, err := services.NewGetter(db)
+	up, err := services.NewUpdater(db)
 	if err != nil {
 		logrus.Fatal(err)
 	}
 
-	up, err := services.NewUpdater(db)
+	sso, err := services.NewSingleSignOner(db, nil)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	tokens, err := services.NewTokenManager(db)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	audit, err := services.NewAuditor(db)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	notify, err := services.NewNotifier(db)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	search, err := services.NewSearcher(db)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	// uploads are kept on disk unless an S3-compatible store is set up
+	var blobs sidebar.BlobStore
+	if os.Getenv("S3_BUCKET") != "" {
+		blobs, err = services.NewS3Blobs(services.S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+		}, nil)
+	} else {
+		dir := os.Getenv("UPLOAD_DIR")
+		if dir == "" {
+			dir = "uploads"
+		}
+		blobs, err = services.NewLocalBlobs(dir)
+	}
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	files, err := services.NewAttacher(db, blobs)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	delete, err := services.NewDeleter(db, blobs)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	unfurl, err := services.NewUnfurler(db, services.NewSafeClient())
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	pins, err := services.NewPinner(db)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	saved, err := services.NewSaver(db)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	schedule, err := services.NewScheduler(db)
 	if err != nil {
 		logrus.Fatal(err)
 	}
 
 	// if the database is empty, create a default workspace
 	if err := db.Empty(); err != nil {
+		id := uuid.New().String()
 		db.CreateDefaultWorkspace(&sidebar.Workspace{
-			ID:          uuid.New().String(),
+			ID:          id,
 			DisplayName: os.Getenv("DEFAULT_DISPLAYNAME"),
+			DisplayImg:  sidebar.IdenticonURL(id),
 			Token:       os.Getenv("DEFAULT_TOKEN"),
 		})
 	}
 
 	// build the server and inject dependencies
-	server := server.NewServer(auth, create, delete, add, get, up)
+	srv := server.NewServer(auth, create, delete, add, get, up, sso, tokens, audit, notify, search, files, unfurl, pins, saved, schedule)
+
+	// override rate limits, e.g. RATE_LIMITS="POST /api/message=30/m"
+	if os.Getenv("RATE_LIMITS") != "" {
+		budgets, err := server.ParseBudgets(os.Getenv("RATE_LIMITS"))
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		srv.SetRateLimits(budgets)
+	}
+
+	// only trust X-Forwarded-For from these proxies, e.g.
+	// TRUSTED_PROXIES="10.0.0.0/8,192.168.1.5"
+	if os.Getenv("TRUSTED_PROXIES") != "" {
+		proxies, err := server.ParseProxies(os.Getenv("TRUSTED_PROXIES"))
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		srv.SetTrustedProxies(proxies)
+	}
 
 	// serve
 	if os.Getenv("PORT") != "" {
-		http.ListenAndServe(":"+os.Getenv("PORT"), server.Serve())
+		http.ListenAndServe(":"+os.Getenv("PORT"), srv.Serve())
 	} else {
-		http.ListenAndServeTLS(":8080", "localhost.pem", "localhost-key.pem", server.Serve())
+		http.ListenAndServeTLS(":8080", "localhost.pem", "localhost-key.pem", srv.Serve())
 	}
 }
@@ -0,0 +1,7 @@
+package sidebar
+
+import "github.com/pkg/errors"
+
+// ErrConflict is the cause of errors from trying to use a name, or
+// anything else that has to be unique, that is already taken.
+var ErrConflict = errors.New("already taken")
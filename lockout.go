@@ -0,0 +1,33 @@
+package sidebar
+
+import (
+	"fmt"
+	"time"
+)
+
+// AttemptTracker keeps count of failed logins for a key, such as an
+// email address or an IP address. The in-process tracker only works for
+// a single instance, so a database-backed tracker should be used when
+// running more than one.
+//
+// RecordLoginFailure adds a failure at the given time, starting over if
+// the last one is older than the window, and returns the failures and
+// the time of the last one from before it was added. It has to be atomic
+// so concurrent logins each see a different count. RemoveLoginFailure
+// takes one back.
+type AttemptTracker interface {
+	GetLoginFailures(string) (int, time.Time, error)
+	RecordLoginFailure(string, time.Time, time.Duration) (int, time.Time, error)
+	RemoveLoginFailure(string) error
+	ResetLoginFailures(string) error
+}
+
+// LockoutError is returned when there have been too many failed logins
+// and the user needs to wait before trying again.
+type LockoutError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockoutError) Error() string {
+	return fmt.Sprintf("Too many failed logins. Try again in %v", e.RetryAfter)
+}
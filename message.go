@@ -1,21 +1,109 @@
 package sidebar
 
+import "time"
+
+// MaxMessageLength is the most characters a message can have.
+const MaxMessageLength = 4000
+
 // event codes
 const (
 	EventMessage      = 1
 	EventTyping       = 2
 	EventStartSpinOff = 3
+
+	// EventSystem marks messages the system bot posts about changes
+	// to a channel. They never mention anyone.
+	EventSystem = 4
 )
 
 // ChatMessage represents a message sent over
-// the Websocket connection.
+// the Websocket connection. Deleted messages are kept as
+// tombstones with no content, and messages from deleted
+// users have no FromUser. Content is the Markdown source
+// and HTML is the sanitized rendering made from it.
 type ChatMessage struct {
 	ID       string `json:"id"`
 	Event    int64  `json:"event"`
 	Content  string `json:"content"`
+	HTML     string `json:"html"`
 	ToUser   string `json:"to_user"`
 	FromUser string `json:"from_user"`
 	Channel  string `json:"channel"`
+
+	CreatedAt time.Time  `json:"created_at"`
+	EditedAt  *time.Time `json:"edited_at,omitempty"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+
+	// ReplyTo is the root of the thread this message is part of. Only
+	// root messages have Thread set.
+	ReplyTo string  `json:"reply_to,omitempty"`
+	Thread  *Thread `json:"thread,omitempty"`
+
+	Reactions []*ReactionCount `json:"reactions,omitempty"`
+	Mentions  []*Mention       `json:"mentions,omitempty"`
+
+	// Attachments are sent with just their ids to attach
+	// previously uploaded files to a new message.
+	Attachments []*Attachment `json:"attachments,omitempty"`
+
+	// Previews are added after the message is sent, once the
+	// pages it links to have been fetched.
+	Previews []*LinkPreview `json:"previews,omitempty"`
+}
+
+// Thread summarizes the replies to a root message.
+type Thread struct {
+	ReplyCount    int       `json:"reply_count"`
+	LastReplyAt   time.Time `json:"last_reply_at"`
+	LastReplyFrom string    `json:"last_reply_from"`
+}
+
+// MessageRevision is a previous version of an edited message.
+// CreatedAt is when this version was written.
+type MessageRevision struct {
+	MessageID string    `json:"message_id"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// mention kinds
+const (
+	MentionUser    = "user"
+	MentionChannel = "channel"
+	MentionHere    = "here"
+)
+
+// Mention records that a user was mentioned in a message, either
+// by name or with @channel or @here. Message is only set when
+// listing a user's mentions.
+type Mention struct {
+	MessageID string       `json:"message_id"`
+	UserID    string       `json:"user_id"`
+	Kind      string       `json:"kind"`
+	Message   *ChatMessage `json:"message,omitempty"`
+}
+
+// Reaction is a user's emoji response to a message.
+type Reaction struct {
+	MessageID string `json:"message_id"`
+	UserID    string `json:"user_id"`
+	Emoji     string `json:"emoji"`
+}
+
+// ReactionCount is the number of users that reacted to a message
+// with an emoji and whether the current user is one of them.
+type ReactionCount struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+	Me    bool   `json:"me"`
+}
+
+// ReactionUpdate is sent over the Websocket connection when
+// a reaction is added or removed.
+type ReactionUpdate struct {
+	Reaction
+	Channel string `json:"channel"`
+	Added   bool   `json:"added"`
 }
 
 // ChannelUpdate is sent over the Websocket connection
@@ -26,9 +114,12 @@ type ChannelUpdate struct {
 	Message string `json:"message"`
 }
 
-// Alert is used to send news of an update to users.
+// Alert is used to send news of an update to users. Mention
+// alerts target the channel with the sender and, for members of
+// the channel, the message's content.
 type Alert struct {
 	Target  string `json:"target"`
+	From    string `json:"from,omitempty"`
 	Message string `json:"message"`
 }
 
@@ -0,0 +1,149 @@
+package sidebar
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// notification levels for a workspace or channel
+const (
+	NotifyAll      = "all"
+	NotifyMentions = "mentions"
+	NotifyMute     = "mute"
+)
+
+// kinds of alerts sent about a new message, which are also the
+// type of the Websocket message carrying the alert
+const (
+	AlertMessage = "alert"
+	AlertMention = "mention"
+)
+
+// NotificationPrefs controls which alerts a user gets in a workspace.
+// Channels without their own level use Default. DoNotDisturb applies
+// across every workspace the user belongs to.
+type NotificationPrefs struct {
+	UserID       string            `json:"user_id"`
+	WorkspaceID  string            `json:"workspace_id"`
+	Default      string            `json:"default"`
+	Channels     map[string]string `json:"channels"`
+	DoNotDisturb *DoNotDisturb     `json:"do_not_disturb,omitempty"`
+}
+
+// Level returns the user's notification level for the channel.
+func (p *NotificationPrefs) Level(cid string) string {
+	if level, ok := p.Channels[cid]; ok {
+		return level
+	}
+
+	if p.Default != "" {
+		return p.Default
+	}
+
+	return NotifyAll
+}
+
+// DoNotDisturb is a daily window, in the user's time zone, when no
+// alerts are sent. Start and End are "15:04" times and the window
+// wraps past midnight when End is before Start. Days are the days
+// the window starts on, or every day if empty.
+type DoNotDisturb struct {
+	Start    string         `json:"start"`
+	End      string         `json:"end"`
+	Timezone string         `json:"timezone"`
+	Days     []time.Weekday `json:"days,omitempty"`
+}
+
+// Validate returns an error if the schedule can't be used.
+func (d *DoNotDisturb) Validate() error {
+	if _, err := time.Parse("15:04", d.Start); err != nil {
+		return errors.Wrap(err, "Invalid start time")
+	}
+
+	if _, err := time.Parse("15:04", d.End); err != nil {
+		return errors.Wrap(err, "Invalid end time")
+	}
+
+	if _, err := time.LoadLocation(d.Timezone); err != nil {
+		return errors.Wrap(err, "Invalid time zone")
+	}
+
+	for _, day := range d.Days {
+		if day < time.Sunday || day > time.Saturday {
+			return errors.Errorf("Invalid day %v", day)
+		}
+	}
+
+	return nil
+}
+
+// Active checks if the window includes the given time.
+func (d *DoNotDisturb) Active(t time.Time) bool {
+	start, err := time.Parse("15:04", d.Start)
+	if err != nil {
+		return false
+	}
+
+	end, err := time.Parse("15:04", d.End)
+	if err != nil {
+		return false
+	}
+
+	loc, err := time.LoadLocation(d.Timezone)
+	if err != nil {
+		return false
+	}
+
+	t = t.In(loc)
+	now := t.Hour()*60 + t.Minute()
+	from := start.Hour()*60 + start.Minute()
+	to := end.Hour()*60 + end.Minute()
+
+	switch {
+	case from == to:
+		return false
+	case from < to:
+		return now >= from && now < to && d.onDay(t.Weekday())
+	case now >= from:
+		return d.onDay(t.Weekday())
+	case now < to:
+		// the window started the day before
+		return d.onDay((t.Weekday() + 6) % 7)
+	default:
+		return false
+	}
+}
+
+// Until returns when the window that's active at the given time ends.
+func (d *DoNotDisturb) Until(t time.Time) time.Time {
+	end, err := time.Parse("15:04", d.End)
+	if err != nil {
+		return t
+	}
+
+	loc, err := time.LoadLocation(d.Timezone)
+	if err != nil {
+		return t
+	}
+
+	t = t.In(loc)
+	until := time.Date(t.Year(), t.Month(), t.Day(), end.Hour(), end.Minute(), 0, 0, loc)
+	if !until.After(t) {
+		until = time.Date(t.Year(), t.Month(), t.Day()+1, end.Hour(), end.Minute(), 0, 0, loc)
+	}
+	return until
+}
+
+func (d *DoNotDisturb) onDay(day time.Weekday) bool {
+	if len(d.Days) == 0 {
+		return true
+	}
+
+	for _, dd := range d.Days {
+		if dd == day {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package sidebar
+
+import "time"
+
+// MaxPins is the most pins or bookmarks a channel can have.
+const MaxPins = 50
+
+// Pin is a message pinned to its channel. Pins are shown in
+// order of Position, which starts at 0.
+type Pin struct {
+	Channel   string       `json:"channel"`
+	MessageID string       `json:"message_id"`
+	PinnedBy  string       `json:"pinned_by"`
+	Position  int          `json:"position"`
+	CreatedAt time.Time    `json:"created_at"`
+	Message   *ChatMessage `json:"message,omitempty"`
+}
+
+// Bookmark is a named link kept at the top of a channel. It
+// points to either a URL or a message in the channel.
+type Bookmark struct {
+	ID        string    `json:"id"`
+	Channel   string    `json:"channel"`
+	Title     string    `json:"title"`
+	URL       string    `json:"url,omitempty"`
+	MessageID string    `json:"message_id,omitempty"`
+	CreatedBy string    `json:"created_by"`
+	Position  int       `json:"position"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ChannelPins is sent over the Websocket connection when a
+// channel's pins change.
+type ChannelPins struct {
+	Channel string `json:"channel"`
+	Pins    []*Pin `json:"pins"`
+}
+
+// ChannelBookmarks is sent over the Websocket connection when
+// a channel's bookmarks change.
+type ChannelBookmarks struct {
+	Channel   string      `json:"channel"`
+	Bookmarks []*Bookmark `json:"bookmarks"`
+}
@@ -0,0 +1,40 @@
+package sidebar
+
+import "time"
+
+// limits on reminders
+const (
+	MaxReminders     = 100
+	MaxReminderDelay = 365 * 24 * time.Hour
+)
+
+// SystemBotID is the id of the bot that sends messages from Sidebar
+// itself, like reminders for users who aren't online.
+const SystemBotID = "00000000-0000-0000-0000-000000000000"
+
+// SavedItem is a message a user has saved to come back to later.
+type SavedItem struct {
+	UserID    string       `json:"user_id"`
+	MessageID string       `json:"message_id"`
+	CreatedAt time.Time    `json:"created_at"`
+	Message   *ChatMessage `json:"message,omitempty"`
+}
+
+// Reminder is sent to a user at RemindAt. Reminders can be about a
+// message, have a note, or both. In can be sent instead of RemindAt
+// to set the reminder for a duration from now, like "2h".
+type Reminder struct {
+	ID          string       `json:"id"`
+	UserID      string       `json:"user_id"`
+	WorkspaceID string       `json:"workspace_id"`
+	MessageID   string       `json:"message_id,omitempty"`
+	Note        string       `json:"note"`
+	In          string       `json:"in,omitempty"`
+	RemindAt    time.Time    `json:"remind_at"`
+	CreatedAt   time.Time    `json:"created_at"`
+	Message     *ChatMessage `json:"message,omitempty"`
+
+	// Quiet is set on due reminders when the user muted the channel or
+	// workspace, so they're delivered without an alert.
+	Quiet bool `json:"-"`
+}
@@ -0,0 +1,23 @@
+package sidebar
+
+import "time"
+
+// MaxScheduledMessages is the most pending scheduled messages a user
+// can have in a workspace.
+const MaxScheduledMessages = 100
+
+// ScheduledMessage is posted to a channel by the user who scheduled it.
+// One-off messages are posted at SendAt. Recurring messages have a cron
+// rule like "0 9 * * 1-5" that is read in Timezone, and SendAt is the
+// next time they'll be posted.
+type ScheduledMessage struct {
+	ID          string    `json:"id"`
+	Channel     string    `json:"channel"`
+	WorkspaceID string    `json:"workspace_id"`
+	UserID      string    `json:"user_id"`
+	Content     string    `json:"content"`
+	Cron        string    `json:"cron,omitempty"`
+	Timezone    string    `json:"timezone,omitempty"`
+	SendAt      time.Time `json:"send_at"`
+	CreatedAt   time.Time `json:"created_at"`
+}
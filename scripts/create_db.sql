@@ -8,36 +8,122 @@ CREATE TABLE workspaces (
     PRIMARY KEY(id)
 );
 
+-- display_name is the name new workspace profiles start with
 DROP TABLE IF EXISTS users CASCADE;
 CREATE TABLE users (
     id VARCHAR(36) UNIQUE NOT NULL,
-    display_name VARCHAR(255) UNIQUE NOT NULL,
+    display_name VARCHAR(255) NOT NULL,
     email VARCHAR(255) UNIQUE NOT NULL,
     password VARCHAR(255) NOT NULL,
     profile_image TEXT NOT NULL,
     user_role INT NOT NULL DEFAULT 1,
+    is_bot BOOLEAN DEFAULT FALSE,
     PRIMARY KEY(id)
 );
 
+-- slugs are unique in a workspace. Channels use their name and sidebars
+-- and direct channels get a generated one. Deleted channels stay archived
+-- until delete_after, when they're purged.
 DROP TABLE IF EXISTS channels CASCADE;
 CREATE TABLE channels (
     id VARCHAR(36) UNIQUE,
-    display_name VARCHAR(255) UNIQUE NOT NULL,
+    workspace_id VARCHAR(36) NOT NULL,
+    display_name VARCHAR(255) NOT NULL,
+    slug VARCHAR(100) NOT NULL,
     details TEXT,
+    topic TEXT NOT NULL DEFAULT '',
+    purpose TEXT NOT NULL DEFAULT '',
     display_image TEXT NOT NULL,
     is_sidebar BOOLEAN DEFAULT FALSE,
     is_direct BOOLEAN DEFAULT FALSE,
+    is_private BOOLEAN DEFAULT FALSE,
     resolved BOOLEAN DEFAULT FALSE,
-    PRIMARY KEY(id)
+    archived_at TIMESTAMPTZ,
+    delete_after TIMESTAMPTZ,
+    PRIMARY KEY(id),
+    UNIQUE(workspace_id, slug),
+    FOREIGN KEY(workspace_id) REFERENCES workspaces(id) ON DELETE CASCADE
+);
+
+CREATE INDEX channels_delete_after ON channels (delete_after) WHERE delete_after IS NOT NULL;
+
+-- direct channels are keyed by their sorted, comma separated members so
+-- the same conversation is used every time
+DROP TABLE IF EXISTS direct_channels CASCADE;
+CREATE TABLE direct_channels (
+    workspace_id VARCHAR(36) NOT NULL,
+    members TEXT NOT NULL,
+    channel_id VARCHAR(36) NOT NULL,
+    PRIMARY KEY(workspace_id, members),
+    FOREIGN KEY(workspace_id) REFERENCES workspaces(id) ON DELETE CASCADE,
+    FOREIGN KEY(channel_id) REFERENCES channels(id) ON DELETE CASCADE
+);
+
+-- every change made to a channel's topic and purpose
+DROP TABLE IF EXISTS channel_changes CASCADE;
+CREATE TABLE channel_changes (
+    id VARCHAR(36) NOT NULL,
+    channel_id VARCHAR(36) NOT NULL,
+    field VARCHAR(16) NOT NULL,
+    value TEXT NOT NULL,
+    changed_by VARCHAR(36),
+    changed_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY(id),
+    FOREIGN KEY(channel_id) REFERENCES channels(id) ON DELETE CASCADE,
+    FOREIGN KEY(changed_by) REFERENCES users(id) ON DELETE SET NULL
+);
+CREATE INDEX channel_changes_channel ON channel_changes (channel_id, changed_at);
+
+DROP TABLE IF EXISTS workspace_settings CASCADE;
+CREATE TABLE workspace_settings (
+    workspace_id VARCHAR(36) NOT NULL,
+    edit_window INT NOT NULL DEFAULT 900,
+    topic_changes VARCHAR(16) NOT NULL DEFAULT 'members',
+    PRIMARY KEY(workspace_id),
+    FOREIGN KEY(workspace_id) REFERENCES workspaces(id) ON DELETE CASCADE
 );
 
+-- display_name and the rest of the profile are the user's in the workspace
 DROP TABLE IF EXISTS workspaces_users CASCADE;
 CREATE TABLE workspaces_users (
     workspace_id VARCHAR(36) NOT NULL,
     user_id VARCHAR(36) NOT NULL,
+    user_role INT NOT NULL DEFAULT 1,
+    display_name VARCHAR(255) NOT NULL,
+    title VARCHAR(255) NOT NULL DEFAULT '',
+    pronouns VARCHAR(255) NOT NULL DEFAULT '',
+    time_zone VARCHAR(64) NOT NULL DEFAULT '',
+    status_emoji VARCHAR(64) NOT NULL DEFAULT '',
+    status_text VARCHAR(255) NOT NULL DEFAULT '',
+    status_expires_at TIMESTAMPTZ,
+    PRIMARY KEY(workspace_id, user_id),
+    UNIQUE(workspace_id, display_name),
     FOREIGN KEY(workspace_id) REFERENCES workspaces(id) ON DELETE CASCADE,
     FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
 );
+CREATE INDEX workspaces_users_status_expires ON workspaces_users (status_expires_at) WHERE status_expires_at IS NOT NULL;
+
+-- custom profile fields defined by a workspace's admins
+DROP TABLE IF EXISTS profile_fields CASCADE;
+CREATE TABLE profile_fields (
+    id VARCHAR(36) NOT NULL,
+    workspace_id VARCHAR(36) NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY(id),
+    UNIQUE(workspace_id, name),
+    FOREIGN KEY(workspace_id) REFERENCES workspaces(id) ON DELETE CASCADE
+);
+
+DROP TABLE IF EXISTS profile_values CASCADE;
+CREATE TABLE profile_values (
+    field_id VARCHAR(36) NOT NULL,
+    user_id VARCHAR(36) NOT NULL,
+    value TEXT NOT NULL,
+    PRIMARY KEY(field_id, user_id),
+    FOREIGN KEY(field_id) REFERENCES profile_fields(id) ON DELETE CASCADE,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
 
 DROP TABLE IF EXISTS workspaces_channels CASCADE;
 CREATE TABLE workspaces_channels (
@@ -51,9 +137,27 @@ DROP TABLE IF EXISTS messages CASCADE;
 CREATE TABLE messages (
     id VARCHAR(36) UNIQUE NOT NULL,
     content TEXT NOT NULL,
+    html TEXT NOT NULL DEFAULT '',
     event INT NOT NULL,
-    PRIMARY KEY(id)
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    edited_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ,
+    reply_to VARCHAR(36),
+    PRIMARY KEY(id),
+    FOREIGN KEY(reply_to) REFERENCES messages(id) ON DELETE CASCADE
 );
+CREATE INDEX messages_reply_to ON messages (reply_to, created_at);
+-- must match the expression used when searching
+CREATE INDEX messages_search ON messages USING GIN (to_tsvector('english', content));
+
+DROP TABLE IF EXISTS message_revisions CASCADE;
+CREATE TABLE message_revisions (
+    message_id VARCHAR(36) NOT NULL,
+    content TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    FOREIGN KEY(message_id) REFERENCES messages(id) ON DELETE CASCADE
+);
+CREATE INDEX message_revisions_message ON message_revisions (message_id, created_at);
 
 DROP TABLE IF EXISTS sidebars;
 CREATE TABLE sidebars (
@@ -65,7 +169,7 @@ CREATE TABLE sidebars (
 
 DROP TABLE IF EXISTS users_channels CASCADE;
 CREATE TABLE users_channels (
-    user_id VARCHAR(36) REFERENCES users (id) ON UPDATE CASCADE,
+    user_id VARCHAR(36) REFERENCES users (id) ON UPDATE CASCADE ON DELETE CASCADE,
     channel_id VARCHAR(36) REFERENCES channels (id) ON UPDATE CASCADE,
     CONSTRAINT users_channels_pkey PRIMARY KEY (user_id, channel_id)
 );
@@ -85,9 +189,9 @@ CREATE TABLE tokens (
 DROP TABLE IF EXISTS users_messages CASCADE;
 CREATE TABLE users_messages (
     user_to_id VARCHAR(36),
-    user_from_id VARCHAR(36) NOT NULL,
+    user_from_id VARCHAR(36),
     message_id VARCHAR(36) NOT NULL,
-    FOREIGN KEY(user_from_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY(user_from_id) REFERENCES users(id) ON DELETE SET NULL,
     FOREIGN KEY(message_id) REFERENCES messages(id) ON DELETE CASCADE
 );
 
@@ -95,6 +199,321 @@ DROP TABLE IF EXISTS channels_messages CASCADE;
 CREATE TABLE channels_messages (
     channel_id VARCHAR(36) NOT NULL,
     message_id VARCHAR(36) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    FOREIGN KEY(channel_id) REFERENCES channels(id) ON DELETE CASCADE,
+    FOREIGN KEY(message_id) REFERENCES messages(id) ON DELETE CASCADE
+);
+-- copy of the message's created_at so unread messages can be
+-- counted without reading the channel's whole history
+CREATE INDEX channels_messages_created ON channels_messages (channel_id, created_at);
+
+DROP TABLE IF EXISTS read_markers CASCADE;
+CREATE TABLE read_markers (
+    user_id VARCHAR(36) NOT NULL,
+    channel_id VARCHAR(36) NOT NULL,
+    message_id VARCHAR(36) NOT NULL,
+    read_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY(user_id, channel_id),
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY(channel_id) REFERENCES channels(id) ON DELETE CASCADE
+);
+
+DROP TABLE IF EXISTS reactions CASCADE;
+CREATE TABLE reactions (
+    message_id VARCHAR(36) NOT NULL,
+    user_id VARCHAR(36) NOT NULL,
+    emoji VARCHAR(64) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY(message_id, user_id, emoji),
+    FOREIGN KEY(message_id) REFERENCES messages(id) ON DELETE CASCADE,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+DROP TABLE IF EXISTS mentions CASCADE;
+CREATE TABLE mentions (
+    message_id VARCHAR(36) NOT NULL,
+    user_id VARCHAR(36) NOT NULL,
+    kind VARCHAR(16) NOT NULL,
+    read BOOLEAN NOT NULL DEFAULT FALSE,
+    PRIMARY KEY(message_id, user_id),
+    FOREIGN KEY(message_id) REFERENCES messages(id) ON DELETE CASCADE,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX mentions_unread ON mentions (user_id) WHERE NOT read;
+
+DROP TABLE IF EXISTS notification_defaults CASCADE;
+CREATE TABLE notification_defaults (
+    user_id VARCHAR(36) NOT NULL,
+    workspace_id VARCHAR(36) NOT NULL,
+    level VARCHAR(16) NOT NULL,
+    PRIMARY KEY(user_id, workspace_id),
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY(workspace_id) REFERENCES workspaces(id) ON DELETE CASCADE
+);
+
+DROP TABLE IF EXISTS channel_notifications CASCADE;
+CREATE TABLE channel_notifications (
+    user_id VARCHAR(36) NOT NULL,
+    channel_id VARCHAR(36) NOT NULL,
+    level VARCHAR(16) NOT NULL,
+    PRIMARY KEY(user_id, channel_id),
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY(channel_id) REFERENCES channels(id) ON DELETE CASCADE
+);
+
+DROP TABLE IF EXISTS do_not_disturb CASCADE;
+CREATE TABLE do_not_disturb (
+    user_id VARCHAR(36) NOT NULL,
+    start_time VARCHAR(5) NOT NULL,
+    end_time VARCHAR(5) NOT NULL,
+    timezone TEXT NOT NULL,
+    days TEXT NOT NULL,
+    PRIMARY KEY(user_id),
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+DROP TABLE IF EXISTS attachments CASCADE;
+CREATE TABLE attachments (
+    id VARCHAR(36) NOT NULL,
+    uploader_id VARCHAR(36),
+    workspace_id VARCHAR(36) NOT NULL,
+    message_id VARCHAR(36),
+    purpose VARCHAR(16) NOT NULL,
+    target VARCHAR(36),
+    name TEXT NOT NULL,
+    content_type VARCHAR(255) NOT NULL,
+    size BIGINT NOT NULL,
+    width INTEGER NOT NULL DEFAULT 0,
+    height INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY(id),
+    FOREIGN KEY(uploader_id) REFERENCES users(id) ON DELETE SET NULL,
+    FOREIGN KEY(workspace_id) REFERENCES workspaces(id) ON DELETE CASCADE,
+    FOREIGN KEY(message_id) REFERENCES messages(id) ON DELETE CASCADE
+);
+CREATE INDEX attachments_message ON attachments (message_id);
+
+DROP TABLE IF EXISTS attachment_thumbnails CASCADE;
+CREATE TABLE attachment_thumbnails (
+    attachment_id VARCHAR(36) NOT NULL,
+    size INTEGER NOT NULL,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    content_type VARCHAR(255) NOT NULL,
+    bytes BIGINT NOT NULL,
+    PRIMARY KEY(attachment_id, size),
+    FOREIGN KEY(attachment_id) REFERENCES attachments(id) ON DELETE CASCADE
+);
+
+DROP TABLE IF EXISTS pins CASCADE;
+CREATE TABLE pins (
+    message_id VARCHAR(36) NOT NULL,
+    channel_id VARCHAR(36) NOT NULL,
+    pinned_by VARCHAR(36),
+    position INTEGER NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY(message_id),
+    FOREIGN KEY(message_id) REFERENCES messages(id) ON DELETE CASCADE,
+    FOREIGN KEY(channel_id) REFERENCES channels(id) ON DELETE CASCADE,
+    FOREIGN KEY(pinned_by) REFERENCES users(id) ON DELETE SET NULL
+);
+CREATE INDEX pins_channel ON pins (channel_id, position);
+
+DROP TABLE IF EXISTS bookmarks CASCADE;
+CREATE TABLE bookmarks (
+    id VARCHAR(36) NOT NULL,
+    channel_id VARCHAR(36) NOT NULL,
+    title VARCHAR(100) NOT NULL,
+    url TEXT,
+    message_id VARCHAR(36),
+    created_by VARCHAR(36),
+    position INTEGER NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY(id),
     FOREIGN KEY(channel_id) REFERENCES channels(id) ON DELETE CASCADE,
+    FOREIGN KEY(message_id) REFERENCES messages(id) ON DELETE CASCADE,
+    FOREIGN KEY(created_by) REFERENCES users(id) ON DELETE SET NULL
+);
+CREATE INDEX bookmarks_channel ON bookmarks (channel_id, position);
+
+DROP TABLE IF EXISTS saved_messages CASCADE;
+CREATE TABLE saved_messages (
+    user_id VARCHAR(36) NOT NULL,
+    message_id VARCHAR(36) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY(user_id, message_id),
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
     FOREIGN KEY(message_id) REFERENCES messages(id) ON DELETE CASCADE
-);
\ No newline at end of file
+);
+
+-- reminders are kept until they're delivered so they survive restarts.
+-- They're claimed by one server at a time by setting claimed_until, and
+-- are claimed again if they weren't delivered by then
+DROP TABLE IF EXISTS reminders CASCADE;
+CREATE TABLE reminders (
+    id VARCHAR(36) NOT NULL,
+    user_id VARCHAR(36) NOT NULL,
+    workspace_id VARCHAR(36) NOT NULL,
+    message_id VARCHAR(36),
+    note TEXT NOT NULL DEFAULT '',
+    remind_at TIMESTAMPTZ NOT NULL,
+    claimed_until TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY(id),
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY(workspace_id) REFERENCES workspaces(id) ON DELETE CASCADE,
+    FOREIGN KEY(message_id) REFERENCES messages(id) ON DELETE SET NULL
+);
+CREATE INDEX reminders_due ON reminders (remind_at);
+CREATE INDEX reminders_user ON reminders (user_id, workspace_id);
+
+-- scheduled messages are claimed by one server at a time by setting
+-- claimed_until, and are claimed again if they weren't finished by then
+DROP TABLE IF EXISTS scheduled_messages CASCADE;
+CREATE TABLE scheduled_messages (
+    id VARCHAR(36) NOT NULL,
+    channel_id VARCHAR(36) NOT NULL,
+    workspace_id VARCHAR(36) NOT NULL,
+    user_id VARCHAR(36) NOT NULL,
+    content TEXT NOT NULL,
+    cron TEXT NOT NULL DEFAULT '',
+    timezone TEXT NOT NULL DEFAULT '',
+    send_at TIMESTAMPTZ NOT NULL,
+    claimed_until TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY(id),
+    FOREIGN KEY(channel_id) REFERENCES channels(id) ON DELETE CASCADE,
+    FOREIGN KEY(workspace_id) REFERENCES workspaces(id) ON DELETE CASCADE,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX scheduled_messages_due ON scheduled_messages (send_at);
+CREATE INDEX scheduled_messages_user ON scheduled_messages (user_id, workspace_id);
+
+DROP TABLE IF EXISTS link_previews CASCADE;
+CREATE TABLE link_previews (
+    url TEXT NOT NULL,
+    title TEXT NOT NULL,
+    description TEXT NOT NULL,
+    image TEXT NOT NULL,
+    site_name TEXT NOT NULL,
+    fetched_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY(url)
+);
+
+DROP TABLE IF EXISTS message_previews CASCADE;
+CREATE TABLE message_previews (
+    message_id VARCHAR(36) NOT NULL,
+    url TEXT NOT NULL,
+    position INTEGER NOT NULL,
+    PRIMARY KEY(message_id, url),
+    FOREIGN KEY(message_id) REFERENCES messages(id) ON DELETE CASCADE,
+    FOREIGN KEY(url) REFERENCES link_previews(url) ON DELETE CASCADE
+);
+
+DROP TABLE IF EXISTS unfurl_domains CASCADE;
+CREATE TABLE unfurl_domains (
+    workspace_id VARCHAR(36) NOT NULL,
+    domain VARCHAR(255) NOT NULL,
+    allow BOOLEAN NOT NULL,
+    PRIMARY KEY(workspace_id, domain),
+    FOREIGN KEY(workspace_id) REFERENCES workspaces(id) ON DELETE CASCADE
+);
+
+DROP TABLE IF EXISTS workspace_sso CASCADE;
+CREATE TABLE workspace_sso (
+    workspace_id VARCHAR(36) NOT NULL,
+    issuer TEXT NOT NULL,
+    client_id VARCHAR(255) NOT NULL,
+    client_secret TEXT NOT NULL,
+    redirect_url TEXT NOT NULL,
+    PRIMARY KEY(workspace_id),
+    FOREIGN KEY(workspace_id) REFERENCES workspaces(id) ON DELETE CASCADE
+);
+
+DROP TABLE IF EXISTS workspace_sso_domains CASCADE;
+CREATE TABLE workspace_sso_domains (
+    workspace_id VARCHAR(36) NOT NULL,
+    domain VARCHAR(255) UNIQUE NOT NULL,
+    FOREIGN KEY(workspace_id) REFERENCES workspaces(id) ON DELETE CASCADE
+);
+
+DROP TABLE IF EXISTS sso_states CASCADE;
+CREATE TABLE sso_states (
+    state VARCHAR(64) NOT NULL,
+    workspace_id VARCHAR(36) NOT NULL,
+    user_id VARCHAR(36),
+    verifier VARCHAR(128) NOT NULL,
+    nonce VARCHAR(64) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY(state),
+    FOREIGN KEY(workspace_id) REFERENCES workspaces(id) ON DELETE CASCADE,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+DROP TABLE IF EXISTS user_identities CASCADE;
+CREATE TABLE user_identities (
+    issuer TEXT NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    user_id VARCHAR(36) NOT NULL,
+    PRIMARY KEY(issuer, subject),
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+DROP TABLE IF EXISTS bots CASCADE;
+CREATE TABLE bots (
+    user_id VARCHAR(36) NOT NULL,
+    workspace_id VARCHAR(36) NOT NULL,
+    owner_id VARCHAR(36) NOT NULL,
+    PRIMARY KEY(user_id),
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY(workspace_id) REFERENCES workspaces(id) ON DELETE CASCADE,
+    FOREIGN KEY(owner_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+DROP TABLE IF EXISTS api_tokens CASCADE;
+CREATE TABLE api_tokens (
+    id VARCHAR(36) UNIQUE NOT NULL,
+    user_id VARCHAR(36) NOT NULL,
+    workspace_id VARCHAR(36) NOT NULL,
+    display_name VARCHAR(255) NOT NULL,
+    token_hash VARCHAR(64) UNIQUE NOT NULL,
+    scopes TEXT NOT NULL,
+    revoked BOOLEAN DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY(id),
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY(workspace_id) REFERENCES workspaces(id) ON DELETE CASCADE
+);
+
+DROP TABLE IF EXISTS login_attempts CASCADE;
+CREATE TABLE login_attempts (
+    attempt_key VARCHAR(320) NOT NULL,
+    failures INT NOT NULL DEFAULT 0,
+    last_failure TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY(attempt_key)
+);
+
+DROP TABLE IF EXISTS audit_log CASCADE;
+CREATE TABLE audit_log (
+    id VARCHAR(36) UNIQUE NOT NULL,
+    actor VARCHAR(320) NOT NULL,
+    action VARCHAR(64) NOT NULL,
+    target VARCHAR(320) NOT NULL,
+    workspace_id VARCHAR(36) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    before_data JSONB,
+    after_data JSONB,
+    PRIMARY KEY(id)
+);
+CREATE INDEX audit_log_workspace_idx ON audit_log (workspace_id, created_at DESC, id DESC);
+
+-- the audit log can only be appended to
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_no_changes
+    BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE PROCEDURE audit_log_append_only();
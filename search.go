@@ -0,0 +1,36 @@
+package sidebar
+
+import "time"
+
+// SearchQuery is a full-text search over the messages a user can see
+// in a workspace. Empty filters aren't used. Resolved only matches
+// sidebars that have or haven't been resolved when it is set.
+type SearchQuery struct {
+	Text         string
+	UserID       string
+	WorkspaceID  string
+	Channel      string
+	Author       string
+	Since        time.Time
+	Until        time.Time
+	SidebarsOnly bool
+	Resolved     *bool
+	Offset       int
+	Limit        int
+}
+
+// SearchResult is a message matching a search along with how well it
+// matched. Snippet is HTML-escaped with matching words wrapped in
+// <mark> tags.
+type SearchResult struct {
+	Message *ChatMessage `json:"message"`
+	Rank    float64      `json:"rank"`
+	Snippet string       `json:"snippet"`
+}
+
+// SearchPage is one page of search results, best match first.
+// NextOffset is 0 when there are no more results.
+type SearchPage struct {
+	Results    []*SearchResult `json:"results"`
+	NextOffset int             `json:"next_offset"`
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/tmitchel/sidebar"
+)
+
+// how often deleted channels are checked to see if they can be purged
+const purgeInterval = time.Hour
+
+// runPurges removes deleted channels once their retention period is over.
+func (s *server) runPurges() {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := s.Delete.PurgeChannels(); err != nil {
+			logrus.Errorf("Unable to purge deleted channels %v", err)
+		}
+	}
+}
+
+func (s *server) GetArchivedChannels() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+
+		channels, err := s.Get.GetArchivedChannels(parsed["UserID"].(string), parsed["WorkspaceID"].(string))
+		if err != nil {
+			return &serverError{err, "Unable to get archived channels", http.StatusInternalServerError}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(channels)
+		return nil
+	}
+}
+
+func (s *server) ArchiveChannel() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+		wid := parsed["WorkspaceID"].(string)
+
+		channel, err := s.Add.ArchiveChannel(mux.Vars(r)["id"], parsed["UserID"].(string), wid)
+		if err != nil {
+			return &serverError{err, "Unable to archive channel", http.StatusBadRequest}
+		}
+
+		s.sendToChannel(channel.ID, wid, sidebar.WebsocketMessage{
+			Type:    "channel-update",
+			Payload: sidebar.ChannelUpdate{Channel: *channel, Message: "archived"},
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(channel)
+		return nil
+	}
+}
+
+func (s *server) UnarchiveChannel() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+		wid := parsed["WorkspaceID"].(string)
+
+		channel, err := s.Add.UnarchiveChannel(mux.Vars(r)["id"], parsed["UserID"].(string), wid)
+		if err != nil {
+			return &serverError{err, "Unable to restore channel", http.StatusBadRequest}
+		}
+
+		s.sendToChannel(channel.ID, wid, sidebar.WebsocketMessage{
+			Type:    "channel-update",
+			Payload: sidebar.ChannelUpdate{Channel: *channel, Message: "restored"},
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(channel)
+		return nil
+	}
+}
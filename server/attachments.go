@@ -0,0 +1,129 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/tmitchel/sidebar"
+)
+
+// room for the multipart headers around an upload
+const uploadOverhead = 1 << 20
+
+// Upload saves the file sent in the "file" field of a multipart form. The
+// "purpose" field says whether it is a file for a message or an image for
+// the user, a channel, or the workspace. Channel images also need the
+// channel id in the "target" field.
+func (s *server) Upload() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		r.Body = http.MaxBytesReader(w, r.Body, sidebar.MaxAttachmentSize+uploadOverhead)
+		if err := r.ParseMultipartForm(8 << 20); err != nil {
+			return &serverError{err, "Unable to read upload", http.StatusRequestEntityTooLarge}
+		}
+		defer r.MultipartForm.RemoveAll()
+
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			return &serverError{err, "Missing file", http.StatusBadRequest}
+		}
+		defer file.Close()
+
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+
+		att, err := s.Files.Upload(&sidebar.Attachment{
+			UploaderID:  parsed["UserID"].(string),
+			WorkspaceID: parsed["WorkspaceID"].(string),
+			Purpose:     r.FormValue("purpose"),
+			Target:      r.FormValue("target"),
+			Name:        header.Filename,
+		}, file)
+		if err != nil {
+			return &serverError{err, "Unable to upload file", http.StatusBadRequest}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(att)
+		return nil
+	}
+}
+
+// Download sends the contents of an attachment the user is allowed to
+// see. Images are shown inline and everything else is downloaded. The
+// size query parameter asks for a thumbnail of an image instead.
+func (s *server) Download() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+
+		var size int
+		if q := r.URL.Query().Get("size"); q != "" {
+			var err error
+			if size, err = strconv.Atoi(q); err != nil {
+				return &serverError{err, "Invalid size", http.StatusBadRequest}
+			}
+		}
+
+		att, body, err := s.Files.Download(mux.Vars(r)["id"], size, parsed["UserID"].(string), parsed["WorkspaceID"].(string))
+		if err != nil {
+			return &serverError{err, "Unable to get attachment", http.StatusNotFound}
+		}
+		defer body.Close()
+
+		// attachments never change once they're uploaded
+		etag := `"` + att.URL + `"`
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+
+		disposition := "attachment"
+		if strings.HasPrefix(att.ContentType, "image/") {
+			disposition = "inline"
+		}
+
+		w.Header().Set("Content-Type", att.ContentType)
+		w.Header().Set("Content-Length", strconv.FormatInt(att.Size, 10))
+		w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": att.Name}))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if _, err := io.Copy(w, body); err != nil {
+			logrus.Errorf("Error sending attachment %v %v", att.ID, err)
+		}
+		return nil
+	}
+}
+
+// Identicon sends the generated default image for a user, channel, or
+// workspace. These are public since they're made from the id alone.
+func (s *server) Identicon() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		size := 128
+		if q := r.URL.Query().Get("size"); q != "" {
+			var err error
+			if size, err = strconv.Atoi(q); err != nil {
+				return &serverError{err, "Invalid size", http.StatusBadRequest}
+			}
+		}
+
+		img, err := s.Files.Identicon(mux.Vars(r)["seed"], size)
+		if err != nil {
+			return &serverError{err, "Unable to draw image", http.StatusBadRequest}
+		}
+
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Content-Length", strconv.Itoa(len(img)))
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Write(img)
+		return nil
+	}
+}
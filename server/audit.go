@@ -0,0 +1,111 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/tmitchel/sidebar"
+)
+
+// GetAuditLog returns one page of the workspace's audit log. Entries can be
+// filtered with the actor, action, target, since, and until query parameters,
+// and the next page is requested with the cursor parameter.
+func (s *server) GetAuditLog() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+
+		filter, err := auditFilter(r.URL.Query(), parsed["WorkspaceID"].(string))
+		if err != nil {
+			return &serverError{err, "Invalid audit log filter", http.StatusBadRequest}
+		}
+
+		page, err := s.Audit.GetAuditLog(filter, parsed["UserID"].(string))
+		if err != nil {
+			return &serverError{err, "Unable to get audit log", http.StatusForbidden}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(page)
+		return nil
+	}
+}
+
+// ExportAuditLog streams every entry matching the filter as JSON Lines.
+func (s *server) ExportAuditLog() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+		uid := parsed["UserID"].(string)
+
+		filter, err := auditFilter(r.URL.Query(), parsed["WorkspaceID"].(string))
+		if err != nil {
+			return &serverError{err, "Invalid audit log filter", http.StatusBadRequest}
+		}
+
+		// get the first page before writing anything so errors can
+		// still be reported
+		page, err := s.Audit.GetAuditLog(filter, uid)
+		if err != nil {
+			return &serverError{err, "Unable to get audit log", http.StatusForbidden}
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+		enc := json.NewEncoder(w)
+		for {
+			for _, entry := range page.Entries {
+				enc.Encode(entry)
+			}
+
+			if page.NextCursor == "" {
+				return nil
+			}
+
+			filter.Cursor = page.NextCursor
+			page, err = s.Audit.GetAuditLog(filter, uid)
+			if err != nil {
+				// too late to change the status
+				logrus.Errorf("Error exporting audit log %v", err)
+				return nil
+			}
+		}
+	}
+}
+
+func auditFilter(q url.Values, wid string) (*sidebar.AuditFilter, error) {
+	filter := &sidebar.AuditFilter{
+		WorkspaceID: wid,
+		Actor:       q.Get("actor"),
+		Action:      q.Get("action"),
+		Target:      q.Get("target"),
+		Cursor:      q.Get("cursor"),
+	}
+
+	var err error
+	if since := q.Get("since"); since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return nil, errors.Wrap(err, "Invalid since")
+		}
+	}
+
+	if until := q.Get("until"); until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return nil, errors.Wrap(err, "Invalid until")
+		}
+	}
+
+	if limit := q.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil {
+			return nil, errors.Wrap(err, "Invalid limit")
+		}
+	}
+
+	return filter, nil
+}
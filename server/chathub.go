@@ -9,16 +9,40 @@ import (
 type chathub struct {
 	clients    map[*client]bool
 	broadcast  chan sidebar.WebsocketMessage
+	unicast    chan clientMessage
+	multicast  chan usersMessage
+	online     chan onlineQuery
 	register   chan *client
 	unregister chan *client
 }
 
+// clientMessage is sent to a single client.
+type clientMessage struct {
+	client  *client
+	message sidebar.WebsocketMessage
+}
+
+// usersMessage is sent to every client belonging to one of the users.
+type usersMessage struct {
+	users   map[string]bool
+	message sidebar.WebsocketMessage
+}
+
+// onlineQuery asks if the user has any open connections.
+type onlineQuery struct {
+	user  string
+	reply chan bool
+}
+
 // NewChathub creates a chathub to handle client Websocket
 // connections and broadcasting messages.
 func newChathub() *chathub {
 	return &chathub{
 		clients:    make(map[*client]bool),
 		broadcast:  make(chan sidebar.WebsocketMessage),
+		unicast:    make(chan clientMessage),
+		multicast:  make(chan usersMessage),
+		online:     make(chan onlineQuery),
 		register:   make(chan *client),
 		unregister: make(chan *client),
 	}
@@ -35,6 +59,39 @@ func (h *chathub) run() {
 				delete(h.clients, client)
 				close(client.send)
 			}
+		case cm := <-h.unicast:
+			if _, ok := h.clients[cm.client]; !ok {
+				continue
+			}
+
+			select {
+			case cm.client.send <- cm.message:
+			default:
+				close(cm.client.send)
+				delete(h.clients, cm.client)
+			}
+		case um := <-h.multicast:
+			for client := range h.clients {
+				if !um.users[client.User.ID] {
+					continue
+				}
+
+				select {
+				case client.send <- um.message:
+				default:
+					close(client.send)
+					delete(h.clients, client)
+				}
+			}
+		case q := <-h.online:
+			online := false
+			for client := range h.clients {
+				if client.User.ID == q.user {
+					online = true
+					break
+				}
+			}
+			q.reply <- online
 		case message := <-h.broadcast:
 			for client := range h.clients {
 				select {
@@ -47,3 +104,10 @@ func (h *chathub) run() {
 		}
 	}
 }
+
+// isOnline checks if the user has any open Websocket connections.
+func (h *chathub) isOnline(uid string) bool {
+	reply := make(chan bool, 1)
+	h.online <- onlineQuery{uid, reply}
+	return <-reply
+}
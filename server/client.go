@@ -18,46 +18,60 @@ const (
 	// Send pings to peer with this period. Must be less than pongWait.
 	pingPeriod = (pongWait * 9) / 10
 
-	// Maximum message size allowed from peer.
-	maxMessageSize = 512
+	// Maximum message size allowed from peer. Leaves room for
+	// a message of sidebar.MaxMessageLength in any script.
+	maxMessageSize = 32768
 )
 
 type client struct {
-	conn *websocket.Conn
-	send chan sidebar.WebsocketMessage
-	hub  *chathub
-	User sidebar.User
+	conn      *websocket.Conn
+	send      chan sidebar.WebsocketMessage
+	hub       *chathub
+	handle    func(*client, wsCommand)
+	workspace string
+	scopes    []string
+	User      sidebar.User
 }
 
-// readPump listens for messages on the Websocket connection and
-// send them to the chathub for broadcasting.
+// hasScope checks if the client can use the scope. Clients connected
+// with a session instead of an API token have every scope.
+func (c *client) hasScope(scope string) bool {
+	if c.scopes == nil {
+		return true
+	}
+
+	for _, s := range c.scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// readPump listens for commands on the Websocket connection and
+// passes them to the client's handler.
 func (c *client) readPump() {
 	defer func() {
+		c.hub.unregister <- c
 		c.conn.Close()
 	}()
 
-	// c.conn.SetReadLimit(maxMessageSize)
-	// c.conn.SetReadDeadline(time.Now().Add(pongWait))
-	// c.conn.SetPongHandler(func(string) error { c.conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
-	// for {
-	// 	var msg sidebar.ChatMessage
-	// 	if err := c.conn.ReadJSON(&msg); err != nil {
-	// 		if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseNoStatusReceived, websocket.CloseGoingAway) {
-	// 			logrus.Error("websocket closed by client")
-	// 		} else {
-	// 			logrus.Errorf("websocket error %v", err)
-	// 		}
-	// 		return
-	// 	}
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error { c.conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
+	for {
+		var cmd wsCommand
+		if err := c.conn.ReadJSON(&cmd); err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseNoStatusReceived, websocket.CloseGoingAway) {
+				logrus.Info("websocket closed by client")
+			} else {
+				logrus.Errorf("websocket error %v", err)
+			}
+			return
+		}
 
-	// 	storedMsg, err := c.hub.db.CreateMessage(&msg)
-	// 	if err != nil {
-	// 		logrus.Errorf("error storing message %v", err)
-	// 	}
-	// 	if storedMsg != nil {
-	// 		c.hub.broadcast <- *storedMsg
-	// 	}
-	// }
+		c.handle(c, cmd)
+	}
 }
 
 // writePump listens for the chathub to broadcast a message then
@@ -75,6 +89,7 @@ func (c *client) writePump() {
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
 				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
 			}
 
 			if err := c.conn.WriteJSON(message); err != nil {
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/json"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tmitchel/sidebar"
+)
+
+// wsCommand is sent by clients over the Websocket connection. The
+// payload is decoded based on the type.
+type wsCommand struct {
+	Type    string
+	Payload json.RawMessage
+}
+
+// Typing is sent to a channel's members when a user is typing in it.
+type Typing struct {
+	User    string `json:"user"`
+	Channel string `json:"channel"`
+}
+
+// RateLimited is sent to a client when one of its commands was
+// dropped for going over budget.
+type RateLimited struct {
+	Command    string `json:"command"`
+	RetryAfter int    `json:"retry_after"`
+}
+
+// commandRoutes maps commands to the routes whose budget they share so
+// clients can't get around a limit by switching to the Websocket.
+var commandRoutes = map[string]string{
+	"chat-message": "POST /api/message",
+}
+
+// commandScopes are the scopes an API token needs for each command, the
+// same as the matching HTTP routes.
+var commandScopes = map[string]string{
+	"chat-message": sidebar.ScopePostMessages,
+	"typing":       sidebar.ScopePostMessages,
+	"read-marker":  sidebar.ScopeReadMessages,
+}
+
+// handleCommand checks scopes and rate limits then runs a command sent
+// by a client over the Websocket connection.
+func (s *server) handleCommand(c *client, cmd wsCommand) {
+	if scope, ok := commandScopes[cmd.Type]; ok && !c.hasScope(scope) {
+		logrus.Errorf("User %v is missing the %v scope for %v", c.User.ID, scope, cmd.Type)
+		return
+	}
+
+	route, ok := commandRoutes[cmd.Type]
+	if !ok {
+		route = "WS " + cmd.Type
+	}
+
+	lim := s.limiter.take(route, "user:"+c.User.ID)
+	if !lim.allowed {
+		s.hub.unicast <- clientMessage{c, sidebar.WebsocketMessage{
+			Type:    "rate-limited",
+			Payload: RateLimited{cmd.Type, int(lim.retryAfter.Seconds())},
+		}}
+		return
+	}
+
+	switch cmd.Type {
+	case "chat-message":
+		var msg sidebar.ChatMessage
+		if err := json.Unmarshal(cmd.Payload, &msg); err != nil {
+			logrus.Errorf("Unable to decode chat message %v", err)
+			return
+		}
+
+		msg.FromUser = c.User.ID
+		send, err := s.Create.CreateMessage(&msg, c.workspace)
+		if err != nil {
+			logrus.Errorf("Unable to save message %v", err)
+			return
+		}
+
+		s.sendToChannel(send.Channel, c.workspace, sidebar.WebsocketMessage{
+			Type:    "chat-message",
+			Payload: send,
+		})
+		s.sendAlerts(send, c.workspace)
+		s.queueUnfurl(send, c.workspace)
+	case "typing":
+		var typing Typing
+		if err := json.Unmarshal(cmd.Payload, &typing); err != nil {
+			logrus.Errorf("Unable to decode typing %v", err)
+			return
+		}
+
+		members, err := s.channelMembers(typing.Channel, c.workspace)
+		if err != nil || !members[c.User.ID] {
+			logrus.Errorf("User %v can't type in channel %v %v", c.User.ID, typing.Channel, err)
+			return
+		}
+
+		typing.User = c.User.ID
+		s.hub.multicast <- usersMessage{members, sidebar.WebsocketMessage{
+			Type:    "typing",
+			Payload: typing,
+		}}
+	case "read-marker":
+		var marker sidebar.ReadMarker
+		if err := json.Unmarshal(cmd.Payload, &marker); err != nil {
+			logrus.Errorf("Unable to decode read marker %v", err)
+			return
+		}
+
+		marker.UserID = c.User.ID
+		if _, err := s.updateReadMarker(&marker, c.workspace); err != nil {
+			logrus.Errorf("Unable to update read marker %v", err)
+		}
+	default:
+		logrus.Errorf("Unknown websocket command %v", cmd.Type)
+	}
+}
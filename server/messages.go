@@ -0,0 +1,365 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/tmitchel/sidebar"
+)
+
+// UpdateMessage edits a message sent by the current user and lets the
+// members of the message's channel know.
+func (s *server) UpdateMessage() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		var msg sidebar.ChatMessage
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			return &serverError{err, "Unable to decode payload", http.StatusBadRequest}
+		}
+
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+		wid := parsed["WorkspaceID"].(string)
+
+		msg.ID = mux.Vars(r)["id"]
+		updated, err := s.Up.UpdateMessage(&msg, parsed["UserID"].(string), wid)
+		if err != nil {
+			return &serverError{err, "Unable to update message", http.StatusBadRequest}
+		}
+
+		s.sendToChannel(updated.Channel, wid, sidebar.WebsocketMessage{
+			Type:    "message-updated",
+			Payload: updated,
+		})
+		s.queueUnfurl(updated, wid)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(updated)
+		return nil
+	}
+}
+
+// DeleteMessage replaces a message with a tombstone and lets the members
+// of the message's channel know.
+func (s *server) DeleteMessage() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+		wid := parsed["WorkspaceID"].(string)
+
+		deleted, err := s.Delete.DeleteMessage(mux.Vars(r)["id"], parsed["UserID"].(string), wid)
+		if err != nil {
+			return &serverError{err, "Unable to delete message", http.StatusBadRequest}
+		}
+
+		s.sendToChannel(deleted.Channel, wid, sidebar.WebsocketMessage{
+			Type:    "message-deleted",
+			Payload: deleted,
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(deleted)
+		return nil
+	}
+}
+
+// AddReaction adds the current user's reaction to a message and lets the
+// members of the message's channel know.
+func (s *server) AddReaction() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		var reaction sidebar.Reaction
+		if err := json.NewDecoder(r.Body).Decode(&reaction); err != nil {
+			return &serverError{err, "Unable to decode payload", http.StatusBadRequest}
+		}
+
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+		wid := parsed["WorkspaceID"].(string)
+
+		reaction.MessageID = mux.Vars(r)["id"]
+		reaction.UserID = parsed["UserID"].(string)
+		update, err := s.Add.AddReaction(&reaction, wid)
+		if err != nil {
+			return &serverError{err, "Unable to add reaction", http.StatusBadRequest}
+		}
+
+		s.sendToChannel(update.Channel, wid, sidebar.WebsocketMessage{
+			Type:    "reaction",
+			Payload: update,
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(update)
+		return nil
+	}
+}
+
+// RemoveReaction removes the current user's reaction from a message and
+// lets the members of the message's channel know.
+func (s *server) RemoveReaction() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+		wid := parsed["WorkspaceID"].(string)
+
+		reaction := sidebar.Reaction{
+			MessageID: mux.Vars(r)["id"],
+			UserID:    parsed["UserID"].(string),
+			Emoji:     mux.Vars(r)["emoji"],
+		}
+		update, err := s.Add.RemoveReaction(&reaction, wid)
+		if err != nil {
+			return &serverError{err, "Unable to remove reaction", http.StatusBadRequest}
+		}
+
+		s.sendToChannel(update.Channel, wid, sidebar.WebsocketMessage{
+			Type:    "reaction",
+			Payload: update,
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(update)
+		return nil
+	}
+}
+
+// GetThread returns the root message and replies of the thread the
+// message is part of.
+func (s *server) GetThread() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		parsed := r.Context().Value("user").(*jwt.Token).Claims.(jwt.MapClaims)
+
+		thread, err := s.Get.GetThread(mux.Vars(r)["id"], parsed["UserID"].(string), parsed["WorkspaceID"].(string))
+		if err != nil {
+			return &serverError{err, "Unable to get thread", http.StatusBadRequest}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(thread)
+		return nil
+	}
+}
+
+// PromoteThread turns a thread into a sidebar seeded with the thread's
+// messages.
+func (s *server) PromoteThread() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		var reqChannel sidebar.Channel
+		if err := json.NewDecoder(r.Body).Decode(&reqChannel); err != nil {
+			return &serverError{err, "Unable to decode payload", http.StatusBadRequest}
+		}
+
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+
+		channel, err := s.Create.PromoteThread(mux.Vars(r)["id"], &reqChannel, parsed["UserID"].(string), parsed["WorkspaceID"].(string))
+		if err != nil {
+			return failure(err, "Unable to promote thread to a sidebar", http.StatusBadRequest)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(channel)
+		return nil
+	}
+}
+
+// GetMessageRevisions returns the previous versions of a message to
+// workspace admins.
+func (s *server) GetMessageRevisions() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+
+		revisions, err := s.Up.GetMessageRevisions(mux.Vars(r)["id"], parsed["UserID"].(string), parsed["WorkspaceID"].(string))
+		if err != nil {
+			return &serverError{err, "Unable to get message revisions", http.StatusForbidden}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(revisions)
+		return nil
+	}
+}
+
+func (s *server) GetWorkspaceSettings() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		wid := r.Context().Value("user").(*jwt.Token).Claims.(jwt.MapClaims)["WorkspaceID"].(string)
+
+		settings, err := s.Get.GetWorkspaceSettings(wid)
+		if err != nil {
+			return &serverError{err, "Unable to get workspace settings", http.StatusInternalServerError}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(settings)
+		return nil
+	}
+}
+
+func (s *server) UpdateWorkspaceSettings() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		var settings sidebar.WorkspaceSettings
+		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+			return &serverError{err, "Unable to decode payload", http.StatusBadRequest}
+		}
+
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+		settings.WorkspaceID = parsed["WorkspaceID"].(string)
+
+		if err := s.Up.UpdateWorkspaceSettings(&settings, parsed["UserID"].(string)); err != nil {
+			return &serverError{err, "Unable to update workspace settings", http.StatusBadRequest}
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "Success")
+		return nil
+	}
+}
+
+func (s *server) GetMentions() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+
+		mentions, err := s.Get.GetMentions(parsed["UserID"].(string), parsed["WorkspaceID"].(string))
+		if err != nil {
+			return &serverError{err, "Unable to get mentions", http.StatusInternalServerError}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(mentions)
+		return nil
+	}
+}
+
+func (s *server) MarkMentionsRead() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+
+		err := s.Up.MarkMentionsRead(parsed["UserID"].(string), mux.Vars(r)["channel"], parsed["WorkspaceID"].(string))
+		if err != nil {
+			return &serverError{err, "Unable to mark mentions as read", http.StatusBadRequest}
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "Success")
+		return nil
+	}
+}
+
+// UpdateReadMarker marks the channel as read up to the given message.
+func (s *server) UpdateReadMarker() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		var marker sidebar.ReadMarker
+		if err := json.NewDecoder(r.Body).Decode(&marker); err != nil {
+			return &serverError{err, "Unable to decode payload", http.StatusBadRequest}
+		}
+
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+
+		marker.UserID = parsed["UserID"].(string)
+		marker.Channel = mux.Vars(r)["channel"]
+		stored, err := s.updateReadMarker(&marker, parsed["WorkspaceID"].(string))
+		if err != nil {
+			return &serverError{err, "Unable to update read marker", http.StatusBadRequest}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stored)
+		return nil
+	}
+}
+
+// updateReadMarker saves the read marker then sends it to all of the
+// user's connections so their other clients stay in sync.
+func (s *server) updateReadMarker(marker *sidebar.ReadMarker, wid string) (*sidebar.ReadMarker, error) {
+	stored, err := s.Up.UpdateReadMarker(marker, wid)
+	if err != nil {
+		return nil, err
+	}
+
+	s.hub.multicast <- usersMessage{map[string]bool{stored.UserID: true}, sidebar.WebsocketMessage{
+		Type:    "read-marker",
+		Payload: stored,
+	}}
+	return stored, nil
+}
+
+// sendAlerts alerts the users who want to hear about the new message,
+// including mentioned users who aren't in the message's channel. Their
+// notification preferences are checked before anything is sent. Only
+// members of the channel get the message's content, everyone else just
+// learns where they were mentioned and by who.
+func (s *server) sendAlerts(msg *sidebar.ChatMessage, wid string) {
+	recipients, err := s.Notify.AlertRecipients(msg, wid)
+	if err != nil {
+		logrus.Errorf("Unable to get alert recipients for %v %v", msg.ID, err)
+		return
+	}
+
+	members, err := s.channelMembers(msg.Channel, wid)
+	if err != nil {
+		logrus.Errorf("Unable to get members of channel %v %v", msg.Channel, err)
+		return
+	}
+
+	type group struct {
+		kind   string
+		member bool
+	}
+
+	groups := make(map[group]map[string]bool)
+	for uid, kind := range recipients {
+		g := group{kind, members[uid]}
+		if groups[g] == nil {
+			groups[g] = make(map[string]bool)
+		}
+		groups[g][uid] = true
+	}
+
+	for g, users := range groups {
+		alert := sidebar.Alert{Target: msg.Channel, From: msg.FromUser}
+		if g.member {
+			alert.Message = msg.Content
+		}
+
+		s.hub.multicast <- usersMessage{users, sidebar.WebsocketMessage{
+			Type:    g.kind,
+			Payload: alert,
+		}}
+	}
+}
+
+// sendToChannel sends the message over the Websocket connection to
+// members of the channel only.
+func (s *server) sendToChannel(cid, wid string, message sidebar.WebsocketMessage) {
+	users, err := s.channelMembers(cid, wid)
+	if err != nil {
+		logrus.Errorf("Unable to get members of channel %v %v", cid, err)
+		return
+	}
+
+	s.hub.multicast <- usersMessage{users, message}
+}
+
+// channelMembers returns the ids of the channel's members.
+func (s *server) channelMembers(cid, wid string) (map[string]bool, error) {
+	members, err := s.Get.GetUsersInChannel(cid, wid)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make(map[string]bool, len(members))
+	for _, m := range members {
+		users[m.ID] = true
+	}
+	return users, nil
+}
@@ -46,6 +46,32 @@ type ChannelWithUsersAndMessages struct {
 	Channel           sidebar.Channel
 	UsersInChannel    []*sidebar.User
 	MessagesInChannel []*sidebar.ChatMessage
+	Pins              []*sidebar.Pin
+	Bookmarks         []*sidebar.Bookmark
+}
+
+// DirectRequest is used to decode requests to open a direct
+// conversation with several users.
+type DirectRequest struct {
+	Users []string `json:"users"`
+}
+
+// DeleteChannelRequest is used to decode requests to delete a
+// channel. Confirm has to be the channel's slug.
+type DeleteChannelRequest struct {
+	ID      string `json:"id"`
+	Confirm string `json:"confirm"`
+}
+
+// ChannelFieldRequest is used to decode requests to change a
+// channel's topic or purpose.
+type ChannelFieldRequest struct {
+	Value string `json:"value"`
+}
+
+// Order is used to decode requests to reorder pins or bookmarks.
+type Order struct {
+	Order []string `json:"order"`
 }
 
 // PasswordUpdate is used to decode requests to update the
@@ -61,3 +87,16 @@ type AuthInfo struct {
 	Password  string `json:"password"`
 	Workspace string `json:"workspace"`
 }
+
+// SSOCallback is used to decode the authorization response
+// the identity provider sent back to the frontend.
+type SSOCallback struct {
+	State string `json:"state"`
+	Code  string `json:"code"`
+}
+
+// UnlockRequest is used to decode requests from admins to
+// unlock an account.
+type UnlockRequest struct {
+	Email string `json:"email"`
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/tmitchel/sidebar"
+)
+
+func (s *server) GetNotificationPrefs() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+
+		prefs, err := s.Notify.GetNotificationPrefs(parsed["UserID"].(string), parsed["WorkspaceID"].(string))
+		if err != nil {
+			return &serverError{err, "Unable to get notification preferences", http.StatusInternalServerError}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(prefs)
+		return nil
+	}
+}
+
+func (s *server) UpdateNotificationPrefs() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		var prefs sidebar.NotificationPrefs
+		if err := json.NewDecoder(r.Body).Decode(&prefs); err != nil {
+			return &serverError{err, "Unable to decode payload", http.StatusBadRequest}
+		}
+
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+		prefs.UserID = parsed["UserID"].(string)
+		prefs.WorkspaceID = parsed["WorkspaceID"].(string)
+
+		if err := s.Notify.UpdateNotificationPrefs(&prefs); err != nil {
+			return &serverError{err, "Unable to update notification preferences", http.StatusBadRequest}
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "Success")
+		return nil
+	}
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"github.com/tmitchel/sidebar"
+)
+
+func (s *server) GetPins() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+
+		pins, err := s.Pins.GetPins(mux.Vars(r)["id"], parsed["UserID"].(string), parsed["WorkspaceID"].(string))
+		if err != nil {
+			return &serverError{err, "Unable to get pins", http.StatusBadRequest}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(pins)
+		return nil
+	}
+}
+
+func (s *server) PinMessage() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+		wid := parsed["WorkspaceID"].(string)
+
+		pins, err := s.Pins.PinMessage(mux.Vars(r)["id"], parsed["UserID"].(string), wid)
+		if err != nil {
+			return &serverError{err, "Unable to pin message", http.StatusBadRequest}
+		}
+
+		s.sendToChannel(pins.Channel, wid, sidebar.WebsocketMessage{
+			Type:    "pins",
+			Payload: pins,
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(pins)
+		return nil
+	}
+}
+
+func (s *server) UnpinMessage() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+		wid := parsed["WorkspaceID"].(string)
+
+		pins, err := s.Pins.UnpinMessage(mux.Vars(r)["id"], parsed["UserID"].(string), wid)
+		if err != nil {
+			return &serverError{err, "Unable to unpin message", http.StatusBadRequest}
+		}
+
+		s.sendToChannel(pins.Channel, wid, sidebar.WebsocketMessage{
+			Type:    "pins",
+			Payload: pins,
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(pins)
+		return nil
+	}
+}
+
+func (s *server) ReorderPins() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		var order Order
+		if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
+			return &serverError{err, "Unable to decode payload", http.StatusBadRequest}
+		}
+
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+		wid := parsed["WorkspaceID"].(string)
+
+		pins, err := s.Pins.ReorderPins(mux.Vars(r)["id"], order.Order, parsed["UserID"].(string), wid)
+		if err != nil {
+			return &serverError{err, "Unable to reorder pins", http.StatusBadRequest}
+		}
+
+		s.sendToChannel(pins.Channel, wid, sidebar.WebsocketMessage{
+			Type:    "pins",
+			Payload: pins,
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(pins)
+		return nil
+	}
+}
+
+func (s *server) GetBookmarks() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+
+		bookmarks, err := s.Pins.GetBookmarks(mux.Vars(r)["id"], parsed["UserID"].(string), parsed["WorkspaceID"].(string))
+		if err != nil {
+			return &serverError{err, "Unable to get bookmarks", http.StatusBadRequest}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(bookmarks)
+		return nil
+	}
+}
+
+func (s *server) AddBookmark() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		var bookmark sidebar.Bookmark
+		if err := json.NewDecoder(r.Body).Decode(&bookmark); err != nil {
+			return &serverError{err, "Unable to decode payload", http.StatusBadRequest}
+		}
+
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+		wid := parsed["WorkspaceID"].(string)
+		bookmark.Channel = mux.Vars(r)["id"]
+
+		bookmarks, err := s.Pins.AddBookmark(&bookmark, parsed["UserID"].(string), wid)
+		if err != nil {
+			return &serverError{err, "Unable to add bookmark", http.StatusBadRequest}
+		}
+
+		s.sendToChannel(bookmarks.Channel, wid, sidebar.WebsocketMessage{
+			Type:    "bookmarks",
+			Payload: bookmarks,
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(bookmarks)
+		return nil
+	}
+}
+
+func (s *server) RemoveBookmark() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+		wid := parsed["WorkspaceID"].(string)
+
+		bookmarks, err := s.Pins.RemoveBookmark(mux.Vars(r)["id"], parsed["UserID"].(string), wid)
+		if err != nil {
+			return &serverError{err, "Unable to remove bookmark", http.StatusBadRequest}
+		}
+
+		s.sendToChannel(bookmarks.Channel, wid, sidebar.WebsocketMessage{
+			Type:    "bookmarks",
+			Payload: bookmarks,
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(bookmarks)
+		return nil
+	}
+}
+
+func (s *server) ReorderBookmarks() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		var order Order
+		if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
+			return &serverError{err, "Unable to decode payload", http.StatusBadRequest}
+		}
+
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+		wid := parsed["WorkspaceID"].(string)
+		cid := mux.Vars(r)["id"]
+
+		bookmarks, err := s.Pins.ReorderBookmarks(cid, order.Order, parsed["UserID"].(string), wid)
+		if err != nil {
+			return &serverError{err, "Unable to reorder bookmarks", http.StatusBadRequest}
+		}
+
+		s.sendToChannel(bookmarks.Channel, wid, sidebar.WebsocketMessage{
+			Type:    "bookmarks",
+			Payload: bookmarks,
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(bookmarks)
+		return nil
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/tmitchel/sidebar"
+)
+
+// how often statuses are checked to see if they've expired
+const statusInterval = time.Minute
+
+// runStatusExpiry clears expired statuses and lets the rest of the
+// workspace know.
+func (s *server) runStatusExpiry() {
+	ticker := time.NewTicker(statusInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		cleared, err := s.Up.ExpireStatuses()
+		if err != nil {
+			logrus.Errorf("Unable to expire statuses %v", err)
+			continue
+		}
+
+		for _, update := range cleared {
+			s.sendToWorkspace(update.WorkspaceID, sidebar.WebsocketMessage{
+				Type:    "status",
+				Payload: update,
+			})
+		}
+	}
+}
+
+// sendToWorkspace sends the message over the Websocket connection to
+// members of the workspace only.
+func (s *server) sendToWorkspace(wid string, message sidebar.WebsocketMessage) {
+	members, err := s.Get.GetUsers(wid)
+	if err != nil {
+		logrus.Errorf("Unable to get members of workspace %v %v", wid, err)
+		return
+	}
+
+	users := make(map[string]bool, len(members))
+	for _, m := range members {
+		users[m.ID] = true
+	}
+
+	s.hub.multicast <- usersMessage{users, message}
+}
+
+func (s *server) GetProfileFields() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+
+		fields, err := s.Up.GetProfileFields(parsed["UserID"].(string), parsed["WorkspaceID"].(string))
+		if err != nil {
+			return &serverError{err, "Unable to get profile fields", http.StatusBadRequest}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(fields)
+		return nil
+	}
+}
+
+func (s *server) CreateProfileField() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		var field sidebar.ProfileField
+		if err := json.NewDecoder(r.Body).Decode(&field); err != nil {
+			return &serverError{err, "Unable to decode payload", http.StatusBadRequest}
+		}
+
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+		field.WorkspaceID = parsed["WorkspaceID"].(string)
+
+		created, err := s.Up.CreateProfileField(&field, parsed["UserID"].(string))
+		if err != nil {
+			return failure(err, "Unable to create profile field", http.StatusBadRequest)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(created)
+		return nil
+	}
+}
+
+func (s *server) DeleteProfileField() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+		uid := parsed["UserID"].(string)
+		wid := parsed["WorkspaceID"].(string)
+
+		if err := s.Up.DeleteProfileField(mux.Vars(r)["id"], uid, wid); err != nil {
+			return &serverError{err, "Unable to delete profile field", http.StatusBadRequest}
+		}
+
+		fields, err := s.Up.GetProfileFields(uid, wid)
+		if err != nil {
+			return &serverError{err, "Unable to get profile fields", http.StatusInternalServerError}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(fields)
+		return nil
+	}
+}
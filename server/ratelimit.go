@@ -0,0 +1,226 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// only sweep idle buckets once this many are being tracked
+const bucketSweepSize = 10000
+
+// Budget is the number of requests allowed in a period. Requests are
+// refilled continuously, so a budget of 60 per minute allows one
+// request a second after the first 60 have been used.
+type Budget struct {
+	Requests int
+	Per      time.Duration
+}
+
+// defaultBudgets are keyed by method and route template. The "*" budget
+// applies to any route without its own.
+var defaultBudgets = map[string]Budget{
+	"*":                 {600, time.Minute},
+	"POST /api/message": {60, time.Minute},
+	"POST /api/channel": {20, time.Hour},
+	"POST /api/sidebar/{parent_id}/{user_id}": {20, time.Hour},
+	"POST /api/direct":                        {30, time.Hour},
+	"POST /api/direct/{to_id}":                {30, time.Hour},
+	"POST /api/attachments":                   {30, time.Hour},
+	"POST /login":                             {10, time.Minute},
+	"POST /user":                              {5, time.Hour},
+	"GET /sso/login":                          {20, time.Minute},
+	"POST /sso/callback":                      {20, time.Minute},
+	"WS typing":                               {30, time.Minute},
+}
+
+// ParseBudgets reads budgets in the form "POST /api/message=60/m" separated
+// by commas. Periods can be "s", "m", "h", or any time.Duration.
+func ParseBudgets(config string) (map[string]Budget, error) {
+	budgets := make(map[string]Budget)
+	for _, entry := range strings.Split(config, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		eq := strings.LastIndex(entry, "=")
+		slash := strings.LastIndex(entry, "/")
+		if eq < 0 || slash < eq {
+			return nil, errors.Errorf("Invalid rate limit %v", entry)
+		}
+
+		requests, err := strconv.Atoi(entry[eq+1 : slash])
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid rate limit %v", entry)
+		}
+
+		var per time.Duration
+		switch period := entry[slash+1:]; period {
+		case "s":
+			per = time.Second
+		case "m":
+			per = time.Minute
+		case "h":
+			per = time.Hour
+		default:
+			if per, err = time.ParseDuration(period); err != nil {
+				return nil, errors.Wrapf(err, "Invalid rate limit %v", entry)
+			}
+		}
+
+		budgets[strings.TrimSpace(entry[:eq])] = Budget{requests, per}
+	}
+	return budgets, nil
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps a token bucket for every route and client.
+type rateLimiter struct {
+	mu      sync.Mutex
+	budgets map[string]Budget
+	buckets map[string]*bucket
+}
+
+func newRateLimiter() *rateLimiter {
+	budgets := make(map[string]Budget)
+	for route, budget := range defaultBudgets {
+		budgets[route] = budget
+	}
+
+	return &rateLimiter{
+		budgets: budgets,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// limit is the result of trying to take a request from a bucket.
+type limit struct {
+	Budget
+	allowed    bool
+	remaining  int
+	reset      time.Duration
+	retryAfter time.Duration
+}
+
+// take tries to use one request from the client's bucket for the route.
+func (l *rateLimiter) take(route, client string) limit {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	budget, ok := l.budgets[route]
+	if !ok {
+		route = "*"
+		budget = l.budgets[route]
+	}
+
+	now := time.Now()
+	rate := float64(budget.Requests) / budget.Per.Seconds()
+	key := route + "|" + client
+
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= bucketSweepSize {
+			l.sweep(now)
+		}
+		b = &bucket{tokens: float64(budget.Requests), last: now}
+		l.buckets[key] = b
+	}
+
+	// refill since the last request
+	b.tokens = math.Min(float64(budget.Requests), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	result := limit{Budget: budget}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.allowed = true
+	} else {
+		result.retryAfter = seconds((1 - b.tokens) / rate)
+	}
+
+	result.remaining = int(b.tokens)
+	result.reset = seconds((float64(budget.Requests) - b.tokens) / rate)
+	return result
+}
+
+// sweep removes buckets that have been idle long enough to be full again.
+// Must be called with the lock held.
+func (l *rateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		budget, ok := l.budgets[key[:strings.LastIndex(key, "|")]]
+		if !ok || now.Sub(b.last) > budget.Per {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// setHeaders adds the standard rate limit headers to the response.
+func (lim limit) setHeaders(w http.ResponseWriter) {
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(lim.Requests))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(lim.remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(int(lim.reset.Seconds())))
+	if !lim.allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(lim.retryAfter.Seconds())))
+	}
+}
+
+// SetRateLimits replaces the budgets for the given routes.
+func (s *server) SetRateLimits(budgets map[string]Budget) {
+	s.limiter.mu.Lock()
+	defer s.limiter.mu.Unlock()
+
+	for route, budget := range budgets {
+		s.limiter.budgets[route] = budget
+	}
+}
+
+// rateLimit limits requests to unprotected routes by IP address.
+// Requests under /api are limited after authentication by apiRateLimit.
+func (s *server) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		template, _ := mux.CurrentRoute(r).GetPathTemplate()
+		if template == "/api" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		s.limitRequest(w, r, next, r.Method+" "+template, "ip:"+s.clientIP(r))
+	})
+}
+
+// apiRateLimit limits requests to protected routes by user.
+func (s *server) apiRateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		template, _ := mux.CurrentRoute(r).GetPathTemplate()
+		uid := r.Context().Value("user").(*jwt.Token).Claims.(jwt.MapClaims)["UserID"].(string)
+		s.limitRequest(w, r, next, r.Method+" "+template, "user:"+uid)
+	})
+}
+
+func (s *server) limitRequest(w http.ResponseWriter, r *http.Request, next http.Handler, route, client string) {
+	lim := s.limiter.take(route, client)
+	lim.setHeaders(w)
+	if !lim.allowed {
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return
+	}
+
+	next.ServeHTTP(w, r)
+}
+
+// seconds rounds up to the next whole second.
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Ceil(s)) * time.Second
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/tmitchel/sidebar"
+)
+
+// how often the database is checked for reminders that are due
+const reminderInterval = 15 * time.Second
+
+// runReminders delivers reminders as they come due. Users that are online
+// get an alert over the Websocket connection and everyone else, or anyone
+// that muted the reminder's channel, gets a direct message from the system
+// bot. Reminders that fail are left to be claimed again.
+func (s *server) runReminders() {
+	ticker := time.NewTicker(reminderInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		reminders, err := s.Saved.DueReminders()
+		if err != nil {
+			logrus.Errorf("Unable to get due reminders %v", err)
+			continue
+		}
+
+		for _, r := range reminders {
+			if err := s.deliverReminder(r); err != nil {
+				logrus.Errorf("Unable to deliver reminder %v %v", r.ID, err)
+				continue
+			}
+
+			if err := s.Saved.FinishReminder(r.ID); err != nil {
+				logrus.Errorf("Unable to finish reminder %v %v", r.ID, err)
+			}
+		}
+	}
+}
+
+func (s *server) deliverReminder(r *sidebar.Reminder) error {
+	if s.hub.isOnline(r.UserID) && !r.Quiet {
+		alert := sidebar.Alert{Message: r.Note}
+		if r.Message != nil {
+			alert.Target = r.Message.Channel
+			if alert.Message == "" {
+				alert.Message = r.Message.Content
+			}
+		}
+
+		s.hub.multicast <- usersMessage{map[string]bool{r.UserID: true}, sidebar.WebsocketMessage{
+			Type:    "reminder",
+			Payload: alert,
+		}}
+		return nil
+	}
+
+	msg, err := s.Saved.DeliverReminder(r)
+	if err != nil {
+		return err
+	}
+
+	s.sendToChannel(msg.Channel, r.WorkspaceID, sidebar.WebsocketMessage{
+		Type:    "chat-message",
+		Payload: msg,
+	})
+	return nil
+}
+
+func (s *server) GetSavedItems() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+
+		saved, err := s.Saved.GetSavedItems(parsed["UserID"].(string), parsed["WorkspaceID"].(string))
+		if err != nil {
+			return &serverError{err, "Unable to get saved messages", http.StatusBadRequest}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(saved)
+		return nil
+	}
+}
+
+func (s *server) SaveMessage() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+
+		item, err := s.Saved.SaveMessage(mux.Vars(r)["id"], parsed["UserID"].(string), parsed["WorkspaceID"].(string))
+		if err != nil {
+			return &serverError{err, "Unable to save message", http.StatusBadRequest}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(item)
+		return nil
+	}
+}
+
+func (s *server) UnsaveMessage() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+
+		err := s.Saved.UnsaveMessage(mux.Vars(r)["id"], parsed["UserID"].(string), parsed["WorkspaceID"].(string))
+		if err != nil {
+			return &serverError{err, "Unable to remove saved message", http.StatusBadRequest}
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "Success")
+		return nil
+	}
+}
+
+func (s *server) GetReminders() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+
+		reminders, err := s.Saved.GetReminders(parsed["UserID"].(string), parsed["WorkspaceID"].(string))
+		if err != nil {
+			return &serverError{err, "Unable to get reminders", http.StatusBadRequest}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reminders)
+		return nil
+	}
+}
+
+func (s *server) CreateReminder() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		var reminder sidebar.Reminder
+		if err := json.NewDecoder(r.Body).Decode(&reminder); err != nil {
+			return &serverError{err, "Unable to decode payload", http.StatusBadRequest}
+		}
+
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+		reminder.UserID = parsed["UserID"].(string)
+		reminder.WorkspaceID = parsed["WorkspaceID"].(string)
+
+		created, err := s.Saved.CreateReminder(&reminder)
+		if err != nil {
+			return &serverError{err, "Unable to create reminder", http.StatusBadRequest}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(created)
+		return nil
+	}
+}
+
+func (s *server) DeleteReminder() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+
+		if err := s.Saved.DeleteReminder(mux.Vars(r)["id"], parsed["UserID"].(string)); err != nil {
+			return &serverError{err, "Unable to delete reminder", http.StatusBadRequest}
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "Success")
+		return nil
+	}
+}
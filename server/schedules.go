@@ -0,0 +1,142 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/tmitchel/sidebar"
+)
+
+// how often the database is checked for scheduled messages that are due
+const scheduleInterval = 15 * time.Second
+
+// runSchedules posts scheduled messages as they come due. Messages go
+// through the same path as messages sent by users, so members of the
+// channel see them and get alerts as usual. Messages that fail to send
+// aren't finished, so they're claimed and tried again once the lease
+// runs out.
+func (s *server) runSchedules() {
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		due, err := s.Schedule.DueScheduledMessages()
+		if err != nil {
+			logrus.Errorf("Unable to get due scheduled messages %v", err)
+			continue
+		}
+
+		for _, m := range due {
+			s.sendScheduled(m)
+		}
+	}
+}
+
+func (s *server) sendScheduled(m *sidebar.ScheduledMessage) {
+	send, err := s.Create.CreateMessage(&sidebar.ChatMessage{
+		Event:    sidebar.EventMessage,
+		Content:  m.Content,
+		FromUser: m.UserID,
+		Channel:  m.Channel,
+	}, m.WorkspaceID)
+	if err != nil {
+		logrus.Errorf("Unable to send scheduled message %v %v", m.ID, err)
+		return
+	}
+
+	if err := s.Schedule.FinishScheduledMessage(m); err != nil {
+		logrus.Errorf("Unable to finish scheduled message %v %v", m.ID, err)
+	}
+
+	s.sendToChannel(send.Channel, m.WorkspaceID, sidebar.WebsocketMessage{
+		Type:    "chat-message",
+		Payload: send,
+	})
+	s.sendAlerts(send, m.WorkspaceID)
+	s.queueUnfurl(send, m.WorkspaceID)
+}
+
+func (s *server) GetScheduledMessages() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+
+		scheduled, err := s.Schedule.GetScheduledMessages(parsed["UserID"].(string), parsed["WorkspaceID"].(string))
+		if err != nil {
+			return &serverError{err, "Unable to get scheduled messages", http.StatusBadRequest}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(scheduled)
+		return nil
+	}
+}
+
+func (s *server) ScheduleMessage() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		var msg sidebar.ScheduledMessage
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			return &serverError{err, "Unable to decode payload", http.StatusBadRequest}
+		}
+
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+		msg.UserID = parsed["UserID"].(string)
+		msg.WorkspaceID = parsed["WorkspaceID"].(string)
+
+		scheduled, err := s.Schedule.ScheduleMessage(&msg)
+		if err != nil {
+			return &serverError{err, "Unable to schedule message", http.StatusBadRequest}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(scheduled)
+		return nil
+	}
+}
+
+func (s *server) UpdateScheduledMessage() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		var msg sidebar.ScheduledMessage
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			return &serverError{err, "Unable to decode payload", http.StatusBadRequest}
+		}
+
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+		msg.ID = mux.Vars(r)["id"]
+		msg.UserID = parsed["UserID"].(string)
+		msg.WorkspaceID = parsed["WorkspaceID"].(string)
+
+		scheduled, err := s.Schedule.UpdateScheduledMessage(&msg)
+		if err != nil {
+			return &serverError{err, "Unable to update scheduled message", http.StatusBadRequest}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(scheduled)
+		return nil
+	}
+}
+
+func (s *server) CancelScheduledMessage() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+
+		err := s.Schedule.CancelScheduledMessage(mux.Vars(r)["id"], parsed["UserID"].(string), parsed["WorkspaceID"].(string))
+		if err != nil {
+			return &serverError{err, "Unable to cancel scheduled message", http.StatusBadRequest}
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "Success")
+		return nil
+	}
+}
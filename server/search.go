@@ -0,0 +1,89 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"github.com/tmitchel/sidebar"
+)
+
+// SearchMessages finds messages in the user's channels. The q query parameter is
+// the text to search for. Results can be filtered with the channel, author,
+// since, until, sidebars, and resolved parameters, and paged with offset
+// and limit.
+func (s *server) SearchMessages() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+
+		query, err := searchQuery(r.URL.Query())
+		if err != nil {
+			return &serverError{err, "Invalid search", http.StatusBadRequest}
+		}
+		query.UserID = parsed["UserID"].(string)
+		query.WorkspaceID = parsed["WorkspaceID"].(string)
+
+		page, err := s.Find.Search(query)
+		if err != nil {
+			return &serverError{err, "Unable to search messages", http.StatusBadRequest}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(page)
+		return nil
+	}
+}
+
+func searchQuery(q url.Values) (*sidebar.SearchQuery, error) {
+	query := &sidebar.SearchQuery{
+		Text:    q.Get("q"),
+		Channel: q.Get("channel"),
+		Author:  q.Get("author"),
+	}
+
+	var err error
+	if since := q.Get("since"); since != "" {
+		if query.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return nil, errors.Wrap(err, "Invalid since")
+		}
+	}
+
+	if until := q.Get("until"); until != "" {
+		if query.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return nil, errors.Wrap(err, "Invalid until")
+		}
+	}
+
+	if sidebars := q.Get("sidebars"); sidebars != "" {
+		if query.SidebarsOnly, err = strconv.ParseBool(sidebars); err != nil {
+			return nil, errors.Wrap(err, "Invalid sidebars")
+		}
+	}
+
+	if resolved := q.Get("resolved"); resolved != "" {
+		b, err := strconv.ParseBool(resolved)
+		if err != nil {
+			return nil, errors.Wrap(err, "Invalid resolved")
+		}
+		query.Resolved = &b
+	}
+
+	if offset := q.Get("offset"); offset != "" {
+		if query.Offset, err = strconv.Atoi(offset); err != nil {
+			return nil, errors.Wrap(err, "Invalid offset")
+		}
+	}
+
+	if limit := q.Get("limit"); limit != "" {
+		if query.Limit, err = strconv.Atoi(limit); err != nil {
+			return nil, errors.Wrap(err, "Invalid limit")
+		}
+	}
+
+	return query, nil
+}
@@ -3,8 +3,11 @@ package server
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	jwtmiddleware "github.com/auth0/go-jwt-middleware"
@@ -37,6 +40,15 @@ func (fn errHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// failure reports a failed request, telling the client when it failed
+// because something it asked for is already taken.
+func failure(err error, msg string, status int) *serverError {
+	if errors.Cause(err) == sidebar.ErrConflict {
+		return &serverError{err, err.Error(), http.StatusConflict}
+	}
+	return &serverError{err, msg, status}
+}
+
 var refreshKey, accessKey []byte
 
 func init() {
@@ -45,33 +57,63 @@ func init() {
 }
 
 type server struct {
-	hub    *chathub
-	router *mux.Router
+	hub     *chathub
+	router  *mux.Router
+	limiter *rateLimiter
+	unfurls chan unfurlJob
+
+	// proxies allowed to set X-Forwarded-For
+	proxies []*net.IPNet
 
 	// services
-	Auth   sidebar.Authenticater
-	Create sidebar.Creater
-	Delete sidebar.Deleter
-	Add    sidebar.Adder
-	Get    sidebar.Getter
-	Up     sidebar.Updater
+	Auth     sidebar.Authenticater
+	Create   sidebar.Creater
+	Delete   sidebar.Deleter
+	Add      sidebar.Adder
+	Get      sidebar.Getter
+	Up       sidebar.Updater
+	SSO      sidebar.SingleSignOner
+	Tokens   sidebar.TokenManager
+	Audit    sidebar.Auditor
+	Notify   sidebar.Notifier
+	Find     sidebar.Searcher
+	Files    sidebar.Attacher
+	Unfurl   sidebar.Unfurler
+	Pins     sidebar.Pinner
+	Saved    sidebar.Saver
+	Schedule sidebar.Scheduler
 }
 
 // NewServer receives all services needed to provide functionality
 // then uses those services to spin-up an HTTP server. A hub for
 // handling Websocket connections is also started in a goroutine.
 // These things are wrapped in the server and returned.
-func NewServer(auth sidebar.Authenticater, create sidebar.Creater, delete sidebar.Deleter, add sidebar.Adder, get sidebar.Getter, up sidebar.Updater) *server {
+func NewServer(auth sidebar.Authenticater, create sidebar.Creater, delete sidebar.Deleter, add sidebar.Adder, get sidebar.Getter, up sidebar.Updater,
+	sso sidebar.SingleSignOner, tokens sidebar.TokenManager, audit sidebar.Auditor,
+	notify sidebar.Notifier, search sidebar.Searcher, files sidebar.Attacher, unfurl sidebar.Unfurler,
+	pins sidebar.Pinner, saved sidebar.Saver, schedule sidebar.Scheduler) *server {
 	hub := newChathub()
 
 	s := &server{
-		hub:    hub,
-		Auth:   auth,
-		Create: create,
-		Delete: delete,
-		Add:    add,
-		Get:    get,
-		Up:     up,
+		hub:      hub,
+		limiter:  newRateLimiter(),
+		unfurls:  make(chan unfurlJob, unfurlQueueSize),
+		Auth:     auth,
+		Create:   create,
+		Delete:   delete,
+		Add:      add,
+		Get:      get,
+		Up:       up,
+		SSO:      sso,
+		Tokens:   tokens,
+		Audit:    audit,
+		Notify:   notify,
+		Find:     search,
+		Files:    files,
+		Unfurl:   unfurl,
+		Pins:     pins,
+		Saved:    saved,
+		Schedule: schedule,
 	}
 
 	jwtMiddleware := jwtmiddleware.New(jwtmiddleware.Options{
@@ -85,60 +127,139 @@ func NewServer(auth sidebar.Authenticater, create sidebar.Creater, delete sideba
 	router := mux.NewRouter().StrictSlash(true)
 	apiBase := mux.NewRouter()
 	router.PathPrefix("/api").Handler(negroni.New(
-		negroni.HandlerFunc(jwtMiddleware.HandlerWithNext),
+		negroni.HandlerFunc(s.authenticate(jwtMiddleware)),
 		negroni.Wrap(apiBase),
 	))
 	apiRouter := apiBase.PathPrefix("/api").Subrouter()
+	apiRouter.Use(checkScopes, s.apiRateLimit)
 
-	apiRouter.Handle("/channels", s.GetChannels()).Methods("GET")
-	apiRouter.Handle("/sidebars", s.GetSidebars()).Methods("GET")
-	apiRouter.Handle("/messages", s.GetMessages()).Methods("GET")
-	apiRouter.Handle("/users", s.GetUsers()).Methods("GET")
+	apiRouter.Handle("/channels", scoped{sidebar.ScopeReadMessages, s.GetChannels()}).Methods("GET")
+	apiRouter.Handle("/channels/archived", scoped{sidebar.ScopeReadMessages, s.GetArchivedChannels()}).Methods("GET")
+	apiRouter.Handle("/sidebars", scoped{sidebar.ScopeReadMessages, s.GetSidebars()}).Methods("GET")
+	apiRouter.Handle("/messages", scoped{sidebar.ScopeReadMessages, s.GetMessages()}).Methods("GET")
+	apiRouter.Handle("/users", scoped{sidebar.ScopeReadMessages, s.GetUsers()}).Methods("GET")
 
-	apiRouter.Handle("/load_channel/{id}", s.LoadChannel()).Methods("GET")
+	apiRouter.Handle("/load_channel/{id}", scoped{sidebar.ScopeReadMessages, s.LoadChannel()}).Methods("GET")
 	apiRouter.Handle("/load_user/{id}", s.LoadUser()).Methods("GET")
 
-	apiRouter.Handle("/channel/{id}", s.GetChannel()).Methods("GET")
-	apiRouter.Handle("/message/{id}", s.GetMessage()).Methods("GET")
-	apiRouter.Handle("/user/{id}", s.GetUser()).Methods("GET")
-
-	apiRouter.Handle("/channels/{user}", s.GetChannelsForUser()).Methods("GET")
-	apiRouter.Handle("/sidebars/{user}", s.GetSidebarsForUser()).Methods("GET")
-	apiRouter.Handle("/messages/{to_user}", s.GetMessagesToUser()).Methods("GET")
-	apiRouter.Handle("/messages/{from_user}", s.GetMessagesFromUser()).Methods("GET")
-	apiRouter.Handle("/messages/{channel}", s.GetMessagesInChannel()).Methods("GET")
-	apiRouter.Handle("/users/{channel}", s.GetUsersInChannel()).Methods("GET")
-
-	apiRouter.Handle("/channel", s.CreateChannel()).Methods("POST")
-	apiRouter.Handle("/sidebar/{parent_id}/{user_id}", s.CreateSidebar()).Methods("POST")
-	apiRouter.Handle("/direct/{to_id}", s.CreateDirect()).Methods("POST")
-	apiRouter.Handle("/message", s.CreateMessage()).Methods("POST")
+	apiRouter.Handle("/channel/{id}", scoped{sidebar.ScopeReadMessages, s.GetChannel()}).Methods("GET")
+	apiRouter.Handle("/message/{id}", scoped{sidebar.ScopeReadMessages, s.GetMessage()}).Methods("GET")
+	apiRouter.Handle("/user/{id}", scoped{sidebar.ScopeReadMessages, s.GetUser()}).Methods("GET")
+
+	apiRouter.Handle("/channels/{user}", scoped{sidebar.ScopeReadMessages, s.GetChannelsForUser()}).Methods("GET")
+	apiRouter.Handle("/sidebars/{user}", scoped{sidebar.ScopeReadMessages, s.GetSidebarsForUser()}).Methods("GET")
+	apiRouter.Handle("/messages/{to_user}", scoped{sidebar.ScopeReadMessages, s.GetMessagesToUser()}).Methods("GET")
+	apiRouter.Handle("/messages/{from_user}", scoped{sidebar.ScopeReadMessages, s.GetMessagesFromUser()}).Methods("GET")
+	apiRouter.Handle("/messages/{channel}", scoped{sidebar.ScopeReadMessages, s.GetMessagesInChannel()}).Methods("GET")
+	apiRouter.Handle("/users/{channel}", scoped{sidebar.ScopeReadMessages, s.GetUsersInChannel()}).Methods("GET")
+
+	apiRouter.Handle("/channel", scoped{sidebar.ScopeManageChannels, s.CreateChannel()}).Methods("POST")
+	apiRouter.Handle("/sidebar/{parent_id}/{user_id}", scoped{sidebar.ScopeManageChannels, s.CreateSidebar()}).Methods("POST")
+	apiRouter.Handle("/direct", scoped{sidebar.ScopePostMessages, s.CreateGroupDirect()}).Methods("POST")
+	apiRouter.Handle("/direct/{to_id}", scoped{sidebar.ScopePostMessages, s.CreateDirect()}).Methods("POST")
+	apiRouter.Handle("/direct/{id}/convert", scoped{sidebar.ScopeManageChannels, s.ConvertDirect()}).Methods("POST")
+	apiRouter.Handle("/message", scoped{sidebar.ScopePostMessages, s.CreateMessage()}).Methods("POST")
+	apiRouter.Handle("/message/{id}", scoped{sidebar.ScopePostMessages, s.UpdateMessage()}).Methods("POST")
+	apiRouter.Handle("/message/{id}", scoped{sidebar.ScopePostMessages, s.DeleteMessage()}).Methods("DELETE")
+	apiRouter.Handle("/message/{id}/revisions", s.GetMessageRevisions()).Methods("GET")
+	apiRouter.Handle("/message/{id}/reactions", scoped{sidebar.ScopePostMessages, s.AddReaction()}).Methods("POST")
+	apiRouter.Handle("/message/{id}/reactions/{emoji}", scoped{sidebar.ScopePostMessages, s.RemoveReaction()}).Methods("DELETE")
+	apiRouter.Handle("/message/{id}/pin", scoped{sidebar.ScopePostMessages, s.PinMessage()}).Methods("POST")
+	apiRouter.Handle("/message/{id}/pin", scoped{sidebar.ScopePostMessages, s.UnpinMessage()}).Methods("DELETE")
+	apiRouter.Handle("/channel/{id}/pins", scoped{sidebar.ScopeReadMessages, s.GetPins()}).Methods("GET")
+	apiRouter.Handle("/channel/{id}/pins/order", scoped{sidebar.ScopePostMessages, s.ReorderPins()}).Methods("POST")
+	apiRouter.Handle("/channel/{id}/bookmarks", scoped{sidebar.ScopeReadMessages, s.GetBookmarks()}).Methods("GET")
+	apiRouter.Handle("/channel/{id}/bookmarks", scoped{sidebar.ScopeManageChannels, s.AddBookmark()}).Methods("POST")
+	apiRouter.Handle("/channel/{id}/bookmarks/order", scoped{sidebar.ScopeManageChannels, s.ReorderBookmarks()}).Methods("POST")
+	apiRouter.Handle("/bookmarks/{id}", scoped{sidebar.ScopeManageChannels, s.RemoveBookmark()}).Methods("DELETE")
+	apiRouter.Handle("/attachments", scoped{sidebar.ScopePostMessages, s.Upload()}).Methods("POST")
+	apiRouter.Handle("/attachments/{id}", scoped{sidebar.ScopeReadMessages, s.Download()}).Methods("GET")
+	apiRouter.Handle("/search", scoped{sidebar.ScopeReadMessages, s.SearchMessages()}).Methods("GET")
+	apiRouter.Handle("/mentions", scoped{sidebar.ScopeReadMessages, s.GetMentions()}).Methods("GET")
+	apiRouter.Handle("/read/{channel}", scoped{sidebar.ScopeReadMessages, s.UpdateReadMarker()}).Methods("POST")
+	apiRouter.Handle("/mentions/read/{channel}", scoped{sidebar.ScopeReadMessages, s.MarkMentionsRead()}).Methods("POST")
+	apiRouter.Handle("/thread/{id}", scoped{sidebar.ScopeReadMessages, s.GetThread()}).Methods("GET")
+	apiRouter.Handle("/thread/{id}/promote", scoped{sidebar.ScopeManageChannels, s.PromoteThread()}).Methods("POST")
 
 	apiRouter.Handle("/update-userinfo", s.UpdateUserInfo()).Methods("POST")
+	apiRouter.Handle("/profile/fields", s.GetProfileFields()).Methods("GET")
+	apiRouter.Handle("/profile/fields", s.CreateProfileField()).Methods("POST")
+	apiRouter.Handle("/profile/fields/{id}", s.DeleteProfileField()).Methods("DELETE")
 	apiRouter.Handle("/update-userpass", s.UpdateUserPassword()).Methods("POST")
-	apiRouter.Handle("/update-channelinfo", s.UpdateChannelInfo()).Methods("POST")
-
-	apiRouter.Handle("/add/{channel}", s.AddUserToChannel()).Methods("POST")
-	apiRouter.Handle("/leave/{channel}", s.RemoveUserFromChannel()).Methods("DELETE")
-	apiRouter.Handle("/resolve/{channel_id}", s.ResolveSidebar()).Methods("POST")
-
-	apiRouter.Handle("/channel", s.DeleteChannel()).Methods("DELETE")
+	apiRouter.Handle("/update-channelinfo", scoped{sidebar.ScopeManageChannels, s.UpdateChannelInfo()}).Methods("POST")
+
+	apiRouter.Handle("/add/{channel}", scoped{sidebar.ScopeManageChannels, s.AddUserToChannel()}).Methods("POST")
+	apiRouter.Handle("/add/{channel}/{user}", scoped{sidebar.ScopeManageChannels, s.AddOtherUserToChannel()}).Methods("POST")
+	apiRouter.Handle("/leave/{channel}", scoped{sidebar.ScopeManageChannels, s.RemoveUserFromChannel()}).Methods("DELETE")
+	apiRouter.Handle("/resolve/{channel_id}", scoped{sidebar.ScopeManageChannels, s.ResolveSidebar()}).Methods("POST")
+	apiRouter.Handle("/channel/{id}/topic", scoped{sidebar.ScopeManageChannels, s.ChangeChannelField(sidebar.FieldTopic)}).Methods("POST")
+	apiRouter.Handle("/channel/{id}/purpose", scoped{sidebar.ScopeManageChannels, s.ChangeChannelField(sidebar.FieldPurpose)}).Methods("POST")
+	apiRouter.Handle("/channel/{id}/changes", scoped{sidebar.ScopeReadMessages, s.GetChannelChanges()}).Methods("GET")
+	apiRouter.Handle("/channel/{id}/archive", scoped{sidebar.ScopeManageChannels, s.ArchiveChannel()}).Methods("POST")
+	apiRouter.Handle("/channel/{id}/unarchive", scoped{sidebar.ScopeManageChannels, s.UnarchiveChannel()}).Methods("POST")
+
+	apiRouter.Handle("/channel", scoped{sidebar.ScopeManageChannels, s.DeleteChannel()}).Methods("DELETE")
 	apiRouter.Handle("/user", s.DeleteUser()).Methods("DELETE")
 
+	apiRouter.Handle("/sso", s.GetSSOConfig()).Methods("GET")
+	apiRouter.Handle("/sso", s.UpdateSSOConfig()).Methods("POST")
+	apiRouter.Handle("/sso/link", s.SSOLink()).Methods("POST")
+
+	apiRouter.Handle("/unlock", s.Unlock()).Methods("POST")
+	apiRouter.Handle("/notifications", s.GetNotificationPrefs()).Methods("GET")
+	apiRouter.Handle("/notifications", s.UpdateNotificationPrefs()).Methods("POST")
+
+	apiRouter.Handle("/saved", s.GetSavedItems()).Methods("GET")
+	apiRouter.Handle("/saved/{id}", s.SaveMessage()).Methods("POST")
+	apiRouter.Handle("/saved/{id}", s.UnsaveMessage()).Methods("DELETE")
+	apiRouter.Handle("/reminders", s.GetReminders()).Methods("GET")
+	apiRouter.Handle("/reminders", s.CreateReminder()).Methods("POST")
+	apiRouter.Handle("/reminders/{id}", s.DeleteReminder()).Methods("DELETE")
+	apiRouter.Handle("/scheduled", scoped{sidebar.ScopeReadMessages, s.GetScheduledMessages()}).Methods("GET")
+	apiRouter.Handle("/scheduled", scoped{sidebar.ScopePostMessages, s.ScheduleMessage()}).Methods("POST")
+	apiRouter.Handle("/scheduled/{id}", scoped{sidebar.ScopePostMessages, s.UpdateScheduledMessage()}).Methods("POST")
+	apiRouter.Handle("/scheduled/{id}", scoped{sidebar.ScopePostMessages, s.CancelScheduledMessage()}).Methods("DELETE")
+
+	apiRouter.Handle("/settings", s.GetWorkspaceSettings()).Methods("GET")
+	apiRouter.Handle("/settings", s.UpdateWorkspaceSettings()).Methods("POST")
+	apiRouter.Handle("/settings/unfurl", s.GetUnfurlSettings()).Methods("GET")
+	apiRouter.Handle("/settings/unfurl", s.UpdateUnfurlSettings()).Methods("POST")
+
+	apiRouter.Handle("/audit", s.GetAuditLog()).Methods("GET")
+	apiRouter.Handle("/audit/export", s.ExportAuditLog()).Methods("GET")
+
+	apiRouter.Handle("/tokens", s.GetTokens()).Methods("GET")
+	apiRouter.Handle("/tokens", s.CreateToken()).Methods("POST")
+	apiRouter.Handle("/tokens/{id}", s.RevokeToken()).Methods("DELETE")
+	apiRouter.Handle("/bots", s.GetBots()).Methods("GET")
+	apiRouter.Handle("/bots", s.CreateBot()).Methods("POST")
+
 	apiRouter.Handle("/online_users", s.OnlineUsers()).Methods("GET")
-	apiRouter.Handle("/ws", s.HandleWS())
+	apiRouter.Handle("/ws", scoped{sidebar.ScopeReadMessages, s.HandleWS()})
 
 	// unprotected
 	router.Handle("/workspaces", s.GetWorkspaces()).Methods("GET")
 	router.Handle("/user", s.CreateUser()).Methods("POST")
 	router.Handle("/login", s.Login()).Methods("POST")
 	router.Handle("/refresh_token", s.RefreshToken()).Methods("POST")
+	router.Handle("/sso/login", s.SSOLogin()).Methods("GET")
+	router.Handle("/sso/callback", s.SSOCallback()).Methods("POST")
+	router.Handle("/avatars/{seed}", s.Identicon()).Methods("GET")
 	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, "views/home.html")
 	}).Methods("GET")
 
+	router.Use(s.rateLimit)
+
 	s.router = router
 	go s.hub.run()
+	for i := 0; i < unfurlWorkers; i++ {
+		go s.runUnfurls()
+	}
+	go s.runReminders()
+	go s.runSchedules()
+	go s.runPurges()
+	go s.runStatusExpiry()
 	return s
 }
 
@@ -149,7 +270,7 @@ func (s *server) Serve() http.Handler {
 	c := cors.New(cors.Options{
 		AllowedOrigins:   []string{"http://localhost:8081", "https://sidebar-frontend.now.sh"},
 		AllowedHeaders:   []string{"Origin", "Content-Type", "Authorization", "Set-Cookie"},
-		ExposedHeaders:   []string{"set-cookie"},
+		ExposedHeaders:   []string{"set-cookie", "Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset"},
 		AllowedMethods:   []string{http.MethodGet, http.MethodPost, http.MethodDelete},
 		AllowCredentials: true,
 	})
@@ -197,34 +318,37 @@ func (s *server) UpdateUserPassword() errHandler {
 
 func (s *server) UpdateUserInfo() errHandler {
 	return func(w http.ResponseWriter, r *http.Request) *serverError {
-		var reqUser sidebar.User
-		if err := json.NewDecoder(r.Body).Decode(&reqUser); err != nil {
+		var req sidebar.UserUpdate
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			return &serverError{err, "Unable to decode payload", http.StatusBadRequest}
 		}
 
 		token := r.Context().Value("user").(*jwt.Token)
 		parsed := token.Claims.(jwt.MapClaims)
 
-		if reqUser.ID != parsed["UserID"].(string) {
+		if req.ID != parsed["UserID"].(string) {
 			return &serverError{
-				errors.Errorf("Request user doesn't match current user. Current: %v Request: %v", parsed["UserID"].(string), reqUser.ID),
+				errors.Errorf("Request user doesn't match current user. Current: %v Request: %v", parsed["UserID"].(string), req.ID),
 				"Request user doesn't match current user.",
 				http.StatusBadRequest,
 			}
 		}
 
-		err := s.Up.UpdateUserInfo(&reqUser)
+		wid := parsed["WorkspaceID"].(string)
+		user, err := s.Up.UpdateUserInfo(&req, wid)
 		if err != nil {
-			return &serverError{err, "Error updating user info", http.StatusBadRequest}
+			return failure(err, "Error updating user info", http.StatusBadRequest)
 		}
 
-		newUser, err := s.Get.GetUser(reqUser.ID)
-		if err != nil {
-			return &serverError{err, "Error getting updated user", http.StatusBadRequest}
+		if req.Status != nil {
+			s.sendToWorkspace(wid, sidebar.WebsocketMessage{
+				Type:    "status",
+				Payload: sidebar.StatusUpdate{UserID: user.ID, WorkspaceID: wid, Status: user.Status},
+			})
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(newUser)
+		json.NewEncoder(w).Encode(user)
 		return nil
 	}
 }
@@ -258,9 +382,9 @@ func (s *server) UpdateChannelInfo() errHandler {
 			return &serverError{err, "Cannot update channel that you aren't a part of", http.StatusBadRequest}
 		}
 
-		err = s.Up.UpdateChannelInfo(&reqChannel)
+		err = s.Up.UpdateChannelInfo(&reqChannel, id, wid)
 		if err != nil {
-			return &serverError{err, "Error updating channel info", http.StatusBadRequest}
+			return failure(err, "Error updating channel info", http.StatusBadRequest)
 		}
 
 		newChannel, err := s.Get.GetChannel(reqChannel.ID)
@@ -277,23 +401,35 @@ func (s *server) UpdateChannelInfo() errHandler {
 func (s *server) LoadChannel() errHandler {
 	return func(w http.ResponseWriter, r *http.Request) *serverError {
 		reqID := mux.Vars(r)["id"]
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+		wid := parsed["WorkspaceID"].(string)
+
+		// this checks the user can read the channel before anything
+		// about it is loaded
+		messages, err := s.Get.GetMessagesInChannel(reqID, parsed["UserID"].(string), wid)
+		if err != nil {
+			return &serverError{err, "Unable to get messages for channel", http.StatusForbidden}
+		}
+
 		channel, err := s.Get.GetChannel(reqID)
 		if err != nil {
 			return &serverError{err, "Unable to get channel id from request param", http.StatusInternalServerError}
 		}
 
-		token := r.Context().Value("user").(*jwt.Token)
-		parsed := token.Claims.(jwt.MapClaims)
-		wid := parsed["WorkspaceID"].(string)
-
 		users, err := s.Get.GetUsers(wid)
 		if err != nil {
 			return &serverError{err, "Unable to get users for channel", http.StatusInternalServerError}
 		}
 
-		messages, err := s.Get.GetMessagesInChannel(reqID, wid)
+		pins, err := s.Pins.GetPins(reqID, parsed["UserID"].(string), wid)
 		if err != nil {
-			return &serverError{err, "Unable to get messages for channel", http.StatusInternalServerError}
+			return &serverError{err, "Unable to get pins for channel", http.StatusInternalServerError}
+		}
+
+		bookmarks, err := s.Pins.GetBookmarks(reqID, parsed["UserID"].(string), wid)
+		if err != nil {
+			return &serverError{err, "Unable to get bookmarks for channel", http.StatusInternalServerError}
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -301,6 +437,8 @@ func (s *server) LoadChannel() errHandler {
 			Channel:           *channel,
 			UsersInChannel:    users,
 			MessagesInChannel: messages,
+			Pins:              pins,
+			Bookmarks:         bookmarks,
 		})
 		return nil
 	}
@@ -308,17 +446,17 @@ func (s *server) LoadChannel() errHandler {
 
 func (s *server) LoadUser() errHandler {
 	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+		wid := parsed["WorkspaceID"].(string)
+
 		reqID := mux.Vars(r)["id"]
-		user, err := s.Get.GetUser(reqID)
+		user, err := s.Get.GetUser(reqID, wid)
 		if err != nil {
 			return &serverError{err, "Unable to get user id from request param", http.StatusInternalServerError}
 		}
 
-		token := r.Context().Value("user").(*jwt.Token)
-		parsed := token.Claims.(jwt.MapClaims)
-		wid := parsed["WorkspaceID"].(string)
-
-		allChannels, err := s.Get.GetChannels(wid)
+		allChannels, err := s.Get.GetChannels(parsed["UserID"].(string), wid)
 		if err != nil {
 			return &serverError{err, "Unable to get all channels", http.StatusInternalServerError}
 		}
@@ -334,6 +472,8 @@ func (s *server) LoadUser() errHandler {
 			matched = false
 			for _, cc := range channelsForUser {
 				if c.ID == cc.ID {
+					// use the user's copy so unread counts are included
+					c = cc
 					matched = true
 					break
 				}
@@ -431,7 +571,7 @@ func (s *server) GetMessagesToUser() errHandler {
 		parsed := token.Claims.(jwt.MapClaims)
 		wid := parsed["WorkspaceID"].(string)
 
-		messages, err := s.Get.GetMessagesToUser(userID, wid)
+		messages, err := s.Get.GetMessagesToUser(userID, parsed["UserID"].(string), wid)
 		if err != nil {
 			return &serverError{err, "Error getting messages to the user", http.StatusBadRequest}
 		}
@@ -449,7 +589,7 @@ func (s *server) GetMessagesFromUser() errHandler {
 		parsed := token.Claims.(jwt.MapClaims)
 		wid := parsed["WorkspaceID"].(string)
 
-		messages, err := s.Get.GetMessagesFromUser(userID, wid)
+		messages, err := s.Get.GetMessagesFromUser(userID, parsed["UserID"].(string), wid)
 		if err != nil {
 			return &serverError{err, "Error getting messages from the user", http.StatusBadRequest}
 		}
@@ -467,7 +607,7 @@ func (s *server) GetMessagesInChannel() errHandler {
 		parsed := token.Claims.(jwt.MapClaims)
 		wid := parsed["WorkspaceID"].(string)
 
-		messages, err := s.Get.GetMessagesInChannel(channelID, wid)
+		messages, err := s.Get.GetMessagesInChannel(channelID, parsed["UserID"].(string), wid)
 		if err != nil {
 			return &serverError{err, "Error getting messages in the channel", http.StatusBadRequest}
 		}
@@ -486,7 +626,7 @@ func (s *server) AddUserToChannel() errHandler {
 		userID := parsed["UserID"].(string)
 		wid := parsed["WorkspaceID"].(string)
 
-		if err := s.Add.AddUserToChannel(userID, channelID, wid); err != nil {
+		if err := s.Add.AddUserToChannel(userID, channelID, wid, userID); err != nil {
 			return &serverError{err, "Unable to add user to channel", http.StatusInternalServerError}
 		}
 
@@ -518,8 +658,11 @@ func (s *server) RemoveUserFromChannel() errHandler {
 
 func (s *server) GetUser() errHandler {
 	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+
 		reqID := mux.Vars(r)["id"]
-		user, err := s.Get.GetUser(reqID)
+		user, err := s.Get.GetUser(reqID, parsed["WorkspaceID"].(string))
 		if err != nil {
 			return &serverError{err, "Unable to get user id from request param", http.StatusInternalServerError}
 		}
@@ -547,7 +690,10 @@ func (s *server) GetChannel() errHandler {
 func (s *server) GetMessage() errHandler {
 	return func(w http.ResponseWriter, r *http.Request) *serverError {
 		reqID := mux.Vars(r)["id"]
-		message, err := s.Get.GetMessage(reqID)
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+
+		message, err := s.Get.GetMessage(reqID, parsed["UserID"].(string), parsed["WorkspaceID"].(string))
 		if err != nil {
 			return &serverError{err, "Unable to get message id from request param", http.StatusInternalServerError}
 		}
@@ -586,7 +732,7 @@ func (s *server) GetChannels() errHandler {
 		parsed := token.Claims.(jwt.MapClaims)
 		wid := parsed["WorkspaceID"].(string)
 
-		channels, err := s.Get.GetChannels(wid)
+		channels, err := s.Get.GetChannels(parsed["UserID"].(string), wid)
 		if err != nil {
 			return &serverError{err, "Unable to get channels", http.StatusInternalServerError}
 		}
@@ -603,7 +749,7 @@ func (s *server) GetSidebars() errHandler {
 		parsed := token.Claims.(jwt.MapClaims)
 		wid := parsed["WorkspaceID"].(string)
 
-		channels, err := s.Get.GetChannels(wid)
+		channels, err := s.Get.GetChannels(parsed["UserID"].(string), wid)
 		if err != nil {
 			return &serverError{err, "Unable to get sidebars", http.StatusInternalServerError}
 		}
@@ -627,7 +773,7 @@ func (s *server) GetMessages() errHandler {
 		parsed := token.Claims.(jwt.MapClaims)
 		wid := parsed["WorkspaceID"].(string)
 
-		messages, err := s.Get.GetMessages(wid)
+		messages, err := s.Get.GetMessages(parsed["UserID"].(string), wid)
 		if err != nil {
 			return &serverError{err, "Unable to get messages", http.StatusInternalServerError}
 		}
@@ -648,12 +794,13 @@ func (s *server) CreateChannel() errHandler {
 		token := r.Context().Value("user").(*jwt.Token)
 		parsed := token.Claims.(jwt.MapClaims)
 		wid := parsed["WorkspaceID"].(string)
+		uid := parsed["UserID"].(string)
 
 		logrus.Infof("%+v\n%+v", reqChannel, parsed)
 
-		channel, err := s.Create.CreateChannel(&reqChannel, wid)
+		channel, err := s.Create.CreateChannel(&reqChannel, uid, wid)
 		if err != nil {
-			return &serverError{err, "Unable to create channel", http.StatusInternalServerError}
+			return failure(err, "Unable to create channel", http.StatusInternalServerError)
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -662,35 +809,70 @@ func (s *server) CreateChannel() errHandler {
 	}
 }
 
+// CreateDirect opens the direct conversation with the user in the route.
+// The existing channel is returned if they've talked before.
 func (s *server) CreateDirect() errHandler {
 	return func(w http.ResponseWriter, r *http.Request) *serverError {
-		var reqChannel sidebar.Channel
-		if err := json.NewDecoder(r.Body).Decode(&reqChannel); err != nil {
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+
+		channel, err := s.Create.CreateDirect([]string{mux.Vars(r)["to_id"]}, parsed["UserID"].(string), parsed["WorkspaceID"].(string))
+		if err != nil {
+			return failure(err, "Unable to create direct channel", http.StatusBadRequest)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(channel)
+		return nil
+	}
+}
+
+// CreateGroupDirect opens the direct conversation with every user in the
+// payload.
+func (s *server) CreateGroupDirect() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		var payload DirectRequest
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 			return &serverError{err, "Unable to decode payload", http.StatusBadRequest}
 		}
 
 		token := r.Context().Value("user").(*jwt.Token)
 		parsed := token.Claims.(jwt.MapClaims)
-		wid := parsed["WorkspaceID"].(string)
-		fromID := parsed["UserID"].(string)
-		toID := mux.Vars(r)["to_id"]
 
-		reqChannel.Direct = true
-		channel, err := s.Create.CreateChannel(&reqChannel, wid)
+		channel, err := s.Create.CreateDirect(payload.Users, parsed["UserID"].(string), parsed["WorkspaceID"].(string))
 		if err != nil {
-			return &serverError{err, "Unable to create direct channel", http.StatusInternalServerError}
+			return failure(err, "Unable to create direct channel", http.StatusBadRequest)
 		}
 
-		err = s.Add.AddUserToChannel(toID, channel.ID, wid)
-		if err != nil {
-			return &serverError{err, "Unable to add 'to' user to channel", http.StatusInternalServerError}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(channel)
+		return nil
+	}
+}
+
+// ConvertDirect turns a group direct conversation into a private channel
+// named in the payload.
+func (s *server) ConvertDirect() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		var reqChannel sidebar.Channel
+		if err := json.NewDecoder(r.Body).Decode(&reqChannel); err != nil {
+			return &serverError{err, "Unable to decode payload", http.StatusBadRequest}
 		}
 
-		err = s.Add.AddUserToChannel(fromID, channel.ID, wid)
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+		wid := parsed["WorkspaceID"].(string)
+
+		channel, err := s.Create.ConvertDirect(mux.Vars(r)["id"], reqChannel.Name, parsed["UserID"].(string), wid)
 		if err != nil {
-			return &serverError{err, "Unable to add 'from' user to channel", http.StatusInternalServerError}
+			return failure(err, "Unable to convert direct channel", http.StatusBadRequest)
 		}
 
+		s.sendToChannel(channel.ID, wid, sidebar.WebsocketMessage{
+			Type:    "channel-update",
+			Payload: sidebar.ChannelUpdate{Channel: *channel, Message: "converted to a private channel"},
+		})
+
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(channel)
 		return nil
@@ -707,13 +889,14 @@ func (s *server) CreateSidebar() errHandler {
 		token := r.Context().Value("user").(*jwt.Token)
 		parsed := token.Claims.(jwt.MapClaims)
 		wid := parsed["WorkspaceID"].(string)
+		uid := parsed["UserID"].(string)
 
 		reqChannel.IsSidebar = true
 		reqChannel.Parent = mux.Vars(r)["parent_id"]
 
-		channel, err := s.Create.CreateChannel(&reqChannel, wid)
+		channel, err := s.Create.CreateChannel(&reqChannel, uid, wid)
 		if err != nil {
-			return &serverError{err, "Unable to create sidebar", http.StatusInternalServerError}
+			return failure(err, "Unable to create sidebar", http.StatusInternalServerError)
 		}
 
 		members, err := s.Get.GetUsersInChannel(reqChannel.Parent, wid)
@@ -722,7 +905,7 @@ func (s *server) CreateSidebar() errHandler {
 		}
 
 		for _, member := range members {
-			err = s.Add.AddUserToChannel(member.ID, channel.ID, wid)
+			err = s.Add.AddUserToChannel(member.ID, channel.ID, wid, uid)
 			if err != nil {
 				return &serverError{err, "Unable to add user to sidebar", http.StatusInternalServerError}
 			}
@@ -750,7 +933,7 @@ func (s *server) CreateUser() errHandler {
 		}
 		user, err := s.Create.CreateUser(&converted)
 		if err != nil {
-			return &serverError{err, "Unable to create user", http.StatusInternalServerError}
+			return failure(err, "Unable to create user", http.StatusInternalServerError)
 		}
 
 		ws, err := s.Get.GetDefaultWorkspace()
@@ -811,17 +994,21 @@ func (s *server) CreateMessage() errHandler {
 			return &serverError{err, "Unable to decode payload", http.StatusBadRequest}
 		}
 
-		uid := r.Context().Value("user").(*jwt.Token).Claims.(jwt.MapClaims)["UserID"].(string)
-		msg.FromUser = uid
-		send, err := s.Create.CreateMessage(&msg)
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+		msg.FromUser = parsed["UserID"].(string)
+		wid := parsed["WorkspaceID"].(string)
+		send, err := s.Create.CreateMessage(&msg, wid)
 		if err != nil {
 			return &serverError{err, "Unable to save message", http.StatusBadRequest}
 		}
 
-		s.hub.broadcast <- sidebar.WebsocketMessage{
+		s.sendToChannel(send.Channel, wid, sidebar.WebsocketMessage{
 			Type:    "chat-message",
 			Payload: send,
-		}
+		})
+		s.sendAlerts(send, wid)
+		s.queueUnfurl(send, wid)
 		w.WriteHeader(http.StatusOK)
 		return nil
 	}
@@ -833,8 +1020,9 @@ func (s *server) ResolveSidebar() errHandler {
 		token := r.Context().Value("user").(*jwt.Token)
 		parsed := token.Claims.(jwt.MapClaims)
 		wid := parsed["WorkspaceID"].(string)
+		uid := parsed["UserID"].(string)
 
-		err := s.Add.ResolveChannel(sid, wid)
+		err := s.Add.ResolveChannel(sid, uid, wid)
 		if err != nil {
 			return &serverError{err, "Unable to resolve channel", http.StatusInternalServerError}
 		}
@@ -848,16 +1036,25 @@ func (s *server) ResolveSidebar() errHandler {
 
 func (s *server) DeleteChannel() errHandler {
 	return func(w http.ResponseWriter, r *http.Request) *serverError {
-		var reqID string
-		if err := json.NewDecoder(r.Body).Decode(&reqID); err != nil || reqID == "" {
+		var req DeleteChannelRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" {
 			return &serverError{err, "Unable to decode payload", http.StatusBadRequest}
 		}
 
-		channel, err := s.Delete.DeleteChannel(reqID)
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+		wid := parsed["WorkspaceID"].(string)
+
+		channel, err := s.Delete.DeleteChannel(req.ID, req.Confirm, parsed["UserID"].(string), wid)
 		if err != nil {
-			return &serverError{err, "Unable to delete channel", http.StatusInternalServerError}
+			return &serverError{err, "Unable to delete channel", http.StatusBadRequest}
 		}
 
+		s.sendToChannel(channel.ID, wid, sidebar.WebsocketMessage{
+			Type:    "channel-update",
+			Payload: sidebar.ChannelUpdate{Channel: *channel, Message: "scheduled for deletion"},
+		})
+
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(channel)
 		return nil
@@ -888,54 +1085,176 @@ func (s *server) Login() errHandler {
 			return &serverError{err, "Ill-formatted login attempt", http.StatusBadRequest}
 		}
 
-		user, err := s.Auth.Validate(auther.Email, auther.Password, auther.Workspace)
-		if err != nil || user == nil {
+		user, err := s.Auth.Validate(auther.Email, auther.Password, auther.Workspace, s.clientIP(r))
+		if lockout, ok := err.(*sidebar.LockoutError); ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(lockout.RetryAfter.Seconds())))
+			return &serverError{err, "Too many failed logins, try again later", http.StatusTooManyRequests}
+		} else if err != nil || user == nil {
 			return &serverError{err, "Incorrect username/password for this workspace", http.StatusForbidden}
 		}
 
-		// create access token
-		expiration := time.Now().Add(time.Minute * 10)
-		claims := &JWTToken{
-			UserID:        user.ID,
-			WorkspaceID:   auther.Workspace,
-			Authenticated: true,
-			StandardClaims: jwt.StandardClaims{
-				ExpiresAt: expiration.Unix(),
-			},
+		return s.issueTokens(w, user, auther.Workspace)
+	}
+}
+
+// Unlock lets workspace admins clear the failed logins for an account
+// that has been locked out.
+func (s *server) Unlock() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		var payload UnlockRequest
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			return &serverError{err, "Unable to decode payload", http.StatusBadRequest}
 		}
-		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(accessKey)
-		if err != nil {
-			return &serverError{err, "Unable to sign access token", http.StatusInternalServerError}
+
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+
+		if err := s.Auth.Unlock(payload.Email, parsed["UserID"].(string), parsed["WorkspaceID"].(string)); err != nil {
+			return &serverError{err, "Unable to unlock account", http.StatusForbidden}
 		}
 
-		// create refresh token
-		refreshToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"UserID": user.ID,
-		}).SignedString(refreshKey)
+		w.WriteHeader(http.StatusOK)
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "Success")
+		return nil
+	}
+}
+
+// ParseProxies parses a comma separated list of IP addresses and CIDR
+// ranges, like "10.0.0.0/8,192.168.1.5".
+func ParseProxies(list string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, errors.Errorf("Invalid proxy address %q", entry)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
 		if err != nil {
-			return &serverError{err, "Unable to sign refresh token", http.StatusInternalServerError}
+			return nil, errors.Wrapf(err, "Invalid proxy range %q", entry)
 		}
+		proxies = append(proxies, network)
+	}
 
-		// set refresh token in HTTP only cookie
-		http.SetCookie(w, &http.Cookie{
-			Name:     "sb_refresh_token",
-			Value:    refreshToken,
-			HttpOnly: true,
-			Secure:   true,
-			SameSite: http.SameSiteNoneMode,
-		})
+	return proxies, nil
+}
 
-		// send access token to client
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(struct {
-			Token string
-			User  sidebar.User
-		}{
-			Token: token,
-			User:  *user,
-		})
-		return nil
+// SetTrustedProxies sets the proxies that are trusted to report the
+// client's address in X-Forwarded-For. It has to be called before the
+// server starts handling requests.
+func (s *server) SetTrustedProxies(proxies []*net.IPNet) {
+	s.proxies = proxies
+}
+
+// clientIP returns the address of the client that made the request. The
+// X-Forwarded-For header is only used when the request came from a trusted
+// proxy. Each proxy appends the address it got the request from, so the
+// client is the last entry that isn't another trusted proxy.
+func (s *server) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	forwarded := r.Header.Get("X-Forwarded-For")
+	if forwarded == "" || !s.trusted(host) {
+		return host
+	}
+
+	ips := strings.Split(forwarded, ",")
+	for i := len(ips) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(ips[i])
+		if net.ParseIP(ip) == nil {
+			// anything before a bad entry can't be trusted either
+			return host
+		}
+
+		host = ip
+		if !s.trusted(ip) {
+			break
+		}
+	}
+
+	return host
+}
+
+// trusted checks if the address belongs to a trusted proxy.
+func (s *server) trusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, proxy := range s.proxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// issueTokens creates a short-lived access token for the user in the
+// given workspace and a refresh token that is stored in an HTTP only
+// cookie. The access token is sent to the client along with the user.
+func (s *server) issueTokens(w http.ResponseWriter, user *sidebar.User, wid string) *serverError {
+	// create access token
+	expiration := time.Now().Add(time.Minute * 10)
+	claims := &JWTToken{
+		UserID:        user.ID,
+		WorkspaceID:   wid,
+		Authenticated: true,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: expiration.Unix(),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(accessKey)
+	if err != nil {
+		return &serverError{err, "Unable to sign access token", http.StatusInternalServerError}
 	}
+
+	// create refresh token
+	refreshToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"UserID":      user.ID,
+		"WorkspaceID": wid,
+	}).SignedString(refreshKey)
+	if err != nil {
+		return &serverError{err, "Unable to sign refresh token", http.StatusInternalServerError}
+	}
+
+	// set refresh token in HTTP only cookie
+	http.SetCookie(w, &http.Cookie{
+		Name:     "sb_refresh_token",
+		Value:    refreshToken,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteNoneMode,
+	})
+
+	// send access token to client
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Token string
+		User  sidebar.User
+	}{
+		Token: token,
+		User:  *user,
+	})
+	return nil
 }
 
 // RefreshToken reads a refresh token from the HTTP only cookie.
@@ -999,23 +1318,29 @@ func (s *server) HandleWS() errHandler {
 		token := r.Context().Value("user").(*jwt.Token)
 		parsed := token.Claims.(jwt.MapClaims)
 
-		user, err := s.Get.GetUser(parsed["UserID"].(string))
+		user, err := s.Get.GetUser(parsed["UserID"].(string), parsed["WorkspaceID"].(string))
 		if err != nil {
 			return &serverError{err, "Unable to get user", http.StatusBadRequest}
 		}
 
 		cl := &client{
-			conn: conn,
-			send: make(chan sidebar.WebsocketMessage),
-			hub:  s.hub,
-			User: *user,
+			conn:      conn,
+			send:      make(chan sidebar.WebsocketMessage, 256),
+			hub:       s.hub,
+			handle:    s.handleCommand,
+			workspace: parsed["WorkspaceID"].(string),
+			User:      *user,
+		}
+
+		// API tokens are limited to their scopes, sessions aren't
+		if scopes, ok := parsed["Scopes"].([]string); ok {
+			cl.scopes = append([]string{}, scopes...)
 		}
 
 		s.hub.register <- cl
 
 		go cl.writePump()
-		// send messages via POST now
-		// go cl.readPump()
+		go cl.readPump()
 		w.WriteHeader(http.StatusOK)
 		return nil
 	}
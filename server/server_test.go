@@ -0,0 +1,66 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseProxies("10.0.0.0/8, 192.168.1.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{proxies: proxies}
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded string
+		want      string
+	}{
+		{"direct", "203.0.113.7:1234", "", "203.0.113.7"},
+		{"forwarded by a stranger", "203.0.113.7:1234", "198.51.100.1", "203.0.113.7"},
+		{"trusted proxy", "10.1.2.3:1234", "198.51.100.1", "198.51.100.1"},
+		{"single trusted proxy", "192.168.1.5:1234", "198.51.100.1", "198.51.100.1"},
+		{"spoofed entry before the client", "10.1.2.3:1234", "1.2.3.4, 198.51.100.1", "198.51.100.1"},
+		{"chain of trusted proxies", "10.1.2.3:1234", "198.51.100.1, 10.9.9.9", "198.51.100.1"},
+		{"only proxies", "10.1.2.3:1234", "10.9.9.9", "10.9.9.9"},
+		{"garbage", "10.1.2.3:1234", "not-an-ip", "10.1.2.3"},
+		{"untrusted neighbour", "192.168.1.6:1234", "198.51.100.1", "192.168.1.6"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+
+			if got := s.clientIP(r); got != tt.want {
+				t.Errorf("clientIP() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseProxies(t *testing.T) {
+	tests := []struct {
+		list string
+		n    int
+		ok   bool
+	}{
+		{"", 0, true},
+		{"10.0.0.0/8", 1, true},
+		{"10.0.0.1, ::1, fd00::/8", 3, true},
+		{"10.0.0.0/33", 0, false},
+		{"proxy.local", 0, false},
+	}
+
+	for _, tt := range tests {
+		proxies, err := ParseProxies(tt.list)
+		if (err == nil) != tt.ok || len(proxies) != tt.n {
+			t.Errorf("ParseProxies(%q) = %v, %v, want %v proxies and ok %v", tt.list, proxies, err, tt.n, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"github.com/tmitchel/sidebar"
+)
+
+// SSOLogin redirects the user to their workspace's identity provider. The
+// workspace is taken from the "workspace" query parameter or looked up
+// from the domain of the "email" query parameter.
+func (s *server) SSOLogin() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		wid := r.URL.Query().Get("workspace")
+		if wid == "" {
+			email := r.URL.Query().Get("email")
+			if email == "" {
+				return &serverError{errors.New("No workspace or email provided"), "Workspace or email is required", http.StatusBadRequest}
+			}
+
+			var err error
+			wid, err = s.SSO.WorkspaceForEmail(email)
+			if err != nil {
+				return &serverError{err, "No workspace uses single sign-on for this email", http.StatusNotFound}
+			}
+		}
+
+		authURL, err := s.SSO.BeginLogin(wid)
+		if err != nil {
+			return &serverError{err, "Unable to start single sign-on", http.StatusBadRequest}
+		}
+
+		http.Redirect(w, r, authURL, http.StatusFound)
+		return nil
+	}
+}
+
+// SSOLink starts linking an identity at the workspace's identity provider
+// to the current user. The URL the user should be sent to is returned
+// since API requests can't follow the redirect themselves.
+func (s *server) SSOLink() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+
+		authURL, err := s.SSO.BeginLink(parsed["WorkspaceID"].(string), parsed["UserID"].(string))
+		if err != nil {
+			return &serverError{err, "Unable to start single sign-on", http.StatusBadRequest}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"url": authURL})
+		return nil
+	}
+}
+
+// SSOCallback finishes a single sign-on login with the state and code the
+// identity provider sent back, then issues tokens the same way as Login.
+func (s *server) SSOCallback() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		var payload SSOCallback
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			return &serverError{err, "Unable to decode payload", http.StatusBadRequest}
+		}
+
+		user, wid, err := s.SSO.FinishLogin(payload.State, payload.Code)
+		if err != nil || user == nil {
+			return failure(err, "Unable to log in with single sign-on", http.StatusForbidden)
+		}
+
+		return s.issueTokens(w, user, wid)
+	}
+}
+
+func (s *server) GetSSOConfig() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+		uid := parsed["UserID"].(string)
+		wid := parsed["WorkspaceID"].(string)
+
+		config, err := s.SSO.GetSSOConfig(wid, uid)
+		if err != nil {
+			return &serverError{err, "Unable to get single sign-on settings", http.StatusForbidden}
+		}
+
+		// never send the secret back out
+		config.ClientSecret = ""
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(config)
+		return nil
+	}
+}
+
+func (s *server) UpdateSSOConfig() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		var config sidebar.SSOConfig
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			return &serverError{err, "Unable to decode payload", http.StatusBadRequest}
+		}
+
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+		config.WorkspaceID = parsed["WorkspaceID"].(string)
+
+		if err := s.SSO.UpdateSSOConfig(&config, parsed["UserID"].(string)); err != nil {
+			return failure(err, "Unable to update single sign-on settings", http.StatusBadRequest)
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "Success")
+		return nil
+	}
+}
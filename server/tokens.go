@@ -0,0 +1,219 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	jwtmiddleware "github.com/auth0/go-jwt-middleware"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/tmitchel/sidebar"
+	"github.com/urfave/negroni"
+)
+
+// scoped marks a route as usable with an API token that has been
+// granted the scope. Routes that aren't scoped require a session.
+type scoped struct {
+	scope   string
+	handler http.Handler
+}
+
+func (sc scoped) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sc.handler.ServeHTTP(w, r)
+}
+
+// authenticate accepts either an access JWT or an API token. API tokens
+// are converted to the same claims an access JWT would have, along with
+// the token's scopes, so handlers don't need to know the difference.
+func (s *server) authenticate(jwtMiddleware *jwtmiddleware.JWTMiddleware) negroni.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		raw := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !strings.HasPrefix(raw, sidebar.TokenPrefix) {
+			jwtMiddleware.HandlerWithNext(w, r, next)
+			return
+		}
+
+		apiToken, err := s.Tokens.ValidateToken(raw)
+		if err != nil {
+			http.Error(w, "Invalid API token", http.StatusUnauthorized)
+			return
+		}
+
+		token := &jwt.Token{
+			Valid: true,
+			Claims: jwt.MapClaims{
+				"UserID":        apiToken.UserID,
+				"WorkspaceID":   apiToken.WorkspaceID,
+				"Authenticated": true,
+				"Scopes":        apiToken.Scopes,
+			},
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), "user", token)))
+	}
+}
+
+// checkScopes rejects requests made with an API token unless the matched
+// route is scoped and the token was granted that scope.
+func checkScopes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scopes, ok := r.Context().Value("user").(*jwt.Token).Claims.(jwt.MapClaims)["Scopes"].([]string)
+		if !ok {
+			// sessions can use every route
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		route, ok := mux.CurrentRoute(r).GetHandler().(scoped)
+		if !ok {
+			http.Error(w, "API tokens can't be used for this request", http.StatusForbidden)
+			return
+		}
+
+		for _, scope := range scopes {
+			if scope == route.scope {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		http.Error(w, fmt.Sprintf("API token is missing the %v scope", route.scope), http.StatusForbidden)
+	})
+}
+
+func (s *server) GetTokens() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+
+		tokens, err := s.Tokens.GetTokens(parsed["UserID"].(string), parsed["WorkspaceID"].(string))
+		if err != nil {
+			return &serverError{err, "Unable to get tokens", http.StatusInternalServerError}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tokens)
+		return nil
+	}
+}
+
+func (s *server) CreateToken() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		var reqToken sidebar.APIToken
+		if err := json.NewDecoder(r.Body).Decode(&reqToken); err != nil {
+			return &serverError{err, "Unable to decode payload", http.StatusBadRequest}
+		}
+
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+		reqToken.WorkspaceID = parsed["WorkspaceID"].(string)
+
+		apiToken, err := s.Tokens.CreateToken(&reqToken, parsed["UserID"].(string))
+		if err != nil {
+			return &serverError{err, "Unable to create token", http.StatusBadRequest}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(apiToken)
+		return nil
+	}
+}
+
+func (s *server) RevokeToken() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		tokenID := mux.Vars(r)["id"]
+		uid := r.Context().Value("user").(*jwt.Token).Claims.(jwt.MapClaims)["UserID"].(string)
+
+		if err := s.Tokens.RevokeToken(tokenID, uid); err != nil {
+			return &serverError{err, "Unable to revoke token", http.StatusBadRequest}
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "Success")
+		return nil
+	}
+}
+
+func (s *server) GetBots() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		wid := r.Context().Value("user").(*jwt.Token).Claims.(jwt.MapClaims)["WorkspaceID"].(string)
+
+		bots, err := s.Tokens.GetBots(wid)
+		if err != nil {
+			return &serverError{err, "Unable to get bots", http.StatusInternalServerError}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(bots)
+		return nil
+	}
+}
+
+// CreateBot lets a workspace admin create a bot user.
+func (s *server) CreateBot() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		var reqUser sidebar.User
+		if err := json.NewDecoder(r.Body).Decode(&reqUser); err != nil {
+			return &serverError{err, "Unable to decode payload", http.StatusBadRequest}
+		}
+
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+
+		bot, err := s.Tokens.CreateBot(&reqUser, parsed["UserID"].(string), parsed["WorkspaceID"].(string))
+		if err != nil {
+			return failure(err, "Unable to create bot", http.StatusBadRequest)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(bot)
+		return nil
+	}
+}
+
+// AddOtherUserToChannel lets a member of a channel add another user, such
+// as a bot, to the channel.
+func (s *server) AddOtherUserToChannel() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		channelID := mux.Vars(r)["channel"]
+		newUserID := mux.Vars(r)["user"]
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+		userID := parsed["UserID"].(string)
+		wid := parsed["WorkspaceID"].(string)
+
+		members, err := s.Get.GetUsersInChannel(channelID, wid)
+		if err != nil {
+			return &serverError{err, "Unable to get members of the channel", http.StatusBadRequest}
+		}
+
+		var found bool
+		for _, m := range members {
+			if m.ID == userID {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return &serverError{
+				errors.Errorf("User %v isn't a member of channel %v", userID, channelID),
+				"Cannot add users to a channel that you aren't a part of",
+				http.StatusBadRequest,
+			}
+		}
+
+		if err := s.Add.AddUserToChannel(newUserID, channelID, wid, userID); err != nil {
+			return &serverError{err, "Unable to add user to channel", http.StatusInternalServerError}
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "Successfully added user %v to channel %v", newUserID, channelID)
+		return nil
+	}
+}
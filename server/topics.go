@@ -0,0 +1,67 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"github.com/tmitchel/sidebar"
+)
+
+// ChangeChannelField returns a handler that sets the given field, topic
+// or purpose, for a channel. Members get the updated channel along with
+// the message announcing the change.
+func (s *server) ChangeChannelField(field string) errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		var req ChannelFieldRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return &serverError{err, "Unable to decode payload", http.StatusBadRequest}
+		}
+
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+		wid := parsed["WorkspaceID"].(string)
+
+		channel, msg, err := s.Up.ChangeChannelField(&sidebar.ChannelChange{
+			Channel:   mux.Vars(r)["id"],
+			Field:     field,
+			Value:     req.Value,
+			ChangedBy: parsed["UserID"].(string),
+		}, wid)
+		if err != nil {
+			return &serverError{err, "Unable to change channel " + field, http.StatusBadRequest}
+		}
+
+		s.sendToChannel(channel.ID, wid, sidebar.WebsocketMessage{
+			Type:    "channel-update",
+			Payload: sidebar.ChannelUpdate{Channel: *channel, Message: field + " changed"},
+		})
+		if msg != nil {
+			s.sendToChannel(channel.ID, wid, sidebar.WebsocketMessage{
+				Type:    "chat-message",
+				Payload: msg,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(channel)
+		return nil
+	}
+}
+
+func (s *server) GetChannelChanges() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+
+		changes, err := s.Up.GetChannelChanges(mux.Vars(r)["id"], parsed["UserID"].(string), parsed["WorkspaceID"].(string))
+		if err != nil {
+			return &serverError{err, "Unable to get channel changes", http.StatusBadRequest}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(changes)
+		return nil
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/sirupsen/logrus"
+	"github.com/tmitchel/sidebar"
+)
+
+// link previews are fetched in the background by a few workers
+const (
+	unfurlWorkers   = 4
+	unfurlQueueSize = 256
+)
+
+type unfurlJob struct {
+	msg *sidebar.ChatMessage
+	wid string
+}
+
+// queueUnfurl asks the workers to preview the links in the message. Links
+// aren't previewed if the workers are too far behind.
+func (s *server) queueUnfurl(msg *sidebar.ChatMessage, wid string) {
+	select {
+	case s.unfurls <- unfurlJob{msg, wid}:
+	default:
+		logrus.Warnf("Too many links to preview, skipping message %v", msg.ID)
+	}
+}
+
+// runUnfurls previews links until the queue is closed and lets the
+// members of the message's channel know when the previews change.
+func (s *server) runUnfurls() {
+	for job := range s.unfurls {
+		msg, err := s.Unfurl.Unfurl(job.msg, job.wid)
+		if err != nil {
+			logrus.Errorf("Unable to preview links in %v %v", job.msg.ID, err)
+			continue
+		}
+
+		if msg != nil {
+			s.sendToChannel(msg.Channel, job.wid, sidebar.WebsocketMessage{
+				Type:    "message-updated",
+				Payload: msg,
+			})
+		}
+	}
+}
+
+func (s *server) GetUnfurlSettings() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+
+		settings, err := s.Unfurl.GetUnfurlSettings(parsed["UserID"].(string), parsed["WorkspaceID"].(string))
+		if err != nil {
+			return &serverError{err, "Unable to get link preview settings", http.StatusForbidden}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(settings)
+		return nil
+	}
+}
+
+func (s *server) UpdateUnfurlSettings() errHandler {
+	return func(w http.ResponseWriter, r *http.Request) *serverError {
+		var settings sidebar.UnfurlSettings
+		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+			return &serverError{err, "Unable to decode payload", http.StatusBadRequest}
+		}
+
+		token := r.Context().Value("user").(*jwt.Token)
+		parsed := token.Claims.(jwt.MapClaims)
+		settings.WorkspaceID = parsed["WorkspaceID"].(string)
+
+		if err := s.Unfurl.UpdateUnfurlSettings(&settings, parsed["UserID"].(string)); err != nil {
+			return &serverError{err, "Unable to update link preview settings", http.StatusBadRequest}
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "Success")
+		return nil
+	}
+}
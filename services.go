@@ -1,10 +1,13 @@
 package sidebar
 
+import "io"
+
 // Authenticater provides methods to check that a
 // user has provided proper login information or
 // a valid token.
 type Authenticater interface {
-	Validate(string, string, string) (*User, error)
+	Validate(string, string, string, string) (*User, error)
+	Unlock(string, string, string) error
 }
 
 // Creater provides methods to create new objects
@@ -12,20 +15,29 @@ type Authenticater interface {
 type Creater interface {
 	CreateWorkspace(*Workspace) (*Workspace, error)
 	CreateUser(*User) (*User, error)
-	CreateChannel(*Channel, string) (*Channel, error)
-	CreateMessage(*ChatMessage) (*ChatMessage, error)
+	CreateChannel(*Channel, string, string) (*Channel, error)
+	CreateDirect([]string, string, string) (*Channel, error)
+	ConvertDirect(string, string, string, string) (*Channel, error)
+	CreateMessage(*ChatMessage, string) (*ChatMessage, error)
+	PromoteThread(string, *Channel, string, string) (*Channel, error)
 }
 
 type Deleter interface {
 	DeleteUser(string) (*User, error)
-	DeleteChannel(string) (*Channel, error)
+	DeleteChannel(string, string, string, string) (*Channel, error)
+	PurgeChannels() error
+	DeleteMessage(string, string, string) (*ChatMessage, error)
 }
 
 type Adder interface {
-	ResolveChannel(string, string) error
-	AddUserToChannel(string, string, string) error
+	ResolveChannel(string, string, string) error
+	ArchiveChannel(string, string, string) (*Channel, error)
+	UnarchiveChannel(string, string, string) (*Channel, error)
+	AddUserToChannel(string, string, string, string) error
 	RemoveUserFromChannel(string, string, string) error
 	AddUserToWorkspace(string, string, string) error
+	AddReaction(*Reaction, string) (*ReactionUpdate, error)
+	RemoveReaction(*Reaction, string) (*ReactionUpdate, error)
 }
 
 type Getter interface {
@@ -33,24 +45,137 @@ type Getter interface {
 	GetWorkspaces() ([]*Workspace, error)
 	GetDefaultWorkspace() (*Workspace, error)
 
-	GetUser(string) (*User, error)
+	GetWorkspaceSettings(string) (*WorkspaceSettings, error)
+
+	GetUser(string, string) (*User, error)
 	GetChannel(string) (*Channel, error)
-	GetMessage(string) (*ChatMessage, error)
+	GetMessage(string, string, string) (*ChatMessage, error)
 
 	GetUsers(string) ([]*User, error)
-	GetChannels(string) ([]*Channel, error)
-	GetMessages(string) ([]*ChatMessage, error)
+	GetChannels(string, string) ([]*Channel, error)
+	GetArchivedChannels(string, string) ([]*Channel, error)
+	GetMessages(string, string) ([]*ChatMessage, error)
 
 	GetUsersInChannel(string, string) ([]*User, error)
 	GetChannelsForUser(string, string) ([]*Channel, error)
 
-	GetMessagesInChannel(string, string) ([]*ChatMessage, error)
-	GetMessagesFromUser(string, string) ([]*ChatMessage, error)
-	GetMessagesToUser(string, string) ([]*ChatMessage, error)
+	GetMessagesInChannel(string, string, string) ([]*ChatMessage, error)
+	GetThread(string, string, string) ([]*ChatMessage, error)
+	GetMentions(string, string) ([]*Mention, error)
+	GetMessagesFromUser(string, string, string) ([]*ChatMessage, error)
+	GetMessagesToUser(string, string, string) ([]*ChatMessage, error)
 }
 
 type Updater interface {
-	UpdateUserInfo(*User) error
-	UpdateChannelInfo(*Channel) error
+	UpdateUserInfo(*UserUpdate, string) (*User, error)
+	ExpireStatuses() ([]*StatusUpdate, error)
+	GetProfileFields(string, string) ([]*ProfileField, error)
+	CreateProfileField(*ProfileField, string) (*ProfileField, error)
+	DeleteProfileField(string, string, string) error
+	UpdateChannelInfo(*Channel, string, string) error
+	ChangeChannelField(*ChannelChange, string) (*Channel, *ChatMessage, error)
+	GetChannelChanges(string, string, string) ([]*ChannelChange, error)
 	UpdateUserPassword(string, []byte, []byte) error
+	UpdateMessage(*ChatMessage, string, string) (*ChatMessage, error)
+	GetMessageRevisions(string, string, string) ([]*MessageRevision, error)
+	UpdateWorkspaceSettings(*WorkspaceSettings, string) error
+	MarkMentionsRead(string, string, string) error
+	UpdateReadMarker(*ReadMarker, string) (*ReadMarker, error)
+}
+
+// SingleSignOner provides methods for logging in with an external
+// OpenID Connect provider instead of a Sidebar password.
+type SingleSignOner interface {
+	BeginLogin(string) (string, error)
+	BeginLink(string, string) (string, error)
+	FinishLogin(string, string) (*User, string, error)
+	WorkspaceForEmail(string) (string, error)
+
+	GetSSOConfig(string, string) (*SSOConfig, error)
+	UpdateSSOConfig(*SSOConfig, string) error
+}
+
+// TokenManager provides methods to create and check long-lived
+// API tokens and the bot users that authenticate with them.
+type TokenManager interface {
+	CreateToken(*APIToken, string) (*APIToken, error)
+	GetTokens(string, string) ([]*APIToken, error)
+	RevokeToken(string, string) error
+	ValidateToken(string) (*APIToken, error)
+
+	CreateBot(*User, string, string) (*User, error)
+	GetBots(string) ([]*User, error)
+}
+
+// Auditor provides methods for reading the audit log. Entries are
+// written by the other services as changes are made.
+type Auditor interface {
+	GetAuditLog(*AuditFilter, string) (*AuditPage, error)
+}
+
+// Notifier provides methods for managing notification preferences and
+// deciding who should be alerted about new messages.
+type Notifier interface {
+	GetNotificationPrefs(string, string) (*NotificationPrefs, error)
+	UpdateNotificationPrefs(*NotificationPrefs) error
+	AlertRecipients(*ChatMessage, string) (map[string]string, error)
+}
+
+// Searcher provides full-text search over message history.
+type Searcher interface {
+	Search(*SearchQuery) (*SearchPage, error)
+}
+
+// Pinner provides methods for managing the pinned messages and
+// bookmarks in a channel. Changes return the channel's new list.
+type Pinner interface {
+	GetPins(string, string, string) ([]*Pin, error)
+	PinMessage(string, string, string) (*ChannelPins, error)
+	UnpinMessage(string, string, string) (*ChannelPins, error)
+	ReorderPins(string, []string, string, string) (*ChannelPins, error)
+
+	GetBookmarks(string, string, string) ([]*Bookmark, error)
+	AddBookmark(*Bookmark, string, string) (*ChannelBookmarks, error)
+	RemoveBookmark(string, string, string) (*ChannelBookmarks, error)
+	ReorderBookmarks(string, []string, string, string) (*ChannelBookmarks, error)
+}
+
+// Unfurler provides methods for previewing the links in messages.
+type Unfurler interface {
+	Unfurl(*ChatMessage, string) (*ChatMessage, error)
+	GetUnfurlSettings(string, string) (*UnfurlSettings, error)
+	UpdateUnfurlSettings(*UnfurlSettings, string) error
+}
+
+// Attacher provides methods for uploading and downloading files.
+type Attacher interface {
+	Upload(*Attachment, io.Reader) (*Attachment, error)
+	Download(string, int, string, string) (*Attachment, io.ReadCloser, error)
+	Identicon(string, int) ([]byte, error)
+}
+
+// Saver provides methods for the messages users save for later and
+// the reminders they set for themselves.
+type Saver interface {
+	GetSavedItems(string, string) ([]*SavedItem, error)
+	SaveMessage(string, string, string) (*SavedItem, error)
+	UnsaveMessage(string, string, string) error
+
+	GetReminders(string, string) ([]*Reminder, error)
+	CreateReminder(*Reminder) (*Reminder, error)
+	DeleteReminder(string, string) error
+	DueReminders() ([]*Reminder, error)
+	DeliverReminder(*Reminder) (*ChatMessage, error)
+	FinishReminder(string) error
+}
+
+// Scheduler provides methods for messages that are posted later, once
+// or on a recurring schedule.
+type Scheduler interface {
+	GetScheduledMessages(string, string) ([]*ScheduledMessage, error)
+	ScheduleMessage(*ScheduledMessage) (*ScheduledMessage, error)
+	UpdateScheduledMessage(*ScheduledMessage) (*ScheduledMessage, error)
+	CancelScheduledMessage(string, string, string) error
+	DueScheduledMessages() ([]*ScheduledMessage, error)
+	FinishScheduledMessage(*ScheduledMessage) error
 }
@@ -1,6 +1,10 @@
 package services
 
 import (
+	"strings"
+	"time"
+	"unicode"
+
 	"github.com/pkg/errors"
 	"github.com/tmitchel/sidebar"
 	"github.com/tmitchel/sidebar/store"
@@ -19,9 +23,11 @@ func NewAdder(db store.Database) (sidebar.Adder, error) {
 	}, nil
 }
 
-// AddUserToChannel checks if the current user and channel are in the
-// provided workspace. If so, the user is added to the channel.
-func (a *adder) AddUserToChannel(userID, channelID, workID string) error {
+// AddUserToChannel checks if the user being added and channel are in the
+// provided workspace. If so, the user is added to the channel. The actor
+// is the user doing the adding, which is usually the same user. Only
+// members and admins can add users to private channels.
+func (a *adder) AddUserToChannel(userID, channelID, workID, actorID string) error {
 	err := a.DB.UserInWorkspace(userID, workID)
 	if err != nil {
 		return err
@@ -32,7 +38,24 @@ func (a *adder) AddUserToChannel(userID, channelID, workID string) error {
 		return err
 	}
 
-	return a.DB.AddUserToChannel(userID, channelID)
+	if err := a.checkNotDirect(channelID); err != nil {
+		return err
+	}
+
+	if err := checkNotArchived(a.DB, channelID); err != nil {
+		return err
+	}
+
+	if err := a.checkCanAdd(channelID, actorID, workID); err != nil {
+		return err
+	}
+
+	if err := a.DB.AddUserToChannel(userID, channelID); err != nil {
+		return err
+	}
+
+	record(a.DB, actorID, sidebar.ActionChannelJoin, channelID, workID, nil, map[string]string{"user": userID})
+	return nil
 }
 
 // RemoveUserFromChannel checks if the current user and channel are in the
@@ -48,17 +71,127 @@ func (a *adder) RemoveUserFromChannel(userID, channelID, workID string) error {
 		return err
 	}
 
-	return a.DB.RemoveUserFromChannel(userID, channelID)
+	if err := a.checkNotDirect(channelID); err != nil {
+		return err
+	}
+
+	if err := a.DB.RemoveUserFromChannel(userID, channelID); err != nil {
+		return err
+	}
+
+	record(a.DB, userID, sidebar.ActionChannelLeave, channelID, workID, map[string]string{"user": userID}, nil)
+	return nil
 }
 
 // ResolveChannel checks if the channel is part of the current workspace.
 // If so, the channel's "Resolved" state if flipped.
-func (a *adder) ResolveChannel(id, workID string) error {
+func (a *adder) ResolveChannel(id, userID, workID string) error {
 	err := a.DB.ChannelInWorkspace(id, workID)
 	if err != nil {
 		return err
 	}
-	return a.DB.ResolveChannel(id)
+
+	before, err := a.DB.GetChannel(id)
+	if err != nil {
+		return err
+	}
+
+	if before.ArchivedAt != nil {
+		return errors.Errorf("Channel %v is archived", id)
+	}
+
+	if err := a.DB.ResolveChannel(id); err != nil {
+		return err
+	}
+
+	after, err := a.DB.GetChannel(id)
+	if err != nil {
+		return err
+	}
+
+	record(a.DB, userID, sidebar.ActionChannelResolve, id, workID, before, after)
+	return nil
+}
+
+// ArchiveChannel makes the channel and its sidebars read-only and hides
+// them from channel listings. Members of the channel and workspace admins
+// can archive it. Direct conversations can't be archived.
+func (a *adder) ArchiveChannel(id, uid, wid string) (*sidebar.Channel, error) {
+	if err := a.DB.ChannelInWorkspace(id, wid); err != nil {
+		return nil, err
+	}
+
+	before, err := a.DB.GetChannel(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if before.Direct {
+		return nil, errors.Errorf("Direct conversation %v can't be archived", id)
+	}
+
+	if before.ArchivedAt != nil {
+		return nil, errors.Errorf("Channel %v is already archived", id)
+	}
+
+	if err := a.DB.UserInChannel(uid, id); err != nil {
+		if err := a.DB.UserIsAdmin(uid, wid); err != nil {
+			return nil, errors.Errorf("User %v can't archive channel %v", uid, id)
+		}
+	}
+
+	if err := a.DB.ArchiveChannel(id, time.Now()); err != nil {
+		return nil, err
+	}
+
+	after, err := a.DB.GetChannel(id)
+	if err != nil {
+		return nil, err
+	}
+
+	record(a.DB, uid, sidebar.ActionChannelArchive, id, wid, before, after)
+	return after, nil
+}
+
+// UnarchiveChannel restores an archived channel, along with the sidebars
+// archived with it, if the current user is an admin. Restoring a deleted
+// channel cancels its deletion. Sidebars can't be restored while their
+// parent is archived.
+func (a *adder) UnarchiveChannel(id, uid, wid string) (*sidebar.Channel, error) {
+	if err := a.DB.UserIsAdmin(uid, wid); err != nil {
+		return nil, err
+	}
+
+	if err := a.DB.ChannelInWorkspace(id, wid); err != nil {
+		return nil, err
+	}
+
+	before, err := a.DB.GetChannel(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if before.ArchivedAt == nil {
+		return nil, errors.Errorf("Channel %v isn't archived", id)
+	}
+
+	if before.IsSidebar && before.Parent != "" {
+		if err := checkNotArchived(a.DB, before.Parent); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := a.DB.UnarchiveChannel(id); err != nil {
+		return nil, err
+	}
+
+	after, err := a.DB.GetChannel(id)
+	if err != nil {
+		return nil, err
+	}
+
+	record(a.DB, uid, sidebar.ActionChannelRestore, id, wid, before, after)
+	return after, nil
 }
 
 // AddUserToWorkspace confirms the user provided the correct token
@@ -73,5 +206,125 @@ func (a *adder) AddUserToWorkspace(uid, wid, token string) error {
 		return errors.Errorf("Token %v doesn't match %v", token, storedToken)
 	}
 
-	return a.DB.AddUserToWorkspace(uid, wid)
+	if err := a.DB.AddUserToWorkspace(uid, wid); err != nil {
+		return err
+	}
+
+	record(a.DB, uid, sidebar.ActionWorkspaceJoin, uid, wid, nil, nil)
+	return nil
+}
+
+// AddReaction adds the user's reaction to a message in the current
+// workspace. Adding the same reaction twice has no effect.
+func (a *adder) AddReaction(r *sidebar.Reaction, wid string) (*sidebar.ReactionUpdate, error) {
+	msg, err := a.checkReaction(r, wid)
+	if err != nil {
+		return nil, err
+	}
+
+	if msg.DeletedAt != nil {
+		return nil, errors.Errorf("Message %v has been deleted", msg.ID)
+	}
+
+	if err := a.DB.AddReaction(r); err != nil {
+		return nil, err
+	}
+
+	return &sidebar.ReactionUpdate{Reaction: *r, Channel: msg.Channel, Added: true}, nil
+}
+
+// RemoveReaction removes the user's reaction from a message in the
+// current workspace.
+func (a *adder) RemoveReaction(r *sidebar.Reaction, wid string) (*sidebar.ReactionUpdate, error) {
+	msg, err := a.checkReaction(r, wid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.DB.RemoveReaction(r); err != nil {
+		return nil, err
+	}
+
+	return &sidebar.ReactionUpdate{Reaction: *r, Channel: msg.Channel, Added: false}, nil
+}
+
+// checkReaction returns the message being reacted to if the emoji is
+// valid and the user can see the message.
+func (a *adder) checkReaction(r *sidebar.Reaction, wid string) (*sidebar.ChatMessage, error) {
+	if !validEmoji(r.Emoji) {
+		return nil, errors.Errorf("Invalid emoji %q", r.Emoji)
+	}
+
+	msg, err := a.DB.GetMessage(r.MessageID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkChannelAccess(a.DB, msg.Channel, r.UserID, wid); err != nil {
+		return nil, err
+	}
+
+	if err := checkNotArchived(a.DB, msg.Channel); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// validEmoji checks that the emoji is a single short name or character
+// with no spaces.
+func validEmoji(emoji string) bool {
+	return emoji != "" && len(emoji) <= 64 && strings.IndexFunc(emoji, unicode.IsSpace) < 0
+}
+
+// checkNotDirect returns an error for direct channels, which always keep
+// the users they were started with.
+func (a *adder) checkNotDirect(cid string) error {
+	channel, err := a.DB.GetChannel(cid)
+	if err != nil {
+		return err
+	}
+
+	if channel.Direct {
+		return errors.Errorf("Members of direct conversation %v can't change", cid)
+	}
+
+	return nil
+}
+
+// checkCanAdd returns an error unless the actor can add users to the
+// channel. Anyone in the workspace can join a public channel, but only
+// members and admins can add users to a private one.
+func (a *adder) checkCanAdd(cid, actorID, wid string) error {
+	channel, err := a.DB.GetChannel(cid)
+	if err != nil {
+		return err
+	}
+
+	if !channel.Private {
+		return nil
+	}
+
+	if err := a.DB.UserInChannel(actorID, cid); err != nil {
+		if err := a.DB.UserIsAdmin(actorID, wid); err != nil {
+			return errors.Errorf("User %v can't add users to private channel %v", actorID, cid)
+		}
+	}
+
+	return nil
+}
+
+// checkNotArchived returns an error for archived channels, which are
+// read-only until they're restored.
+func checkNotArchived(db store.Database, cid string) error {
+	channel, err := db.GetChannel(cid)
+	if err != nil {
+		return err
+	}
+
+	if channel.ArchivedAt != nil {
+		return errors.Errorf("Channel %v is archived", cid)
+	}
+
+	return nil
 }
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/tmitchel/sidebar"
+	"github.com/tmitchel/sidebar/store"
+)
+
+// joinDB has a public channel and a group conversation that was converted
+// to a private channel.
+type joinDB struct {
+	store.Database
+
+	members map[string]bool
+}
+
+func (d *joinDB) UserInWorkspace(uid, wid string) error {
+	return nil
+}
+
+func (d *joinDB) ChannelInWorkspace(cid, wid string) error {
+	return nil
+}
+
+func (d *joinDB) GetChannel(cid string) (*sidebar.Channel, error) {
+	return &sidebar.Channel{ID: cid, Private: cid == "converted"}, nil
+}
+
+func (d *joinDB) UserInChannel(uid, cid string) error {
+	if !d.members[uid+"#"+cid] {
+		return errors.New("not in channel")
+	}
+	return nil
+}
+
+func (d *joinDB) UserIsAdmin(uid, wid string) error {
+	if uid != "admin" {
+		return errors.New("not an admin")
+	}
+	return nil
+}
+
+func (d *joinDB) AddUserToChannel(uid, cid string) error {
+	d.members[uid+"#"+cid] = true
+	return nil
+}
+
+func (d *joinDB) CreateAuditEntry(*sidebar.AuditEntry) error {
+	return nil
+}
+
+func TestAddUserToChannel(t *testing.T) {
+	tests := []struct {
+		name    string
+		channel string
+		user    string
+		actor   string
+		allowed bool
+	}{
+		{"join a public channel", "public", "other", "other", true},
+		{"join a converted group conversation", "converted", "other", "other", false},
+		{"added to a converted group conversation by a stranger", "converted", "bot", "other", false},
+		{"added to a converted group conversation by a member", "converted", "bot", "member", true},
+		{"added to a converted group conversation by an admin", "converted", "other", "admin", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &joinDB{members: map[string]bool{"member#converted": true}}
+			a := &adder{DB: db}
+
+			err := a.AddUserToChannel(tt.user, tt.channel, "w1", tt.actor)
+			if (err == nil) != tt.allowed {
+				t.Fatalf("AddUserToChannel(%v, %v) by %v = %v, want allowed %v", tt.user, tt.channel, tt.actor, err, tt.allowed)
+			}
+			if db.members[tt.user+"#"+tt.channel] != tt.allowed {
+				t.Fatalf("%v member of %v = %v", tt.user, tt.channel, !tt.allowed)
+			}
+		})
+	}
+}
+
+// reactDB is an accessDB where each channel has one message with the
+// channel's id.
+type reactDB struct {
+	*accessDB
+
+	reacted []string
+}
+
+func (d *reactDB) GetMessage(id string) (*sidebar.ChatMessage, error) {
+	return &sidebar.ChatMessage{ID: id, Channel: id}, nil
+}
+
+func (d *reactDB) AddReaction(r *sidebar.Reaction) error {
+	d.reacted = append(d.reacted, r.MessageID)
+	return nil
+}
+
+func (d *reactDB) RemoveReaction(r *sidebar.Reaction) error {
+	d.reacted = append(d.reacted, r.MessageID)
+	return nil
+}
+
+func TestReactionAccess(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		uid     string
+		allowed bool
+	}{
+		{"public channel", "public", "other", true},
+		{"private channel, member", "private", "member", true},
+		{"private channel, non-member", "private", "other", false},
+		{"direct channel, member", "direct", "member", true},
+		{"direct channel, non-member", "direct", "other", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &reactDB{accessDB: &accessDB{
+				channels: map[string]*sidebar.Channel{
+					"public":  {ID: "public"},
+					"private": {ID: "private", Private: true},
+					"direct":  {ID: "direct", Direct: true},
+				},
+				members: map[string]bool{
+					"member#private": true,
+					"member#direct":  true,
+				},
+			}}
+			a := &adder{DB: db}
+			r := &sidebar.Reaction{MessageID: tt.message, UserID: tt.uid, Emoji: "thumbsup"}
+
+			_, addErr := a.AddReaction(r, "w1")
+			_, removeErr := a.RemoveReaction(r, "w1")
+			if (addErr == nil) != tt.allowed || (removeErr == nil) != tt.allowed {
+				t.Fatalf("add = %v, remove = %v, want allowed %v", addErr, removeErr, tt.allowed)
+			}
+			if !tt.allowed && len(db.reacted) != 0 {
+				t.Fatalf("reacted to %v", db.reacted)
+			}
+		})
+	}
+}
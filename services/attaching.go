@@ -0,0 +1,303 @@
+package services
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/tmitchel/sidebar"
+	"github.com/tmitchel/sidebar/store"
+)
+
+type attacher struct {
+	DB    store.Database
+	Blobs sidebar.BlobStore
+}
+
+// NewAttacher wraps a database connection and a blob store with an
+// *attacher that implements the sidebar.Attacher interface.
+func NewAttacher(db store.Database, blobs sidebar.BlobStore) (sidebar.Attacher, error) {
+	return &attacher{
+		DB:    db,
+		Blobs: blobs,
+	}, nil
+}
+
+// Upload saves a new file after checking its size and type. The type is
+// sniffed from the contents rather than trusting the client. Images for
+// a user, channel, or workspace replace the current image right away,
+// while files wait to be attached to a message.
+func (a *attacher) Upload(att *sidebar.Attachment, r io.Reader) (*sidebar.Attachment, error) {
+	if err := a.DB.UserInWorkspace(att.UploaderID, att.WorkspaceID); err != nil {
+		return nil, err
+	}
+
+	limit := int64(sidebar.MaxImageSize)
+	switch att.Purpose {
+	case "", sidebar.PurposeFile:
+		att.Purpose = sidebar.PurposeFile
+		att.Target = ""
+		limit = sidebar.MaxAttachmentSize
+	case sidebar.PurposeAvatar:
+		att.Target = att.UploaderID
+	case sidebar.PurposeChannel:
+		if err := a.DB.ChannelInWorkspace(att.Target, att.WorkspaceID); err != nil {
+			return nil, err
+		}
+		if err := a.DB.UserInChannel(att.UploaderID, att.Target); err != nil {
+			return nil, err
+		}
+		if err := checkNotArchived(a.DB, att.Target); err != nil {
+			return nil, err
+		}
+	case sidebar.PurposeWorkspace:
+		if err := a.DB.UserIsAdmin(att.UploaderID, att.WorkspaceID); err != nil {
+			return nil, err
+		}
+		att.Target = att.WorkspaceID
+	default:
+		return nil, errors.Errorf("Unknown upload purpose %v", att.Purpose)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading upload")
+	}
+
+	if int64(len(data)) > limit {
+		return nil, errors.Errorf("Uploads can't be larger than %v bytes", limit)
+	}
+
+	if len(data) == 0 {
+		return nil, errors.New("Uploads can't be empty")
+	}
+
+	contentType, _, err := mime.ParseMediaType(http.DetectContentType(data))
+	if err != nil || !sidebar.AttachmentTypes[contentType] {
+		return nil, errors.Errorf("Files of type %v can't be uploaded", contentType)
+	}
+
+	if att.Purpose != sidebar.PurposeFile && !strings.HasPrefix(contentType, "image/") {
+		return nil, errors.New("Only images can be used for a user, channel, or workspace")
+	}
+
+	att.ID = uuid.New().String()
+	att.Name = cleanName(att.Name)
+	att.ContentType = contentType
+	att.URL = sidebar.AttachmentURL(att.ID)
+	att.CreatedAt = time.Now()
+	att.MessageID = ""
+	att.Thumbnails = nil
+
+	var thumbnails []*thumbnail
+	if strings.HasPrefix(contentType, "image/") {
+		img, err := processImage(data, contentType, att.Purpose != sidebar.PurposeFile)
+		if err != nil {
+			return nil, err
+		}
+
+		data = img.data
+		att.Width = img.width
+		att.Height = img.height
+		thumbnails = img.thumbnails
+	}
+	att.Size = int64(len(data))
+
+	keys := []string{blobKey(att.ID)}
+	err = a.Blobs.Put(keys[0], bytes.NewReader(data), att.Size, att.ContentType)
+	for _, t := range thumbnails {
+		if err != nil {
+			break
+		}
+
+		t.URL = sidebar.ThumbnailURL(att.ID, t.Size)
+		keys = append(keys, thumbnailKey(att.ID, t.Size))
+		err = a.Blobs.Put(keys[len(keys)-1], bytes.NewReader(t.data), t.Bytes, t.ContentType)
+		att.Thumbnails = append(att.Thumbnails, &t.Thumbnail)
+	}
+
+	if err == nil {
+		err = a.DB.CreateAttachment(att)
+	}
+	if err != nil {
+		for _, key := range keys {
+			if derr := a.Blobs.Delete(key); derr != nil {
+				logrus.Errorf("Error removing blob %v %v", key, derr)
+			}
+		}
+		return nil, err
+	}
+
+	if err := a.useImage(att); err != nil {
+		return nil, err
+	}
+
+	return att, nil
+}
+
+// Download returns the attachment and its contents if the user can see
+// it. Files attached to a message can be seen by members of the message's
+// channel, while files that haven't been attached yet can only be seen
+// by the uploader. Images can be seen by anyone in the workspace.
+//
+// If size is set, the smallest thumbnail at least that big is returned
+// instead, or the original image if there's no thumbnail that big.
+func (a *attacher) Download(id string, size int, uid, wid string) (*sidebar.Attachment, io.ReadCloser, error) {
+	att, err := a.DB.GetAttachment(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := a.DB.UserInWorkspace(uid, wid); err != nil {
+		return nil, nil, err
+	}
+
+	switch {
+	case att.Purpose == sidebar.PurposeAvatar:
+		// avatars follow the user into every workspace
+		err = a.DB.UserInWorkspace(att.UploaderID, wid)
+	case att.WorkspaceID != wid:
+		err = errors.Errorf("Attachment %v isn't in workspace %v", id, wid)
+	case att.Purpose != sidebar.PurposeFile:
+	case att.MessageID == "":
+		if att.UploaderID != uid {
+			err = errors.Errorf("Attachment %v hasn't been shared", id)
+		}
+	default:
+		var msg *sidebar.ChatMessage
+		if msg, err = a.DB.GetMessage(att.MessageID); err == nil {
+			if msg.DeletedAt != nil {
+				err = errors.Errorf("Message %v has been deleted", msg.ID)
+			} else {
+				err = a.DB.UserInChannel(uid, msg.Channel)
+			}
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key := blobKey(att.ID)
+	if size > 0 {
+		for _, t := range att.Thumbnails {
+			if t.Size >= size {
+				key = thumbnailKey(att.ID, t.Size)
+				att.ContentType = t.ContentType
+				att.Size = t.Bytes
+				att.Width = t.Width
+				att.Height = t.Height
+				att.URL = t.URL
+				break
+			}
+		}
+	}
+
+	body, err := a.Blobs.Get(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return att, body, nil
+}
+
+// Identicon draws the default image for a user, channel, or workspace.
+// The size is rounded up to the next thumbnail size.
+func (a *attacher) Identicon(seed string, size int) ([]byte, error) {
+	if seed == "" {
+		return nil, errors.New("Identicons need a seed")
+	}
+
+	sizes := sidebar.ThumbnailSizes
+	for _, s := range sizes {
+		if s >= size {
+			return identicon(seed, s)
+		}
+	}
+	return identicon(seed, sizes[len(sizes)-1])
+}
+
+// useImage makes the uploaded image the current image for its target.
+func (a *attacher) useImage(att *sidebar.Attachment) error {
+	switch att.Purpose {
+	case sidebar.PurposeAvatar:
+		user, err := a.DB.GetUser(att.UploaderID)
+		if err != nil {
+			return err
+		}
+
+		before := *user
+		user.ProfileImg = att.URL
+		if err := a.DB.UpdateUserInformation(user); err != nil {
+			return err
+		}
+		recordForUser(a.DB, user.ID, sidebar.ActionUserUpdate, user.ID, before, user)
+	case sidebar.PurposeChannel:
+		channel, err := a.DB.GetChannel(att.Target)
+		if err != nil {
+			return err
+		}
+
+		before := *channel
+		channel.Image = att.URL
+		if err := a.DB.UpdateChannelInformation(channel); err != nil {
+			return err
+		}
+		record(a.DB, att.UploaderID, sidebar.ActionChannelUpdate, channel.ID, att.WorkspaceID, before, channel)
+	case sidebar.PurposeWorkspace:
+		if err := a.DB.UpdateWorkspaceImage(att.WorkspaceID, att.URL); err != nil {
+			return err
+		}
+		record(a.DB, att.UploaderID, sidebar.ActionWorkspaceUpdate, att.WorkspaceID, att.WorkspaceID, nil, map[string]string{"image": att.URL})
+	}
+
+	return nil
+}
+
+func blobKey(id string) string {
+	return "attachments/" + id
+}
+
+func thumbnailKey(id string, size int) string {
+	return "thumbnails/" + id + "/" + strconv.Itoa(size)
+}
+
+// removeBlobs deletes the files for attachments that are no longer in
+// the database. Every thumbnail size is tried since deleting a missing
+// key isn't an error. Failures are only logged because the rows are
+// already gone.
+func removeBlobs(blobs sidebar.BlobStore, ids []string) {
+	for _, id := range ids {
+		keys := []string{blobKey(id)}
+		for _, size := range sidebar.ThumbnailSizes {
+			keys = append(keys, thumbnailKey(id, size))
+		}
+
+		for _, key := range keys {
+			if err := blobs.Delete(key); err != nil {
+				logrus.Errorf("Error removing blob %v %v", key, err)
+			}
+		}
+	}
+}
+
+// cleanName strips any directories from the client's file name.
+func cleanName(name string) string {
+	name = path.Base(strings.Replace(name, "\\", "/", -1))
+	if name == "." || name == "/" {
+		name = "upload"
+	}
+
+	if len(name) > 255 {
+		name = strings.ToValidUTF8(name[:255], "")
+	}
+	return name
+}
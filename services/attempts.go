@@ -0,0 +1,98 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"github.com/tmitchel/sidebar"
+)
+
+const (
+	// forget keys that haven't failed in this long when pruning
+	attemptsMaxAge = 24 * time.Hour
+
+	// only prune once this many keys are being tracked
+	attemptsPruneSize = 10000
+)
+
+type attempt struct {
+	failures int
+	last     time.Time
+}
+
+type memoryAttempts struct {
+	mu       sync.Mutex
+	attempts map[string]*attempt
+}
+
+// NewMemoryAttempts returns a sidebar.AttemptTracker that keeps failed
+// logins in memory. It should only be used with a single instance of
+// the server.
+func NewMemoryAttempts() sidebar.AttemptTracker {
+	return &memoryAttempts{
+		attempts: make(map[string]*attempt),
+	}
+}
+
+func (m *memoryAttempts) GetLoginFailures(key string) (int, time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a, ok := m.attempts[key]
+	if !ok {
+		return 0, time.Time{}, nil
+	}
+	return a.failures, a.last, nil
+}
+
+func (m *memoryAttempts) RecordLoginFailure(key string, at time.Time, window time.Duration) (int, time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a, ok := m.attempts[key]
+	if !ok {
+		if len(m.attempts) >= attemptsPruneSize {
+			m.prune(at)
+		}
+		a = &attempt{}
+		m.attempts[key] = a
+	}
+
+	if at.Sub(a.last) > window {
+		a.failures = 0
+		a.last = time.Time{}
+	}
+
+	failures, last := a.failures, a.last
+	a.failures++
+	a.last = at
+	return failures, last, nil
+}
+
+func (m *memoryAttempts) RemoveLoginFailure(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if a, ok := m.attempts[key]; ok && a.failures > 0 {
+		a.failures--
+	}
+	return nil
+}
+
+func (m *memoryAttempts) ResetLoginFailures(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.attempts, key)
+	return nil
+}
+
+// prune removes old keys so the map can't grow forever. Must be called
+// with the lock held.
+func (m *memoryAttempts) prune(now time.Time) {
+	for key, a := range m.attempts {
+		if now.Sub(a.last) > attemptsMaxAge {
+			delete(m.attempts, key)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+
+	"github.com/tmitchel/sidebar"
+	"github.com/tmitchel/sidebar/store"
+)
+
+// the most entries returned in one page of the audit log
+const maxAuditPage = 500
+
+type auditor struct {
+	DB store.Database
+}
+
+// NewAuditor takes the database dependency and uses it to implement
+// the sidebar.Auditor interface. This interface is used to read the
+// audit log.
+func NewAuditor(db store.Database) (sidebar.Auditor, error) {
+	return &auditor{
+		DB: db,
+	}, nil
+}
+
+// GetAuditLog returns a page of the workspace's audit log if the user
+// is an admin of the workspace.
+func (a *auditor) GetAuditLog(f *sidebar.AuditFilter, uid string) (*sidebar.AuditPage, error) {
+	if err := a.DB.UserIsAdmin(uid, f.WorkspaceID); err != nil {
+		return nil, err
+	}
+
+	if f.Limit <= 0 || f.Limit > maxAuditPage {
+		f.Limit = maxAuditPage
+	}
+
+	return a.DB.GetAuditLog(f)
+}
+
+// record appends an entry to the audit log. Snapshots are stored as
+// JSON and can be nil. Failing to record doesn't fail the change
+// being recorded, but it is logged.
+func record(db store.Auditer, actor, action, target, wid string, before, after interface{}) {
+	entry := &sidebar.AuditEntry{
+		ID:          uuid.New().String(),
+		Actor:       actor,
+		Action:      action,
+		Target:      target,
+		WorkspaceID: wid,
+		CreatedAt:   time.Now(),
+		Before:      snapshot(before),
+		After:       snapshot(after),
+	}
+
+	if err := db.CreateAuditEntry(entry); err != nil {
+		logrus.Errorf("Error recording %v by %v in the audit log %v", action, actor, err)
+	}
+}
+
+// recordForUser records the change in every workspace the user belongs
+// to, for changes that aren't tied to a single workspace.
+func recordForUser(db store.Database, actor, action, target string, before, after interface{}) {
+	workspaces, err := db.GetWorkspacesForUser(target)
+	if err != nil {
+		logrus.Errorf("Error getting workspaces for %v %v", target, err)
+		return
+	}
+
+	for _, ws := range workspaces {
+		record(db, actor, action, target, ws.ID, before, after)
+	}
+}
+
+// messageSnapshot is what the audit log keeps of a message. The content
+// is left out since the log is never cleaned up, so deleting a message
+// or purging its channel wouldn't remove it otherwise.
+type messageSnapshot struct {
+	ID          string     `json:"id"`
+	Channel     string     `json:"channel"`
+	FromUser    string     `json:"from_user"`
+	ToUser      string     `json:"to_user,omitempty"`
+	ReplyTo     string     `json:"reply_to,omitempty"`
+	Attachments []string   `json:"attachments,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	EditedAt    *time.Time `json:"edited_at,omitempty"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+}
+
+// messageAudit returns the snapshot of the message for the audit log.
+func messageAudit(m *sidebar.ChatMessage) interface{} {
+	if m == nil {
+		return nil
+	}
+
+	s := &messageSnapshot{
+		ID:        m.ID,
+		Channel:   m.Channel,
+		FromUser:  m.FromUser,
+		ToUser:    m.ToUser,
+		ReplyTo:   m.ReplyTo,
+		CreatedAt: m.CreatedAt,
+		EditedAt:  m.EditedAt,
+		DeletedAt: m.DeletedAt,
+	}
+	for _, a := range m.Attachments {
+		s.Attachments = append(s.Attachments, a.ID)
+	}
+	return s
+}
+
+func snapshot(v interface{}) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		logrus.Errorf("Error taking snapshot for the audit log %v", err)
+		return nil
+	}
+	return b
+}
@@ -1,28 +1,123 @@
 package services
 
 import (
+	"strings"
+	"time"
+
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
 
 	"github.com/tmitchel/sidebar"
 	"github.com/tmitchel/sidebar/store"
 )
 
+// lockout policy for failed logins
+const (
+	// failures allowed before backoff starts
+	accountFreeAttempts = 3
+	ipFreeAttempts      = 10
+
+	// failures before the key is locked out completely
+	accountLockoutAttempts = 10
+	ipLockoutAttempts      = 50
+
+	// how long a lockout lasts, which is also the longest backoff
+	lockoutDuration = 15 * time.Minute
+)
+
 type auth struct {
-	DB store.Database
+	DB       store.Database
+	Attempts sidebar.AttemptTracker
 }
 
 // NewAuthenticater wraps a database connection with an *auth that
-// implements the sidebar.Authenticater interface.
-func NewAuthenticater(db store.Database) (sidebar.Authenticater, error) {
+// implements the sidebar.Authenticater interface. Failed logins are
+// tracked with attempts, or in memory if attempts is nil.
+func NewAuthenticater(db store.Database, attempts sidebar.AttemptTracker) (sidebar.Authenticater, error) {
+	if attempts == nil {
+		attempts = NewMemoryAttempts()
+	}
+
 	return &auth{
-		DB: db,
+		DB:       db,
+		Attempts: attempts,
 	}, nil
 }
 
 // Validate gets the requested user from the database, checks the given password,
-// then returns the full user if the password is correct.
-func (a *auth) Validate(email, password, wid string) (*sidebar.User, error) {
+// then returns the full user if the password is correct. Logins are refused
+// without checking the password while the account or IP is backing off or
+// locked out. Each attempt is counted as a failure before the password is
+// checked, so concurrent guesses can't all get in before the first one
+// fails, and taken back if it succeeds.
+func (a *auth) Validate(email, password, wid, ip string) (*sidebar.User, error) {
+	accountKey := "account:" + strings.ToLower(email)
+	ipKey := "ip:" + ip
+
+	if err := a.check(accountKey, accountFreeAttempts, accountLockoutAttempts); err != nil {
+		a.audit(email, email, ip, wid, false, "account locked")
+		return nil, err
+	}
+
+	if err := a.check(ipKey, ipFreeAttempts, ipLockoutAttempts); err != nil {
+		a.audit(email, email, ip, wid, false, "ip locked")
+		return nil, err
+	}
+
+	if err := a.count(accountKey, accountFreeAttempts, accountLockoutAttempts); err != nil {
+		a.audit(email, email, ip, wid, false, "account locked")
+		return nil, err
+	}
+
+	if err := a.count(ipKey, ipFreeAttempts, ipLockoutAttempts); err != nil {
+		a.audit(email, email, ip, wid, false, "ip locked")
+		return nil, err
+	}
+
+	user, err := a.validate(email, password, wid)
+	if err != nil {
+		a.audit(email, email, ip, wid, false, err.Error())
+		return nil, err
+	}
+
+	if err := a.Attempts.ResetLoginFailures(accountKey); err != nil {
+		logrus.Errorf("Error resetting login failures %v", err)
+	}
+
+	if err := a.Attempts.RemoveLoginFailure(ipKey); err != nil {
+		logrus.Errorf("Error removing login failure %v", err)
+	}
+
+	a.audit(user.ID, email, ip, wid, true, "")
+	return user, nil
+}
+
+// Unlock clears the failed logins for the account with the given email
+// if the current user is an admin of a workspace the account belongs to.
+func (a *auth) Unlock(email, uid, wid string) error {
+	if err := a.DB.UserIsAdmin(uid, wid); err != nil {
+		return err
+	}
+
+	authUser, err := a.DB.UserForAuth(email)
+	if err != nil {
+		return err
+	}
+
+	if err := a.DB.UserInWorkspace(authUser.ID, wid); err != nil {
+		return err
+	}
+
+	if err := a.Attempts.ResetLoginFailures("account:" + strings.ToLower(email)); err != nil {
+		return err
+	}
+
+	record(a.DB, uid, sidebar.ActionAccountUnlock, authUser.ID, wid, nil, nil)
+	return nil
+}
+
+func (a *auth) validate(email, password, wid string) (*sidebar.User, error) {
 	authUser, err := a.DB.UserForAuth(email)
 	if err != nil {
 		return nil, err
@@ -37,9 +132,74 @@ func (a *auth) Validate(email, password, wid string) (*sidebar.User, error) {
 		return nil, err
 	}
 
+	if user.IsBot {
+		return nil, errors.New("Bots can only authenticate with API tokens")
+	}
+
 	if err := a.DB.UserInWorkspace(user.ID, wid); err != nil {
 		return nil, err
 	}
 
 	return user, nil
 }
+
+// check returns a *sidebar.LockoutError if the key has failed too many
+// times recently.
+func (a *auth) check(key string, free, lockout int) error {
+	failures, last, err := a.Attempts.GetLoginFailures(key)
+	if err != nil {
+		return err
+	}
+
+	return lockedOut(failures, last, free, lockout)
+}
+
+// count records the attempt as a failure up front and checks the failures
+// from before it. Concurrent attempts each see a different count.
+func (a *auth) count(key string, free, lockout int) error {
+	failures, last, err := a.Attempts.RecordLoginFailure(key, time.Now(), lockoutDuration)
+	if err != nil {
+		return err
+	}
+
+	return lockedOut(failures, last, free, lockout)
+}
+
+// lockedOut returns a *sidebar.LockoutError if the key has to wait after
+// its failures, the last of them at the given time. After free failures,
+// each failure doubles the time the key has to wait before trying again.
+func lockedOut(failures int, last time.Time, free, lockout int) error {
+	since := time.Since(last)
+	if failures <= free || since > lockoutDuration {
+		return nil
+	}
+
+	wait := lockoutDuration
+	if failures < lockout && failures-free < 20 {
+		if backoff := time.Second << uint(failures-free); backoff < lockoutDuration {
+			wait = backoff
+		}
+	}
+
+	if wait > since {
+		return &sidebar.LockoutError{RetryAfter: (wait - since).Round(time.Second)}
+	}
+	return nil
+}
+
+// audit records the login in the audit log. Failed logins for accounts
+// that don't exist use the email as the actor.
+func (a *auth) audit(actor, email, ip, wid string, success bool, reason string) {
+	details := map[string]interface{}{
+		"email": email,
+		"ip":    ip,
+	}
+
+	action := sidebar.ActionLoginSuccess
+	if !success {
+		action = sidebar.ActionLoginFailure
+		details["reason"] = reason
+	}
+
+	record(a.DB, actor, action, email, wid, nil, details)
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/tmitchel/sidebar"
+	"github.com/tmitchel/sidebar/store"
+)
+
+// authDB has one user, counting how often their password is checked.
+type authDB struct {
+	store.Database
+
+	hash   []byte
+	checks int32
+}
+
+func (d *authDB) UserForAuth(email string) (*sidebar.User, error) {
+	atomic.AddInt32(&d.checks, 1)
+	return &sidebar.User{ID: "u1", Email: email, Password: d.hash}, nil
+}
+
+func (d *authDB) GetUser(id string) (*sidebar.User, error) {
+	return &sidebar.User{ID: id}, nil
+}
+
+func (d *authDB) UserInWorkspace(uid, wid string) error {
+	if wid != "w1" {
+		return errors.New("not a member")
+	}
+	return nil
+}
+
+func (d *authDB) CreateAuditEntry(*sidebar.AuditEntry) error {
+	return nil
+}
+
+func TestValidateConcurrentGuesses(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("right"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := &authDB{hash: hash}
+	attempts := NewMemoryAttempts()
+	a := &auth{DB: db, Attempts: attempts}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.Validate("user@example.com", "wrong", "w1", "10.0.0.1")
+		}()
+	}
+	wg.Wait()
+
+	if db.checks != accountFreeAttempts+1 {
+		t.Errorf("checked the password %v times, want %v", db.checks, accountFreeAttempts+1)
+	}
+
+	failures, _, _ := attempts.GetLoginFailures("account:user@example.com")
+	if failures < accountFreeAttempts+1 {
+		t.Errorf("recorded %v failures, want at least %v", failures, accountFreeAttempts+1)
+	}
+}
+
+func TestValidateSuccessIsNotAFailure(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("right"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attempts := NewMemoryAttempts()
+	a := &auth{DB: &authDB{hash: hash}, Attempts: attempts}
+
+	if _, err := a.Validate("user@example.com", "wrong", "w1", "10.0.0.1"); err == nil {
+		t.Fatal("logged in with the wrong password")
+	}
+	if _, err := a.Validate("user@example.com", "right", "w1", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if failures, _, _ := attempts.GetLoginFailures("account:user@example.com"); failures != 0 {
+		t.Errorf("account has %v failures after logging in, want 0", failures)
+	}
+	if failures, _, _ := attempts.GetLoginFailures("ip:10.0.0.1"); failures != 1 {
+		t.Errorf("ip has %v failures after logging in, want 1", failures)
+	}
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/pkg/errors"
+	"github.com/tmitchel/sidebar"
+)
+
+// validBlobKey matches the keys blob stores accept so keys can't
+// escape the directory or bucket.
+var validBlobKey = regexp.MustCompile(`^[a-zA-Z0-9-]+(/[a-zA-Z0-9-]+)*$`)
+
+type localBlobs struct {
+	dir string
+}
+
+// NewLocalBlobs returns a sidebar.BlobStore that keeps files in the
+// given directory, creating it if needed.
+func NewLocalBlobs(dir string) (sidebar.BlobStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "Error creating blob directory")
+	}
+
+	return &localBlobs{dir: dir}, nil
+}
+
+// Put writes the file to a temporary file first so readers never see
+// a partial file.
+func (l *localBlobs) Put(key string, r io.Reader, size int64, contentType string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return errors.Wrap(err, "Error creating blob directory")
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".upload-")
+	if err != nil {
+		return errors.Wrap(err, "Error creating blob")
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return errors.Wrap(err, "Error writing blob")
+	}
+
+	if n != size {
+		return errors.Errorf("Expected %v bytes but got %v", size, n)
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func (l *localBlobs) Get(key string) (io.ReadCloser, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Open(path)
+}
+
+func (l *localBlobs) Delete(key string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (l *localBlobs) path(key string) (string, error) {
+	if !validBlobKey.MatchString(key) {
+		return "", errors.Errorf("Invalid blob key %v", key)
+	}
+
+	return filepath.Join(l.dir, filepath.FromSlash(key)), nil
+}
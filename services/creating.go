@@ -1,7 +1,13 @@
 package services
 
 import (
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 
 	"github.com/google/uuid"
 	"github.com/tmitchel/sidebar"
@@ -9,6 +15,13 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+// most files that can be attached to one message
+const maxAttachments = 10
+
+// longest slug made from a channel's name, leaving room in the column for
+// the suffix sidebars get
+const maxSlugLength = 80
+
 type creater struct {
 	DB store.Database
 }
@@ -30,7 +43,16 @@ func (c *creater) CreateWorkspace(w *sidebar.Workspace) (*sidebar.Workspace, err
 
 	w.ID = uuid.New().String()
 	w.Token = uuid.New().String()
-	return c.DB.CreateWorkspace(w)
+	if w.DisplayImg == "" {
+		w.DisplayImg = sidebar.IdenticonURL(w.ID)
+	}
+	ws, err := c.DB.CreateWorkspace(w)
+	if err != nil {
+		return nil, err
+	}
+
+	record(c.DB, "", sidebar.ActionWorkspaceCreate, ws.ID, ws.ID, nil, ws)
+	return ws, nil
 }
 
 // CreateUser takes the new user's information and the token they were sent
@@ -51,7 +73,7 @@ func (c *creater) CreateUser(u *sidebar.User) (*sidebar.User, error) {
 
 	u.Password = hashed
 	if u.ProfileImg == "" {
-		u.ProfileImg = "https://randomuser.me/api/portraits/women/81.jpg"
+		u.ProfileImg = sidebar.IdenticonURL(u.ID)
 	}
 
 	ws, err := c.DB.GetDefaultWorkspace()
@@ -68,42 +90,369 @@ func (c *creater) CreateUser(u *sidebar.User) (*sidebar.User, error) {
 		return nil, err
 	}
 
+	record(c.DB, user.ID, sidebar.ActionUserCreate, user.ID, ws.ID, nil, user)
+	record(c.DB, user.ID, sidebar.ActionWorkspaceJoin, user.ID, ws.ID, nil, nil)
 	return user, nil
 }
 
 // CreateChannel takes the information sent for creating a new channel,
 // gives it an id and a default image if one isn't provided. The channel is
-// saved.
-func (c *creater) CreateChannel(ch *sidebar.Channel, wid string) (*sidebar.Channel, error) {
+// saved. Channel names have to be unique in the workspace, but sidebars get
+// a unique slug so they can reuse names.
+func (c *creater) CreateChannel(ch *sidebar.Channel, uid, wid string) (*sidebar.Channel, error) {
+	if err := c.newChannel(ch, uid, wid); err != nil {
+		return nil, err
+	}
+
+	channel, err := c.DB.CreateChannel(ch, wid)
+	if err != nil {
+		return nil, err
+	}
+
+	record(c.DB, uid, sidebar.ActionChannelCreate, channel.ID, wid, nil, channel)
+	return channel, nil
+}
+
+// newChannel checks the channel can be created in the workspace and fills
+// in its id, image, and slug. Sidebars can only be made off of channels
+// the user can read.
+func (c *creater) newChannel(ch *sidebar.Channel, uid, wid string) error {
 	if ch.Name == "" {
-		return nil, errors.New("Invalid fields when trying to create channel")
+		return errors.New("Invalid fields when trying to create channel")
 	}
 
 	ch.ID = uuid.New().String()
 	if ch.Image == "" {
-		ch.Image = "https://randomuser.me/api/portraits/women/81.jpg"
+		ch.Image = sidebar.IdenticonURL(ch.ID)
+	}
+
+	ch.Slug = slugify(ch.Name)
+	if ch.IsSidebar {
+		ch.Slug += "-" + ch.ID[:8]
 	}
 
 	// check if workspace exists
 	if err := c.DB.GetWorkspaceExists(wid); err != nil {
+		return err
+	}
+
+	if ch.IsSidebar {
+		if err := checkChannelAccess(c.DB, ch.Parent, uid, wid); err != nil {
+			return err
+		}
+
+		if err := checkNotArchived(c.DB, ch.Parent); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CreateDirect returns the direct channel between the current user and
+// the given users, creating it the first time they talk. Users are only
+// counted once and all of them have to be in the workspace.
+func (c *creater) CreateDirect(uids []string, uid, wid string) (*sidebar.Channel, error) {
+	seen := map[string]bool{uid: true}
+	members := []string{uid}
+	for _, id := range uids {
+		if !seen[id] {
+			seen[id] = true
+			members = append(members, id)
+		}
+	}
+
+	switch {
+	case len(members) < 2:
+		return nil, errors.New("Direct conversations need another user")
+	case len(members) > sidebar.MaxDirectMembers:
+		return nil, errors.Errorf("Direct conversations can't have more than %v users", sidebar.MaxDirectMembers)
+	}
+
+	for _, id := range members {
+		if err := c.DB.UserInWorkspace(id, wid); err != nil {
+			return nil, err
+		}
+	}
+
+	key := directKey(members)
+	if cid, err := c.DB.GetDirectChannel(wid, key); err == nil {
+		return c.DB.GetChannel(cid)
+	}
+
+	id := uuid.New().String()
+	channel := &sidebar.Channel{
+		ID:     id,
+		Name:   "direct-" + id,
+		Slug:   "direct-" + id,
+		Image:  sidebar.IdenticonURL(key),
+		Direct: true,
+	}
+	if err := c.DB.CreateDirectChannel(channel, wid, key, members); err != nil {
+		// someone else may have started the same conversation first
+		if cid, err := c.DB.GetDirectChannel(wid, key); err == nil {
+			return c.DB.GetChannel(cid)
+		}
+		return nil, err
+	}
+
+	record(c.DB, uid, sidebar.ActionChannelCreate, channel.ID, wid, nil, channel)
+	return channel, nil
+}
+
+// slugify turns a channel name into the lowercase, dash separated form
+// used in links. Names without any letters or digits get a placeholder.
+func slugify(name string) string {
+	var slug []rune
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			dash = true
+			continue
+		}
+
+		if dash && len(slug) > 0 {
+			// a dash needs a letter after it, so stop if there's no room
+			if len(slug) >= maxSlugLength-1 {
+				break
+			}
+			slug = append(slug, '-')
+		}
+
+		if len(slug) == maxSlugLength {
+			break
+		}
+		dash = false
+		slug = append(slug, r)
+	}
+
+	if len(slug) == 0 {
+		return "channel"
+	}
+	return string(slug)
+}
+
+// directKey is the sorted, comma separated list of members that direct
+// channels are found by.
+func directKey(members []string) string {
+	sorted := append([]string(nil), members...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+// ConvertDirect turns a group direct conversation the current user is part
+// of into a private channel with the given name.
+func (c *creater) ConvertDirect(cid, name, uid, wid string) (*sidebar.Channel, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, errors.New("Invalid fields when trying to convert channel")
+	}
+
+	if err := c.DB.ChannelInWorkspace(cid, wid); err != nil {
+		return nil, err
+	}
+
+	if err := c.DB.UserInChannel(uid, cid); err != nil {
 		return nil, err
 	}
 
-	channel, err := c.DB.CreateChannel(ch)
+	before, err := c.DB.GetChannel(cid)
 	if err != nil {
 		return nil, err
 	}
 
-	// add channel to the workspace
-	if err := c.DB.AddChannelToWorkspace(ch.ID, wid); err != nil {
+	if !before.Direct {
+		return nil, errors.Errorf("Channel %v isn't a direct conversation", cid)
+	}
+
+	members, err := c.DB.GetUsersInChannel(cid)
+	if err != nil {
 		return nil, err
 	}
 
-	return channel, nil
+	if len(members) < 3 {
+		return nil, errors.New("Only group conversations can be converted")
+	}
+
+	after := *before
+	after.Name = name
+	after.Slug = slugify(name)
+	if err := c.DB.ConvertDirectChannel(&after); err != nil {
+		return nil, err
+	}
+
+	after.Direct = false
+	after.Private = true
+	record(c.DB, uid, sidebar.ActionChannelUpdate, cid, wid, before, &after)
+	return &after, nil
 }
 
 // CreateMessage gives the message an id and stores in the database.
-func (c *creater) CreateMessage(m *sidebar.ChatMessage) (*sidebar.ChatMessage, error) {
+// Replies to a reply are added to the root message's thread. The sender
+// has to be able to read the channel in the workspace.
+func (c *creater) CreateMessage(m *sidebar.ChatMessage, wid string) (*sidebar.ChatMessage, error) {
+	m.Thread = nil
+	m.Mentions = nil
+	if m.ReplyTo != "" {
+		root, err := c.DB.GetMessage(m.ReplyTo)
+		if err != nil {
+			return nil, err
+		}
+
+		if root.Channel != m.Channel {
+			return nil, errors.Errorf("Message %v isn't in channel %v", root.ID, m.Channel)
+		}
+
+		if root.DeletedAt != nil {
+			return nil, errors.Errorf("Message %v has been deleted", root.ID)
+		}
+
+		if root.ReplyTo != "" {
+			m.ReplyTo = root.ReplyTo
+		}
+	}
+
+	if err := checkChannelAccess(c.DB, m.Channel, m.FromUser, wid); err != nil {
+		return nil, err
+	}
+
+	if err := checkNotArchived(c.DB, m.Channel); err != nil {
+		return nil, err
+	}
+
+	attachments, err := c.checkAttachments(m)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := formatMessage(m); err != nil {
+		return nil, err
+	}
+
 	m.ID = uuid.New().String()
-	return c.DB.CreateMessage(m)
+	m.CreatedAt = time.Now()
+	m.EditedAt = nil
+	msg, err := c.DB.CreateMessage(m)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range attachments {
+		if err := c.DB.AttachToMessage(a.ID, msg.ID); err != nil {
+			return nil, err
+		}
+		a.MessageID = msg.ID
+	}
+	msg.Attachments = attachments
+
+	if msg.Mentions, err = c.resolveMentions(msg, wid); err != nil {
+		logrus.Errorf("Error resolving mentions in %v %v", msg.ID, err)
+	}
+
+	showReferences(c.DB, wid, msg)
+	return msg, nil
+}
+
+// checkAttachments returns the full attachments for the ids sent with a
+// message. Only files the sender uploaded to the message's workspace that
+// haven't been attached to another message can be used.
+func (c *creater) checkAttachments(m *sidebar.ChatMessage) ([]*sidebar.Attachment, error) {
+	if len(m.Attachments) == 0 {
+		return nil, nil
+	}
+
+	if len(m.Attachments) > maxAttachments {
+		return nil, errors.Errorf("Messages can't have more than %v attachments", maxAttachments)
+	}
+
+	wid, err := c.DB.GetWorkspaceForChannel(m.Channel)
+	if err != nil {
+		return nil, err
+	}
+
+	var attachments []*sidebar.Attachment
+	for _, a := range m.Attachments {
+		full, err := c.DB.GetAttachment(a.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		if full.UploaderID != m.FromUser || full.WorkspaceID != wid ||
+			full.Purpose != sidebar.PurposeFile || full.MessageID != "" {
+			return nil, errors.Errorf("Attachment %v can't be added to this message", a.ID)
+		}
+
+		attachments = append(attachments, full)
+	}
+
+	return attachments, nil
+}
+
+// PromoteThread creates a sidebar off of the thread's channel with a
+// copy of every message in the thread. The current user and everyone
+// who took part in the thread are added to the sidebar.
+func (c *creater) PromoteThread(id string, ch *sidebar.Channel, uid, wid string) (*sidebar.Channel, error) {
+	root, err := c.DB.GetMessage(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if root.ReplyTo != "" {
+		if root, err = c.DB.GetMessage(root.ReplyTo); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.DB.ChannelInWorkspace(root.Channel, wid); err != nil {
+		return nil, err
+	}
+
+	if err := c.DB.UserInChannel(uid, root.Channel); err != nil {
+		return nil, err
+	}
+
+	thread, err := c.DB.GetThread(root.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	ch.IsSidebar = true
+	ch.Parent = root.Channel
+	if err := c.newChannel(ch, uid, wid); err != nil {
+		return nil, err
+	}
+
+	members := []string{uid}
+	seen := map[string]bool{uid: true}
+	for _, m := range thread {
+		if m.FromUser != "" && !seen[m.FromUser] && c.DB.UserInChannel(m.FromUser, root.Channel) == nil {
+			seen[m.FromUser] = true
+			members = append(members, m.FromUser)
+		}
+	}
+
+	var copies []*sidebar.ChatMessage
+	for _, m := range thread {
+		if m.DeletedAt != nil {
+			continue
+		}
+
+		copied := *m
+		copied.ID = uuid.New().String()
+		copied.Channel = ch.ID
+		copied.ReplyTo = ""
+		copies = append(copies, &copied)
+	}
+
+	if err := c.DB.PromoteThread(ch, wid, members, copies); err != nil {
+		return nil, err
+	}
+
+	record(c.DB, uid, sidebar.ActionChannelCreate, ch.ID, wid, nil, ch)
+	for _, member := range members {
+		record(c.DB, uid, sidebar.ActionChannelJoin, ch.ID, wid, nil, map[string]string{"user": member})
+	}
+
+	record(c.DB, uid, sidebar.ActionThreadPromote, root.ID, wid, nil, ch)
+	return ch, nil
 }
@@ -0,0 +1,294 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tmitchel/sidebar"
+	"github.com/tmitchel/sidebar/store"
+)
+
+func TestSlugify(t *testing.T) {
+	long := strings.Repeat("a", maxSlugLength)
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "general", "general"},
+		{"lowercased", "General", "general"},
+		{"spaces", "team updates", "team-updates"},
+		{"runs of punctuation", "q&a -- help!!", "q-a-help"},
+		{"leading and trailing", "  #random!  ", "random"},
+		{"digits", "2021 plans", "2021-plans"},
+		{"unicode letters", "Café Zoë", "café-zoë"},
+		{"no letters", "!!!", "channel"},
+		{"empty", "", "channel"},
+		{"cut to length", long + "b", long},
+		{"no dash at the end", long[:maxSlugLength-1] + " b", long[:maxSlugLength-1]},
+		{"dash fits", long[:maxSlugLength-2] + " bc", long[:maxSlugLength-2] + "-b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := slugify(tt.in); got != tt.want {
+				t.Errorf("slugify(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDirectKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		members []string
+		want    string
+	}{
+		{"pair", []string{"b", "a"}, "a,b"},
+		{"already sorted", []string{"a", "b"}, "a,b"},
+		{"group", []string{"c", "a", "b"}, "a,b,c"},
+		{"single", []string{"a"}, "a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			members := append([]string(nil), tt.members...)
+			if got := directKey(members); got != tt.want {
+				t.Errorf("directKey(%v) = %v, want %v", tt.members, got, tt.want)
+			}
+
+			for i := range members {
+				if members[i] != tt.members[i] {
+					t.Fatalf("directKey reordered its argument to %v", members)
+				}
+			}
+		})
+	}
+}
+
+// accessDB knows which channels are in workspace w1 and who is in them.
+type accessDB struct {
+	store.Database
+
+	channels map[string]*sidebar.Channel
+	members  map[string]bool
+}
+
+func (d *accessDB) ChannelInWorkspace(cid, wid string) error {
+	if _, ok := d.channels[cid]; !ok || wid != "w1" {
+		return errors.New("channel isn't in the workspace")
+	}
+	return nil
+}
+
+func (d *accessDB) GetChannel(cid string) (*sidebar.Channel, error) {
+	return d.channels[cid], nil
+}
+
+func (d *accessDB) UserInChannel(uid, cid string) error {
+	if !d.members[uid+"#"+cid] {
+		return errors.New("not in channel")
+	}
+	return nil
+}
+
+func (d *accessDB) UserInWorkspace(uid, wid string) error {
+	if wid != "w1" || uid == "stranger" {
+		return errors.New("not in workspace")
+	}
+	return nil
+}
+
+func TestCheckChannelAccess(t *testing.T) {
+	db := &accessDB{
+		channels: map[string]*sidebar.Channel{
+			"public":  {ID: "public"},
+			"private": {ID: "private", Private: true},
+			"direct":  {ID: "direct", Direct: true},
+		},
+		members: map[string]bool{
+			"member#private": true,
+			"member#direct":  true,
+		},
+	}
+
+	tests := []struct {
+		name    string
+		cid     string
+		uid     string
+		wid     string
+		allowed bool
+	}{
+		{"public channel, workspace member", "public", "other", "w1", true},
+		{"public channel, stranger", "public", "stranger", "w1", false},
+		{"public channel, other workspace", "public", "member", "w2", false},
+		{"private channel, member", "private", "member", "w1", true},
+		{"private channel, non-member", "private", "other", "w1", false},
+		{"direct channel, member", "direct", "member", "w1", true},
+		{"direct channel, non-member", "direct", "other", "w1", false},
+		{"unknown channel", "missing", "member", "w1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkChannelAccess(db, tt.cid, tt.uid, tt.wid)
+			if (err == nil) != tt.allowed {
+				t.Errorf("checkChannelAccess(%v, %v, %v) = %v, want allowed %v", tt.cid, tt.uid, tt.wid, err, tt.allowed)
+			}
+		})
+	}
+}
+
+// promoteDB has a thread in c1 from members and one user who has since
+// left the channel.
+type promoteDB struct {
+	store.Database
+
+	channel  *sidebar.Channel
+	members  []string
+	messages []*sidebar.ChatMessage
+}
+
+func (d *promoteDB) GetMessage(id string) (*sidebar.ChatMessage, error) {
+	if id == "reply" {
+		return &sidebar.ChatMessage{ID: id, Channel: "c1", ReplyTo: "root"}, nil
+	}
+	return &sidebar.ChatMessage{ID: id, Channel: "c1"}, nil
+}
+
+func (d *promoteDB) ChannelInWorkspace(cid, wid string) error {
+	return nil
+}
+
+func (d *promoteDB) UserInChannel(uid, cid string) error {
+	if uid == "left" {
+		return errors.New("not in channel")
+	}
+	return nil
+}
+
+func (d *promoteDB) GetThread(id string) ([]*sidebar.ChatMessage, error) {
+	now := time.Now()
+	return []*sidebar.ChatMessage{
+		{ID: "root", Channel: "c1", FromUser: "u2", Content: "root"},
+		{ID: "reply", Channel: "c1", FromUser: "u2", Content: "reply", ReplyTo: "root"},
+		{ID: "gone", Channel: "c1", FromUser: "u3", ReplyTo: "root", DeletedAt: &now},
+		{ID: "old", Channel: "c1", FromUser: "left", Content: "bye", ReplyTo: "root"},
+		{ID: "orphan", Channel: "c1", Content: "from a deleted user", ReplyTo: "root"},
+	}, nil
+}
+
+func (d *promoteDB) UserInWorkspace(uid, wid string) error {
+	return nil
+}
+
+func (d *promoteDB) GetWorkspaceExists(wid string) error {
+	return nil
+}
+
+func (d *promoteDB) GetChannel(cid string) (*sidebar.Channel, error) {
+	return &sidebar.Channel{ID: cid}, nil
+}
+
+func (d *promoteDB) PromoteThread(c *sidebar.Channel, wid string, members []string, messages []*sidebar.ChatMessage) error {
+	d.channel = c
+	d.members = members
+	d.messages = messages
+	return nil
+}
+
+func (d *promoteDB) CreateAuditEntry(*sidebar.AuditEntry) error {
+	return nil
+}
+
+func TestPromoteThread(t *testing.T) {
+	db := &promoteDB{}
+	c := &creater{DB: db}
+
+	channel, err := c.PromoteThread("reply", &sidebar.Channel{Name: "Follow up"}, "u1", "w1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if db.channel != channel || !channel.IsSidebar || channel.Parent != "c1" || !strings.HasPrefix(channel.Slug, "follow-up-") {
+		t.Fatalf("created %+v", channel)
+	}
+
+	if got := strings.Join(db.members, ","); got != "u1,u2,u3" {
+		t.Errorf("members %v, want u1,u2,u3", got)
+	}
+
+	var contents []string
+	for _, m := range db.messages {
+		if m.Channel != channel.ID || m.ReplyTo != "" || m.ID == "root" || m.ID == "reply" {
+			t.Errorf("copied %+v", m)
+		}
+		contents = append(contents, m.Content)
+	}
+	if got := strings.Join(contents, ","); got != "root,reply,bye,from a deleted user" {
+		t.Errorf("copied %v", got)
+	}
+}
+
+// sidebarDB is an accessDB that can create channels.
+type sidebarDB struct {
+	*accessDB
+
+	created []string
+}
+
+func (d *sidebarDB) GetWorkspaceExists(wid string) error {
+	return nil
+}
+
+func (d *sidebarDB) CreateChannel(ch *sidebar.Channel, wid string) (*sidebar.Channel, error) {
+	d.created = append(d.created, ch.Parent)
+	return ch, nil
+}
+
+func (d *sidebarDB) CreateAuditEntry(*sidebar.AuditEntry) error {
+	return nil
+}
+
+func TestCreateSidebarAccess(t *testing.T) {
+	tests := []struct {
+		name    string
+		parent  string
+		uid     string
+		allowed bool
+	}{
+		{"public parent", "public", "other", true},
+		{"private parent, member", "private", "member", true},
+		{"private parent, non-member", "private", "other", false},
+		{"direct parent, non-member", "direct", "other", false},
+		{"parent in another workspace", "missing", "member", false},
+		{"no parent", "", "member", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &sidebarDB{accessDB: &accessDB{
+				channels: map[string]*sidebar.Channel{
+					"public":  {ID: "public"},
+					"private": {ID: "private", Private: true},
+					"direct":  {ID: "direct", Direct: true},
+				},
+				members: map[string]bool{
+					"member#private": true,
+				},
+			}}
+			c := &creater{DB: db}
+
+			_, err := c.CreateChannel(&sidebar.Channel{Name: "aside", IsSidebar: true, Parent: tt.parent}, tt.uid, "w1")
+			if (err == nil) != tt.allowed {
+				t.Fatalf("CreateChannel under %v by %v = %v, want allowed %v", tt.parent, tt.uid, err, tt.allowed)
+			}
+			if !tt.allowed && len(db.created) != 0 {
+				t.Fatalf("created sidebars under %v", db.created)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package services
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// how far ahead to look for the next time a cron rule matches
+const cronHorizon = 5
+
+// shorthands for common rules
+var cronMacros = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+	"@yearly":  "0 0 1 1 *",
+}
+
+// cronRule is a standard five field cron rule: minute, hour, day of the
+// month, month, and day of the week. Fields can be *, numbers, ranges
+// like 1-5, lists like 1,15, and steps like */15. Sunday is 0 or 7.
+type cronRule struct {
+	minute, hour, dom, month, dow uint64
+
+	// when only one of the day fields is restricted it's the only one
+	// that matters, otherwise a day matching either one is used
+	domAny, dowAny bool
+}
+
+func parseCron(expr string) (*cronRule, error) {
+	expr = strings.TrimSpace(expr)
+	if macro, ok := cronMacros[expr]; ok {
+		expr = macro
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, errors.Errorf("Cron rule %q needs 5 fields", expr)
+	}
+
+	var c cronRule
+	var err error
+	if c.minute, err = cronField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if c.hour, err = cronField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if c.dom, err = cronField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if c.month, err = cronField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if c.dow, err = cronField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+
+	c.domAny = fields[2] == "*"
+	c.dowAny = fields[4] == "*"
+	return &c, nil
+}
+
+// cronField returns a bit set of the values the field matches.
+func cronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return 0, errors.Errorf("Invalid step in %q", part)
+			}
+			step = n
+			part = part[:i]
+		}
+
+		lo, hi := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, errors.Errorf("Invalid range %q", part)
+			}
+			if hi, err = strconv.Atoi(bounds[1]); err != nil {
+				return 0, errors.Errorf("Invalid range %q", part)
+			}
+		default:
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return 0, errors.Errorf("Invalid value %q", part)
+			}
+
+			// a single value with a step runs to the end of the field
+			lo = n
+			if step == 1 {
+				hi = n
+			}
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return 0, errors.Errorf("%q is out of range %v-%v", part, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+// next returns the first time after t that the rule matches, in loc. The
+// zero time is returned if the rule doesn't match in the next few years,
+// like a rule for February 30th. Times skipped by a daylight saving change
+// don't match, and times repeated by one only match the first time, so
+// the wall clock always moves forward.
+func (c *cronRule) next(t time.Time, loc *time.Location) time.Time {
+	after := t.In(loc)
+	wall := wallClock(after)
+	t = time.Date(after.Year(), after.Month(), after.Day(), after.Hour(), after.Minute()+1, 0, 0, loc)
+	limit := t.AddDate(cronHorizon, 0, 0)
+
+	for t.Before(limit) {
+		prev := t
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case c.minute&(1<<uint(t.Minute())) == 0,
+			!t.After(after) || !wallClock(t).After(wall):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+
+		// daylight saving changes can move a time backwards
+		if !t.After(prev) {
+			t = prev.Add(time.Minute)
+		}
+	}
+
+	return time.Time{}
+}
+
+func (c *cronRule) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// wallClock returns the date and time shown on a clock in t's location,
+// without the offset, so times repeated by daylight saving compare equal.
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr string
+		ok   bool
+	}{
+		{"* * * * *", true},
+		{"0 9 * * 1-5", true},
+		{"*/15 * * * *", true},
+		{"0 0,12 1,15 * *", true},
+		{"5/10 * * * *", true},
+		{"0 0 * * 7", true},
+		{"@daily", true},
+		{" @weekly ", true},
+		{"* * * *", false},
+		{"* * * * * *", false},
+		{"60 * * * *", false},
+		{"* 24 * * *", false},
+		{"* * 0 * *", false},
+		{"* * * 13 *", false},
+		{"* * * * 8", false},
+		{"5-1 * * * *", false},
+		{"*/0 * * * *", false},
+		{"a * * * *", false},
+		{"1- * * * *", false},
+		{"@sometimes", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := parseCron(tt.expr)
+			if (err == nil) != tt.ok {
+				t.Errorf("parseCron(%q) = %v, want ok %v", tt.expr, err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	utc := time.UTC
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		rule string
+		loc  *time.Location
+		from time.Time
+		want time.Time
+	}{
+		{"weekdays skip the weekend", "0 9 * * 1-5", utc,
+			time.Date(2021, 6, 4, 10, 0, 0, 0, utc), time.Date(2021, 6, 7, 9, 0, 0, 0, utc)},
+		{"step", "*/15 * * * *", utc,
+			time.Date(2021, 6, 1, 10, 7, 30, 0, utc), time.Date(2021, 6, 1, 10, 15, 0, 0, utc)},
+		{"strictly after", "30 10 * * *", utc,
+			time.Date(2021, 6, 1, 10, 30, 0, 0, utc), time.Date(2021, 6, 2, 10, 30, 0, 0, utc)},
+		{"next month", "0 0 1 * *", utc,
+			time.Date(2021, 1, 31, 12, 0, 0, 0, utc), time.Date(2021, 2, 1, 0, 0, 0, 0, utc)},
+		{"leap day", "0 0 29 2 *", utc,
+			time.Date(2021, 3, 1, 0, 0, 0, 0, utc), time.Date(2024, 2, 29, 0, 0, 0, 0, utc)},
+		{"never", "0 0 30 2 *", utc,
+			time.Date(2021, 3, 1, 0, 0, 0, 0, utc), time.Time{}},
+		{"day of the month or week", "0 12 13 * 5", utc,
+			time.Date(2021, 6, 1, 0, 0, 0, 0, utc), time.Date(2021, 6, 4, 12, 0, 0, 0, utc)},
+		{"sunday as 7", "0 8 * * 7", utc,
+			time.Date(2021, 6, 5, 8, 0, 0, 0, utc), time.Date(2021, 6, 6, 8, 0, 0, 0, utc)},
+		{"other time zone", "0 9 * * *", tokyo,
+			time.Date(2021, 6, 1, 0, 0, 0, 0, utc), time.Date(2021, 6, 2, 0, 0, 0, 0, utc)},
+		{"skipped by spring forward", "30 2 * * *", ny,
+			time.Date(2021, 3, 13, 12, 0, 0, 0, ny), time.Date(2021, 3, 15, 2, 30, 0, 0, ny)},
+		{"hourly over spring forward", "0 * * * *", ny,
+			time.Date(2021, 3, 14, 1, 30, 0, 0, ny), time.Date(2021, 3, 14, 3, 0, 0, 0, ny)},
+		{"daily across spring forward", "0 9 * * *", ny,
+			time.Date(2021, 3, 13, 9, 0, 0, 0, ny), time.Date(2021, 3, 14, 9, 0, 0, 0, ny)},
+		{"first of a repeated time", "30 1 * * *", ny,
+			time.Date(2021, 11, 6, 12, 0, 0, 0, ny), time.Date(2021, 11, 7, 5, 30, 0, 0, utc)},
+		{"repeated time only once", "30 1 * * *", ny,
+			time.Date(2021, 11, 7, 5, 30, 0, 0, utc), time.Date(2021, 11, 8, 1, 30, 0, 0, ny)},
+		{"hourly over fall back", "0 * * * *", ny,
+			time.Date(2021, 11, 7, 5, 0, 0, 0, utc), time.Date(2021, 11, 7, 2, 0, 0, 0, ny)},
+		{"daily across fall back", "0 9 * * *", ny,
+			time.Date(2021, 11, 6, 9, 0, 0, 0, ny), time.Date(2021, 11, 7, 9, 0, 0, 0, ny)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := parseCron(tt.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := rule.next(tt.from, tt.loc)
+			if !got.Equal(tt.want) {
+				t.Errorf("next(%v) for %q = %v, want %v", tt.from, tt.rule, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,29 +1,152 @@
 package services
 
 import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	"github.com/tmitchel/sidebar"
 	"github.com/tmitchel/sidebar/store"
 )
 
 type deleter struct {
-	DB store.Deleter
+	DB    store.Database
+	Blobs sidebar.BlobStore
 }
 
-// NewDeleter takes the database dependency and uses it to implement
-// the sidebar.Deleter interface. This interface is used to remove
-// objects from store the database.
-func NewDeleter(db store.Deleter) (sidebar.Deleter, error) {
+// NewDeleter takes the database and blob store dependencies and uses them
+// to implement the sidebar.Deleter interface. This interface is used to
+// remove objects from the database along with any files they uploaded.
+func NewDeleter(db store.Database, blobs sidebar.BlobStore) (sidebar.Deleter, error) {
 	return &deleter{
-		DB: db,
+		DB:    db,
+		Blobs: blobs,
 	}, nil
 }
 
-// DeleteChannel deletes the channel with the given id.
-func (a *deleter) DeleteChannel(id string) (*sidebar.Channel, error) {
-	return a.DB.DeleteChannel(id)
+// DeleteChannel archives the channel and its sidebars and schedules them
+// to be purged once the retention period is over. Only admins can delete
+// channels and they have to confirm by sending the channel's slug.
+func (a *deleter) DeleteChannel(id, confirm, uid, wid string) (*sidebar.Channel, error) {
+	if err := a.DB.UserIsAdmin(uid, wid); err != nil {
+		return nil, err
+	}
+
+	if err := a.DB.ChannelInWorkspace(id, wid); err != nil {
+		return nil, err
+	}
+
+	before, err := a.DB.GetChannel(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if confirm != before.Slug {
+		return nil, errors.Errorf("Confirm deleting channel %v by sending its slug", id)
+	}
+
+	if before.DeleteAfter != nil {
+		return nil, errors.Errorf("Channel %v is already being deleted", id)
+	}
+
+	now := time.Now()
+	if err := a.DB.ScheduleChannelDeletion(id, now, now.Add(sidebar.ChannelRetention)); err != nil {
+		return nil, err
+	}
+
+	after, err := a.DB.GetChannel(id)
+	if err != nil {
+		return nil, err
+	}
+
+	record(a.DB, uid, sidebar.ActionChannelDelete, id, wid, before, after)
+	return after, nil
 }
 
-// DeleteUser deletes the user with the given id.
+// PurgeChannels removes deleted channels whose retention period is over,
+// along with their messages and attachments.
+func (a *deleter) PurgeChannels() error {
+	now := time.Now()
+	due, err := a.DB.DueChannelDeletions(now)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range due {
+		channel, err := a.DB.GetChannel(id)
+		if err != nil {
+			logrus.Errorf("Unable to get channel %v to purge %v", id, err)
+			continue
+		}
+
+		wid, err := a.DB.GetWorkspaceForChannel(id)
+		if err != nil {
+			logrus.Errorf("Unable to get workspace for channel %v %v", id, err)
+			continue
+		}
+
+		purged, attachments, err := a.DB.PurgeChannel(id, now)
+		if err != nil {
+			logrus.Errorf("Unable to purge channel %v %v", id, err)
+			continue
+		}
+		removeBlobs(a.Blobs, attachments)
+
+		if purged {
+			record(a.DB, "", sidebar.ActionChannelPurge, id, wid, channel, nil)
+		}
+	}
+
+	return nil
+}
+
+// DeleteMessage replaces the message with a tombstone so replies and
+// sidebars still have something to point to. Authors can delete their
+// own messages and admins can delete any message in the workspace. Files
+// attached to the message are deleted with it.
+func (a *deleter) DeleteMessage(id, uid, wid string) (*sidebar.ChatMessage, error) {
+	msg, err := a.DB.GetMessage(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.DB.ChannelInWorkspace(msg.Channel, wid); err != nil {
+		return nil, err
+	}
+
+	if err := checkNotArchived(a.DB, msg.Channel); err != nil {
+		return nil, err
+	}
+
+	if msg.FromUser != uid {
+		if err := a.DB.UserIsAdmin(uid, wid); err != nil {
+			return nil, errors.Wrapf(err, "User %v can't delete message %v", uid, id)
+		}
+	}
+
+	now := time.Now()
+	attachments, err := a.DB.DeleteMessage(id, now)
+	if err != nil {
+		return nil, err
+	}
+	removeBlobs(a.Blobs, attachments)
+
+	before := messageAudit(msg)
+	msg.Content = ""
+	msg.DeletedAt = &now
+	record(a.DB, uid, sidebar.ActionMessageDelete, id, wid, before, messageAudit(msg))
+	return msg, nil
+}
+
+// DeleteUser deletes the user with the given id. Messages they sent are
+// kept without an author.
 func (a *deleter) DeleteUser(id string) (*sidebar.User, error) {
+	// record before deleting so we still know the user's workspaces
+	user, err := a.DB.GetUser(id)
+	if err != nil {
+		return nil, err
+	}
+	recordForUser(a.DB, id, sidebar.ActionUserDelete, id, user, nil)
+
 	return a.DB.DeleteUser(id)
 }
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tmitchel/sidebar"
+	"github.com/tmitchel/sidebar/store"
+)
+
+// memoryBlobs keeps blobs in a map.
+type memoryBlobs map[string]string
+
+func (b memoryBlobs) Put(key string, r io.Reader, size int64, contentType string) error {
+	data, err := ioutil.ReadAll(r)
+	b[key] = string(data)
+	return err
+}
+
+func (b memoryBlobs) Get(key string) (io.ReadCloser, error) {
+	data, ok := b[key]
+	if !ok {
+		return nil, errors.New("no blob")
+	}
+	return ioutil.NopCloser(strings.NewReader(data)), nil
+}
+
+func (b memoryBlobs) Delete(key string) error {
+	delete(b, key)
+	return nil
+}
+
+// deleteDB has a message with an attachment in a channel that's due to
+// be purged.
+type deleteDB struct {
+	store.Database
+}
+
+func (d *deleteDB) GetMessage(id string) (*sidebar.ChatMessage, error) {
+	return &sidebar.ChatMessage{ID: id, FromUser: "u1", Channel: "c1"}, nil
+}
+
+func (d *deleteDB) ChannelInWorkspace(cid, wid string) error {
+	return nil
+}
+
+func (d *deleteDB) GetChannel(cid string) (*sidebar.Channel, error) {
+	return &sidebar.Channel{ID: cid}, nil
+}
+
+func (d *deleteDB) GetWorkspaceForChannel(cid string) (string, error) {
+	return "w1", nil
+}
+
+func (d *deleteDB) DeleteMessage(id string, at time.Time) ([]string, error) {
+	return []string{"a1"}, nil
+}
+
+func (d *deleteDB) DueChannelDeletions(now time.Time) ([]string, error) {
+	return []string{"c1"}, nil
+}
+
+func (d *deleteDB) PurgeChannel(cid string, now time.Time) (bool, []string, error) {
+	return true, []string{"a1"}, nil
+}
+
+func (d *deleteDB) CreateAuditEntry(*sidebar.AuditEntry) error {
+	return nil
+}
+
+func TestDeleteRemovesBlobs(t *testing.T) {
+	tests := []struct {
+		name   string
+		delete func(*deleter) error
+	}{
+		{"message", func(d *deleter) error {
+			_, err := d.DeleteMessage("m1", "u1", "w1")
+			return err
+		}},
+		{"purged channel", func(d *deleter) error {
+			return d.PurgeChannels()
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blobs := memoryBlobs{
+				blobKey("a1"):           "file",
+				thumbnailKey("a1", 32):  "small",
+				thumbnailKey("a1", 512): "large",
+				blobKey("a2"):           "other file",
+			}
+
+			if err := tt.delete(&deleter{DB: &deleteDB{}, Blobs: blobs}); err != nil {
+				t.Fatal(err)
+			}
+
+			var left []string
+			for key := range blobs {
+				left = append(left, key)
+			}
+			sort.Strings(left)
+
+			if got := strings.Join(left, ","); got != blobKey("a2") {
+				t.Fatalf("blobs left %v, want %v", got, blobKey("a2"))
+			}
+		})
+	}
+}
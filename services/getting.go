@@ -31,9 +31,15 @@ func (g *getter) GetDefaultWorkspace() (*sidebar.Workspace, error) {
 	return g.DB.GetDefaultWorkspace()
 }
 
-// GetUser returns the user with the given id.
-func (g *getter) GetUser(id string) (*sidebar.User, error) {
-	return g.DB.GetUser(id)
+// GetWorkspaceSettings returns the settings for the workspace.
+func (g *getter) GetWorkspaceSettings(wid string) (*sidebar.WorkspaceSettings, error) {
+	return g.DB.GetWorkspaceSettings(wid)
+}
+
+// GetUser returns the user with the given id using their profile in
+// the workspace.
+func (g *getter) GetUser(id, wid string) (*sidebar.User, error) {
+	return userWithFields(g.DB, id, wid)
 }
 
 // GetChannel returns the channel with the given id.
@@ -41,30 +47,55 @@ func (g *getter) GetChannel(id string) (*sidebar.Channel, error) {
 	return g.DB.GetChannel(id)
 }
 
-// GetMessage returns the message with the given id.
-func (g *getter) GetMessage(id string) (*sidebar.ChatMessage, error) {
-	return g.DB.GetMessage(id)
+// GetMessage returns the message with the given id if the user can read
+// its channel in the workspace.
+func (g *getter) GetMessage(id, uid, wid string) (*sidebar.ChatMessage, error) {
+	msg, err := g.DB.GetMessage(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkChannelAccess(g.DB, msg.Channel, uid, wid); err != nil {
+		return nil, err
+	}
+
+	showReferences(g.DB, wid, msg)
+	return msg, nil
 }
 
-// GetUsers returns all users in the given workspace.
+// GetUsers returns all users in the given workspace along with their
+// profiles in it.
 func (g *getter) GetUsers(wid string) ([]*sidebar.User, error) {
-	users, err := g.DB.GetUsers()
+	users, err := g.DB.GetUsers(wid)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := g.DB.GetProfileValues(wid)
 	if err != nil {
 		return nil, err
 	}
 
-	var usersInWS []*sidebar.User
 	for _, u := range users {
-		if err := g.DB.UserInWorkspace(u.ID, wid); err != nil {
-			continue
-		}
-		usersInWS = append(usersInWS, u)
+		u.Fields = values[u.ID]
 	}
-	return usersInWS, nil
+	return users, nil
+}
+
+// GetChannels returns all channels in the given workspace. Direct and
+// private channels are left out unless the user is a member, and archived
+// channels are left out.
+func (g *getter) GetChannels(uid, wid string) ([]*sidebar.Channel, error) {
+	return g.channelsInWorkspace(uid, wid, false)
 }
 
-// GetChannels returns all channels in the given workspace.
-func (g *getter) GetChannels(wid string) ([]*sidebar.Channel, error) {
+// GetArchivedChannels returns the archived channels in the given workspace
+// that the user can see.
+func (g *getter) GetArchivedChannels(uid, wid string) ([]*sidebar.Channel, error) {
+	return g.channelsInWorkspace(uid, wid, true)
+}
+
+func (g *getter) channelsInWorkspace(uid, wid string, archived bool) ([]*sidebar.Channel, error) {
 	channels, err := g.DB.GetChannels()
 	if err != nil {
 		return nil, err
@@ -72,31 +103,33 @@ func (g *getter) GetChannels(wid string) ([]*sidebar.Channel, error) {
 
 	var channelsInWS []*sidebar.Channel
 	for _, c := range channels {
+		if (c.ArchivedAt != nil) != archived {
+			continue
+		}
+
 		if err := g.DB.ChannelInWorkspace(c.ID, wid); err != nil {
 			continue
 		}
+
+		if c.Direct || c.Private {
+			if err := g.DB.UserInChannel(uid, c.ID); err != nil {
+				continue
+			}
+		}
 		channelsInWS = append(channelsInWS, c)
 	}
 	return channelsInWS, nil
 }
 
-// GetMessages returns all messages sent in a channel that is part of
-// the current workspace.
-func (g *getter) GetMessages(wid string) ([]*sidebar.ChatMessage, error) {
+// GetMessages returns all messages the user can read in channels that are
+// part of the current workspace.
+func (g *getter) GetMessages(uid, wid string) ([]*sidebar.ChatMessage, error) {
 	messages, err := g.DB.GetMessages()
 	if err != nil {
 		return nil, err
 	}
 
-	var messagesInWS []*sidebar.ChatMessage
-	for _, m := range messages {
-		if g.DB.ChannelInWorkspace(m.Channel, wid); err != nil {
-			continue
-		}
-		messagesInWS = append(messagesInWS, m)
-	}
-
-	return messagesInWS, nil
+	return g.readable(messages, uid, wid), nil
 }
 
 // GetUsersInChannel checks whether the given channel is in the current
@@ -112,66 +145,208 @@ func (g *getter) GetUsersInChannel(id, wid string) ([]*sidebar.User, error) {
 
 // GetChannelsForUser gets all the channels in which the user is a member.
 // Users can join channels in any of their workspaces, so we need to check
-// if each channel is part of the current workspace.
+// if each channel is part of the current workspace. Archived channels are
+// left out.
 func (g *getter) GetChannelsForUser(id, wid string) ([]*sidebar.Channel, error) {
 	channels, err := g.DB.GetChannelsForUser(id)
 	if err != nil {
 		return nil, err
 	}
 
+	unread, mentions, err := g.DB.GetUnreadCounts(id)
+	if err != nil {
+		return nil, err
+	}
+
 	var channelsInWS []*sidebar.Channel
 	for _, c := range channels {
+		if c.ArchivedAt != nil {
+			continue
+		}
+
 		if err := g.DB.ChannelInWorkspace(c.ID, wid); err != nil {
 			continue
 		}
+		c.Unread = unread[c.ID]
+		c.UnreadMentions = mentions[c.ID]
 		channelsInWS = append(channelsInWS, c)
 	}
 	return channelsInWS, nil
 }
 
 // GetMessagesInChannel returns all messages for the given channel after checking
-// that the provided channel is in the current workspace.
-func (g *getter) GetMessagesInChannel(id, wid string) ([]*sidebar.ChatMessage, error) {
-	if err := g.DB.ChannelInWorkspace(id, wid); err != nil {
+// that the user can read the channel in the current workspace. Reactions are
+// counted for each message, noting which ones came from the current user.
+func (g *getter) GetMessagesInChannel(id, uid, wid string) ([]*sidebar.ChatMessage, error) {
+	if err := checkChannelAccess(g.DB, id, uid, wid); err != nil {
+		return nil, err
+	}
+
+	messages, err := g.DB.GetMessagesInChannel(id)
+	if err != nil {
 		return nil, err
 	}
-	return g.DB.GetMessagesInChannel(id)
+
+	reactions, err := g.DB.GetReactionsInChannel(id, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range messages {
+		m.Reactions = reactions[m.ID]
+	}
+
+	return messages, g.addDetails(messages, wid)
 }
 
-// GetMessagesFromUser returns all messages sent by the user in a channel that is
-// a member of the current workspace.
-func (g *getter) GetMessagesFromUser(id, wid string) ([]*sidebar.ChatMessage, error) {
-	messages, err := g.DB.GetMessagesFromUser(id)
+// GetThread returns the thread the message is part of, starting with the
+// root message, after checking the user can read the thread's channel in
+// the current workspace.
+func (g *getter) GetThread(id, uid, wid string) ([]*sidebar.ChatMessage, error) {
+	msg, err := g.DB.GetMessage(id)
 	if err != nil {
 		return nil, err
 	}
 
-	var messagesInWS []*sidebar.ChatMessage
+	if err := checkChannelAccess(g.DB, msg.Channel, uid, wid); err != nil {
+		return nil, err
+	}
+
+	if msg.ReplyTo != "" {
+		id = msg.ReplyTo
+	}
+
+	thread, err := g.DB.GetThread(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return thread, g.addDetails(thread, wid)
+}
+
+// addDetails fills in the attachments, link previews, and referenced names
+// for each message.
+func (g *getter) addDetails(messages []*sidebar.ChatMessage, wid string) error {
+	ids := make([]string, len(messages))
+	for i, m := range messages {
+		ids[i] = m.ID
+	}
+
+	attachments, err := g.DB.GetAttachmentsForMessages(ids)
+	if err != nil {
+		return err
+	}
+
+	previews, err := g.DB.GetPreviewsForMessages(ids)
+	if err != nil {
+		return err
+	}
+
 	for _, m := range messages {
-		if g.DB.ChannelInWorkspace(m.Channel, wid); err != nil {
+		m.Attachments = attachments[m.ID]
+		m.Previews = previews[m.ID]
+	}
+
+	showReferences(g.DB, wid, messages...)
+	return nil
+}
+
+// GetMentions returns the user's unread mentions in the workspace. The
+// content of messages in channels the user can't read is left out.
+func (g *getter) GetMentions(uid, wid string) ([]*sidebar.Mention, error) {
+	mentions, err := g.DB.GetUnreadMentions(uid, wid)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := make(map[string]bool)
+	for _, m := range mentions {
+		if m.Message == nil {
 			continue
 		}
-		messagesInWS = append(messagesInWS, m)
+
+		cid := m.Message.Channel
+		ok, checked := allowed[cid]
+		if !checked {
+			ok = checkChannelAccess(g.DB, cid, uid, wid) == nil
+			allowed[cid] = ok
+		}
+
+		if !ok {
+			m.Message = &sidebar.ChatMessage{
+				ID:        m.Message.ID,
+				Channel:   cid,
+				FromUser:  m.Message.FromUser,
+				CreatedAt: m.Message.CreatedAt,
+			}
+			continue
+		}
+		showReferences(g.DB, wid, m.Message)
 	}
 
-	return messagesInWS, nil
+	return mentions, nil
 }
 
-// GetMessagesToUser returns all messages sent to the user in a channel that is
-// a member of the current workspace.
-func (g *getter) GetMessagesToUser(id, wid string) ([]*sidebar.ChatMessage, error) {
+// GetMessagesFromUser returns all messages sent by the user that the current
+// user can read in channels that are part of the current workspace.
+func (g *getter) GetMessagesFromUser(id, uid, wid string) ([]*sidebar.ChatMessage, error) {
+	messages, err := g.DB.GetMessagesFromUser(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return g.readable(messages, uid, wid), nil
+}
+
+// GetMessagesToUser returns all messages sent to the user that the current
+// user can read in channels that are part of the current workspace.
+func (g *getter) GetMessagesToUser(id, uid, wid string) ([]*sidebar.ChatMessage, error) {
 	messages, err := g.DB.GetMessagesToUser(id)
 	if err != nil {
 		return nil, err
 	}
 
-	var messagesInWS []*sidebar.ChatMessage
+	return g.readable(messages, uid, wid), nil
+}
+
+// readable filters out messages in channels the user can't read in the
+// workspace and shows references in the rest. Each channel is only
+// checked once.
+func (g *getter) readable(messages []*sidebar.ChatMessage, uid, wid string) []*sidebar.ChatMessage {
+	allowed := make(map[string]bool)
+	var filtered []*sidebar.ChatMessage
 	for _, m := range messages {
-		if g.DB.ChannelInWorkspace(m.Channel, wid); err != nil {
-			continue
+		ok, checked := allowed[m.Channel]
+		if !checked {
+			ok = checkChannelAccess(g.DB, m.Channel, uid, wid) == nil
+			allowed[m.Channel] = ok
+		}
+
+		if ok {
+			filtered = append(filtered, m)
 		}
-		messagesInWS = append(messagesInWS, m)
 	}
 
-	return messagesInWS, nil
+	showReferences(g.DB, wid, filtered...)
+	return filtered
+}
+
+// checkChannelAccess makes sure the channel is in the workspace and the
+// user can see it. Direct and private channels are only open to their
+// members while public channels are open to the whole workspace.
+func checkChannelAccess(db store.Database, cid, uid, wid string) error {
+	if err := db.ChannelInWorkspace(cid, wid); err != nil {
+		return err
+	}
+
+	channel, err := db.GetChannel(cid)
+	if err != nil {
+		return err
+	}
+
+	if channel.Direct || channel.Private {
+		return db.UserInChannel(uid, cid)
+	}
+
+	return db.UserInWorkspace(uid, wid)
 }
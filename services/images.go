@@ -0,0 +1,434 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+
+	"github.com/pkg/errors"
+	"github.com/tmitchel/sidebar"
+)
+
+// processedImage is an uploaded image after it has been re-encoded
+// along with the thumbnails made from it.
+type processedImage struct {
+	data          []byte
+	width, height int
+	thumbnails    []*thumbnail
+}
+
+type thumbnail struct {
+	sidebar.Thumbnail
+	data []byte
+}
+
+// processImage decodes the image to make sure it really is one, turns it
+// upright, and encodes it again. Encoding again drops EXIF and any other
+// metadata, like the location a photo was taken. Square thumbnails are
+// cropped from the middle of the image.
+func processImage(data []byte, contentType string, square bool) (*processedImage, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid image")
+	}
+
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > sidebar.MaxImagePixels {
+		return nil, errors.Errorf("Images can't be larger than %v pixels", sidebar.MaxImagePixels)
+	}
+
+	var img *image.RGBA
+	var buf bytes.Buffer
+	switch contentType {
+	case "image/jpeg":
+		decoded, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, errors.Wrap(err, "Invalid image")
+		}
+
+		img = orient(toRGBA(decoded), jpegOrientation(data))
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90})
+		if err != nil {
+			return nil, errors.Wrap(err, "Error encoding image")
+		}
+	case "image/png":
+		decoded, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, errors.Wrap(err, "Invalid image")
+		}
+
+		img = toRGBA(decoded)
+		if err := png.Encode(&buf, decoded); err != nil {
+			return nil, errors.Wrap(err, "Error encoding image")
+		}
+	case "image/gif":
+		frames, pixels := gifFrames(data)
+		if frames > sidebar.MaxGIFFrames {
+			return nil, errors.Errorf("GIFs can't have more than %v frames", sidebar.MaxGIFFrames)
+		}
+		if pixels > sidebar.MaxGIFPixels {
+			return nil, errors.Errorf("GIFs can't have more than %v pixels across all frames", sidebar.MaxGIFPixels)
+		}
+
+		// keep every frame so animations still play
+		decoded, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return nil, errors.Wrap(err, "Invalid image")
+		}
+
+		img = image.NewRGBA(image.Rect(0, 0, decoded.Config.Width, decoded.Config.Height))
+		first := decoded.Image[0]
+		draw.Draw(img, first.Bounds(), first, first.Bounds().Min, draw.Over)
+		if err := gif.EncodeAll(&buf, decoded); err != nil {
+			return nil, errors.Wrap(err, "Error encoding image")
+		}
+	default:
+		return nil, errors.Errorf("Images of type %v can't be processed", contentType)
+	}
+
+	p := &processedImage{
+		data:   buf.Bytes(),
+		width:  img.Bounds().Dx(),
+		height: img.Bounds().Dy(),
+	}
+
+	src := img
+	if square {
+		src = cropSquare(img)
+	}
+	bounds := src.Bounds()
+
+	// make the largest thumbnail first and scale the rest down from the
+	// one before, which is much faster than starting from the original
+	for i := len(sidebar.ThumbnailSizes) - 1; i >= 0; i-- {
+		size := sidebar.ThumbnailSizes[i]
+		w, h := fit(bounds.Dx(), bounds.Dy(), size)
+		if w >= bounds.Dx() || h >= bounds.Dy() {
+			// images are never scaled up
+			continue
+		}
+
+		src = resize(src, w, h)
+
+		var buf bytes.Buffer
+		t := &thumbnail{Thumbnail: sidebar.Thumbnail{Size: size, Width: w, Height: h}}
+		if contentType == "image/jpeg" {
+			t.ContentType = "image/jpeg"
+			err = jpeg.Encode(&buf, src, &jpeg.Options{Quality: 85})
+		} else {
+			// keep transparency
+			t.ContentType = "image/png"
+			err = png.Encode(&buf, src)
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "Error encoding thumbnail")
+		}
+
+		t.data = buf.Bytes()
+		t.Bytes = int64(len(t.data))
+		p.thumbnails = append([]*thumbnail{t}, p.thumbnails...)
+	}
+
+	return p, nil
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	return dst
+}
+
+// fit scales w by h so the longest side is size.
+func fit(w, h, size int) (int, int) {
+	if w >= h {
+		return size, max(1, h*size/w)
+	}
+	return max(1, w*size/h), size
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func cropSquare(img *image.RGBA) *image.RGBA {
+	b := img.Bounds()
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+
+	x := b.Min.X + (b.Dx()-side)/2
+	y := b.Min.Y + (b.Dy()-side)/2
+	return img.SubImage(image.Rect(x, y, x+side, y+side)).(*image.RGBA)
+}
+
+// resize scales the image down to w by h. Each new pixel is the average
+// of the block of pixels it covers in the original.
+func resize(src *image.RGBA, w, h int) *image.RGBA {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	for y := 0; y < h; y++ {
+		y0, y1 := y*sh/h, (y+1)*sh/h
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+
+		for x := 0; x < w; x++ {
+			x0, x1 := x*sw/w, (x+1)*sw/w
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, bl, a, n int
+			for sy := y0; sy < y1; sy++ {
+				i := src.PixOffset(b.Min.X+x0, b.Min.Y+sy)
+				for sx := x0; sx < x1; sx++ {
+					r += int(src.Pix[i])
+					g += int(src.Pix[i+1])
+					bl += int(src.Pix[i+2])
+					a += int(src.Pix[i+3])
+					i += 4
+					n++
+				}
+			}
+
+			j := dst.PixOffset(x, y)
+			dst.Pix[j] = uint8(r / n)
+			dst.Pix[j+1] = uint8(g / n)
+			dst.Pix[j+2] = uint8(bl / n)
+			dst.Pix[j+3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
+
+// orient flips and rotates the image so it displays the way the EXIF
+// orientation says it should.
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // flip horizontally
+				sx, sy = w-1-x, y
+			case 3: // rotate 180°
+				sx, sy = w-1-x, h-1-y
+			case 4: // flip vertically
+				sx, sy = x, h-1-y
+			case 5: // flip across the diagonal
+				sx, sy = y, x
+			case 6: // rotate 90° clockwise
+				sx, sy = y, h-1-x
+			case 7: // flip across the other diagonal
+				sx, sy = w-1-y, h-1-x
+			case 8: // rotate 90° counterclockwise
+				sx, sy = w-1-y, x
+			}
+
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(sx, sy):src.PixOffset(sx, sy)+4])
+		}
+	}
+
+	return dst
+}
+
+// jpegOrientation looks for the orientation tag in the JPEG's EXIF data.
+// Images without one are upright.
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+
+		marker := data[i+1]
+		switch {
+		case marker == 0xFF:
+			// padding
+			i++
+			continue
+		case marker == 0xDA || marker == 0xD9:
+			// EXIF comes before the image data
+			return 1
+		case marker >= 0xD0 && marker <= 0xD7 || marker == 0x01:
+			// markers without a length
+			i += 2
+			continue
+		}
+
+		n := int(binary.BigEndian.Uint16(data[i+2:]))
+		if n < 2 || i+2+n > len(data) {
+			return 1
+		}
+
+		segment := data[i+4 : i+2+n]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		i += 2 + n
+	}
+
+	return 1
+}
+
+// tiffOrientation reads the orientation tag from the first directory of
+// the TIFF structure EXIF data is stored in.
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	offset := int64(order.Uint32(tiff[4:]))
+	if offset < 8 || offset+2 > int64(len(tiff)) {
+		return 1
+	}
+
+	entries := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < entries; i++ {
+		entry := int(offset) + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			// a short stored at the start of the value
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation < 1 || orientation > 8 {
+				return 1
+			}
+			return orientation
+		}
+	}
+
+	return 1
+}
+
+// gifFrames counts the frames in the GIF and the pixels in all of them
+// from the image descriptors, without decoding anything. Counting stops
+// at the first block that doesn't make sense and leaves the rest to the
+// decoder.
+func gifFrames(data []byte) (frames, pixels int) {
+	if len(data) < 13 || !bytes.HasPrefix(data, []byte("GIF8")) {
+		return 0, 0
+	}
+
+	// header and logical screen descriptor, then the global color table
+	i := 13
+	if data[10]&0x80 != 0 {
+		i += 3 << (data[10]&0x07 + 1)
+	}
+
+	for i < len(data) {
+		switch data[i] {
+		case 0x21:
+			// extension label, then data sub-blocks
+			i = skipSubBlocks(data, i+2)
+		case 0x2C:
+			if i+10 > len(data) {
+				return frames, pixels
+			}
+
+			w := int(binary.LittleEndian.Uint16(data[i+5:]))
+			h := int(binary.LittleEndian.Uint16(data[i+7:]))
+			frames++
+			pixels += w * h
+
+			// local color table, then the LZW code size and image data
+			packed := data[i+9]
+			i += 10
+			if packed&0x80 != 0 {
+				i += 3 << (packed&0x07 + 1)
+			}
+			i = skipSubBlocks(data, i+1)
+		default:
+			// the trailer or something unexpected
+			return frames, pixels
+		}
+	}
+
+	return frames, pixels
+}
+
+// skipSubBlocks returns the index just past the sub-blocks starting at i,
+// which end with an empty block.
+func skipSubBlocks(data []byte, i int) int {
+	for i < len(data) {
+		n := int(data[i])
+		i++
+		if n == 0 {
+			break
+		}
+		i += n
+	}
+	return i
+}
+
+// identicon draws a default image for the seed: a mirrored 5x5 grid of
+// blocks in a color picked from a hash of the seed, so the same user or
+// channel always gets the same image.
+func identicon(seed string, size int) ([]byte, error) {
+	sum := sha256.Sum256([]byte(seed))
+	fg := color.RGBA{64 + sum[0]%160, 64 + sum[1]%160, 64 + sum[2]%160, 255}
+	bg := color.RGBA{240, 240, 240, 255}
+
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(img, img.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+
+	cell := size / 6
+	margin := (size - 5*cell) / 2
+	bits := binary.BigEndian.Uint32(sum[3:])
+	for row := 0; row < 5; row++ {
+		for col := 0; col < 3; col++ {
+			if bits&(1<<uint(row*3+col)) == 0 {
+				continue
+			}
+
+			for _, c := range []int{col, 4 - col} {
+				r := image.Rect(margin+c*cell, margin+row*cell, margin+(c+1)*cell, margin+(row+1)*cell)
+				draw.Draw(img, r, image.NewUniform(fg), image.Point{}, draw.Src)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, errors.Wrap(err, "Error encoding identicon")
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"strings"
+	"testing"
+
+	"github.com/tmitchel/sidebar"
+)
+
+// animation encodes a GIF with a frame of each of the given sizes. Every
+// other frame gets its own palette so local color tables are covered.
+func animation(t *testing.T, sizes ...image.Point) []byte {
+	g := &gif.GIF{}
+	for i, size := range sizes {
+		p := color.Palette{color.Black, color.White}
+		if i%2 == 1 {
+			p = palette.Plan9
+		}
+		g.Image = append(g.Image, image.NewPaletted(image.Rect(0, 0, size.X, size.Y), p))
+		g.Delay = append(g.Delay, 10)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestGIFFrames(t *testing.T) {
+	three := animation(t, image.Pt(4, 5), image.Pt(4, 5), image.Pt(2, 3))
+
+	tests := []struct {
+		name   string
+		data   []byte
+		frames int
+		pixels int
+	}{
+		{"one frame", animation(t, image.Pt(10, 10)), 1, 100},
+		{"animation", three, 3, 46},
+		{"not a gif", []byte("\x89PNG\r\n\x1a\n0000000000"), 0, 0},
+		{"empty", nil, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames, pixels := gifFrames(tt.data)
+			if frames != tt.frames || pixels != tt.pixels {
+				t.Errorf("gifFrames() = %v frames, %v pixels, want %v, %v", frames, pixels, tt.frames, tt.pixels)
+			}
+		})
+	}
+
+	t.Run("truncated", func(t *testing.T) {
+		for n := range three {
+			if frames, pixels := gifFrames(three[:n]); frames > 3 || pixels > 46 {
+				t.Fatalf("gifFrames() of %v bytes = %v frames, %v pixels", n, frames, pixels)
+			}
+		}
+	})
+}
+
+func TestProcessImageLimitsGIFs(t *testing.T) {
+	sizes := make([]image.Point, sidebar.MaxGIFFrames+1)
+	for i := range sizes {
+		sizes[i] = image.Pt(1, 1)
+	}
+
+	_, err := processImage(animation(t, sizes...), "image/gif", false)
+	if err == nil || !strings.Contains(err.Error(), "frames") {
+		t.Fatalf("processImage() = %v, want a frame limit error", err)
+	}
+
+	if _, err := processImage(animation(t, image.Pt(8, 8), image.Pt(8, 8)), "image/gif", false); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,417 @@
+package services
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/tmitchel/sidebar"
+	"github.com/tmitchel/sidebar/store"
+)
+
+// limits on rendering so a single message can't do too much work
+const (
+	maxQuoteDepth = 5
+	maxReferences = 50
+)
+
+var (
+	codeLanguage = regexp.MustCompile(`^[A-Za-z0-9_+-]{1,20}$`)
+	orderedItem  = regexp.MustCompile(`^[0-9]{1,9}\. `)
+	reference    = regexp.MustCompile(`^<([#@])([A-Za-z0-9-]{1,64})>`)
+
+	// rendered references, with or without a name in them
+	renderedReference = regexp.MustCompile(`<span class="(channel|user)-ref" data-(?:channel|user)="([A-Za-z0-9-]{1,64})">[^<]*</span>`)
+)
+
+// characters that can be escaped with a backslash
+const escapable = "\\`*_~[]()<>#@-+.!"
+
+// formatMessage cleans up the message's content, checks its length, and
+// renders it to HTML. Channels and users referenced by id are stored
+// without their names, which are filled in by showReferences whenever the
+// message is read so renames show up.
+func formatMessage(m *sidebar.ChatMessage) error {
+	m.Content = canonicalContent(m.Content)
+	if n := utf8.RuneCountInString(m.Content); n > sidebar.MaxMessageLength {
+		return errors.Errorf("Messages can't be longer than %v characters", sidebar.MaxMessageLength)
+	}
+
+	m.HTML = renderMarkdown(m.Content)
+	return nil
+}
+
+// showReferences fills in the current names of the channels and users
+// referenced in the messages' HTML. References to anything that isn't in
+// the workspace go back to plain text. Each name is only looked up once.
+func showReferences(db store.Database, wid string, messages ...*sidebar.ChatMessage) {
+	names := make(map[string]string)
+	lookup := func(kind, id string) string {
+		key := kind + id
+		if name, ok := names[key]; ok {
+			return name
+		}
+
+		var name string
+		switch kind {
+		case "channel":
+			if db.ChannelInWorkspace(id, wid) == nil {
+				if channel, err := db.GetChannel(id); err == nil {
+					name = "#" + channel.Name
+				}
+			}
+		case "user":
+			if user, err := db.GetUserInWorkspace(id, wid); err == nil {
+				name = "@" + user.DisplayName
+			}
+		}
+
+		names[key] = name
+		return name
+	}
+
+	for _, m := range messages {
+		if m == nil || !strings.Contains(m.HTML, "-ref") {
+			continue
+		}
+
+		m.HTML = renderedReference.ReplaceAllStringFunc(m.HTML, func(span string) string {
+			match := renderedReference.FindStringSubmatch(span)
+			name := lookup(match[1], match[2])
+			if name == "" {
+				sigil := "#"
+				if match[1] == "user" {
+					sigil = "@"
+				}
+				return html.EscapeString("<" + sigil + match[2] + ">")
+			}
+			return `<span class="` + match[1] + `-ref" data-` + match[1] + `="` + match[2] + `">` + html.EscapeString(name) + `</span>`
+		})
+	}
+}
+
+// canonicalContent is the form message content is stored in: valid UTF-8
+// with Unix line endings, no control characters, and no trailing spaces.
+func canonicalContent(s string) string {
+	s = strings.ToValidUTF8(s, "�")
+	s = strings.Replace(s, "\r\n", "\n", -1)
+	s = strings.Replace(s, "\r", "\n", -1)
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && r != '\n' && r != '\t' {
+			return -1
+		}
+		return r
+	}, s)
+
+	lines := strings.Split(s, "\n")
+	for i := range lines {
+		lines[i] = strings.TrimRightFunc(lines[i], unicode.IsSpace)
+	}
+	return strings.Trim(strings.Join(lines, "\n"), "\n")
+}
+
+// renderer turns the Markdown subset messages can use into HTML. Every
+// bit of text is escaped and the only tags written are the ones for the
+// syntax below, so the result is safe to show as is:
+//
+//	```code blocks```, `inline code`, **bold**, *italic*, ~~strikethrough~~,
+//	[links](https://example.com), > quotes, - lists, 1. numbered lists,
+//	<#channel id> and <@user id>
+type renderer struct {
+	references map[string]bool
+	b          strings.Builder
+}
+
+func renderMarkdown(src string) string {
+	r := &renderer{
+		references: make(map[string]bool),
+	}
+	r.blocks(strings.Split(src, "\n"), 0)
+	return r.b.String()
+}
+
+func (r *renderer) blocks(lines []string, depth int) {
+	for i := 0; i < len(lines); {
+		trimmed := strings.TrimSpace(lines[i])
+		switch {
+		case trimmed == "":
+			i++
+		case strings.HasPrefix(trimmed, "```"):
+			// a code block without an end runs to the end of the message
+			j := i + 1
+			for j < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[j]), "```") {
+				j++
+			}
+
+			r.b.WriteString("<pre><code")
+			if lang := strings.TrimSpace(trimmed[3:]); codeLanguage.MatchString(lang) {
+				r.b.WriteString(` class="language-` + lang + `"`)
+			}
+			r.b.WriteString(">" + html.EscapeString(strings.Join(lines[i+1:j], "\n")) + "</code></pre>")
+			i = j + 1
+		case strings.HasPrefix(trimmed, ">") && depth < maxQuoteDepth:
+			var quoted []string
+			for ; i < len(lines); i++ {
+				line := strings.TrimSpace(lines[i])
+				if !strings.HasPrefix(line, ">") {
+					break
+				}
+				quoted = append(quoted, strings.TrimPrefix(line[1:], " "))
+			}
+
+			r.b.WriteString("<blockquote>")
+			r.blocks(quoted, depth+1)
+			r.b.WriteString("</blockquote>")
+		case listItem(trimmed) != "":
+			kind := listItem(trimmed)
+			r.b.WriteString("<" + kind + ">")
+			for ; i < len(lines); i++ {
+				line := strings.TrimSpace(lines[i])
+				if listItem(line) != kind {
+					break
+				}
+
+				item := line[2:]
+				if kind == "ol" {
+					item = line[strings.IndexByte(line, ' ')+1:]
+				}
+				r.b.WriteString("<li>" + r.inline(item, false) + "</li>")
+			}
+			r.b.WriteString("</" + kind + ">")
+		default:
+			// lines in a paragraph keep their line breaks
+			r.b.WriteString("<p>")
+			for start := i; i < len(lines); i++ {
+				line := strings.TrimSpace(lines[i])
+				if i > start && (line == "" || strings.HasPrefix(line, "```") || strings.HasPrefix(line, ">") || listItem(line) != "") {
+					break
+				}
+
+				if i > start {
+					r.b.WriteString("<br>")
+				}
+				r.b.WriteString(r.inline(line, false))
+			}
+			r.b.WriteString("</p>")
+		}
+	}
+}
+
+// listItem returns the kind of list the line is an item of, if any.
+func listItem(line string) string {
+	switch {
+	case strings.HasPrefix(line, "- "), strings.HasPrefix(line, "* "):
+		return "ul"
+	case orderedItem.MatchString(line):
+		return "ol"
+	}
+	return ""
+}
+
+// emphasis markers and the tags they become, longest first
+var emphasisMarkers = []struct {
+	delim, tag string
+}{
+	{"**", "strong"},
+	{"__", "strong"},
+	{"~~", "del"},
+	{"*", "em"},
+	{"_", "em"},
+}
+
+func (r *renderer) inline(s string, inLink bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s) && strings.IndexByte(escapable, s[i+1]) >= 0:
+			b.WriteString(html.EscapeString(s[i+1 : i+2]))
+			i += 2
+			continue
+		case c == '`':
+			if j := strings.IndexByte(s[i+1:], '`'); j >= 0 {
+				b.WriteString("<code>" + html.EscapeString(s[i+1:i+1+j]) + "</code>")
+				i += j + 2
+				continue
+			}
+		case c == '<':
+			if n, out := r.reference(s[i:]); n > 0 {
+				b.WriteString(out)
+				i += n
+				continue
+			}
+		case c == '[' && !inLink:
+			if n, out := r.link(s[i:]); n > 0 {
+				b.WriteString(out)
+				i += n
+				continue
+			}
+		case c == 'h' && !inLink && wordStart(s, i):
+			if n := autolink(s[i:]); n > 0 {
+				b.WriteString(anchor(s[i:i+n], html.EscapeString(s[i:i+n])))
+				i += n
+				continue
+			}
+		case c == '*' || c == '_' || c == '~':
+			if n, out := r.emphasis(s, i, inLink); n > 0 {
+				b.WriteString(out)
+				i += n
+				continue
+			}
+		}
+
+		_, size := utf8.DecodeRuneInString(s[i:])
+		b.WriteString(html.EscapeString(s[i : i+size]))
+		i += size
+	}
+
+	return b.String()
+}
+
+// emphasis renders the emphasized text starting at s[i] and returns how
+// much of s it used. Underscores only count at the start and end of
+// words so names like snake_case are left alone.
+func (r *renderer) emphasis(s string, i int, inLink bool) (int, string) {
+	for _, e := range emphasisMarkers {
+		if !strings.HasPrefix(s[i:], e.delim) {
+			continue
+		}
+
+		if e.delim[0] == '_' && !wordStart(s, i) {
+			return 0, ""
+		}
+
+		rest := s[i+len(e.delim):]
+		if j := closing(rest, e.delim); j > 0 {
+			return len(e.delim)*2 + j, "<" + e.tag + ">" + r.inline(rest[:j], inLink) + "</" + e.tag + ">"
+		}
+		return 0, ""
+	}
+	return 0, ""
+}
+
+// closing finds the marker that ends emphasized text. The text can't
+// start or end with a space.
+func closing(s, delim string) int {
+	if s == "" || s[0] == ' ' {
+		return -1
+	}
+
+	for j := 0; j < len(s); {
+		k := strings.Index(s[j:], delim)
+		if k < 0 {
+			return -1
+		}
+		k += j
+
+		end := k + len(delim)
+		switch {
+		case len(delim) == 1 && end < len(s) && s[end] == delim[0]:
+			// part of a longer marker
+			j = end + 1
+		case k == 0 || s[k-1] == ' ':
+			j = end
+		case delim[0] == '_' && end < len(s) && isNameRune(firstRune(s[end:])):
+			j = end
+		default:
+			return k
+		}
+	}
+	return -1
+}
+
+// link renders a [text](url) link. Links that aren't http, https, or
+// mailto are left as text.
+func (r *renderer) link(s string) (int, string) {
+	textEnd := strings.IndexByte(s, ']')
+	if textEnd < 0 || textEnd+1 >= len(s) || s[textEnd+1] != '(' {
+		return 0, ""
+	}
+
+	urlEnd := strings.IndexByte(s[textEnd+2:], ')')
+	if urlEnd < 0 {
+		return 0, ""
+	}
+
+	href := strings.TrimSpace(s[textEnd+2 : textEnd+2+urlEnd])
+	if !safeURL(href) {
+		return 0, ""
+	}
+
+	return textEnd + 3 + urlEnd, anchor(href, r.inline(s[1:textEnd], true))
+}
+
+// reference renders an empty placeholder for a <#channel> or <@user>
+// reference, which showReferences fills in with the name. Only so many
+// different references are rendered, and the rest are left as text.
+func (r *renderer) reference(s string) (int, string) {
+	match := reference.FindStringSubmatch(s)
+	if match == nil {
+		return 0, ""
+	}
+
+	key := match[1] + match[2]
+	if !r.references[key] {
+		if len(r.references) >= maxReferences {
+			return 0, ""
+		}
+		r.references[key] = true
+	}
+
+	if match[1] == "#" {
+		return len(match[0]), `<span class="channel-ref" data-channel="` + match[2] + `"></span>`
+	}
+	return len(match[0]), `<span class="user-ref" data-user="` + match[2] + `"></span>`
+}
+
+// autolink returns the length of the bare URL at the start of s, leaving
+// off punctuation that probably ends the sentence instead.
+func autolink(s string) int {
+	if !strings.HasPrefix(s, "http://") && !strings.HasPrefix(s, "https://") {
+		return 0
+	}
+
+	n := strings.IndexFunc(s, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '<'
+	})
+	if n < 0 {
+		n = len(s)
+	}
+
+	n = len(strings.TrimRight(s[:n], `.,;:!?)"'`))
+	if !safeURL(s[:n]) {
+		return 0
+	}
+	return n
+}
+
+func anchor(href, text string) string {
+	return `<a href="` + html.EscapeString(href) + `" rel="nofollow noopener noreferrer" target="_blank">` + text + `</a>`
+}
+
+func safeURL(href string) bool {
+	u, err := url.Parse(href)
+	if err != nil {
+		return false
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		return u.Host != ""
+	case "mailto":
+		return u.Opaque != ""
+	}
+	return false
+}
+
+func wordStart(s string, i int) bool {
+	return i == 0 || !isNameRune(lastRune(s[:i]))
+}
+
+func firstRune(s string) rune {
+	r, _ := utf8.DecodeRuneInString(s)
+	return r
+}
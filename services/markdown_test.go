@@ -0,0 +1,95 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/tmitchel/sidebar"
+	"github.com/tmitchel/sidebar/store"
+)
+
+// referenceDB has channel c1 and user u1 in workspace w1, with names that
+// can change between reads.
+type referenceDB struct {
+	store.Database
+
+	channel, user string
+	lookups       int
+}
+
+func (d *referenceDB) ChannelInWorkspace(cid, wid string) error {
+	if cid != "c1" || wid != "w1" {
+		return errors.New("not in workspace")
+	}
+	return nil
+}
+
+func (d *referenceDB) GetChannel(cid string) (*sidebar.Channel, error) {
+	d.lookups++
+	return &sidebar.Channel{ID: cid, Name: d.channel}, nil
+}
+
+func (d *referenceDB) GetUserInWorkspace(uid, wid string) (*sidebar.User, error) {
+	d.lookups++
+	if uid != "u1" || wid != "w1" {
+		return nil, errors.New("not in workspace")
+	}
+	return &sidebar.User{ID: uid, DisplayName: d.user}, nil
+}
+
+func TestShowReferences(t *testing.T) {
+	m := &sidebar.ChatMessage{Content: "see <#c1>, ask <@u1> or <@nobody>"}
+	if err := formatMessage(m); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := `<p>see <span class="channel-ref" data-channel="c1"></span>, ask <span class="user-ref" data-user="u1"></span> ` +
+		`or <span class="user-ref" data-user="nobody"></span></p>`
+	if m.HTML != stored {
+		t.Fatalf("stored HTML %q, want %q", m.HTML, stored)
+	}
+
+	db := &referenceDB{channel: "general", user: "alice"}
+	tests := []struct {
+		name    string
+		channel string
+		user    string
+		wid     string
+		want    string
+	}{
+		{"names", "general", "alice", "w1",
+			`<p>see <span class="channel-ref" data-channel="c1">#general</span>, ask <span class="user-ref" data-user="u1">@alice</span> or &lt;@nobody&gt;</p>`},
+		{"after a rename", "news", "<b>al</b>", "w1",
+			`<p>see <span class="channel-ref" data-channel="c1">#news</span>, ask <span class="user-ref" data-user="u1">@&lt;b&gt;al&lt;/b&gt;</span> or &lt;@nobody&gt;</p>`},
+		{"other workspace", "news", "alice", "w2",
+			`<p>see &lt;#c1&gt;, ask &lt;@u1&gt; or &lt;@nobody&gt;</p>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db.channel, db.user = tt.channel, tt.user
+			read := &sidebar.ChatMessage{HTML: stored}
+			showReferences(db, tt.wid, read)
+			if read.HTML != tt.want {
+				t.Errorf("showReferences() = %q, want %q", read.HTML, tt.want)
+			}
+		})
+	}
+
+	t.Run("names stored by older versions", func(t *testing.T) {
+		db.channel = "renamed"
+		read := &sidebar.ChatMessage{HTML: `<p><span class="channel-ref" data-channel="c1">#old</span></p>`}
+		showReferences(db, "w1", read)
+		if want := `<p><span class="channel-ref" data-channel="c1">#renamed</span></p>`; read.HTML != want {
+			t.Errorf("showReferences() = %q, want %q", read.HTML, want)
+		}
+	})
+
+	t.Run("each name looked up once", func(t *testing.T) {
+		db.lookups = 0
+		showReferences(db, "w1", &sidebar.ChatMessage{HTML: stored}, &sidebar.ChatMessage{HTML: stored}, nil)
+		if db.lookups != 3 {
+			t.Errorf("looked up %v names, want 3", db.lookups)
+		}
+	})
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/tmitchel/sidebar"
+)
+
+// resolveMentions finds the users mentioned in the message and stores
+// the mentions. Members of the workspace can be mentioned by display
+// name even if they aren't in a public channel, but direct and private
+// channels only mention their members. @channel and @here mention
+// everyone in the channel, but @here mentions aren't stored since they
+// are only meant for users who are online.
+func (c *creater) resolveMentions(m *sidebar.ChatMessage, wid string) ([]*sidebar.Mention, error) {
+	if !strings.Contains(m.Content, "@") {
+		return nil, nil
+	}
+
+	members, err := c.DB.GetUsers(wid)
+	if err != nil {
+		return nil, err
+	}
+
+	named, everyone := parseMentions(m.Content, members)
+	if len(named) == 0 && everyone == "" {
+		return nil, nil
+	}
+
+	channel, err := c.DB.GetChannel(m.Channel)
+	if err != nil {
+		return nil, err
+	}
+
+	inChannel, err := c.DB.GetUsersInChannel(m.Channel)
+	if err != nil {
+		return nil, err
+	}
+
+	kinds := make(map[string]string)
+	isMember := make(map[string]bool, len(inChannel))
+	for _, u := range inChannel {
+		isMember[u.ID] = true
+		if everyone != "" {
+			kinds[u.ID] = everyone
+		}
+	}
+
+	// mentioning someone by name wins over @channel and @here
+	for _, u := range named {
+		if (channel.Direct || channel.Private) && !isMember[u.ID] {
+			continue
+		}
+		kinds[u.ID] = sidebar.MentionUser
+	}
+	delete(kinds, m.FromUser)
+
+	var mentions, stored []*sidebar.Mention
+	for uid, kind := range kinds {
+		mention := &sidebar.Mention{MessageID: m.ID, UserID: uid, Kind: kind}
+		mentions = append(mentions, mention)
+		if kind != sidebar.MentionHere {
+			stored = append(stored, mention)
+		}
+	}
+
+	if err := c.DB.CreateMentions(stored); err != nil {
+		return nil, err
+	}
+
+	return mentions, nil
+}
+
+// parseMentions returns the members mentioned by display name, or by id
+// as <@id>, along with sidebar.MentionChannel or sidebar.MentionHere if
+// the content mentions everyone in the channel. Display names can contain
+// spaces, so the longest name that matches is used.
+func parseMentions(content string, members []*sidebar.User) ([]*sidebar.User, string) {
+	sorted := make([]*sidebar.User, len(members))
+	copy(sorted, members)
+	sort.Slice(sorted, func(i, j int) bool {
+		return len(sorted[i].DisplayName) > len(sorted[j].DisplayName)
+	})
+
+	var named []*sidebar.User
+	var everyone string
+	seen := make(map[string]bool)
+	for i := strings.IndexByte(content, '@'); i >= 0; {
+		rest := content[i+1:]
+		if i == 0 || !isNameRune(lastRune(content[:i])) {
+			switch {
+			case i > 0 && content[i-1] == '<' && strings.IndexByte(rest, '>') > 0:
+				id := rest[:strings.IndexByte(rest, '>')]
+				for _, u := range members {
+					if u.ID == id && !seen[u.ID] {
+						seen[u.ID] = true
+						named = append(named, u)
+					}
+				}
+			case hasName(rest, "channel"):
+				everyone = sidebar.MentionChannel
+			case hasName(rest, "here"):
+				if everyone == "" {
+					everyone = sidebar.MentionHere
+				}
+			default:
+				for _, u := range sorted {
+					if u.DisplayName != "" && hasName(rest, u.DisplayName) {
+						if !seen[u.ID] {
+							seen[u.ID] = true
+							named = append(named, u)
+						}
+						break
+					}
+				}
+			}
+		}
+
+		next := strings.IndexByte(rest, '@')
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+
+	return named, everyone
+}
+
+// hasName returns true if s starts with the name, ignoring case, and the
+// name isn't just the start of a longer word.
+func hasName(s, name string) bool {
+	if len(s) < len(name) || !strings.EqualFold(s[:len(name)], name) {
+		return false
+	}
+
+	if len(s) == len(name) {
+		return true
+	}
+
+	r, _ := utf8.DecodeRuneInString(s[len(name):])
+	return !isNameRune(r)
+}
+
+func lastRune(s string) rune {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return r
+}
+
+func isNameRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/tmitchel/sidebar"
+	"github.com/tmitchel/sidebar/store"
+)
+
+func TestParseMentions(t *testing.T) {
+	members := []*sidebar.User{
+		{ID: "u1", DisplayName: "alice"},
+		{ID: "u2", DisplayName: "alice smith"},
+		{ID: "u3", DisplayName: "bob"},
+		{ID: "u4", DisplayName: "Zoë"},
+	}
+
+	tests := []struct {
+		name     string
+		content  string
+		named    string
+		everyone string
+	}{
+		{"no mentions", "hello there", "", ""},
+		{"by name", "hi @bob", "u3", ""},
+		{"ignores case", "hi @BOB!", "u3", ""},
+		{"longest name wins", "ping @alice smith please", "u2", ""},
+		{"shorter name", "ping @alice please", "u1", ""},
+		{"by id", "hi <@u3>", "u3", ""},
+		{"unknown id", "hi <@nobody>", "", ""},
+		{"start of a longer word", "hi @bobby", "", ""},
+		{"inside an email address", "mail bob@example.com", "", ""},
+		{"counted once", "@bob @bob <@u3>", "u3", ""},
+		{"several", "@bob and @Zoë", "u3,u4", ""},
+		{"channel", "@channel look", "", sidebar.MentionChannel},
+		{"here", "@here look", "", sidebar.MentionHere},
+		{"channel wins over here", "@here and @channel", "", sidebar.MentionChannel},
+		{"not a channel mention", "@channels", "", ""},
+		{"names and everyone", "@here @bob", "u3", sidebar.MentionHere},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			named, everyone := parseMentions(tt.content, members)
+
+			var ids []string
+			for _, u := range named {
+				ids = append(ids, u.ID)
+			}
+			sort.Strings(ids)
+
+			if got := strings.Join(ids, ","); got != tt.named {
+				t.Errorf("parseMentions(%q) named %v, want %v", tt.content, got, tt.named)
+			}
+			if everyone != tt.everyone {
+				t.Errorf("parseMentions(%q) everyone %q, want %q", tt.content, everyone, tt.everyone)
+			}
+		})
+	}
+}
+
+// mentionDB has one workspace with a public and a private channel.
+type mentionDB struct {
+	store.Database
+
+	stored []*sidebar.Mention
+}
+
+func (d *mentionDB) GetUsers(string) ([]*sidebar.User, error) {
+	return []*sidebar.User{
+		{ID: "u1", DisplayName: "alice"},
+		{ID: "u2", DisplayName: "bob"},
+		{ID: "u3", DisplayName: "carol"},
+	}, nil
+}
+
+func (d *mentionDB) GetChannel(cid string) (*sidebar.Channel, error) {
+	return &sidebar.Channel{ID: cid, Private: cid == "private"}, nil
+}
+
+func (d *mentionDB) GetUsersInChannel(string) ([]*sidebar.User, error) {
+	return []*sidebar.User{{ID: "u1"}, {ID: "u2"}}, nil
+}
+
+func (d *mentionDB) CreateMentions(mentions []*sidebar.Mention) error {
+	d.stored = append(d.stored, mentions...)
+	return nil
+}
+
+func TestResolveMentions(t *testing.T) {
+	tests := []struct {
+		name    string
+		channel string
+		content string
+		want    string
+	}{
+		{"member of a private channel", "private", "@bob", "u2"},
+		{"non-member of a private channel", "private", "@carol", ""},
+		{"non-member of a public channel", "public", "@carol", "u3"},
+		{"everyone in a private channel", "private", "@channel @carol", "u2"},
+		{"not the sender", "public", "@alice @bob", "u2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &mentionDB{}
+			c := &creater{DB: db}
+			_, err := c.resolveMentions(&sidebar.ChatMessage{ID: "m", FromUser: "u1", Channel: tt.channel, Content: tt.content}, "w1")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var ids []string
+			for _, m := range db.stored {
+				ids = append(ids, m.UserID)
+			}
+			sort.Strings(ids)
+
+			if got := strings.Join(ids, ","); got != tt.want {
+				t.Errorf("stored mentions for %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
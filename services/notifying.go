@@ -0,0 +1,120 @@
+package services
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tmitchel/sidebar"
+	"github.com/tmitchel/sidebar/store"
+)
+
+type notifier struct {
+	DB store.Database
+}
+
+// NewNotifier wraps a database connection with a *notifier that
+// implements the sidebar.Notifier interface.
+func NewNotifier(db store.Database) (sidebar.Notifier, error) {
+	return &notifier{
+		DB: db,
+	}, nil
+}
+
+// GetNotificationPrefs returns the user's notification preferences in
+// the workspace.
+func (n *notifier) GetNotificationPrefs(uid, wid string) (*sidebar.NotificationPrefs, error) {
+	if err := n.DB.UserInWorkspace(uid, wid); err != nil {
+		return nil, err
+	}
+
+	prefs, err := n.DB.GetNotificationPrefs([]string{uid}, wid)
+	if err != nil {
+		return nil, err
+	}
+
+	return prefs[uid], nil
+}
+
+// UpdateNotificationPrefs replaces the user's notification preferences in
+// the workspace after checking every level and channel is valid.
+func (n *notifier) UpdateNotificationPrefs(prefs *sidebar.NotificationPrefs) error {
+	if err := n.DB.UserInWorkspace(prefs.UserID, prefs.WorkspaceID); err != nil {
+		return err
+	}
+
+	if prefs.Default != "" && !validLevel(prefs.Default) {
+		return errors.Errorf("Invalid notification level %v", prefs.Default)
+	}
+
+	for cid, level := range prefs.Channels {
+		if !validLevel(level) {
+			return errors.Errorf("Invalid notification level %v", level)
+		}
+
+		if err := n.DB.ChannelInWorkspace(cid, prefs.WorkspaceID); err != nil {
+			return err
+		}
+	}
+
+	if prefs.DoNotDisturb != nil {
+		if err := prefs.DoNotDisturb.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return n.DB.UpdateNotificationPrefs(prefs)
+}
+
+// AlertRecipients returns who should be alerted about the new message,
+// mapped to the kind of alert. Members of the channel are alerted
+// about every message unless they've limited the channel to mentions or
+// muted it, and mentioned users are alerted unless they've muted the
+// channel. Nobody is alerted while their do not disturb window is active.
+func (n *notifier) AlertRecipients(msg *sidebar.ChatMessage, wid string) (map[string]string, error) {
+	members, err := n.DB.GetUsersInChannel(msg.Channel)
+	if err != nil {
+		return nil, err
+	}
+
+	kinds := make(map[string]string)
+	for _, m := range members {
+		kinds[m.ID] = sidebar.AlertMessage
+	}
+
+	for _, m := range msg.Mentions {
+		kinds[m.UserID] = sidebar.AlertMention
+	}
+	delete(kinds, msg.FromUser)
+
+	uids := make([]string, 0, len(kinds))
+	for uid := range kinds {
+		uids = append(uids, uid)
+	}
+
+	prefs, err := n.DB.GetNotificationPrefs(uids, wid)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for uid, kind := range kinds {
+		p := prefs[uid]
+		level := p.Level(msg.Channel)
+		switch {
+		case level == sidebar.NotifyMute,
+			level == sidebar.NotifyMentions && kind != sidebar.AlertMention,
+			p.DoNotDisturb != nil && p.DoNotDisturb.Active(now):
+			delete(kinds, uid)
+		}
+	}
+
+	return kinds, nil
+}
+
+func validLevel(level string) bool {
+	switch level {
+	case sidebar.NotifyAll, sidebar.NotifyMentions, sidebar.NotifyMute:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,328 @@
+package services
+
+import (
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/tmitchel/sidebar"
+	"github.com/tmitchel/sidebar/store"
+)
+
+type pinner struct {
+	DB store.Database
+}
+
+// NewPinner wraps a database connection with a *pinner that
+// implements the sidebar.Pinner interface.
+func NewPinner(db store.Database) (sidebar.Pinner, error) {
+	return &pinner{
+		DB: db,
+	}, nil
+}
+
+// GetPins returns the pinned messages in a channel the user can read in
+// the workspace.
+func (p *pinner) GetPins(cid, uid, wid string) ([]*sidebar.Pin, error) {
+	if err := checkChannelAccess(p.DB, cid, uid, wid); err != nil {
+		return nil, err
+	}
+
+	pins, err := p.DB.GetPins(cid)
+	if err != nil {
+		return nil, err
+	}
+
+	showPins(p.DB, wid, pins)
+	return pins, nil
+}
+
+// PinMessage pins the message to its channel. Only members of the
+// channel can pin messages.
+func (p *pinner) PinMessage(mid, uid, wid string) (*sidebar.ChannelPins, error) {
+	msg, err := p.DB.GetMessage(mid)
+	if err != nil {
+		return nil, err
+	}
+
+	if msg.DeletedAt != nil {
+		return nil, errors.Errorf("Message %v has been deleted", mid)
+	}
+
+	if err := p.checkMember(msg.Channel, uid, wid); err != nil {
+		return nil, err
+	}
+
+	pins, err := p.DB.GetPins(msg.Channel)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pins) >= sidebar.MaxPins {
+		return nil, errors.Errorf("Channels can't have more than %v pins", sidebar.MaxPins)
+	}
+
+	pin := &sidebar.Pin{
+		Channel:   msg.Channel,
+		MessageID: mid,
+		PinnedBy:  uid,
+		CreatedAt: time.Now(),
+	}
+	if err := p.DB.CreatePin(pin); err != nil {
+		return nil, err
+	}
+
+	record(p.DB, uid, sidebar.ActionMessagePin, mid, wid, nil, pin)
+	return p.channelPins(msg.Channel, wid)
+}
+
+// UnpinMessage removes the pin from a message. Pins can be removed by the
+// user that pinned the message or by workspace admins.
+func (p *pinner) UnpinMessage(mid, uid, wid string) (*sidebar.ChannelPins, error) {
+	pin, err := p.DB.GetPin(mid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.DB.ChannelInWorkspace(pin.Channel, wid); err != nil {
+		return nil, err
+	}
+
+	if err := checkNotArchived(p.DB, pin.Channel); err != nil {
+		return nil, err
+	}
+
+	if pin.PinnedBy != uid {
+		if err := p.DB.UserIsAdmin(uid, wid); err != nil {
+			return nil, errors.Errorf("User %v can't unpin message %v", uid, mid)
+		}
+	}
+
+	if err := p.DB.DeletePin(mid); err != nil {
+		return nil, err
+	}
+
+	record(p.DB, uid, sidebar.ActionMessageUnpin, mid, wid, pin, nil)
+	return p.channelPins(pin.Channel, wid)
+}
+
+// ReorderPins puts the channel's pins in the given order. Every pinned
+// message has to be included.
+func (p *pinner) ReorderPins(cid string, mids []string, uid, wid string) (*sidebar.ChannelPins, error) {
+	if err := p.checkMember(cid, uid, wid); err != nil {
+		return nil, err
+	}
+
+	pins, err := p.DB.GetPins(cid)
+	if err != nil {
+		return nil, err
+	}
+
+	current := make([]string, len(pins))
+	for i, pin := range pins {
+		current[i] = pin.MessageID
+	}
+
+	if err := sameItems(current, mids); err != nil {
+		return nil, err
+	}
+
+	if err := p.DB.ReorderPins(cid, mids); err != nil {
+		return nil, err
+	}
+
+	record(p.DB, uid, sidebar.ActionPinReorder, cid, wid, map[string][]string{"order": current}, map[string][]string{"order": mids})
+
+	return p.channelPins(cid, wid)
+}
+
+// GetBookmarks returns the bookmarks in a channel the user can read in
+// the workspace.
+func (p *pinner) GetBookmarks(cid, uid, wid string) ([]*sidebar.Bookmark, error) {
+	if err := checkChannelAccess(p.DB, cid, uid, wid); err != nil {
+		return nil, err
+	}
+
+	return p.DB.GetBookmarks(cid)
+}
+
+// AddBookmark adds a bookmark to the channel. Bookmarks need a title and
+// either an http or https URL or a message in the same channel. Only
+// members of the channel can add bookmarks.
+func (p *pinner) AddBookmark(b *sidebar.Bookmark, uid, wid string) (*sidebar.ChannelBookmarks, error) {
+	b.Title = strings.TrimSpace(b.Title)
+	if b.Title == "" || utf8.RuneCountInString(b.Title) > 100 {
+		return nil, errors.New("Bookmarks need a title of at most 100 characters")
+	}
+
+	if err := p.checkMember(b.Channel, uid, wid); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case b.URL != "" && b.MessageID != "":
+		return nil, errors.New("Bookmarks can't have both a URL and a message")
+	case b.URL != "":
+		u, err := url.Parse(b.URL)
+		if err != nil || len(b.URL) > maxURLLength || checkURL(u) != nil {
+			return nil, errors.Errorf("Invalid bookmark URL %v", b.URL)
+		}
+	case b.MessageID != "":
+		msg, err := p.DB.GetMessage(b.MessageID)
+		if err != nil {
+			return nil, err
+		}
+
+		if msg.Channel != b.Channel || msg.DeletedAt != nil {
+			return nil, errors.Errorf("Message %v can't be bookmarked in channel %v", b.MessageID, b.Channel)
+		}
+	default:
+		return nil, errors.New("Bookmarks need a URL or a message")
+	}
+
+	bookmarks, err := p.DB.GetBookmarks(b.Channel)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(bookmarks) >= sidebar.MaxPins {
+		return nil, errors.Errorf("Channels can't have more than %v bookmarks", sidebar.MaxPins)
+	}
+
+	b.ID = uuid.New().String()
+	b.CreatedBy = uid
+	b.CreatedAt = time.Now()
+	if err := p.DB.CreateBookmark(b); err != nil {
+		return nil, err
+	}
+
+	record(p.DB, uid, sidebar.ActionBookmarkCreate, b.ID, wid, nil, b)
+	return p.channelBookmarks(b.Channel)
+}
+
+// RemoveBookmark removes a bookmark from its channel. Bookmarks can be
+// removed by the user that added them or by workspace admins.
+func (p *pinner) RemoveBookmark(id, uid, wid string) (*sidebar.ChannelBookmarks, error) {
+	b, err := p.DB.GetBookmark(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.DB.ChannelInWorkspace(b.Channel, wid); err != nil {
+		return nil, err
+	}
+
+	if err := checkNotArchived(p.DB, b.Channel); err != nil {
+		return nil, err
+	}
+
+	if b.CreatedBy != uid {
+		if err := p.DB.UserIsAdmin(uid, wid); err != nil {
+			return nil, errors.Errorf("User %v can't remove bookmark %v", uid, id)
+		}
+	}
+
+	if err := p.DB.DeleteBookmark(id); err != nil {
+		return nil, err
+	}
+
+	record(p.DB, uid, sidebar.ActionBookmarkDelete, id, wid, b, nil)
+	return p.channelBookmarks(b.Channel)
+}
+
+// ReorderBookmarks puts the channel's bookmarks in the given order. Every
+// bookmark has to be included.
+func (p *pinner) ReorderBookmarks(cid string, ids []string, uid, wid string) (*sidebar.ChannelBookmarks, error) {
+	if err := p.checkMember(cid, uid, wid); err != nil {
+		return nil, err
+	}
+
+	bookmarks, err := p.DB.GetBookmarks(cid)
+	if err != nil {
+		return nil, err
+	}
+
+	current := make([]string, len(bookmarks))
+	for i, b := range bookmarks {
+		current[i] = b.ID
+	}
+
+	if err := sameItems(current, ids); err != nil {
+		return nil, err
+	}
+
+	if err := p.DB.ReorderBookmarks(cid, ids); err != nil {
+		return nil, err
+	}
+
+	record(p.DB, uid, sidebar.ActionBookmarkReorder, cid, wid, map[string][]string{"order": current}, map[string][]string{"order": ids})
+
+	return p.channelBookmarks(cid)
+}
+
+func (p *pinner) channelPins(cid, wid string) (*sidebar.ChannelPins, error) {
+	pins, err := p.DB.GetPins(cid)
+	if err != nil {
+		return nil, err
+	}
+
+	showPins(p.DB, wid, pins)
+	return &sidebar.ChannelPins{Channel: cid, Pins: pins}, nil
+}
+
+// showPins shows references in the pinned messages.
+func showPins(db store.Database, wid string, pins []*sidebar.Pin) {
+	messages := make([]*sidebar.ChatMessage, len(pins))
+	for i, pin := range pins {
+		messages[i] = pin.Message
+	}
+
+	showReferences(db, wid, messages...)
+}
+
+func (p *pinner) channelBookmarks(cid string) (*sidebar.ChannelBookmarks, error) {
+	bookmarks, err := p.DB.GetBookmarks(cid)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sidebar.ChannelBookmarks{Channel: cid, Bookmarks: bookmarks}, nil
+}
+
+// checkMember makes sure the channel is in the workspace, isn't archived,
+// and the user is a member of it.
+func (p *pinner) checkMember(cid, uid, wid string) error {
+	if err := p.DB.ChannelInWorkspace(cid, wid); err != nil {
+		return err
+	}
+
+	if err := checkNotArchived(p.DB, cid); err != nil {
+		return err
+	}
+
+	return p.DB.UserInChannel(uid, cid)
+}
+
+// sameItems checks that order has each of the current items exactly once.
+func sameItems(current, order []string) error {
+	if len(current) != len(order) {
+		return errors.Errorf("Expected %v items but got %v", len(current), len(order))
+	}
+
+	remaining := make(map[string]bool, len(current))
+	for _, id := range current {
+		remaining[id] = true
+	}
+
+	for _, id := range order {
+		if !remaining[id] {
+			return errors.Errorf("Unknown or repeated item %v", id)
+		}
+		delete(remaining, id)
+	}
+
+	return nil
+}
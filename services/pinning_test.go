@@ -0,0 +1,84 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/tmitchel/sidebar"
+	"github.com/tmitchel/sidebar/store"
+)
+
+// reorderDB has two pins and two bookmarks in channel c1 and keeps the
+// audit log.
+type reorderDB struct {
+	store.Database
+
+	audit []*sidebar.AuditEntry
+}
+
+func (d *reorderDB) ChannelInWorkspace(cid, wid string) error {
+	return nil
+}
+
+func (d *reorderDB) GetChannel(cid string) (*sidebar.Channel, error) {
+	return &sidebar.Channel{ID: cid}, nil
+}
+
+func (d *reorderDB) UserInChannel(uid, cid string) error {
+	return nil
+}
+
+func (d *reorderDB) GetPins(cid string) ([]*sidebar.Pin, error) {
+	return []*sidebar.Pin{{Channel: cid, MessageID: "m1"}, {Channel: cid, MessageID: "m2"}}, nil
+}
+
+func (d *reorderDB) ReorderPins(cid string, mids []string) error {
+	return nil
+}
+
+func (d *reorderDB) GetBookmarks(cid string) ([]*sidebar.Bookmark, error) {
+	return []*sidebar.Bookmark{{ID: "b1", Channel: cid}, {ID: "b2", Channel: cid}}, nil
+}
+
+func (d *reorderDB) ReorderBookmarks(cid string, ids []string) error {
+	return nil
+}
+
+func (d *reorderDB) CreateAuditEntry(e *sidebar.AuditEntry) error {
+	d.audit = append(d.audit, e)
+	return nil
+}
+
+func TestReorderAudited(t *testing.T) {
+	tests := []struct {
+		name    string
+		reorder func(*pinner) error
+		action  string
+		after   string
+	}{
+		{"pins", func(p *pinner) error {
+			_, err := p.ReorderPins("c1", []string{"m2", "m1"}, "u1", "w1")
+			return err
+		}, sidebar.ActionPinReorder, `{"order":["m2","m1"]}`},
+		{"bookmarks", func(p *pinner) error {
+			_, err := p.ReorderBookmarks("c1", []string{"b2", "b1"}, "u1", "w1")
+			return err
+		}, sidebar.ActionBookmarkReorder, `{"order":["b2","b1"]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &reorderDB{}
+			if err := tt.reorder(&pinner{DB: db}); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(db.audit) != 1 {
+				t.Fatalf("recorded %v entries, want 1", len(db.audit))
+			}
+			e := db.audit[0]
+			if e.Action != tt.action || e.Actor != "u1" || e.Target != "c1" || string(e.After) != tt.after {
+				t.Fatalf("recorded %v by %v on %v after %s", e.Action, e.Actor, e.Target, e.After)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package services
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/tmitchel/sidebar"
+	"github.com/tmitchel/sidebar/store"
+)
+
+// ExpireStatuses clears statuses that have expired and returns the users
+// whose status was cleared.
+func (u *updater) ExpireStatuses() ([]*sidebar.StatusUpdate, error) {
+	return u.DB.ClearExpiredStatuses(time.Now())
+}
+
+// GetProfileFields returns the workspace's custom profile fields.
+func (u *updater) GetProfileFields(uid, wid string) ([]*sidebar.ProfileField, error) {
+	if err := u.DB.UserInWorkspace(uid, wid); err != nil {
+		return nil, err
+	}
+
+	return u.DB.GetProfileFields(wid)
+}
+
+// CreateProfileField adds a custom profile field to the workspace if the
+// current user is an admin.
+func (u *updater) CreateProfileField(f *sidebar.ProfileField, uid string) (*sidebar.ProfileField, error) {
+	if err := u.DB.UserIsAdmin(uid, f.WorkspaceID); err != nil {
+		return nil, err
+	}
+
+	f.Name = strings.TrimSpace(f.Name)
+	switch {
+	case f.Name == "":
+		return nil, errors.New("Profile fields need a name")
+	case utf8.RuneCountInString(f.Name) > sidebar.MaxProfileLength:
+		return nil, errors.Errorf("Profile field names can't be longer than %v characters", sidebar.MaxProfileLength)
+	}
+
+	fields, err := u.DB.GetProfileFields(f.WorkspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(fields) >= sidebar.MaxProfileFields {
+		return nil, errors.Errorf("Workspaces can't have more than %v profile fields", sidebar.MaxProfileFields)
+	}
+
+	f.ID = uuid.New().String()
+	f.CreatedAt = time.Now()
+	if err := u.DB.CreateProfileField(f); err != nil {
+		return nil, err
+	}
+
+	record(u.DB, uid, sidebar.ActionFieldCreate, f.ID, f.WorkspaceID, nil, f)
+	return f, nil
+}
+
+// DeleteProfileField removes a custom profile field, and every user's
+// value for it, if the current user is an admin.
+func (u *updater) DeleteProfileField(id, uid, wid string) error {
+	if err := u.DB.UserIsAdmin(uid, wid); err != nil {
+		return err
+	}
+
+	f, err := u.DB.GetProfileField(id)
+	if err != nil {
+		return err
+	}
+
+	if f.WorkspaceID != wid {
+		return errors.Errorf("Profile field %v isn't in workspace %v", id, wid)
+	}
+
+	if err := u.DB.DeleteProfileField(id); err != nil {
+		return err
+	}
+
+	record(u.DB, uid, sidebar.ActionFieldDelete, id, wid, f, nil)
+	return nil
+}
+
+// applyUpdate checks the fields set in the update and copies them to the
+// user.
+func (u *updater) applyUpdate(user *sidebar.User, update *sidebar.UserUpdate, wid string) error {
+	if update.DisplayName != nil {
+		user.DisplayName = strings.TrimSpace(*update.DisplayName)
+		if user.DisplayName == "" {
+			return errors.New("Display names can't be empty")
+		}
+	}
+
+	if update.Email != nil {
+		user.Email = strings.TrimSpace(*update.Email)
+		if user.Email == "" {
+			return errors.New("Emails can't be empty")
+		}
+	}
+
+	if update.ProfileImg != nil {
+		user.ProfileImg = *update.ProfileImg
+	}
+
+	var err error
+	if update.Title != nil {
+		if user.Title, err = profileText("Titles", *update.Title); err != nil {
+			return err
+		}
+	}
+
+	if update.Pronouns != nil {
+		if user.Pronouns, err = profileText("Pronouns", *update.Pronouns); err != nil {
+			return err
+		}
+	}
+
+	if update.TimeZone != nil {
+		user.TimeZone = strings.TrimSpace(*update.TimeZone)
+		if _, err := time.LoadLocation(user.TimeZone); err != nil {
+			return errors.Wrapf(err, "Invalid time zone %v", user.TimeZone)
+		}
+	}
+
+	if update.Status != nil {
+		if user.Status, err = checkStatus(update.Status); err != nil {
+			return err
+		}
+	}
+
+	if len(update.Fields) > 0 {
+		fields, err := u.DB.GetProfileFields(wid)
+		if err != nil {
+			return err
+		}
+
+		known := make(map[string]bool, len(fields))
+		for _, f := range fields {
+			known[f.ID] = true
+		}
+
+		for fid, value := range update.Fields {
+			if !known[fid] {
+				return errors.Errorf("Unknown profile field %v", fid)
+			}
+
+			if update.Fields[fid], err = profileText("Profile fields", value); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkStatus returns the status to store, which is nil if the status is
+// being cleared.
+func checkStatus(s *sidebar.Status) (*sidebar.Status, error) {
+	text, err := profileText("Statuses", s.Text)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.Emoji == "" && text == "" {
+		return nil, nil
+	}
+
+	if s.Emoji != "" && !validEmoji(s.Emoji) {
+		return nil, errors.Errorf("Invalid emoji %q", s.Emoji)
+	}
+
+	if s.ExpiresAt != nil && !s.ExpiresAt.After(time.Now()) {
+		return nil, errors.New("Statuses have to expire in the future")
+	}
+
+	return &sidebar.Status{Emoji: s.Emoji, Text: text, ExpiresAt: s.ExpiresAt}, nil
+}
+
+// profileText cleans up a line of profile text and checks its length.
+func profileText(what, s string) (string, error) {
+	s = strings.Join(strings.Fields(canonicalContent(s)), " ")
+	if utf8.RuneCountInString(s) > sidebar.MaxProfileLength {
+		return "", errors.Errorf("%v can't be longer than %v characters", what, sidebar.MaxProfileLength)
+	}
+
+	return s, nil
+}
+
+// userWithFields returns the user with their profile in the workspace,
+// including custom profile fields.
+func userWithFields(db store.Database, uid, wid string) (*sidebar.User, error) {
+	user, err := db.GetUserInWorkspace(uid, wid)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := db.GetUserProfileValues(uid, wid)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(values) > 0 {
+		user.Fields = values
+	}
+	return user, nil
+}
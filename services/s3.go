@@ -0,0 +1,188 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tmitchel/sidebar"
+)
+
+// S3Config is the connection information for an S3-compatible object
+// store. Endpoint is the base URL, e.g. https://s3.us-east-1.amazonaws.com
+// or http://localhost:9000 for MinIO. Buckets are always addressed by
+// path so any endpoint works.
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+}
+
+type s3Blobs struct {
+	config S3Config
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewS3Blobs returns a sidebar.BlobStore that keeps files in an S3
+// bucket. Requests are signed with AWS Signature Version 4. If client
+// is nil, a client with a timeout is used.
+func NewS3Blobs(config S3Config, client *http.Client) (sidebar.BlobStore, error) {
+	if config.Endpoint == "" || config.Bucket == "" || config.AccessKey == "" || config.SecretKey == "" {
+		return nil, errors.New("Missing S3 configuration")
+	}
+
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Minute}
+	}
+
+	return &s3Blobs{
+		config: config,
+		client: client,
+		now:    time.Now,
+	}, nil
+}
+
+func (s *s3Blobs) Put(key string, r io.Reader, size int64, contentType string) error {
+	req, err := s.request(http.MethodPut, key, r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+
+	res, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+func (s *s3Blobs) Get(key string) (io.ReadCloser, error) {
+	req, err := s.request(http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+func (s *s3Blobs) Delete(key string) error {
+	req, err := s.request(http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+func (s *s3Blobs) request(method, key string, body io.Reader) (*http.Request, error) {
+	if !validBlobKey.MatchString(key) {
+		return nil, errors.Errorf("Invalid blob key %v", key)
+	}
+
+	req, err := http.NewRequest(method, s.config.Endpoint+"/"+s.config.Bucket+"/"+key, body)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error building S3 request")
+	}
+	return req, nil
+}
+
+// do signs and sends the request, turning error responses into errors.
+func (s *s3Blobs) do(req *http.Request) (*http.Response, error) {
+	s.sign(req)
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error sending S3 request")
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		defer res.Body.Close()
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return nil, errors.Errorf("S3 %v %v failed with %v: %s", req.Method, req.URL.Path, res.Status, msg)
+	}
+
+	return res, nil
+}
+
+// sign adds the headers for AWS Signature Version 4. The payload isn't
+// hashed so uploads can be streamed.
+func (s *s3Blobs) sign(req *http.Request) {
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payload := "UNSIGNED-PAYLOAD"
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": payload,
+		"x-amz-date":           amzDate,
+	}
+	names := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if ct := req.Header.Get("Content-Type"); ct != "" {
+		headers["content-type"] = ct
+		names = []string{"content-type", "host", "x-amz-content-sha256", "x-amz-date"}
+	}
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		(&url.URL{Path: req.URL.Path}).EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payload,
+	}, "\n")
+
+	scope := date + "/" + s.config.Region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.config.SecretKey), date)
+	key = hmacSHA256(key, s.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.config.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,370 @@
+package services
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/tmitchel/sidebar"
+	"github.com/tmitchel/sidebar/store"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// longest note a reminder can have
+const maxReminderNote = 500
+
+// how long a server has to deliver the reminders it claims before
+// they're handed out again
+const reminderLease = time.Minute
+
+type saver struct {
+	DB store.Database
+}
+
+// NewSaver wraps a database connection with a *saver that implements
+// the sidebar.Saver interface.
+func NewSaver(db store.Database) (sidebar.Saver, error) {
+	return &saver{
+		DB: db,
+	}, nil
+}
+
+// GetSavedItems returns the user's saved messages in the workspace.
+func (s *saver) GetSavedItems(uid, wid string) ([]*sidebar.SavedItem, error) {
+	if err := s.DB.UserInWorkspace(uid, wid); err != nil {
+		return nil, err
+	}
+
+	items, err := s.DB.GetSavedMessages(uid, wid)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]*sidebar.ChatMessage, len(items))
+	for i, item := range items {
+		messages[i] = item.Message
+	}
+
+	showReferences(s.DB, wid, messages...)
+	return items, nil
+}
+
+// SaveMessage saves a message for the user. Only messages in channels
+// the user is a member of can be saved.
+func (s *saver) SaveMessage(mid, uid, wid string) (*sidebar.SavedItem, error) {
+	msg, err := s.checkMessage(mid, uid, wid)
+	if err != nil {
+		return nil, err
+	}
+
+	item := &sidebar.SavedItem{
+		UserID:    uid,
+		MessageID: mid,
+		CreatedAt: time.Now(),
+		Message:   msg,
+	}
+	if err := s.DB.SaveMessage(item); err != nil {
+		return nil, err
+	}
+
+	showReferences(s.DB, wid, msg)
+	return item, nil
+}
+
+// UnsaveMessage removes the message from the user's saved messages.
+func (s *saver) UnsaveMessage(mid, uid, wid string) error {
+	if err := s.DB.UserInWorkspace(uid, wid); err != nil {
+		return err
+	}
+
+	return s.DB.UnsaveMessage(uid, mid)
+}
+
+// GetReminders returns the user's pending reminders in the workspace
+// along with the messages they're about.
+func (s *saver) GetReminders(uid, wid string) ([]*sidebar.Reminder, error) {
+	if err := s.DB.UserInWorkspace(uid, wid); err != nil {
+		return nil, err
+	}
+
+	reminders, err := s.DB.GetReminders(uid, wid)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range reminders {
+		if r.MessageID == "" {
+			continue
+		}
+
+		if msg, err := s.DB.GetMessage(r.MessageID); err == nil && msg.DeletedAt == nil {
+			showReferences(s.DB, wid, msg)
+			r.Message = msg
+		}
+	}
+
+	return reminders, nil
+}
+
+// CreateReminder sets a reminder for the user. Reminders need a note, a
+// message in a channel the user is a member of, or both, and have to be
+// set for some time in the next year.
+func (s *saver) CreateReminder(r *sidebar.Reminder) (*sidebar.Reminder, error) {
+	now := time.Now()
+	if r.In != "" {
+		d, err := time.ParseDuration(r.In)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid duration %v", r.In)
+		}
+		r.RemindAt = now.Add(d)
+		r.In = ""
+	}
+
+	switch {
+	case r.RemindAt.Before(now):
+		return nil, errors.New("Reminders have to be set for the future")
+	case r.RemindAt.After(now.Add(sidebar.MaxReminderDelay)):
+		return nil, errors.New("Reminders can't be set more than a year ahead")
+	}
+
+	r.Note = strings.TrimSpace(r.Note)
+	if utf8.RuneCountInString(r.Note) > maxReminderNote {
+		return nil, errors.Errorf("Reminder notes can't be longer than %v characters", maxReminderNote)
+	}
+
+	r.Message = nil
+	if r.MessageID != "" {
+		msg, err := s.checkMessage(r.MessageID, r.UserID, r.WorkspaceID)
+		if err != nil {
+			return nil, err
+		}
+		r.Message = msg
+	} else if r.Note == "" {
+		return nil, errors.New("Reminders need a note or a message")
+	}
+
+	if err := s.DB.UserInWorkspace(r.UserID, r.WorkspaceID); err != nil {
+		return nil, err
+	}
+
+	pending, err := s.DB.GetReminders(r.UserID, r.WorkspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pending) >= sidebar.MaxReminders {
+		return nil, errors.Errorf("Users can't have more than %v reminders", sidebar.MaxReminders)
+	}
+
+	r.ID = uuid.New().String()
+	r.CreatedAt = now
+	if err := s.DB.CreateReminder(r); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// DeleteReminder cancels one of the user's reminders.
+func (s *saver) DeleteReminder(id, uid string) error {
+	r, err := s.DB.GetReminder(id)
+	if err != nil {
+		return err
+	}
+
+	if r.UserID != uid {
+		return errors.Errorf("User %v didn't set reminder %v", uid, id)
+	}
+
+	return s.DB.DeleteReminder(id)
+}
+
+// DueReminders claims the reminders that are due. Each one has to be
+// finished with FinishReminder once it's delivered, or it's handed out
+// again when the claim runs out. Reminders for users in do not disturb
+// are pushed back to the end of the window instead.
+func (s *saver) DueReminders() ([]*sidebar.Reminder, error) {
+	now := time.Now()
+	claimed, err := s.DB.ClaimDueReminders(now, reminderLease)
+	if err != nil {
+		return nil, err
+	}
+
+	reminders := make([]*sidebar.Reminder, 0, len(claimed))
+	for _, r := range claimed {
+		prefs, err := s.DB.GetNotificationPrefs([]string{r.UserID}, r.WorkspaceID)
+		if err != nil {
+			return nil, err
+		}
+		p := prefs[r.UserID]
+
+		if dnd := p.DoNotDisturb; dnd != nil && dnd.Active(now) {
+			if err := s.DB.PostponeReminder(r.ID, dnd.Until(now)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if r.MessageID != "" {
+			if msg, err := s.DB.GetMessage(r.MessageID); err == nil && msg.DeletedAt == nil {
+				r.Message = msg
+			}
+		}
+
+		cid := ""
+		if r.Message != nil {
+			cid = r.Message.Channel
+		}
+		r.Quiet = p.Level(cid) == sidebar.NotifyMute
+
+		reminders = append(reminders, r)
+	}
+
+	return reminders, nil
+}
+
+// FinishReminder removes a reminder once it's been delivered.
+func (s *saver) FinishReminder(id string) error {
+	return s.DB.DeleteReminder(id)
+}
+
+// DeliverReminder sends the reminder to the user as a direct message from
+// the system bot and returns the message.
+func (s *saver) DeliverReminder(r *sidebar.Reminder) (*sidebar.ChatMessage, error) {
+	if _, err := systemBot(s.DB, r.WorkspaceID); err != nil {
+		return nil, err
+	}
+
+	cid, err := s.reminderChannel(r.UserID, r.WorkspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	content := "Reminder"
+	if r.Message != nil {
+		content += " about a message in <#" + r.Message.Channel + ">"
+	}
+	if r.Note != "" {
+		content += ": " + r.Note
+	}
+
+	return postAsBot(s.DB, &sidebar.ChatMessage{
+		Event:   sidebar.EventMessage,
+		Content: content,
+		ToUser:  r.UserID,
+		Channel: cid,
+	}, r.WorkspaceID)
+}
+
+// checkMessage returns the message if it hasn't been deleted and the user
+// is a member of its channel in the workspace.
+func (s *saver) checkMessage(mid, uid, wid string) (*sidebar.ChatMessage, error) {
+	msg, err := s.DB.GetMessage(mid)
+	if err != nil {
+		return nil, err
+	}
+
+	if msg.DeletedAt != nil {
+		return nil, errors.Errorf("Message %v has been deleted", mid)
+	}
+
+	if err := s.DB.ChannelInWorkspace(msg.Channel, wid); err != nil {
+		return nil, err
+	}
+
+	if err := s.DB.UserInChannel(uid, msg.Channel); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// systemBot returns the system bot after making sure it's part of the
+// workspace. The bot is created the first time it's needed and gets a
+// random password so nobody can log in as it.
+func systemBot(db store.Database, wid string) (*sidebar.User, error) {
+	bot, err := db.GetUser(sidebar.SystemBotID)
+	if err != nil {
+		password, err := randomString(32)
+		if err != nil {
+			return nil, err
+		}
+
+		hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error hashing password")
+		}
+
+		bot, err = db.CreateUser(&sidebar.User{
+			ID:          sidebar.SystemBotID,
+			DisplayName: "Sidebar",
+			Email:       "system@bots.sidebar",
+			Password:    hashed,
+			ProfileImg:  sidebar.IdenticonURL(sidebar.SystemBotID),
+			IsBot:       true,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := db.UserInWorkspace(bot.ID, wid); err != nil {
+		if err := db.AddUserToWorkspace(bot.ID, wid); err != nil {
+			return nil, err
+		}
+	}
+
+	return bot, nil
+}
+
+// postAsBot stores a message from the system bot. Unlike messages from
+// users, the bot's messages skip the channel membership checks and
+// mentions in them are never resolved, so whatever text they quote from
+// a user can't notify anyone.
+func postAsBot(db store.Database, m *sidebar.ChatMessage, wid string) (*sidebar.ChatMessage, error) {
+	bot, err := systemBot(db, wid)
+	if err != nil {
+		return nil, err
+	}
+
+	m.FromUser = bot.ID
+	if err := formatMessage(m); err != nil {
+		return nil, err
+	}
+
+	m.ID = uuid.New().String()
+	m.CreatedAt = time.Now()
+	msg, err := db.CreateMessage(m)
+	if err != nil {
+		return nil, err
+	}
+
+	showReferences(db, wid, msg)
+	return msg, nil
+}
+
+// reminderChannel returns the id of the direct channel between the system
+// bot and the user in the workspace, creating it if needed.
+func (s *saver) reminderChannel(uid, wid string) (string, error) {
+	members := []string{sidebar.SystemBotID, uid}
+	key := directKey(members)
+	if cid, err := s.DB.GetDirectChannel(wid, key); err == nil {
+		return cid, nil
+	}
+
+	id := uuid.New().String()
+	channel := &sidebar.Channel{
+		ID:     id,
+		Name:   "direct-" + id,
+		Slug:   "direct-" + id,
+		Image:  sidebar.IdenticonURL(key),
+		Direct: true,
+	}
+	if err := s.DB.CreateDirectChannel(channel, wid, key, members); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tmitchel/sidebar"
+	"github.com/tmitchel/sidebar/store"
+)
+
+// reminderDB hands out one due reminder for each user in prefs.
+type reminderDB struct {
+	store.Database
+
+	prefs     map[string]*sidebar.NotificationPrefs
+	postponed map[string]time.Time
+}
+
+func (d *reminderDB) ClaimDueReminders(now time.Time, lease time.Duration) ([]*sidebar.Reminder, error) {
+	var reminders []*sidebar.Reminder
+	for uid := range d.prefs {
+		reminders = append(reminders, &sidebar.Reminder{ID: uid, UserID: uid, WorkspaceID: "w1", RemindAt: now})
+	}
+	return reminders, nil
+}
+
+func (d *reminderDB) GetNotificationPrefs(uids []string, wid string) (map[string]*sidebar.NotificationPrefs, error) {
+	return map[string]*sidebar.NotificationPrefs{uids[0]: d.prefs[uids[0]]}, nil
+}
+
+func (d *reminderDB) PostponeReminder(id string, at time.Time) error {
+	d.postponed[id] = at
+	return nil
+}
+
+func TestDueReminders(t *testing.T) {
+	// a window covering the whole day apart from a minute around now
+	now := time.Now().UTC()
+	always := &sidebar.DoNotDisturb{
+		Start:    now.Add(2 * time.Minute).Format("15:04"),
+		End:      now.Add(time.Minute).Format("15:04"),
+		Timezone: "UTC",
+	}
+
+	db := &reminderDB{
+		prefs: map[string]*sidebar.NotificationPrefs{
+			"plain": {},
+			"muted": {Default: sidebar.NotifyMute},
+			"away":  {DoNotDisturb: always},
+		},
+		postponed: make(map[string]time.Time),
+	}
+
+	reminders, err := (&saver{DB: db}).DueReminders()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	quiet := make(map[string]bool)
+	for _, r := range reminders {
+		quiet[r.ID] = r.Quiet
+	}
+
+	if q, ok := quiet["plain"]; !ok || q {
+		t.Errorf("plain reminder returned %v, quiet %v", ok, q)
+	}
+	if q, ok := quiet["muted"]; !ok || !q {
+		t.Errorf("muted reminder returned %v, quiet %v", ok, q)
+	}
+	if _, ok := quiet["away"]; ok {
+		t.Error("reminder delivered during do not disturb")
+	}
+
+	at, ok := db.postponed["away"]
+	if !ok {
+		t.Fatal("reminder during do not disturb wasn't postponed")
+	}
+	if d := at.Sub(now); d <= 0 || d > 2*time.Minute {
+		t.Errorf("reminder postponed by %v, want the end of the window", d)
+	}
+}
@@ -0,0 +1,215 @@
+package services
+
+import (
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/tmitchel/sidebar"
+	"github.com/tmitchel/sidebar/store"
+)
+
+// how long a server has to send the messages it claims before another
+// server can claim them
+const scheduleLease = 5 * time.Minute
+
+type scheduler struct {
+	DB store.Database
+}
+
+// NewScheduler wraps a database connection with a *scheduler that
+// implements the sidebar.Scheduler interface.
+func NewScheduler(db store.Database) (sidebar.Scheduler, error) {
+	return &scheduler{
+		DB: db,
+	}, nil
+}
+
+// GetScheduledMessages returns the user's pending scheduled messages in
+// the workspace.
+func (s *scheduler) GetScheduledMessages(uid, wid string) ([]*sidebar.ScheduledMessage, error) {
+	if err := s.DB.UserInWorkspace(uid, wid); err != nil {
+		return nil, err
+	}
+
+	return s.DB.GetScheduledMessages(uid, wid)
+}
+
+// ScheduleMessage schedules a message in a channel the user is a member
+// of. The message is sent at SendAt, or on the cron rule if one is given.
+func (s *scheduler) ScheduleMessage(m *sidebar.ScheduledMessage) (*sidebar.ScheduledMessage, error) {
+	if err := s.DB.ChannelInWorkspace(m.Channel, m.WorkspaceID); err != nil {
+		return nil, err
+	}
+
+	if err := checkNotArchived(s.DB, m.Channel); err != nil {
+		return nil, err
+	}
+
+	if err := s.DB.UserInChannel(m.UserID, m.Channel); err != nil {
+		return nil, err
+	}
+
+	if err := checkSchedule(m, time.Now()); err != nil {
+		return nil, err
+	}
+
+	pending, err := s.DB.GetScheduledMessages(m.UserID, m.WorkspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pending) >= sidebar.MaxScheduledMessages {
+		return nil, errors.Errorf("Users can't have more than %v scheduled messages", sidebar.MaxScheduledMessages)
+	}
+
+	m.ID = uuid.New().String()
+	m.CreatedAt = time.Now()
+	if err := s.DB.CreateScheduledMessage(m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// UpdateScheduledMessage replaces the content and schedule of one of the
+// user's pending messages. The channel can't be changed.
+func (s *scheduler) UpdateScheduledMessage(m *sidebar.ScheduledMessage) (*sidebar.ScheduledMessage, error) {
+	current, err := s.DB.GetScheduledMessage(m.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if current.UserID != m.UserID || current.WorkspaceID != m.WorkspaceID {
+		return nil, errors.Errorf("User %v didn't schedule message %v", m.UserID, m.ID)
+	}
+
+	current.Content = m.Content
+	current.Cron = m.Cron
+	current.Timezone = m.Timezone
+	current.SendAt = m.SendAt
+	if err := checkSchedule(current, time.Now()); err != nil {
+		return nil, err
+	}
+
+	if err := s.DB.UpdateScheduledMessage(current); err != nil {
+		return nil, err
+	}
+
+	return current, nil
+}
+
+// CancelScheduledMessage removes one of the user's pending messages.
+func (s *scheduler) CancelScheduledMessage(id, uid, wid string) error {
+	m, err := s.DB.GetScheduledMessage(id)
+	if err != nil {
+		return err
+	}
+
+	if m.UserID != uid || m.WorkspaceID != wid {
+		return errors.Errorf("User %v didn't schedule message %v", uid, id)
+	}
+
+	return s.DB.DeleteScheduledMessage(id)
+}
+
+// DueScheduledMessages claims the messages that are due so no other
+// server sends them. Messages from users who have left the channel are
+// dropped instead of returned. Each returned message has to be finished
+// with FinishScheduledMessage once it's sent.
+func (s *scheduler) DueScheduledMessages() ([]*sidebar.ScheduledMessage, error) {
+	claimed, err := s.DB.ClaimDueScheduledMessages(time.Now(), scheduleLease)
+	if err != nil {
+		return nil, err
+	}
+
+	due := make([]*sidebar.ScheduledMessage, 0, len(claimed))
+	for _, m := range claimed {
+		if err := s.DB.UserInChannel(m.UserID, m.Channel); err != nil {
+			logrus.Infof("Dropping scheduled message %v, user %v left channel %v", m.ID, m.UserID, m.Channel)
+			if err := s.DB.DeleteScheduledMessage(m.ID); err != nil {
+				logrus.Errorf("Unable to drop scheduled message %v %v", m.ID, err)
+			}
+			continue
+		}
+		due = append(due, m)
+	}
+
+	return due, nil
+}
+
+// FinishScheduledMessage moves a recurring message to its next time, or
+// removes a one-off message, after it has been sent.
+func (s *scheduler) FinishScheduledMessage(m *sidebar.ScheduledMessage) error {
+	if m.Cron == "" {
+		return s.DB.FinishScheduledMessage(m.ID, nil)
+	}
+
+	rule, err := parseCron(m.Cron)
+	if err != nil {
+		return err
+	}
+
+	loc, err := time.LoadLocation(m.Timezone)
+	if err != nil {
+		return err
+	}
+
+	// a server that was down for a while shouldn't send every missed time
+	from := m.SendAt
+	if now := time.Now(); from.Before(now) {
+		from = now
+	}
+
+	next := rule.next(from, loc)
+	if next.IsZero() {
+		return s.DB.FinishScheduledMessage(m.ID, nil)
+	}
+
+	return s.DB.FinishScheduledMessage(m.ID, &next)
+}
+
+// checkSchedule cleans up the message's content and works out when it
+// should first be sent. Recurring messages use UTC if they don't have a
+// time zone.
+func checkSchedule(m *sidebar.ScheduledMessage, now time.Time) error {
+	m.Content = canonicalContent(m.Content)
+	if m.Content == "" {
+		return errors.New("Messages can't be empty")
+	}
+
+	if utf8.RuneCountInString(m.Content) > sidebar.MaxMessageLength {
+		return errors.Errorf("Messages can't be longer than %v characters", sidebar.MaxMessageLength)
+	}
+
+	if m.Cron == "" {
+		m.Timezone = ""
+		if !m.SendAt.After(now) {
+			return errors.New("Messages have to be scheduled for the future")
+		}
+		return nil
+	}
+
+	rule, err := parseCron(m.Cron)
+	if err != nil {
+		return err
+	}
+
+	if m.Timezone == "" {
+		m.Timezone = "UTC"
+	}
+
+	loc, err := time.LoadLocation(m.Timezone)
+	if err != nil {
+		return errors.Wrap(err, "Invalid time zone")
+	}
+
+	m.SendAt = rule.next(now, loc)
+	if m.SendAt.IsZero() {
+		return errors.Errorf("Cron rule %q never matches", m.Cron)
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"html"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tmitchel/sidebar"
+	"github.com/tmitchel/sidebar/store"
+)
+
+// page sizes for search results
+const (
+	defaultSearchPage = 20
+	maxSearchPage     = 100
+)
+
+type searcher struct {
+	DB store.Database
+}
+
+// NewSearcher wraps a database connection with a *searcher that
+// implements the sidebar.Searcher interface.
+func NewSearcher(db store.Database) (sidebar.Searcher, error) {
+	return &searcher{
+		DB: db,
+	}, nil
+}
+
+// Search returns one page of messages matching the query. Only channels
+// the user belongs to are searched.
+func (s *searcher) Search(q *sidebar.SearchQuery) (*sidebar.SearchPage, error) {
+	if strings.TrimSpace(q.Text) == "" {
+		return nil, errors.New("Search text can't be empty")
+	}
+
+	if q.Offset < 0 {
+		return nil, errors.New("Offset can't be negative")
+	}
+
+	if err := s.DB.UserInWorkspace(q.UserID, q.WorkspaceID); err != nil {
+		return nil, err
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultSearchPage
+	} else if limit > maxSearchPage {
+		limit = maxSearchPage
+	}
+
+	// get one extra result to know if there is another page
+	query := *q
+	query.Limit = limit + 1
+	results, err := s.DB.SearchMessages(&query)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &sidebar.SearchPage{Results: results}
+	if len(results) > limit {
+		page.Results = results[:limit]
+		page.NextOffset = q.Offset + limit
+	}
+
+	messages := make([]*sidebar.ChatMessage, len(page.Results))
+	for i, r := range page.Results {
+		r.Snippet = highlight(r.Snippet)
+		messages[i] = r.Message
+	}
+
+	showReferences(s.DB, q.WorkspaceID, messages...)
+
+	return page, nil
+}
+
+// highlight escapes the snippet then swaps the search markers for
+// <mark> tags.
+func highlight(snippet string) string {
+	return strings.NewReplacer(
+		store.SearchMarkStart, "<mark>",
+		store.SearchMarkEnd, "</mark>",
+	).Replace(html.EscapeString(snippet))
+}
@@ -0,0 +1,560 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/tmitchel/sidebar"
+	"github.com/tmitchel/sidebar/store"
+)
+
+const (
+	// how long a user has to finish logging in at the identity provider
+	ssoStateTTL = 10 * time.Minute
+
+	// how long to wait before fetching a JWKS again for an unknown key
+	jwksRefetchInterval = time.Minute
+
+	// how far the identity provider's clock can be from ours
+	ssoClockSkew = time.Minute
+)
+
+type sso struct {
+	DB     store.Database
+	Client *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched map[string]time.Time
+}
+
+// NewSingleSignOner wraps a database connection with an *sso that implements
+// the sidebar.SingleSignOner interface. The client is used for all requests
+// to identity providers so tests can point it at a local stand-in. If client
+// is nil, a client with a short timeout is used.
+func NewSingleSignOner(db store.Database, client *http.Client) (sidebar.SingleSignOner, error) {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &sso{
+		DB:      db,
+		Client:  client,
+		keys:    make(map[string]*rsa.PublicKey),
+		fetched: make(map[string]time.Time),
+	}, nil
+}
+
+// oidcProvider holds the parts of the discovery document we use.
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// BeginLogin starts an authorization code flow with PKCE for the workspace
+// and returns the URL the user should be sent to.
+func (s *sso) BeginLogin(wid string) (string, error) {
+	return s.begin(wid, "")
+}
+
+// BeginLink starts an authorization code flow like BeginLogin, but the
+// identity the user logs in with is linked to their current account.
+func (s *sso) BeginLink(wid, uid string) (string, error) {
+	if err := s.DB.UserInWorkspace(uid, wid); err != nil {
+		return "", err
+	}
+
+	return s.begin(wid, uid)
+}
+
+// begin saves a new login state for the workspace and returns the
+// provider's authorization URL for it.
+func (s *sso) begin(wid, uid string) (string, error) {
+	config, err := s.DB.GetSSOConfig(wid)
+	if err != nil {
+		return "", errors.Wrap(err, "Single sign-on isn't configured for this workspace")
+	}
+
+	provider, err := s.discover(config.Issuer)
+	if err != nil {
+		return "", err
+	}
+
+	state, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	verifier, err := randomString(48)
+	if err != nil {
+		return "", err
+	}
+
+	nonce, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	err = s.DB.CreateSSOState(&sidebar.SSOState{
+		State:       state,
+		WorkspaceID: wid,
+		UserID:      uid,
+		Verifier:    verifier,
+		Nonce:       nonce,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	authURL, err := url.Parse(provider.AuthorizationEndpoint)
+	if err != nil {
+		return "", errors.Wrap(err, "Invalid authorization endpoint")
+	}
+
+	q := authURL.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", config.ClientID)
+	q.Set("redirect_uri", config.RedirectURL)
+	q.Set("scope", "openid email profile")
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+	authURL.RawQuery = q.Encode()
+
+	return authURL.String(), nil
+}
+
+// FinishLogin exchanges the authorization code for an ID token, validates
+// the token, then returns the linked user and the workspace they logged
+// in to. Users are created the first time they log in. Identities are
+// only linked to an existing account when it's already in the workspace
+// or when the login was started with BeginLink.
+func (s *sso) FinishLogin(state, code string) (*sidebar.User, string, error) {
+	st, err := s.DB.TakeSSOState(state)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "Unknown login state")
+	}
+
+	wid := st.WorkspaceID
+	if time.Since(st.CreatedAt) > ssoStateTTL {
+		return nil, "", errors.New("Login state has expired")
+	}
+
+	config, err := s.DB.GetSSOConfig(wid)
+	if err != nil {
+		return nil, "", err
+	}
+
+	provider, err := s.discover(config.Issuer)
+	if err != nil {
+		return nil, "", err
+	}
+
+	resp, err := s.Client.PostForm(provider.TokenEndpoint, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {config.RedirectURL},
+		"client_id":     {config.ClientID},
+		"client_secret": {config.ClientSecret},
+		"code_verifier": {st.Verifier},
+	})
+	if err != nil {
+		return nil, "", errors.Wrap(err, "Error exchanging authorization code")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", errors.Errorf("Token endpoint returned %v", resp.Status)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, "", errors.Wrap(err, "Unable to decode token response")
+	}
+
+	claims, err := s.validate(tokens.IDToken, provider, config, st.Nonce)
+	if err != nil {
+		return nil, "", err
+	}
+
+	email := strings.ToLower(claims["email"].(string))
+	if !domainAllowed(email, config.Domains) {
+		return nil, "", errors.Errorf("Email %v isn't allowed in workspace %v", email, wid)
+	}
+
+	subject := claims["sub"].(string)
+	if st.UserID != "" {
+		user, err := s.link(config.Issuer, subject, st.UserID)
+		if err != nil {
+			return nil, "", err
+		}
+
+		record(s.DB, user.ID, sidebar.ActionSSOLink, user.ID, wid, nil, map[string]string{"issuer": config.Issuer})
+		return user, wid, nil
+	}
+
+	name, _ := claims["name"].(string)
+	user, err := s.provision(config.Issuer, subject, email, name, wid)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := s.DB.UserInWorkspace(user.ID, wid); err != nil {
+		if err := s.DB.AddUserToWorkspace(user.ID, wid); err != nil {
+			return nil, "", err
+		}
+		record(s.DB, user.ID, sidebar.ActionWorkspaceJoin, user.ID, wid, nil, nil)
+	}
+
+	record(s.DB, user.ID, sidebar.ActionLoginSuccess, email, wid, nil, map[string]string{"issuer": config.Issuer})
+	return user, wid, nil
+}
+
+// WorkspaceForEmail returns the workspace that the email's domain is
+// mapped to.
+func (s *sso) WorkspaceForEmail(email string) (string, error) {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return "", errors.Errorf("Invalid email %v", email)
+	}
+	return s.DB.GetWorkspaceForDomain(strings.ToLower(email[at+1:]))
+}
+
+// GetSSOConfig returns the workspace's settings if the user is an admin
+// of the workspace.
+func (s *sso) GetSSOConfig(wid, uid string) (*sidebar.SSOConfig, error) {
+	if err := s.DB.UserIsAdmin(uid, wid); err != nil {
+		return nil, err
+	}
+	return s.DB.GetSSOConfig(wid)
+}
+
+// UpdateSSOConfig saves the workspace's settings if the user is an admin
+// of the workspace. The existing client secret is kept if a new one isn't
+// provided.
+func (s *sso) UpdateSSOConfig(c *sidebar.SSOConfig, uid string) error {
+	if err := s.DB.UserIsAdmin(uid, c.WorkspaceID); err != nil {
+		return err
+	}
+
+	if c.Issuer == "" || c.ClientID == "" || c.RedirectURL == "" {
+		return errors.New("Invalid fields when trying to update single sign-on")
+	}
+
+	old, err := s.DB.GetSSOConfig(c.WorkspaceID)
+	if err == nil {
+		if c.ClientSecret == "" {
+			c.ClientSecret = old.ClientSecret
+		}
+
+		// keep secrets out of the audit log
+		old.ClientSecret = ""
+	}
+
+	for i, domain := range c.Domains {
+		c.Domains[i] = strings.ToLower(strings.TrimPrefix(domain, "@"))
+	}
+
+	if err := s.DB.UpdateSSOConfig(c); err != nil {
+		return err
+	}
+
+	after := *c
+	after.ClientSecret = ""
+	record(s.DB, uid, sidebar.ActionSSOUpdate, c.WorkspaceID, c.WorkspaceID, old, after)
+	return nil
+}
+
+// discover fetches the provider's discovery document and checks that
+// it belongs to the configured issuer.
+func (s *sso) discover(issuer string) (*oidcProvider, error) {
+	resp, err := s.Client.Get(strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, errors.Wrap(err, "Error fetching discovery document")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("Discovery document returned %v", resp.Status)
+	}
+
+	var provider oidcProvider
+	if err := json.NewDecoder(resp.Body).Decode(&provider); err != nil {
+		return nil, errors.Wrap(err, "Unable to decode discovery document")
+	}
+
+	if provider.Issuer != issuer {
+		return nil, errors.Errorf("Discovery document issuer %v doesn't match %v", provider.Issuer, issuer)
+	}
+
+	return &provider, nil
+}
+
+// validate checks the ID token's signature against the provider's JWKS
+// along with its issuer, audience, expiration, nonce, and email.
+func (s *sso) validate(idToken string, provider *oidcProvider, config *sidebar.SSOConfig, nonce string) (jwt.MapClaims, error) {
+	// the time claims are checked below, allowing for clock skew
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.Parse(idToken, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, errors.Errorf("Unexpected signing method %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return s.key(provider.JWKSURI, kid)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid ID token")
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	now := time.Now()
+	if !claims.VerifyExpiresAt(now.Add(-ssoClockSkew).Unix(), true) {
+		return nil, errors.New("ID token is expired or has no expiry")
+	}
+
+	if !claims.VerifyIssuedAt(now.Add(ssoClockSkew).Unix(), true) {
+		return nil, errors.New("ID token is missing its issue time or was issued in the future")
+	}
+
+	if !claims.VerifyNotBefore(now.Add(ssoClockSkew).Unix(), false) {
+		return nil, errors.New("ID token isn't valid yet")
+	}
+
+	if !claims.VerifyIssuer(config.Issuer, true) {
+		return nil, errors.Errorf("ID token issuer %v isn't allowed", claims["iss"])
+	}
+
+	if !hasAudience(claims, config.ClientID) {
+		return nil, errors.New("ID token wasn't issued for this client")
+	}
+
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, errors.New("ID token nonce doesn't match")
+	}
+
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, errors.New("ID token is missing a subject")
+	}
+
+	if email, _ := claims["email"].(string); email == "" {
+		return nil, errors.New("ID token is missing an email")
+	}
+
+	verified, ok := claims["email_verified"].(bool)
+	if !ok {
+		// some providers send the claim as a string
+		verified = claims["email_verified"] == "true"
+	}
+
+	if !verified {
+		return nil, errors.New("Email address hasn't been verified")
+	}
+
+	return claims, nil
+}
+
+// key returns the public key with the given id from the JWKS. Keys are
+// cached and the JWKS is only fetched again when a key isn't found, at
+// most once every jwksRefetchInterval so tokens with made up key ids
+// can't make us hammer the provider.
+func (s *sso) key(jwksURI, kid string) (*rsa.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key, ok := s.keys[jwksURI+"#"+kid]; ok {
+		return key, nil
+	}
+
+	if time.Since(s.fetched[jwksURI]) < jwksRefetchInterval {
+		return nil, errors.Errorf("Unable to find key %v", kid)
+	}
+	s.fetched[jwksURI] = time.Now()
+
+	resp, err := s.Client.Get(jwksURI)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error fetching JWKS")
+	}
+	defer resp.Body.Close()
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return nil, errors.Wrap(err, "Unable to decode JWKS")
+	}
+
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+
+		s.keys[jwksURI+"#"+k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	key, ok := s.keys[jwksURI+"#"+kid]
+	if !ok {
+		return nil, errors.Errorf("Unable to find key %v", kid)
+	}
+	return key, nil
+}
+
+// provision returns the user linked to the identity. If there isn't one,
+// the identity is linked to the user with the same verified email address
+// as long as they're already in the workspace. Otherwise a new user is
+// created. Accounts outside the workspace have to be linked by their
+// owner with BeginLink since anyone can run an identity provider that
+// claims any email address.
+func (s *sso) provision(issuer, subject, email, name, wid string) (*sidebar.User, error) {
+	if uid, err := s.DB.GetUserForIdentity(issuer, subject); err == nil {
+		return s.DB.GetUser(uid)
+	}
+
+	var user *sidebar.User
+	if authUser, err := s.DB.UserForAuth(email); err == nil {
+		if err := s.DB.UserInWorkspace(authUser.ID, wid); err != nil {
+			return nil, errors.Errorf("An account for %v already exists. Log in and link single sign-on to it first", email)
+		}
+
+		user, err = s.DB.GetUser(authUser.ID)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// the password is never shown to anyone, it only exists
+		// because every user needs one
+		password, err := randomString(32)
+		if err != nil {
+			return nil, err
+		}
+
+		hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error hashing password")
+		}
+
+		if name == "" {
+			name = email[:strings.LastIndex(email, "@")]
+		}
+
+		u := &sidebar.User{
+			ID:          uuid.New().String(),
+			DisplayName: name,
+			Email:       email,
+			Password:    hashed,
+		}
+		u.ProfileImg = sidebar.IdenticonURL(u.ID)
+
+		user, err = s.DB.CreateUser(u)
+		if err != nil {
+			// display names are unique so fall back to the email
+			u.DisplayName = email
+			user, err = s.DB.CreateUser(u)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := s.DB.CreateIdentity(issuer, subject, user.ID); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// link links the identity to the user that started the login. An identity
+// can only be linked to one user.
+func (s *sso) link(issuer, subject, uid string) (*sidebar.User, error) {
+	linked, err := s.DB.GetUserForIdentity(issuer, subject)
+	switch {
+	case err == nil && linked != uid:
+		return nil, errors.Wrap(sidebar.ErrConflict, "Identity is already linked to another user")
+	case err == nil:
+		return s.DB.GetUser(uid)
+	}
+
+	if err := s.DB.CreateIdentity(issuer, subject, uid); err != nil {
+		return nil, err
+	}
+
+	return s.DB.GetUser(uid)
+}
+
+// hasAudience checks the aud claim, which can be a string or a list.
+func hasAudience(claims jwt.MapClaims, clientID string) bool {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		for _, a := range aud {
+			if a == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// domainAllowed checks if the email is in one of the domains. An empty
+// list allows every domain.
+func domainAllowed(email string, domains []string) bool {
+	if len(domains) == 0 {
+		return true
+	}
+
+	domain := email[strings.LastIndex(email, "@")+1:]
+	for _, d := range domains {
+		if d == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// randomString returns n random bytes encoded for use in a URL.
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "Unable to generate random string")
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
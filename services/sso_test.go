@@ -0,0 +1,399 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"github.com/tmitchel/sidebar"
+	"github.com/tmitchel/sidebar/store"
+)
+
+// ssoDB keeps just enough state in memory for logins.
+type ssoDB struct {
+	store.Database
+
+	configs    map[string]*sidebar.SSOConfig
+	states     map[string]*sidebar.SSOState
+	identities map[string]string
+	users      map[string]*sidebar.User
+	members    map[string]bool
+}
+
+func newSSODB() *ssoDB {
+	return &ssoDB{
+		configs:    make(map[string]*sidebar.SSOConfig),
+		states:     make(map[string]*sidebar.SSOState),
+		identities: make(map[string]string),
+		users:      make(map[string]*sidebar.User),
+		members:    make(map[string]bool),
+	}
+}
+
+func (d *ssoDB) GetSSOConfig(wid string) (*sidebar.SSOConfig, error) {
+	c, ok := d.configs[wid]
+	if !ok {
+		return nil, errors.New("no config")
+	}
+	copied := *c
+	return &copied, nil
+}
+
+func (d *ssoDB) CreateSSOState(st *sidebar.SSOState) error {
+	copied := *st
+	copied.CreatedAt = time.Now()
+	d.states[st.State] = &copied
+	return nil
+}
+
+func (d *ssoDB) TakeSSOState(state string) (*sidebar.SSOState, error) {
+	st, ok := d.states[state]
+	if !ok {
+		return nil, errors.New("no state")
+	}
+	delete(d.states, state)
+	return st, nil
+}
+
+func (d *ssoDB) GetUserForIdentity(issuer, subject string) (string, error) {
+	uid, ok := d.identities[issuer+"#"+subject]
+	if !ok {
+		return "", errors.New("no identity")
+	}
+	return uid, nil
+}
+
+func (d *ssoDB) CreateIdentity(issuer, subject, uid string) error {
+	d.identities[issuer+"#"+subject] = uid
+	return nil
+}
+
+func (d *ssoDB) GetUser(id string) (*sidebar.User, error) {
+	u, ok := d.users[id]
+	if !ok {
+		return nil, errors.New("no user")
+	}
+	return u, nil
+}
+
+func (d *ssoDB) UserForAuth(email string) (*sidebar.User, error) {
+	for _, u := range d.users {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return nil, errors.New("no user")
+}
+
+func (d *ssoDB) CreateUser(u *sidebar.User) (*sidebar.User, error) {
+	d.users[u.ID] = u
+	return u, nil
+}
+
+func (d *ssoDB) UserInWorkspace(uid, wid string) error {
+	if !d.members[uid+"#"+wid] {
+		return errors.New("not a member")
+	}
+	return nil
+}
+
+func (d *ssoDB) AddUserToWorkspace(uid, wid string) error {
+	d.members[uid+"#"+wid] = true
+	return nil
+}
+
+func (d *ssoDB) CreateAuditEntry(*sidebar.AuditEntry) error {
+	return nil
+}
+
+// standInIdP is a local OpenID Connect provider that issues ID tokens
+// with whatever claims the test registered for an authorization code.
+type standInIdP struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu         sync.Mutex
+	kid        string
+	codes      map[string]jwt.MapClaims
+	challenges map[string]string
+	jwksHits   int
+}
+
+func newStandInIdP(t *testing.T) *standInIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := &standInIdP{
+		key:        key,
+		kid:        "k1",
+		codes:      make(map[string]jwt.MapClaims),
+		challenges: make(map[string]string),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcProvider{
+			Issuer:                idp.URL,
+			AuthorizationEndpoint: idp.URL + "/authorize",
+			TokenEndpoint:         idp.URL + "/token",
+			JWKSURI:               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		idp.jwksHits++
+		idp.mu.Unlock()
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "k1",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		code := r.PostForm.Get("code")
+
+		idp.mu.Lock()
+		claims, ok := idp.codes[code]
+		challenge := idp.challenges[code]
+		kid := idp.kid
+		delete(idp.codes, code)
+		idp.mu.Unlock()
+
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+			http.Error(w, "invalid_grant", http.StatusBadRequest)
+			return
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed})
+	})
+
+	idp.Server = httptest.NewServer(mux)
+	return idp
+}
+
+// login runs a whole login through the stand-in, starting a link instead
+// when uid is set.
+func (idp *standInIdP) login(t *testing.T, s *sso, wid, uid string, claims jwt.MapClaims) (*sidebar.User, error) {
+	var authURL string
+	var err error
+	if uid == "" {
+		authURL, err = s.BeginLogin(wid)
+	} else {
+		authURL, err = s.BeginLink(wid, uid)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+
+	code, err := randomString(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	full := jwt.MapClaims{
+		"iss":   idp.URL,
+		"aud":   q.Get("client_id"),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": q.Get("nonce"),
+	}
+	for k, v := range claims {
+		// nil leaves the claim out
+		if v == nil {
+			delete(full, k)
+			continue
+		}
+		full[k] = v
+	}
+
+	idp.mu.Lock()
+	idp.codes[code] = full
+	idp.challenges[code] = q.Get("code_challenge")
+	idp.mu.Unlock()
+
+	user, _, err := s.FinishLogin(q.Get("state"), code)
+	return user, err
+}
+
+func TestSSOLogin(t *testing.T) {
+	idp := newStandInIdP(t)
+	defer idp.Close()
+
+	db := newSSODB()
+	db.configs["w1"] = &sidebar.SSOConfig{WorkspaceID: "w1", Issuer: idp.URL, ClientID: "client", RedirectURL: "http://sidebar/callback"}
+	db.users["member"] = &sidebar.User{ID: "member", Email: "member@example.com"}
+	db.members["member#w1"] = true
+	db.users["outsider"] = &sidebar.User{ID: "outsider", Email: "outsider@example.com"}
+	db.members["outsider#w2"] = true
+
+	s := &sso{DB: db, Client: idp.Client(), keys: make(map[string]*rsa.PublicKey), fetched: make(map[string]time.Time)}
+
+	newUser, err := idp.login(t, s, "w1", "", jwt.MapClaims{"sub": "new", "email": "new@example.com", "email_verified": true})
+	if err != nil {
+		t.Fatalf("new user: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		uid     string
+		claims  jwt.MapClaims
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "returning identity",
+			claims: jwt.MapClaims{"sub": "new", "email": "new@example.com", "email_verified": true},
+			want:   newUser.ID,
+		},
+		{
+			name:   "verified email of a workspace member",
+			claims: jwt.MapClaims{"sub": "m", "email": "member@example.com", "email_verified": true},
+			want:   "member",
+		},
+		{
+			name:    "email of an account outside the workspace",
+			claims:  jwt.MapClaims{"sub": "o", "email": "outsider@example.com", "email_verified": true},
+			wantErr: true,
+		},
+		{
+			name:    "unverified email",
+			claims:  jwt.MapClaims{"sub": "u", "email": "unverified@example.com", "email_verified": false},
+			wantErr: true,
+		},
+		{
+			name:    "no expiry",
+			claims:  jwt.MapClaims{"sub": "new", "email": "new@example.com", "email_verified": true, "exp": nil},
+			wantErr: true,
+		},
+		{
+			name:    "expired",
+			claims:  jwt.MapClaims{"sub": "new", "email": "new@example.com", "email_verified": true, "exp": time.Now().Add(-time.Hour).Unix()},
+			wantErr: true,
+		},
+		{
+			name:    "no issue time",
+			claims:  jwt.MapClaims{"sub": "new", "email": "new@example.com", "email_verified": true, "iat": nil},
+			wantErr: true,
+		},
+		{
+			name:    "issued far in the future",
+			claims:  jwt.MapClaims{"sub": "new", "email": "new@example.com", "email_verified": true, "iat": time.Now().Add(time.Hour).Unix()},
+			wantErr: true,
+		},
+		{
+			name:   "provider clock slightly ahead",
+			claims: jwt.MapClaims{"sub": "new", "email": "new@example.com", "email_verified": true, "iat": time.Now().Add(20 * time.Second).Unix()},
+			want:   newUser.ID,
+		},
+		{
+			name:    "link from an account outside the workspace",
+			uid:     "outsider",
+			claims:  jwt.MapClaims{"sub": "o", "email": "outsider@example.com", "email_verified": true},
+			wantErr: true,
+		},
+		{
+			name:    "link an identity that belongs to someone else",
+			uid:     "member",
+			claims:  jwt.MapClaims{"sub": "new", "email": "member@example.com", "email_verified": true},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := idp.login(t, s, "w1", tt.uid, tt.claims)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, logged in as %v", user.ID)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.ID != tt.want {
+				t.Fatalf("logged in as %v, want %v", user.ID, tt.want)
+			}
+		})
+	}
+
+	t.Run("explicit link", func(t *testing.T) {
+		db.members["outsider#w1"] = true
+		user, err := idp.login(t, s, "w1", "outsider", jwt.MapClaims{"sub": "o2", "email": "someone@example.com", "email_verified": true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.ID != "outsider" {
+			t.Fatalf("linked to %v", user.ID)
+		}
+
+		user, err = idp.login(t, s, "w1", "", jwt.MapClaims{"sub": "o2", "email": "someone@example.com", "email_verified": true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.ID != "outsider" {
+			t.Fatalf("logged in as %v after linking", user.ID)
+		}
+	})
+}
+
+func TestSSOUnknownKeyRefetch(t *testing.T) {
+	idp := newStandInIdP(t)
+	defer idp.Close()
+
+	db := newSSODB()
+	db.configs["w1"] = &sidebar.SSOConfig{WorkspaceID: "w1", Issuer: idp.URL, ClientID: "client", RedirectURL: "http://sidebar/callback"}
+	s := &sso{DB: db, Client: idp.Client(), keys: make(map[string]*rsa.PublicKey), fetched: make(map[string]time.Time)}
+
+	claims := jwt.MapClaims{"sub": "a", "email": "a@example.com", "email_verified": true}
+	if _, err := idp.login(t, s, "w1", "", claims); err != nil {
+		t.Fatal(err)
+	}
+
+	idp.mu.Lock()
+	idp.kid = "made-up"
+	idp.mu.Unlock()
+
+	for i := 0; i < 3; i++ {
+		if _, err := idp.login(t, s, "w1", "", claims); err == nil {
+			t.Fatal("expected a token with an unknown key to be rejected")
+		}
+	}
+
+	if idp.jwksHits != 1 {
+		t.Fatalf("JWKS fetched %v times, want 1", idp.jwksHits)
+	}
+}
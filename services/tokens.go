@@ -0,0 +1,198 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/tmitchel/sidebar"
+	"github.com/tmitchel/sidebar/store"
+)
+
+type tokens struct {
+	DB store.Database
+}
+
+// NewTokenManager wraps a database connection with a *tokens that
+// implements the sidebar.TokenManager interface.
+func NewTokenManager(db store.Database) (sidebar.TokenManager, error) {
+	return &tokens{
+		DB: db,
+	}, nil
+}
+
+// CreateToken creates a new API token for the user given in the token, or
+// for the current user if none is given. Users can create tokens for
+// themselves and for bots they own. Workspace admins can create tokens for
+// any bot in the workspace. The raw token is returned once and only a hash
+// is stored.
+func (t *tokens) CreateToken(token *sidebar.APIToken, uid string) (*sidebar.APIToken, error) {
+	if token.Name == "" || len(token.Scopes) == 0 {
+		return nil, errors.New("Invalid fields when trying to create token")
+	}
+
+	for _, scope := range token.Scopes {
+		switch scope {
+		case sidebar.ScopeReadMessages, sidebar.ScopePostMessages, sidebar.ScopeManageChannels:
+		default:
+			return nil, errors.Errorf("Unknown scope %v", scope)
+		}
+	}
+
+	if token.UserID == "" {
+		token.UserID = uid
+	}
+
+	if err := t.canManage(token.UserID, token.WorkspaceID, uid); err != nil {
+		return nil, err
+	}
+
+	raw, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+
+	token.ID = uuid.New().String()
+	token.Token = sidebar.TokenPrefix + raw
+	token.CreatedAt = time.Now()
+	if err := t.DB.CreateToken(token, hashToken(token.Token)); err != nil {
+		return nil, err
+	}
+
+	record(t.DB, uid, sidebar.ActionTokenCreate, token.ID, token.WorkspaceID, nil, map[string]interface{}{
+		"user":   token.UserID,
+		"name":   token.Name,
+		"scopes": token.Scopes,
+	})
+	return token, nil
+}
+
+// GetTokens returns the current user's tokens in the workspace. The raw
+// tokens are never included.
+func (t *tokens) GetTokens(uid, wid string) ([]*sidebar.APIToken, error) {
+	return t.DB.GetTokensForUser(uid, wid)
+}
+
+// RevokeToken revokes the token if the current user is allowed to manage
+// the token's owner.
+func (t *tokens) RevokeToken(id, uid string) error {
+	token, err := t.DB.GetToken(id)
+	if err != nil {
+		return err
+	}
+
+	if err := t.canManage(token.UserID, token.WorkspaceID, uid); err != nil {
+		return err
+	}
+
+	if err := t.DB.RevokeToken(id); err != nil {
+		return err
+	}
+
+	record(t.DB, uid, sidebar.ActionTokenRevoke, id, token.WorkspaceID, token, nil)
+	return nil
+}
+
+// ValidateToken returns the token matching the raw token if it hasn't been
+// revoked and its owner is still part of the token's workspace.
+func (t *tokens) ValidateToken(raw string) (*sidebar.APIToken, error) {
+	if !strings.HasPrefix(raw, sidebar.TokenPrefix) {
+		return nil, errors.New("Not an API token")
+	}
+
+	token, err := t.DB.GetTokenByHash(hashToken(raw))
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid API token")
+	}
+
+	if err := t.DB.UserInWorkspace(token.UserID, token.WorkspaceID); err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// CreateBot creates a bot user in the workspace owned by the current user.
+// Only admins can create bots. Bots get a random password so they can
+// only authenticate with tokens.
+func (t *tokens) CreateBot(u *sidebar.User, uid, wid string) (*sidebar.User, error) {
+	if u.DisplayName == "" {
+		return nil, errors.New("Invalid fields when trying to create bot")
+	}
+
+	if err := t.DB.UserIsAdmin(uid, wid); err != nil {
+		return nil, errors.Wrapf(err, "User %v can't create bots", uid)
+	}
+
+	password, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error hashing password")
+	}
+
+	u.ID = uuid.New().String()
+	u.Email = u.ID + "@bots.sidebar"
+	u.Password = hashed
+	u.IsBot = true
+	if u.ProfileImg == "" {
+		u.ProfileImg = sidebar.IdenticonURL(u.ID)
+	}
+
+	bot, err := t.DB.CreateUser(u)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := t.DB.AddUserToWorkspace(bot.ID, wid); err != nil {
+		return nil, err
+	}
+
+	if err := t.DB.CreateBot(bot.ID, wid, uid); err != nil {
+		return nil, err
+	}
+
+	record(t.DB, uid, sidebar.ActionBotCreate, bot.ID, wid, nil, bot)
+	return bot, nil
+}
+
+// GetBots returns all bots in the workspace.
+func (t *tokens) GetBots(wid string) ([]*sidebar.User, error) {
+	return t.DB.GetBots(wid)
+}
+
+// canManage returns an error unless the current user is the owner, or the
+// owner is a bot in the workspace that the current user owns or admins.
+func (t *tokens) canManage(owner, wid, uid string) error {
+	if err := t.DB.UserInWorkspace(owner, wid); err != nil {
+		return err
+	}
+
+	if owner == uid {
+		return nil
+	}
+
+	botOwner, botWorkspace, err := t.DB.GetBotOwner(owner)
+	if err != nil || botWorkspace != wid {
+		return errors.Errorf("User %v can't manage tokens for %v", uid, owner)
+	}
+
+	if botOwner == uid {
+		return nil
+	}
+
+	return t.DB.UserIsAdmin(uid, wid)
+}
+
+func hashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/tmitchel/sidebar"
+	"github.com/tmitchel/sidebar/store"
+)
+
+// botDB has an admin and a member in workspace w1.
+type botDB struct {
+	store.Database
+
+	created []string
+}
+
+func (d *botDB) UserIsAdmin(uid, wid string) error {
+	if uid != "admin" || wid != "w1" {
+		return errors.New("not an admin")
+	}
+	return nil
+}
+
+func (d *botDB) CreateUser(u *sidebar.User) (*sidebar.User, error) {
+	return u, nil
+}
+
+func (d *botDB) AddUserToWorkspace(uid, wid string) error {
+	return nil
+}
+
+func (d *botDB) CreateBot(uid, wid, owner string) error {
+	d.created = append(d.created, uid)
+	return nil
+}
+
+func (d *botDB) CreateAuditEntry(*sidebar.AuditEntry) error {
+	return nil
+}
+
+func TestCreateBot(t *testing.T) {
+	tests := []struct {
+		name    string
+		uid     string
+		wid     string
+		allowed bool
+	}{
+		{"admin", "admin", "w1", true},
+		{"member", "member", "w1", false},
+		{"admin of another workspace", "admin", "w2", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &botDB{}
+			tk := &tokens{DB: db}
+
+			bot, err := tk.CreateBot(&sidebar.User{DisplayName: "helper"}, tt.uid, tt.wid)
+			if (err == nil) != tt.allowed {
+				t.Fatalf("CreateBot by %v in %v = %v, want allowed %v", tt.uid, tt.wid, err, tt.allowed)
+			}
+
+			if !tt.allowed {
+				if len(db.created) != 0 {
+					t.Fatalf("created bots %v", db.created)
+				}
+				return
+			}
+			if !bot.IsBot || len(db.created) != 1 || db.created[0] != bot.ID {
+				t.Fatalf("created %+v, recorded %v", bot, db.created)
+			}
+		})
+	}
+}
@@ -0,0 +1,522 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"html"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/tmitchel/sidebar"
+	"github.com/tmitchel/sidebar/store"
+)
+
+// limits on unfurling links
+const (
+	maxPreviews     = 3
+	maxRedirects    = 3
+	maxPageSize     = 512 << 10
+	maxOEmbedSize   = 64 << 10
+	maxURLLength    = 2048
+	maxDomains      = 100
+	previewLifetime = 24 * time.Hour
+)
+
+// addresses that aren't on the public internet
+var privateNetworks = func() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+		"172.16.0.0/12", "192.0.0.0/24", "192.168.0.0/16", "198.18.0.0/15", "224.0.0.0/3",
+		"::/128", "::1/128", "64:ff9b::/96", "fc00::/7", "fe80::/10", "ff00::/8",
+	} {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}()
+
+// NewSafeClient returns an *http.Client for fetching URLs sent by users.
+// It only connects to public addresses on the standard HTTP ports, which
+// is checked after DNS lookups so a hostname can't point it at the local
+// network. Redirects are limited and each request has a time limit.
+func NewSafeClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 3 * time.Second,
+		Control: dialPublic,
+	}
+
+	return &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			// a proxy would make the connection instead of the dialer
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   3 * time.Second,
+			ResponseHeaderTimeout: 3 * time.Second,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return errors.New("Too many redirects")
+			}
+			return checkURL(req.URL)
+		},
+	}
+}
+
+// dialPublic refuses connections to anything but public addresses on the
+// standard HTTP ports. It's run with the address DNS resolved to, right
+// before connecting.
+func dialPublic(network, address string, c syscall.RawConn) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	if port != "80" && port != "443" {
+		return errors.Errorf("Port %v isn't allowed", port)
+	}
+
+	if !publicIP(net.ParseIP(host)) {
+		return errors.Errorf("Address %v isn't public", host)
+	}
+	return nil
+}
+
+func publicIP(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.Errorf("Links using %v can't be previewed", u.Scheme)
+	}
+
+	if u.User != nil || u.Hostname() == "" {
+		return errors.Errorf("Invalid link %v", u)
+	}
+	return nil
+}
+
+// checkLink checks that the URL can be fetched and that the workspace
+// wants previews for its host.
+func checkLink(u *url.URL, settings *sidebar.UnfurlSettings) error {
+	if err := checkURL(u); err != nil {
+		return err
+	}
+
+	if !settings.Allowed(u.Hostname()) {
+		return errors.Errorf("Links to %v can't be previewed", u.Hostname())
+	}
+	return nil
+}
+
+type unfurler struct {
+	DB     store.Database
+	Client *http.Client
+}
+
+// NewUnfurler wraps a database connection and an *http.Client with an
+// *unfurler that implements the sidebar.Unfurler interface. The client
+// should come from NewSafeClient unless it only talks to trusted servers.
+func NewUnfurler(db store.Database, client *http.Client) (sidebar.Unfurler, error) {
+	return &unfurler{
+		DB:     db,
+		Client: client,
+	}, nil
+}
+
+// Unfurl fetches previews for the first few links in the message and
+// shows them with the message. The updated message is returned if its
+// previews changed. Since this runs after the message is sent, nothing
+// is returned if the message was edited or deleted in the meantime.
+func (u *unfurler) Unfurl(msg *sidebar.ChatMessage, wid string) (*sidebar.ChatMessage, error) {
+	settings, err := u.DB.GetUnfurlSettings(wid)
+	if err != nil {
+		return nil, err
+	}
+
+	var previews []*sidebar.LinkPreview
+	var urls []string
+	for _, link := range messageLinks(msg.HTML) {
+		if len(previews) >= maxPreviews {
+			break
+		}
+
+		parsed, err := url.Parse(link)
+		if err != nil || checkLink(parsed, settings) != nil {
+			continue
+		}
+
+		preview, err := u.preview(link, settings)
+		if err != nil {
+			logrus.Errorf("Error previewing %v %v", link, err)
+			continue
+		}
+
+		if !preview.Empty() {
+			previews = append(previews, preview)
+			urls = append(urls, preview.URL)
+		}
+	}
+
+	existing, err := u.DB.GetPreviewsForMessages([]string{msg.ID})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(previews) == 0 && len(existing[msg.ID]) == 0 {
+		return nil, nil
+	}
+
+	current, err := u.DB.GetMessage(msg.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if current.DeletedAt != nil || current.Content != msg.Content {
+		return nil, nil
+	}
+
+	if err := u.DB.SetMessagePreviews(msg.ID, urls); err != nil {
+		return nil, err
+	}
+
+	current.Previews = previews
+	showReferences(u.DB, wid, current)
+	return current, nil
+}
+
+// preview returns the cached preview for the link or fetches it again if
+// it's missing or too old. Failed fetches are cached as empty previews
+// so the page isn't asked again for every message.
+func (u *unfurler) preview(link string, settings *sidebar.UnfurlSettings) (*sidebar.LinkPreview, error) {
+	cached, err := u.DB.GetLinkPreview(link)
+	if err == nil && time.Since(cached.FetchedAt) < previewLifetime {
+		return cached, nil
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	preview, err := u.fetch(link, settings)
+	if err != nil {
+		logrus.Infof("Unable to fetch %v %v", link, err)
+		preview = &sidebar.LinkPreview{URL: link}
+	}
+
+	preview.FetchedAt = time.Now()
+	if err := u.DB.SaveLinkPreview(preview); err != nil {
+		return nil, err
+	}
+
+	return preview, nil
+}
+
+var (
+	tagPattern    = regexp.MustCompile(`(?is)<(meta|link)\b[^>]*>`)
+	attrPattern   = regexp.MustCompile(`(?s)([a-zA-Z:_-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	titlePattern  = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	anchorPattern = regexp.MustCompile(`<a href="([^"]*)"`)
+)
+
+// messageLinks returns the links in the message's rendered HTML, so
+// links in code aren't included.
+func messageLinks(rendered string) []string {
+	var links []string
+	seen := make(map[string]bool)
+	for _, match := range anchorPattern.FindAllStringSubmatch(rendered, -1) {
+		link := html.UnescapeString(match[1])
+		if !seen[link] && len(link) <= maxURLLength {
+			seen[link] = true
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// fetch reads the page's OpenGraph tags, falling back to oEmbed and then
+// the page's title. Only the start of the page is read.
+func (u *unfurler) fetch(link string, settings *sidebar.UnfurlSettings) (*sidebar.LinkPreview, error) {
+	body, final, err := u.get(link, settings, "text/html", maxPageSize, "text/html", "application/xhtml+xml")
+	if err != nil {
+		return nil, err
+	}
+
+	page := string(body)
+	if i := strings.Index(strings.ToLower(page), "<body"); i >= 0 {
+		page = page[:i]
+	}
+
+	preview := &sidebar.LinkPreview{URL: link}
+	var oembed, description string
+	for _, tag := range tagPattern.FindAllStringSubmatch(page, -1) {
+		attrs := make(map[string]string)
+		for _, attr := range attrPattern.FindAllStringSubmatch(tag[0], -1) {
+			attrs[strings.ToLower(attr[1])] = html.UnescapeString(attr[2] + attr[3] + attr[4])
+		}
+
+		if strings.EqualFold(tag[1], "link") {
+			if strings.EqualFold(attrs["type"], "application/json+oembed") && oembed == "" {
+				oembed = resolveLink(final, attrs["href"])
+			}
+			continue
+		}
+
+		key := attrs["property"]
+		if key == "" {
+			key = attrs["name"]
+		}
+
+		content := attrs["content"]
+		switch strings.ToLower(key) {
+		case "og:title":
+			preview.Title = firstOf(preview.Title, content)
+		case "og:description":
+			preview.Description = firstOf(preview.Description, content)
+		case "description":
+			description = firstOf(description, content)
+		case "og:image", "og:image:url":
+			preview.Image = firstOf(preview.Image, resolveLink(final, content))
+		case "og:site_name":
+			preview.SiteName = firstOf(preview.SiteName, content)
+		}
+	}
+
+	preview.Description = firstOf(preview.Description, description)
+	if preview.Title == "" && oembed != "" {
+		if parsed, err := url.Parse(oembed); err == nil && checkLink(parsed, settings) == nil {
+			if err := u.fetchOEmbed(oembed, settings, preview); err != nil {
+				logrus.Infof("Unable to fetch oEmbed for %v %v", link, err)
+			}
+		}
+	}
+
+	if preview.Title == "" {
+		if match := titlePattern.FindStringSubmatch(page); match != nil {
+			preview.Title = html.UnescapeString(match[1])
+		}
+	}
+
+	preview.Title = clip(preview.Title, 200)
+	preview.Description = clip(preview.Description, 500)
+	preview.SiteName = clip(preview.SiteName, 100)
+	if len(preview.Image) > maxURLLength {
+		preview.Image = ""
+	}
+	return preview, nil
+}
+
+func (u *unfurler) fetchOEmbed(link string, settings *sidebar.UnfurlSettings, preview *sidebar.LinkPreview) error {
+	body, final, err := u.get(link, settings, "application/json", maxOEmbedSize, "application/json", "text/javascript")
+	if err != nil {
+		return err
+	}
+
+	var oembed struct {
+		Title        string `json:"title"`
+		AuthorName   string `json:"author_name"`
+		ProviderName string `json:"provider_name"`
+		ThumbnailURL string `json:"thumbnail_url"`
+	}
+	if err := json.Unmarshal(body, &oembed); err != nil {
+		return errors.Wrap(err, "Invalid oEmbed response")
+	}
+
+	preview.Title = oembed.Title
+	preview.Description = firstOf(preview.Description, oembed.AuthorName)
+	preview.SiteName = firstOf(preview.SiteName, oembed.ProviderName)
+	preview.Image = firstOf(preview.Image, resolveLink(final, oembed.ThumbnailURL))
+	return nil
+}
+
+// get reads up to limit bytes of the response if it's one of the given
+// content types. The link and every redirect have to pass checkLink. The
+// URL after any redirects is returned too.
+func (u *unfurler) get(link string, settings *sidebar.UnfurlSettings, accept string, limit int64, types ...string) ([]byte, *url.URL, error) {
+	req, err := http.NewRequest("GET", link, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := checkLink(req.URL, settings); err != nil {
+		return nil, nil, err
+	}
+
+	// the settings are per workspace, so each request needs its own policy
+	client := *u.Client
+	client.CheckRedirect = func(next *http.Request, via []*http.Request) error {
+		if u.Client.CheckRedirect != nil {
+			if err := u.Client.CheckRedirect(next, via); err != nil {
+				return err
+			}
+		} else if len(via) >= maxRedirects {
+			return errors.New("Too many redirects")
+		}
+		return checkLink(next.URL, settings)
+	}
+
+	req.Header.Set("Accept", accept)
+	req.Header.Set("User-Agent", "SidebarBot/1.0 (link previews)")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, errors.Errorf("Got status %v", resp.StatusCode)
+	}
+
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	var ok bool
+	for _, t := range types {
+		ok = ok || contentType == t
+	}
+	if !ok {
+		return nil, nil, errors.Errorf("Can't preview content of type %v", contentType)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, limit))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Error reading response")
+	}
+
+	return body, resp.Request.URL, nil
+}
+
+// resolveLink makes a link found on a page absolute, dropping anything
+// that isn't http or https.
+func resolveLink(base *url.URL, link string) string {
+	ref, err := url.Parse(strings.TrimSpace(link))
+	if err != nil || link == "" {
+		return ""
+	}
+
+	resolved := base.ResolveReference(ref)
+	if checkURL(resolved) != nil {
+		return ""
+	}
+	return resolved.String()
+}
+
+func firstOf(values ...string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// clip shortens s to at most n characters.
+func clip(s string, n int) string {
+	s = strings.Join(strings.Fields(strings.ToValidUTF8(s, "")), " ")
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+
+	runes := []rune(s)
+	return strings.TrimSpace(string(runes[:n-1])) + "…"
+}
+
+// GetUnfurlSettings returns the domains the workspace does and doesn't
+// want previews for.
+func (u *unfurler) GetUnfurlSettings(uid, wid string) (*sidebar.UnfurlSettings, error) {
+	if err := u.DB.UserInWorkspace(uid, wid); err != nil {
+		return nil, err
+	}
+
+	return u.DB.GetUnfurlSettings(wid)
+}
+
+// UpdateUnfurlSettings replaces the workspace's domain lists. Only admins
+// can change them. A domain in both lists is only kept in Deny.
+func (u *unfurler) UpdateUnfurlSettings(s *sidebar.UnfurlSettings, uid string) error {
+	if err := u.DB.UserIsAdmin(uid, s.WorkspaceID); err != nil {
+		return err
+	}
+
+	deny, err := cleanDomains(s.Deny, nil)
+	if err != nil {
+		return err
+	}
+
+	denied := make(map[string]bool)
+	for _, d := range deny {
+		denied[d] = true
+	}
+
+	allow, err := cleanDomains(s.Allow, denied)
+	if err != nil {
+		return err
+	}
+
+	if len(allow)+len(deny) > maxDomains {
+		return errors.Errorf("Workspaces can't list more than %v domains", maxDomains)
+	}
+
+	before, err := u.DB.GetUnfurlSettings(s.WorkspaceID)
+	if err != nil {
+		return err
+	}
+
+	s.Allow = allow
+	s.Deny = deny
+	if err := u.DB.UpdateUnfurlSettings(s); err != nil {
+		return err
+	}
+
+	record(u.DB, uid, sidebar.ActionSettingsUpdate, s.WorkspaceID, s.WorkspaceID, before, s)
+	return nil
+}
+
+var domainPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*$`)
+
+// cleanDomains lowercases and checks the domains, dropping duplicates
+// and any that are in skip.
+func cleanDomains(domains []string, skip map[string]bool) ([]string, error) {
+	cleaned := []string{}
+	seen := make(map[string]bool)
+	for _, d := range domains {
+		d = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(d)), ".")
+		if len(d) > 253 || !domainPattern.MatchString(d) {
+			return nil, errors.Errorf("Invalid domain %v", d)
+		}
+
+		if !seen[d] && !skip[d] {
+			seen[d] = true
+			cleaned = append(cleaned, d)
+		}
+	}
+	return cleaned, nil
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/tmitchel/sidebar"
+)
+
+func TestDialPublic(t *testing.T) {
+	tests := []struct {
+		address string
+		ok      bool
+	}{
+		{"93.184.216.34:443", true},
+		{"93.184.216.34:80", true},
+		{"[2606:2800:220:1::1]:443", true},
+		{"93.184.216.34:22", false},
+		{"93.184.216.34:8080", false},
+		{"127.0.0.1:80", false},
+		{"10.1.2.3:443", false},
+		{"172.16.0.1:443", false},
+		{"192.168.1.1:80", false},
+		{"100.64.0.1:80", false},
+		{"169.254.169.254:80", false},
+		{"0.0.0.0:80", false},
+		{"224.0.0.1:80", false},
+		{"[::1]:443", false},
+		{"[::]:443", false},
+		{"[fe80::1]:443", false},
+		{"[fd00::1]:443", false},
+		{"[::ffff:127.0.0.1]:80", false},
+		{"[::ffff:10.0.0.1]:443", false},
+		{"[64:ff9b::a00:1]:443", false},
+		{"localhost:80", false},
+		{"no-port", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			err := dialPublic("tcp", tt.address, nil)
+			if (err == nil) != tt.ok {
+				t.Errorf("dialPublic(%v) = %v, want ok %v", tt.address, err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestUnfurlRedirects(t *testing.T) {
+	var mu sync.Mutex
+	hits := make(map[string]int)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.Host]++
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html><head><title>Page</title></head><body></body></html>")
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	base, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the stand-in answers to both names, so a redirect can change host
+	other := "http://localhost:" + base.Port() + "/page"
+	mux.HandleFunc("/hop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, other, http.StatusFound)
+	})
+
+	tests := []struct {
+		name     string
+		path     string
+		settings *sidebar.UnfurlSettings
+		title    string
+	}{
+		{"no redirect", "/page", &sidebar.UnfurlSettings{}, "Page"},
+		{"redirect to another host", "/hop", &sidebar.UnfurlSettings{}, "Page"},
+		{"redirect to a denied host", "/hop", &sidebar.UnfurlSettings{Deny: []string{"localhost"}}, ""},
+		{"redirect outside the allow list", "/hop", &sidebar.UnfurlSettings{Allow: []string{base.Hostname()}}, ""},
+		{"too many redirects", "/loop", &sidebar.UnfurlSettings{}, ""},
+	}
+
+	u := &unfurler{Client: srv.Client()}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			before := hits["localhost:"+base.Port()]
+			mu.Unlock()
+
+			preview, err := u.fetch(srv.URL+tt.path, tt.settings)
+			if tt.title == "" {
+				if err == nil {
+					t.Fatalf("fetched %+v, want an error", preview)
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+				if hits["localhost:"+base.Port()] != before {
+					t.Error("followed a redirect the policy doesn't allow")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if preview.Title != tt.title {
+				t.Errorf("got title %q, want %q", preview.Title, tt.title)
+			}
+		})
+	}
+
+	t.Run("safe client refuses the local network", func(t *testing.T) {
+		safe := &unfurler{Client: NewSafeClient()}
+		if _, err := safe.fetch(srv.URL+"/page", &sidebar.UnfurlSettings{}); err == nil {
+			t.Fatal("the safe client fetched a page on the local network")
+		}
+	})
+}
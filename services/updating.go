@@ -1,7 +1,13 @@
 package services
 
 import (
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	"github.com/tmitchel/sidebar"
 	"github.com/tmitchel/sidebar/store"
 	"golang.org/x/crypto/bcrypt"
@@ -20,9 +26,40 @@ func NewUpdater(db store.Database) (sidebar.Updater, error) {
 	}, nil
 }
 
-// UpdateUserInfo updates things like the display_image.
-func (u *updater) UpdateUserInfo(user *sidebar.User) error {
-	return u.DB.UpdateUserInformation(user)
+// UpdateUserInfo changes the fields set in the update, leaving the rest
+// as they are. The email and image are part of the user's account, while
+// the display name and the rest of the profile are only changed in the
+// given workspace. Everything is saved in one transaction, and the updated
+// user is returned.
+func (u *updater) UpdateUserInfo(update *sidebar.UserUpdate, wid string) (*sidebar.User, error) {
+	before, err := userWithFields(u.DB, update.ID, wid)
+	if err != nil {
+		return nil, err
+	}
+
+	user := *before
+	if err := u.applyUpdate(&user, update, wid); err != nil {
+		return nil, err
+	}
+
+	if err := u.DB.UpdateProfile(&user, wid, update.Fields); err != nil {
+		return nil, err
+	}
+
+	// the account is shared by every workspace the user is in
+	if user.Email != before.Email || user.ProfileImg != before.ProfileImg {
+		recordForUser(u.DB, user.ID, sidebar.ActionUserUpdate, user.ID,
+			map[string]string{"email": before.Email, "profile_image": before.ProfileImg},
+			map[string]string{"email": user.Email, "profile_image": user.ProfileImg})
+	}
+
+	after, err := userWithFields(u.DB, user.ID, wid)
+	if err != nil {
+		return nil, err
+	}
+
+	record(u.DB, user.ID, sidebar.ActionUserUpdate, user.ID, wid, before, after)
+	return after, nil
 }
 
 // UpdateUserPassword gets the user, checks they've provided the correct
@@ -42,10 +79,297 @@ func (u *updater) UpdateUserPassword(id string, newPassword, oldPassword []byte)
 		return errors.Wrap(err, "Unable to hash")
 	}
 
-	return u.DB.UpdateUserPassword(id, hashed)
+	if err := u.DB.UpdateUserPassword(id, hashed); err != nil {
+		return err
+	}
+
+	recordForUser(u.DB, id, sidebar.ActionUserPassword, id, nil, nil)
+	return nil
+}
+
+// UpdateChannelInfo updates things like the display_image after checking
+// the channel is part of the current workspace.
+func (u *updater) UpdateChannelInfo(channel *sidebar.Channel, uid, wid string) error {
+	if err := u.DB.ChannelInWorkspace(channel.ID, wid); err != nil {
+		return err
+	}
+
+	before, err := u.DB.GetChannel(channel.ID)
+	if err != nil {
+		return err
+	}
+
+	if before.ArchivedAt != nil {
+		return errors.Errorf("Channel %v is archived", channel.ID)
+	}
+
+	// sidebars and direct channels keep the unique slugs they were given
+	channel.Slug = before.Slug
+	if !before.IsSidebar && !before.Direct {
+		channel.Slug = slugify(channel.Name)
+	}
+
+	if err := u.DB.UpdateChannelInformation(channel); err != nil {
+		return err
+	}
+
+	record(u.DB, uid, sidebar.ActionChannelUpdate, channel.ID, wid, before, channel)
+	return nil
+}
+
+// ChangeChannelField sets the channel's topic or purpose, records who
+// changed it, and posts a message about the change in the channel from
+// the system bot. The workspace's settings decide who can make changes.
+// The channel is returned along with the message, which is nil if it
+// couldn't be posted.
+func (u *updater) ChangeChannelField(c *sidebar.ChannelChange, wid string) (*sidebar.Channel, *sidebar.ChatMessage, error) {
+	if c.Field != sidebar.FieldTopic && c.Field != sidebar.FieldPurpose {
+		return nil, nil, errors.Errorf("Unknown channel field %v", c.Field)
+	}
+
+	c.Value = strings.Join(strings.Fields(canonicalContent(c.Value)), " ")
+	if utf8.RuneCountInString(c.Value) > sidebar.MaxTopicLength {
+		return nil, nil, errors.Errorf("Channel %ss can't be longer than %v characters", c.Field, sidebar.MaxTopicLength)
+	}
+
+	if err := u.DB.ChannelInWorkspace(c.Channel, wid); err != nil {
+		return nil, nil, err
+	}
+
+	if err := checkNotArchived(u.DB, c.Channel); err != nil {
+		return nil, nil, err
+	}
+
+	if err := u.checkTopicChange(c.Channel, c.ChangedBy, wid); err != nil {
+		return nil, nil, err
+	}
+
+	before, err := u.DB.GetChannel(c.Channel)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c.ID = uuid.New().String()
+	c.ChangedAt = time.Now()
+	if err := u.DB.ChangeChannelField(c); err != nil {
+		return nil, nil, err
+	}
+
+	after, err := u.DB.GetChannel(c.Channel)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	record(u.DB, c.ChangedBy, sidebar.ActionChannelUpdate, c.Channel, wid, before, after)
+
+	content := "<@" + c.ChangedBy + "> cleared the channel " + c.Field
+	if c.Value != "" {
+		content = "<@" + c.ChangedBy + "> set the channel " + c.Field + ": " + c.Value
+	}
+
+	msg, err := postAsBot(u.DB, &sidebar.ChatMessage{
+		Event:   sidebar.EventSystem,
+		Content: content,
+		Channel: c.Channel,
+	}, wid)
+	if err != nil {
+		logrus.Errorf("Unable to post change to channel %v %v", c.Channel, err)
+		return after, nil, nil
+	}
+
+	return after, msg, nil
+}
+
+// GetChannelChanges returns the history of the channel's topic and
+// purpose if the user can see the channel.
+func (u *updater) GetChannelChanges(cid, uid, wid string) ([]*sidebar.ChannelChange, error) {
+	if err := u.DB.ChannelInWorkspace(cid, wid); err != nil {
+		return nil, err
+	}
+
+	channel, err := u.DB.GetChannel(cid)
+	if err != nil {
+		return nil, err
+	}
+
+	if channel.Direct || channel.Private {
+		if err := u.DB.UserInChannel(uid, cid); err != nil {
+			return nil, err
+		}
+	} else if err := u.DB.UserInWorkspace(uid, wid); err != nil {
+		return nil, err
+	}
+
+	return u.DB.GetChannelChanges(cid)
 }
 
-// UpdateChannelInfo updates things like the display_image.
-func (u *updater) UpdateChannelInfo(channel *sidebar.Channel) error {
-	return u.DB.UpdateChannelInformation(channel)
+// checkTopicChange makes sure the user is allowed to change the channel's
+// topic and purpose under the workspace's settings. Only members can
+// change direct and private channels.
+func (u *updater) checkTopicChange(cid, uid, wid string) error {
+	settings, err := u.DB.GetWorkspaceSettings(wid)
+	if err != nil {
+		return err
+	}
+
+	channel, err := u.DB.GetChannel(cid)
+	if err != nil {
+		return err
+	}
+
+	if channel.Direct || channel.Private {
+		if err := u.DB.UserInChannel(uid, cid); err != nil {
+			return err
+		}
+	}
+
+	switch settings.TopicChanges {
+	case sidebar.TopicsByAdmins:
+		return u.DB.UserIsAdmin(uid, wid)
+	case sidebar.TopicsByAnyone:
+		return u.DB.UserInWorkspace(uid, wid)
+	default:
+		return u.DB.UserInChannel(uid, cid)
+	}
+}
+
+// UpdateMessage replaces the content of a message sent by the current
+// user. The previous content is kept as a revision. Messages can only be
+// edited within the workspace's edit window.
+func (u *updater) UpdateMessage(msg *sidebar.ChatMessage, uid, wid string) (*sidebar.ChatMessage, error) {
+	if strings.TrimSpace(msg.Content) == "" {
+		return nil, errors.New("Messages can't be empty")
+	}
+
+	current, err := u.DB.GetMessage(msg.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if current.DeletedAt != nil {
+		return nil, errors.Errorf("Message %v has been deleted", msg.ID)
+	}
+
+	if current.FromUser != uid {
+		return nil, errors.Errorf("User %v didn't send message %v", uid, msg.ID)
+	}
+
+	if err := u.DB.ChannelInWorkspace(current.Channel, wid); err != nil {
+		return nil, err
+	}
+
+	if err := checkNotArchived(u.DB, current.Channel); err != nil {
+		return nil, err
+	}
+
+	settings, err := u.DB.GetWorkspaceSettings(wid)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	window := time.Duration(settings.EditWindow) * time.Second
+	if window > 0 && now.Sub(current.CreatedAt) > window {
+		return nil, errors.Errorf("Message %v can no longer be edited", msg.ID)
+	}
+
+	before := messageAudit(current)
+	current.Content = msg.Content
+	if err := formatMessage(current); err != nil {
+		return nil, err
+	}
+
+	current.EditedAt = &now
+	if err := u.DB.UpdateMessage(current); err != nil {
+		return nil, err
+	}
+
+	record(u.DB, uid, sidebar.ActionMessageUpdate, current.ID, wid, before, messageAudit(current))
+	showReferences(u.DB, wid, current)
+	return current, nil
+}
+
+// GetMessageRevisions returns every previous version of the message if
+// the current user is an admin of the message's workspace.
+func (u *updater) GetMessageRevisions(id, uid, wid string) ([]*sidebar.MessageRevision, error) {
+	if err := u.DB.UserIsAdmin(uid, wid); err != nil {
+		return nil, err
+	}
+
+	msg, err := u.DB.GetMessage(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := u.DB.ChannelInWorkspace(msg.Channel, wid); err != nil {
+		return nil, err
+	}
+
+	return u.DB.GetMessageRevisions(id)
+}
+
+// UpdateWorkspaceSettings replaces the workspace's settings if the
+// current user is an admin.
+func (u *updater) UpdateWorkspaceSettings(settings *sidebar.WorkspaceSettings, uid string) error {
+	if settings.EditWindow < 0 {
+		return errors.New("Edit window can't be negative")
+	}
+
+	switch settings.TopicChanges {
+	case "":
+		settings.TopicChanges = sidebar.TopicsByMembers
+	case sidebar.TopicsByMembers, sidebar.TopicsByAdmins, sidebar.TopicsByAnyone:
+	default:
+		return errors.Errorf("Unknown topic setting %v", settings.TopicChanges)
+	}
+
+	if err := u.DB.UserIsAdmin(uid, settings.WorkspaceID); err != nil {
+		return err
+	}
+
+	before, err := u.DB.GetWorkspaceSettings(settings.WorkspaceID)
+	if err != nil {
+		return err
+	}
+
+	if err := u.DB.UpdateWorkspaceSettings(settings); err != nil {
+		return err
+	}
+
+	record(u.DB, uid, sidebar.ActionSettingsUpdate, settings.WorkspaceID, settings.WorkspaceID, before, settings)
+	return nil
+}
+
+// MarkMentionsRead marks the user's mentions in the channel as read.
+func (u *updater) MarkMentionsRead(uid, cid, wid string) error {
+	if err := u.DB.ChannelInWorkspace(cid, wid); err != nil {
+		return err
+	}
+
+	return u.DB.MarkMentionsRead(uid, cid)
+}
+
+// UpdateReadMarker marks everything up to and including the given message
+// as read by the user. The marker stored after the update is returned.
+func (u *updater) UpdateReadMarker(marker *sidebar.ReadMarker, wid string) (*sidebar.ReadMarker, error) {
+	msg, err := u.DB.GetMessage(marker.MessageID)
+	if err != nil {
+		return nil, err
+	}
+
+	if marker.Channel != "" && marker.Channel != msg.Channel {
+		return nil, errors.Errorf("Message %v isn't in channel %v", msg.ID, marker.Channel)
+	}
+
+	if err := u.DB.ChannelInWorkspace(msg.Channel, wid); err != nil {
+		return nil, err
+	}
+
+	if err := u.DB.UserInChannel(marker.UserID, msg.Channel); err != nil {
+		return nil, err
+	}
+
+	marker.Channel = msg.Channel
+	marker.ReadAt = msg.CreatedAt
+	return u.DB.UpdateReadMarker(marker)
 }
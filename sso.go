@@ -0,0 +1,28 @@
+package sidebar
+
+import "time"
+
+// SSOConfig contains the OpenID Connect settings for a workspace. Only
+// ID tokens from Issuer are accepted and, when Domains is non-empty,
+// only users with an email address in one of those domains can sign
+// in to the workspace.
+type SSOConfig struct {
+	WorkspaceID  string   `json:"workspace_id"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret,omitempty"`
+	RedirectURL  string   `json:"redirect_url"`
+	Domains      []string `json:"domains"`
+}
+
+// SSOState is a login that has been sent to the identity provider and
+// hasn't come back yet. UserID is set when a logged in user is linking
+// an identity to their account instead of logging in with it.
+type SSOState struct {
+	State       string
+	WorkspaceID string
+	UserID      string
+	Verifier    string
+	Nonce       string
+	CreatedAt   time.Time
+}
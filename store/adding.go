@@ -1,7 +1,12 @@
 package store
 
 import (
+	"fmt"
+	"time"
+
 	sq "github.com/Masterminds/squirrel"
+	"github.com/pkg/errors"
+	"github.com/tmitchel/sidebar"
 )
 
 // Adder provides methods for updating an existing channel. "Adder"
@@ -10,10 +15,15 @@ type Adder interface {
 	AddUserToChannel(string, string) error
 	RemoveUserFromChannel(string, string) error
 	ResolveChannel(string) error
+	ArchiveChannel(string, time.Time) error
+	UnarchiveChannel(string) error
 	AddUserToWorkspace(string, string) error
-	AddChannelToWorkspace(string, string) error
 }
 
+// how many numbered names to try when a user's name is already taken in
+// the workspace they're joining
+const maxNameAttempts = 20
+
 // AddUserToChannel takes a user id and channel id then adds that pair to
 // the users_channels table.
 func (d *database) AddUserToChannel(userID, channelID string) error {
@@ -42,18 +52,116 @@ func (d *database) ResolveChannel(channelID string) error {
 	return err
 }
 
-// AddUserToWorkspace adds a user to the given workspace.
-func (d *database) AddUserToWorkspace(uid, wid string) error {
-	_, err := psql.Insert("workspaces_users").
-		Columns("workspace_id", "user_id").Values(wid, uid).
+// ArchiveChannel archives the channel and any of its sidebars that aren't
+// archived already.
+func (d *database) ArchiveChannel(cid string, at time.Time) error {
+	_, err := psql.Update("channels").
+		Set("archived_at", at).
+		Where(channelAndSidebars(cid)).
+		Where(sq.Eq{"archived_at": nil}).
 		RunWith(d).Exec()
 	return err
 }
 
-// AddChannelToWorkspace adds a channel to the given workspace.
-func (d *database) AddChannelToWorkspace(cid, wid string) error {
-	_, err := psql.Insert("workspaces_channels").
-		Columns("workspace_id", "channel_id").Values(wid, cid).
-		RunWith(d).Exec()
-	return err
+// UnarchiveChannel restores the channel along with the sidebars that were
+// archived or deleted with it, and cancels its deletion.
+func (d *database) UnarchiveChannel(cid string) error {
+	tx, err := d.Begin()
+	if err != nil {
+		return errors.Wrap(err, "Error starting transaction")
+	}
+	defer tx.Rollback()
+
+	sidebars := sq.Expr("id IN (SELECT id FROM sidebars WHERE parent_id = ?)", cid)
+	_, err = psql.Update("channels").
+		Set("archived_at", nil).
+		Where(sidebars).
+		Where("archived_at = (SELECT archived_at FROM channels WHERE id = ?)", cid).
+		RunWith(tx).Exec()
+	if err != nil {
+		return err
+	}
+
+	_, err = psql.Update("channels").
+		Set("delete_after", nil).
+		Where(sidebars).
+		Where("delete_after = (SELECT delete_after FROM channels WHERE id = ?)", cid).
+		RunWith(tx).Exec()
+	if err != nil {
+		return err
+	}
+
+	_, err = psql.Update("channels").
+		Set("archived_at", nil).
+		Set("delete_after", nil).
+		Where(sq.Eq{"id": cid}).
+		RunWith(tx).Exec()
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// channelAndSidebars matches the channel and every sidebar off of it.
+func channelAndSidebars(cid string) sq.Sqlizer {
+	return sq.Or{
+		sq.Eq{"id": cid},
+		sq.Expr("id IN (SELECT id FROM sidebars WHERE parent_id = ?)", cid),
+	}
+}
+
+// AddUserToWorkspace adds a user to the given workspace. The first
+// user to join a workspace becomes its admin. The user's profile in the
+// workspace starts with their account's display name, numbered if the
+// name is already taken. Users already in the workspace are left alone.
+func (d *database) AddUserToWorkspace(uid, wid string) error {
+	if err := d.UserInWorkspace(uid, wid); err == nil {
+		return nil
+	}
+
+	var name string
+	err := psql.Select("display_name").From("users").Where(sq.Eq{"id": uid}).
+		RunWith(d).QueryRow().Scan(&name)
+	if err != nil {
+		return err
+	}
+
+	var members int
+	err = psql.Select("COUNT(*)").From("workspaces_users").Where(sq.Eq{"workspace_id": wid}).
+		RunWith(d).QueryRow().Scan(&members)
+	if err != nil {
+		return err
+	}
+
+	role := sidebar.RoleMember
+	if members == 0 {
+		role = sidebar.RoleAdmin
+	}
+
+	for i := 1; i <= maxNameAttempts; i++ {
+		try := name
+		if i > 1 {
+			try = fmt.Sprintf("%v %v", name, i)
+		}
+
+		res, err := psql.Insert("workspaces_users").
+			Columns("workspace_id", "user_id", "user_role", "display_name").Values(wid, uid, role, try).
+			Suffix("ON CONFLICT DO NOTHING").
+			RunWith(d).Exec()
+		if err != nil {
+			return err
+		}
+
+		if n, err := res.RowsAffected(); err != nil || n > 0 {
+			return err
+		}
+
+		// a conflict on the primary key means the user joined meanwhile
+		if err := d.UserInWorkspace(uid, wid); err == nil {
+			return nil
+		}
+	}
+
+	return errors.Wrap(sidebar.ErrConflict, "Display name "+name)
 }
@@ -0,0 +1,206 @@
+package store
+
+import (
+	"database/sql"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/pkg/errors"
+	"github.com/tmitchel/sidebar"
+)
+
+// Attacher provides methods for storing information about uploaded
+// files. The files themselves are kept in a sidebar.BlobStore.
+type Attacher interface {
+	CreateAttachment(*sidebar.Attachment) error
+	GetAttachment(string) (*sidebar.Attachment, error)
+	AttachToMessage(string, string) error
+	GetAttachmentsForMessages([]string) (map[string][]*sidebar.Attachment, error)
+}
+
+var attachmentColumns = []string{
+	"id", "COALESCE(uploader_id, '')", "workspace_id", "message_id", "purpose", "target", "name", "content_type", "size", "width", "height", "created_at",
+}
+
+func scanAttachment(row sq.RowScanner) (*sidebar.Attachment, error) {
+	var a sidebar.Attachment
+	var mid, target sql.NullString
+	err := row.Scan(&a.ID, &a.UploaderID, &a.WorkspaceID, &mid, &a.Purpose, &target, &a.Name, &a.ContentType, &a.Size, &a.Width, &a.Height, &a.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	a.MessageID = mid.String
+	a.Target = target.String
+	a.URL = sidebar.AttachmentURL(a.ID)
+	return &a, nil
+}
+
+// CreateAttachment stores the information for a newly uploaded file
+// along with any thumbnails made from it.
+func (d *database) CreateAttachment(a *sidebar.Attachment) error {
+	var target interface{}
+	if a.Target != "" {
+		target = a.Target
+	}
+
+	tx, err := d.Begin()
+	if err != nil {
+		return errors.Wrap(err, "Error starting transaction")
+	}
+	defer tx.Rollback()
+
+	_, err = psql.Insert("attachments").
+		Columns("id", "uploader_id", "workspace_id", "purpose", "target", "name", "content_type", "size", "width", "height", "created_at").
+		Values(a.ID, a.UploaderID, a.WorkspaceID, a.Purpose, target, a.Name, a.ContentType, a.Size, a.Width, a.Height, a.CreatedAt).
+		RunWith(tx).Exec()
+	if err != nil {
+		return err
+	}
+
+	if len(a.Thumbnails) > 0 {
+		query := psql.Insert("attachment_thumbnails").
+			Columns("attachment_id", "size", "width", "height", "content_type", "bytes")
+		for _, t := range a.Thumbnails {
+			query = query.Values(a.ID, t.Size, t.Width, t.Height, t.ContentType, t.Bytes)
+		}
+
+		if _, err := query.RunWith(tx).Exec(); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetAttachment returns the attachment with the given id.
+func (d *database) GetAttachment(id string) (*sidebar.Attachment, error) {
+	a, err := scanAttachment(psql.Select(attachmentColumns...).From("attachments").
+		Where(sq.Eq{"id": id}).RunWith(d).QueryRow())
+	if err != nil {
+		return nil, err
+	}
+
+	thumbnails, err := d.getThumbnails([]string{id})
+	if err != nil {
+		return nil, err
+	}
+
+	a.Thumbnails = thumbnails[id]
+	return a, nil
+}
+
+// AttachToMessage links the attachment to a message if it hasn't been
+// attached to one already.
+func (d *database) AttachToMessage(id, mid string) error {
+	res, err := psql.Update("attachments").
+		Set("message_id", mid).
+		Where(sq.Eq{"id": id, "message_id": nil}).
+		RunWith(d).Exec()
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return errors.Errorf("Attachment %v is already attached to a message", id)
+	}
+
+	return nil
+}
+
+// GetAttachmentsForMessages returns the attachments for each of the
+// messages, keyed by message id.
+func (d *database) GetAttachmentsForMessages(mids []string) (map[string][]*sidebar.Attachment, error) {
+	attachments := make(map[string][]*sidebar.Attachment)
+	if len(mids) == 0 {
+		return attachments, nil
+	}
+
+	rows, err := psql.Select(attachmentColumns...).From("attachments").
+		Where(sq.Eq{"message_id": mids}).
+		OrderBy("created_at").
+		RunWith(d).Query()
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to find attachments")
+	}
+	defer rows.Close()
+
+	var all []*sidebar.Attachment
+	var ids []string
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error scanning attachments")
+		}
+
+		all = append(all, a)
+		ids = append(ids, a.ID)
+	}
+
+	thumbnails, err := d.getThumbnails(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range all {
+		a.Thumbnails = thumbnails[a.ID]
+		attachments[a.MessageID] = append(attachments[a.MessageID], a)
+	}
+
+	return attachments, nil
+}
+
+// deleteAttachments removes the attachments matching where, along with
+// their thumbnails, and returns their ids.
+func deleteAttachments(tx *sql.Tx, where sq.Sqlizer) ([]string, error) {
+	rows, err := psql.Delete("attachments").
+		Where(where).
+		Suffix("RETURNING id").
+		RunWith(tx).Query()
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to delete attachments")
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, errors.Wrap(err, "Error scanning attachments")
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// getThumbnails returns the thumbnails for each attachment, smallest
+// first, keyed by attachment id.
+func (d *database) getThumbnails(ids []string) (map[string][]*sidebar.Thumbnail, error) {
+	thumbnails := make(map[string][]*sidebar.Thumbnail)
+	if len(ids) == 0 {
+		return thumbnails, nil
+	}
+
+	rows, err := psql.Select("attachment_id", "size", "width", "height", "content_type", "bytes").
+		From("attachment_thumbnails").
+		Where(sq.Eq{"attachment_id": ids}).
+		OrderBy("size").
+		RunWith(d).Query()
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to find thumbnails")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var t sidebar.Thumbnail
+		if err := rows.Scan(&id, &t.Size, &t.Width, &t.Height, &t.ContentType, &t.Bytes); err != nil {
+			return nil, errors.Wrap(err, "Error scanning thumbnails")
+		}
+
+		t.URL = sidebar.ThumbnailURL(id, t.Size)
+		thumbnails[id] = append(thumbnails[id], &t)
+	}
+
+	return thumbnails, nil
+}
@@ -0,0 +1,94 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// LoginAttempter provides methods for tracking failed logins in the
+// database so every instance of the server sees the same lockouts.
+type LoginAttempter interface {
+	GetLoginFailures(string) (int, time.Time, error)
+	RecordLoginFailure(string, time.Time, time.Duration) (int, time.Time, error)
+	RemoveLoginFailure(string) error
+	ResetLoginFailures(string) error
+}
+
+// GetLoginFailures returns the number of failed logins for the key and
+// the time of the last failure. Keys without failures return zero.
+func (d *database) GetLoginFailures(key string) (int, time.Time, error) {
+	var failures int
+	var last time.Time
+	err := psql.Select("failures", "last_failure").From("login_attempts").
+		Where(sq.Eq{"attempt_key": key}).RunWith(d).QueryRow().
+		Scan(&failures, &last)
+	if err == sql.ErrNoRows {
+		return 0, time.Time{}, nil
+	} else if err != nil {
+		return 0, time.Time{}, err
+	}
+	return failures, last, nil
+}
+
+// RecordLoginFailure adds one to the failed logins for the key, starting
+// over if the last failure is older than the window. The row is locked
+// while it's updated, so concurrent logins each get their own count. The
+// failures and last failure from before this one are returned.
+func (d *database) RecordLoginFailure(key string, at time.Time, window time.Duration) (int, time.Time, error) {
+	tx, err := d.Begin()
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = psql.Insert("login_attempts").
+		Columns("attempt_key", "failures", "last_failure").Values(key, 0, at).
+		Suffix("ON CONFLICT (attempt_key) DO NOTHING").
+		RunWith(tx).Exec()
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	var failures int
+	var last time.Time
+	err = psql.Select("failures", "last_failure").From("login_attempts").
+		Where(sq.Eq{"attempt_key": key}).Suffix("FOR UPDATE").
+		RunWith(tx).QueryRow().Scan(&failures, &last)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	if failures == 0 || at.Sub(last) > window {
+		failures, last = 0, time.Time{}
+	}
+
+	_, err = psql.Update("login_attempts").
+		Set("failures", failures+1).
+		Set("last_failure", at).
+		Where(sq.Eq{"attempt_key": key}).
+		RunWith(tx).Exec()
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	return failures, last, tx.Commit()
+}
+
+// RemoveLoginFailure takes one failed login away from the key.
+func (d *database) RemoveLoginFailure(key string) error {
+	_, err := psql.Update("login_attempts").
+		Set("failures", sq.Expr("GREATEST(failures - 1, 0)")).
+		Where(sq.Eq{"attempt_key": key}).
+		RunWith(d).Exec()
+	return err
+}
+
+// ResetLoginFailures clears all failed logins for the key.
+func (d *database) ResetLoginFailures(key string) error {
+	_, err := psql.Delete("login_attempts").
+		Where(sq.Eq{"attempt_key": key}).
+		RunWith(d).Exec()
+	return err
+}
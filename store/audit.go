@@ -0,0 +1,109 @@
+package store
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/pkg/errors"
+	"github.com/tmitchel/sidebar"
+)
+
+// Auditer provides methods for appending to and reading from the
+// audit log.
+type Auditer interface {
+	CreateAuditEntry(*sidebar.AuditEntry) error
+	GetAuditLog(*sidebar.AuditFilter) (*sidebar.AuditPage, error)
+}
+
+// CreateAuditEntry appends the entry to the audit log.
+func (d *database) CreateAuditEntry(e *sidebar.AuditEntry) error {
+	var before, after interface{}
+	if e.Before != nil {
+		before = string(e.Before)
+	}
+	if e.After != nil {
+		after = string(e.After)
+	}
+
+	_, err := psql.Insert("audit_log").
+		Columns("id", "actor", "action", "target", "workspace_id", "created_at", "before_data", "after_data").
+		Values(e.ID, e.Actor, e.Action, e.Target, e.WorkspaceID, e.CreatedAt, before, after).
+		RunWith(d).Exec()
+	return err
+}
+
+// GetAuditLog returns one page of entries matching the filter, newest first.
+// The cursor is the creation time and id of the last entry on the previous
+// page.
+func (d *database) GetAuditLog(f *sidebar.AuditFilter) (*sidebar.AuditPage, error) {
+	query := psql.Select("id", "actor", "action", "target", "workspace_id", "created_at", "before_data", "after_data").
+		From("audit_log").Where(sq.Eq{"workspace_id": f.WorkspaceID})
+
+	if f.Actor != "" {
+		query = query.Where(sq.Eq{"actor": f.Actor})
+	}
+	if f.Action != "" {
+		query = query.Where(sq.Eq{"action": f.Action})
+	}
+	if f.Target != "" {
+		query = query.Where(sq.Eq{"target": f.Target})
+	}
+	if !f.Since.IsZero() {
+		query = query.Where(sq.GtOrEq{"created_at": f.Since})
+	}
+	if !f.Until.IsZero() {
+		query = query.Where(sq.Lt{"created_at": f.Until})
+	}
+	if f.Cursor != "" {
+		created, id, err := parseCursor(f.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("(created_at, id) < (?, ?)", created, id)
+	}
+
+	// get one extra to know if there is another page
+	rows, err := query.OrderBy("created_at DESC", "id DESC").
+		Limit(uint64(f.Limit + 1)).RunWith(d).Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &sidebar.AuditPage{}
+	for rows.Next() {
+		var e sidebar.AuditEntry
+		var before, after []byte
+		err := rows.Scan(&e.ID, &e.Actor, &e.Action, &e.Target, &e.WorkspaceID, &e.CreatedAt, &before, &after)
+		if err != nil {
+			return nil, err
+		}
+
+		e.Before, e.After = before, after
+		page.Entries = append(page.Entries, &e)
+	}
+
+	if len(page.Entries) > f.Limit {
+		page.Entries = page.Entries[:f.Limit]
+		last := page.Entries[f.Limit-1]
+		page.NextCursor = strconv.FormatInt(last.CreatedAt.UnixNano(), 10) + "_" + last.ID
+	}
+
+	return page, nil
+}
+
+func parseCursor(cursor string) (time.Time, string, error) {
+	parts := strings.SplitN(cursor, "_", 2)
+	if len(parts) != 2 {
+		return time.Time{}, "", errors.Errorf("Invalid cursor %v", cursor)
+	}
+
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, "", errors.Wrapf(err, "Invalid cursor %v", cursor)
+	}
+
+	return time.Unix(0, nanos), parts[1], nil
+}
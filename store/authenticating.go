@@ -11,7 +11,9 @@ import (
 type Authenticater interface {
 	UserForAuth(string) (*sidebar.User, error)
 	UserInWorkspace(string, string) error
+	UserIsAdmin(string, string) error
 	ChannelInWorkspace(string, string) error
+	UserInChannel(string, string) error
 }
 
 // UserForAuth takes a user email, queries the database for that user,
@@ -45,6 +47,23 @@ func (d *database) UserInWorkspace(uid, wid string) error {
 	return nil
 }
 
+// UserIsAdmin returns an error if the user isn't an admin of
+// the provided workspace.
+func (d *database) UserIsAdmin(uid, wid string) error {
+	var role int
+	err := psql.Select("user_role").From("workspaces_users").Where(sq.Eq{"workspace_id": wid}).Where(sq.Eq{"user_id": uid}).
+		RunWith(d).QueryRow().Scan(&role)
+	if err != nil {
+		return err
+	}
+
+	if role != sidebar.RoleAdmin {
+		return errors.Errorf("User %v is not an admin of workspace %v", uid, wid)
+	}
+
+	return nil
+}
+
 // ChannelInWorkspace returns an error if the channel isn't a member
 // of the workspace.
 func (d *database) ChannelInWorkspace(cid, wid string) error {
@@ -61,3 +80,16 @@ func (d *database) ChannelInWorkspace(cid, wid string) error {
 
 	return nil
 }
+
+// UserInChannel returns an error if the user isn't a member
+// of the channel.
+func (d *database) UserInChannel(uid, cid string) error {
+	var id string
+	err := psql.Select("user_id").From("users_channels").Where(sq.Eq{"channel_id": cid}).Where(sq.Eq{"user_id": uid}).
+		RunWith(d).QueryRow().Scan(&id)
+	if err != nil {
+		return errors.Wrapf(err, "User %v isn't a member of channel %v", uid, cid)
+	}
+
+	return nil
+}
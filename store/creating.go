@@ -1,6 +1,8 @@
 package store
 
 import (
+	sq "github.com/Masterminds/squirrel"
+	"github.com/pkg/errors"
 	"github.com/tmitchel/sidebar"
 )
 
@@ -9,8 +11,9 @@ type Creater interface {
 	CreateUser(*sidebar.User) (*sidebar.User, error)
 	CreateWorkspace(*sidebar.Workspace) (*sidebar.Workspace, error)
 	CreateDefaultWorkspace(*sidebar.Workspace) (*sidebar.Workspace, error)
-	CreateChannel(*sidebar.Channel) (*sidebar.Channel, error)
+	CreateChannel(*sidebar.Channel, string) (*sidebar.Channel, error)
 	CreateMessage(*sidebar.ChatMessage) (*sidebar.ChatMessage, error)
+	PromoteThread(*sidebar.Channel, string, []string, []*sidebar.ChatMessage) error
 }
 
 // CreateUserNoToken is used to create a default user when the app starts
@@ -18,7 +21,7 @@ type Creater interface {
 func CreateUserNoToken(d Database, u *sidebar.User) (*sidebar.User, error) {
 	_, err := psql.Insert("users").
 		Columns("id", "display_name", "email", "password", "profile_image").
-		Values(u.ID, u.DisplayName, u.Email, u.Password, "https://randomuser.me/api/portraits/women/81.jpg").
+		Values(u.ID, u.DisplayName, u.Email, u.Password, sidebar.IdenticonURL(u.ID)).
 		Suffix("RETURNING id").
 		RunWith(d).Exec()
 	if err != nil {
@@ -31,7 +34,7 @@ func CreateUserNoToken(d Database, u *sidebar.User) (*sidebar.User, error) {
 func createUser(d Database, u *sidebar.User) (*sidebar.User, error) {
 	_, err := psql.Insert("users").
 		Columns("id", "display_name", "email", "password", "profile_image").
-		Values(u.ID, u.DisplayName, u.Email, u.Password, "https://randomuser.me/api/portraits/women/81.jpg").
+		Values(u.ID, u.DisplayName, u.Email, u.Password, sidebar.IdenticonURL(u.ID)).
 		RunWith(d).Exec()
 	if err != nil {
 		return nil, err
@@ -42,11 +45,11 @@ func createUser(d Database, u *sidebar.User) (*sidebar.User, error) {
 
 func (d *database) CreateUser(u *sidebar.User) (*sidebar.User, error) {
 	_, err := psql.Insert("users").
-		Columns("id", "display_name", "email", "password", "profile_image").
-		Values(u.ID, u.DisplayName, u.Email, u.Password, u.ProfileImg).
+		Columns("id", "display_name", "email", "password", "profile_image", "is_bot").
+		Values(u.ID, u.DisplayName, u.Email, u.Password, u.ProfileImg, u.IsBot).
 		RunWith(d).Exec()
 	if err != nil {
-		return nil, err
+		return nil, conflict(err, "Email "+u.Email)
 	}
 
 	return u, nil
@@ -58,7 +61,7 @@ func (d *database) CreateWorkspace(w *sidebar.Workspace) (*sidebar.Workspace, er
 		Values(w.ID, w.DisplayName, w.DisplayImg, w.Token, false).
 		RunWith(d).Exec()
 	if err != nil {
-		return nil, err
+		return nil, conflict(err, "Workspace "+w.DisplayName)
 	}
 
 	return w, nil
@@ -76,48 +79,122 @@ func (d *database) CreateDefaultWorkspace(w *sidebar.Workspace) (*sidebar.Worksp
 	return w, nil
 }
 
-func (d *database) CreateChannel(c *sidebar.Channel) (*sidebar.Channel, error) {
-	_, err := psql.Insert("channels").
-		Columns("id", "display_name", "details", "display_image", "is_sidebar", "is_direct").
-		Values(c.ID, c.Name, c.Details, c.Image, c.IsSidebar, c.Direct).
-		RunWith(d).Exec()
+// CreateChannel creates the channel in the workspace. The channel's slug
+// has to be unique in the workspace.
+func (d *database) CreateChannel(c *sidebar.Channel, wid string) (*sidebar.Channel, error) {
+	tx, err := d.Begin()
 	if err != nil {
+		return nil, errors.Wrap(err, "Error starting transaction")
+	}
+	defer tx.Rollback()
+
+	if err := insertChannel(tx, c, wid); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (d *database) CreateMessage(m *sidebar.ChatMessage) (*sidebar.ChatMessage, error) {
+	if err := insertMessage(d, m); err != nil {
 		return nil, err
 	}
 
+	return m, nil
+}
+
+// PromoteThread creates the sidebar with its members and copies of the
+// thread's messages all at once, so a failure part way through doesn't
+// leave a half-filled sidebar behind.
+func (d *database) PromoteThread(c *sidebar.Channel, wid string, uids []string, messages []*sidebar.ChatMessage) error {
+	tx, err := d.Begin()
+	if err != nil {
+		return errors.Wrap(err, "Error starting transaction")
+	}
+	defer tx.Rollback()
+
+	if err := insertChannel(tx, c, wid); err != nil {
+		return err
+	}
+
+	for _, uid := range uids {
+		_, err := psql.Insert("users_channels").
+			Columns("user_id", "channel_id").
+			Values(uid, c.ID).
+			RunWith(tx).Exec()
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, m := range messages {
+		if err := insertMessage(tx, m); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func insertChannel(run sq.BaseRunner, c *sidebar.Channel, wid string) error {
+	_, err := psql.Insert("channels").
+		Columns("id", "workspace_id", "display_name", "slug", "details", "display_image", "is_sidebar", "is_direct", "is_private").
+		Values(c.ID, wid, c.Name, c.Slug, c.Details, c.Image, c.IsSidebar, c.Direct, c.Private).
+		RunWith(run).Exec()
+	if err != nil {
+		return conflict(err, "Channel "+c.Slug)
+	}
+
+	_, err = psql.Insert("workspaces_channels").
+		Columns("workspace_id", "channel_id").Values(wid, c.ID).
+		RunWith(run).Exec()
+	if err != nil {
+		return err
+	}
+
 	if c.IsSidebar {
 		_, err := psql.Insert("sidebars").
 			Columns("id", "parent_id").Values(c.ID, c.Parent).
-			RunWith(d).Exec()
+			RunWith(run).Exec()
 		if err != nil {
-			return nil, err
+			return err
 		}
 	}
 
-	return c, nil
+	return nil
 }
 
-func (d *database) CreateMessage(m *sidebar.ChatMessage) (*sidebar.ChatMessage, error) {
+func insertMessage(run sq.BaseRunner, m *sidebar.ChatMessage) error {
+	// messages from deleted users and top-level messages store NULLs
+	var from, replyTo interface{}
+	if m.FromUser != "" {
+		from = m.FromUser
+	}
+	if m.ReplyTo != "" {
+		replyTo = m.ReplyTo
+	}
+
 	_, err := psql.Insert("messages").
-		Columns("id", "content", "event").Values(m.ID, m.Content, m.Event).
-		RunWith(d).Exec()
+		Columns("id", "content", "html", "event", "created_at", "reply_to").
+		Values(m.ID, m.Content, m.HTML, m.Event, m.CreatedAt, replyTo).
+		RunWith(run).Exec()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	_, err = psql.Insert("users_messages").
-		Columns("user_to_id", "user_from_id", "message_id").Values(m.ToUser, m.FromUser, m.ID).
-		RunWith(d).Exec()
+		Columns("user_to_id", "user_from_id", "message_id").Values(m.ToUser, from, m.ID).
+		RunWith(run).Exec()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	_, err = psql.Insert("channels_messages").
-		Columns("channel_id", "message_id").Values(m.Channel, m.ID).
-		RunWith(d).Exec()
-	if err != nil {
-		return nil, err
-	}
-
-	return m, nil
+		Columns("channel_id", "message_id", "created_at").Values(m.Channel, m.ID, m.CreatedAt).
+		RunWith(run).Exec()
+	return err
 }
@@ -0,0 +1,62 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tmitchel/sidebar"
+)
+
+func TestPromoteThread(t *testing.T) {
+	d := testDB(t)
+	defer d.Close()
+
+	if _, err := d.CreateWorkspace(&sidebar.Workspace{ID: "w1", DisplayName: "w1", Token: "t1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.CreateUser(&sidebar.User{ID: "u1", DisplayName: "u1", Email: "u1@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.CreateChannel(&sidebar.Channel{ID: "c1", Name: "c1", Slug: "c1"}, "w1"); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	sidebarChannel := func(id string) *sidebar.Channel {
+		return &sidebar.Channel{ID: id, Name: id, Slug: id, IsSidebar: true, Parent: "c1"}
+	}
+
+	// the last message is from a user that doesn't exist, so nothing
+	// before it should be kept either
+	err := d.PromoteThread(sidebarChannel("s1"), "w1", []string{"u1"}, []*sidebar.ChatMessage{
+		{ID: "m1", Channel: "s1", FromUser: "u1", Content: "first", CreatedAt: now},
+		{ID: "m2", Channel: "s1", FromUser: "missing", Content: "second", CreatedAt: now},
+	})
+	if err == nil {
+		t.Fatal("expected promoting with a missing author to fail")
+	}
+	if _, err := d.GetChannel("s1"); err == nil {
+		t.Fatal("sidebar was left behind after a failed promotion")
+	}
+	if msgs, err := d.GetMessagesInChannel("s1"); err == nil && len(msgs) != 0 {
+		t.Fatalf("%v messages were left behind after a failed promotion", len(msgs))
+	}
+
+	err = d.PromoteThread(sidebarChannel("s2"), "w1", []string{"u1"}, []*sidebar.ChatMessage{
+		{ID: "m3", Channel: "s2", FromUser: "u1", Content: "first", CreatedAt: now},
+		{ID: "m4", Channel: "s2", FromUser: "u1", Content: "second", CreatedAt: now},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.UserInChannel("u1", "s2"); err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := d.GetMessagesInChannel("s2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 {
+		t.Fatalf("sidebar has %v messages, want 2", len(msgs))
+	}
+}
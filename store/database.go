@@ -5,15 +5,28 @@ import (
 	"time"
 
 	sq "github.com/Masterminds/squirrel"
+	"github.com/lib/pq" // postgres drivers
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
-
-	_ "github.com/lib/pq" // postgres drivers
+	"github.com/tmitchel/sidebar"
 )
 
 // statement builder using postgres style
 var psql = sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
 
+// postgres error code for unique constraint violations
+const uniqueViolation = "23505"
+
+// conflict turns unique constraint violations into errors caused by
+// sidebar.ErrConflict so they can be told apart from other failures.
+// Other errors are returned as is.
+func conflict(err error, what string) error {
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == uniqueViolation {
+		return errors.Wrap(sidebar.ErrConflict, what)
+	}
+	return err
+}
+
 // Database provides methods to query the database.
 type Database interface {
 	Adder
@@ -22,6 +35,24 @@ type Database interface {
 	Getter
 	Updater
 	Authenticater
+	SingleSignOner
+	TokenManager
+	LoginAttempter
+	Auditer
+	Settings
+	Reacter
+	Mentioner
+	ReadMarkers
+	Notifications
+	Searcher
+	Attacher
+	Previews
+	Pinner
+	Saved
+	Schedules
+	Directs
+	Topics
+	Profiles
 	sq.BaseRunner
 	Empty() error
 
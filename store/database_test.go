@@ -0,0 +1,34 @@
+package store
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// testDB connects to the scratch database in SIDEBAR_TEST_DB and loads a
+// fresh schema into it. Everything in that database is dropped, so never
+// point it at real data. Tests are skipped when it isn't set.
+func testDB(t *testing.T) *database {
+	dsn := os.Getenv("SIDEBAR_TEST_DB")
+	if dsn == "" {
+		t.Skip("SIDEBAR_TEST_DB isn't set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema, err := ioutil.ReadFile("../scripts/create_db.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatalf("Unable to load schema %v", err)
+	}
+
+	return &database{db}
+}
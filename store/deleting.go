@@ -1,6 +1,9 @@
 package store
 
 import (
+	"database/sql"
+	"time"
+
 	sq "github.com/Masterminds/squirrel"
 	"github.com/pkg/errors"
 	"github.com/tmitchel/sidebar"
@@ -9,10 +12,14 @@ import (
 // Deleter provides methods for deleting rows from the database.
 type Deleter interface {
 	DeleteUser(string) (*sidebar.User, error)
-	DeleteChannel(string) (*sidebar.Channel, error)
+	ScheduleChannelDeletion(string, time.Time, time.Time) error
+	DueChannelDeletions(time.Time) ([]string, error)
+	PurgeChannel(string, time.Time) (bool, []string, error)
+	DeleteMessage(string, time.Time) ([]string, error)
 }
 
-// DeleteUser removes the user with the given id from the database.
+// DeleteUser removes the user with the given id from the database. Their
+// messages are kept but no longer point to an author.
 func (d *database) DeleteUser(id string) (*sidebar.User, error) {
 	user, err := d.GetUser(id)
 	if err != nil || user.ID == "" {
@@ -27,17 +34,131 @@ func (d *database) DeleteUser(id string) (*sidebar.User, error) {
 	return user, nil
 }
 
-// DeleteChannel removes the channel with the given id from the database.
-func (d *database) DeleteChannel(id string) (*sidebar.Channel, error) {
-	channel, err := d.GetChannel(id)
-	if err != nil || channel.ID == "" {
-		return nil, errors.Errorf("User with id: %v doesn't exist", id)
+// ScheduleChannelDeletion archives the channel and its sidebars, if they
+// aren't already, and marks them to be purged after the given time.
+func (d *database) ScheduleChannelDeletion(cid string, at, after time.Time) error {
+	_, err := psql.Update("channels").
+		Set("archived_at", sq.Expr("COALESCE(archived_at, ?)", at)).
+		Set("delete_after", after).
+		Where(channelAndSidebars(cid)).
+		RunWith(d).Exec()
+	return err
+}
+
+// DueChannelDeletions returns the ids of channels that are ready to be
+// purged.
+func (d *database) DueChannelDeletions(now time.Time) ([]string, error) {
+	rows, err := psql.Select("id").From("channels").
+		Where(sq.LtOrEq{"delete_after": now}).
+		RunWith(d).Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// PurgeChannel removes the channel, its messages, and its members from
+// the database if it's still due to be deleted. False is returned if the
+// channel was restored or another server purged it first. The ids of the
+// attachments removed with it are returned so their files can be deleted.
+func (d *database) PurgeChannel(cid string, now time.Time) (bool, []string, error) {
+	tx, err := d.Begin()
+	if err != nil {
+		return false, nil, errors.Wrap(err, "Error starting transaction")
+	}
+	defer tx.Rollback()
+
+	var id string
+	err = psql.Select("id").From("channels").
+		Where(sq.Eq{"id": cid}).
+		Where(sq.LtOrEq{"delete_after": now}).
+		Suffix("FOR UPDATE SKIP LOCKED").
+		RunWith(tx).QueryRow().Scan(&id)
+	if err == sql.ErrNoRows {
+		return false, nil, nil
+	} else if err != nil {
+		return false, nil, err
+	}
+
+	attachments, err := deleteAttachments(tx, sq.Or{
+		sq.Expr("message_id IN (SELECT message_id FROM channels_messages WHERE channel_id = ?)", cid),
+		sq.Eq{"purpose": sidebar.PurposeChannel, "target": cid},
+	})
+	if err != nil {
+		return false, nil, err
+	}
+
+	_, err = psql.Delete("messages").
+		Where("id IN (SELECT message_id FROM channels_messages WHERE channel_id = ?)", cid).
+		RunWith(tx).Exec()
+	if err != nil {
+		return false, nil, err
+	}
+
+	_, err = psql.Delete("users_channels").Where(sq.Eq{"channel_id": cid}).RunWith(tx).Exec()
+	if err != nil {
+		return false, nil, err
+	}
+
+	_, err = psql.Delete("channels").Where(sq.Eq{"id": cid}).RunWith(tx).Exec()
+	if err != nil {
+		return false, nil, err
+	}
+
+	return true, attachments, tx.Commit()
+}
+
+// DeleteMessage replaces the message with a tombstone, removing its
+// content, attachments, and every previous revision, and unpins it. The
+// ids of the removed attachments are returned so their files can be
+// deleted.
+func (d *database) DeleteMessage(id string, at time.Time) ([]string, error) {
+	tx, err := d.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error starting transaction")
+	}
+	defer tx.Rollback()
+
+	res, err := psql.Update("messages").
+		Set("content", "").
+		Set("html", "").
+		Set("deleted_at", at).
+		Where(sq.Eq{"id": id, "deleted_at": nil}).
+		RunWith(tx).Exec()
+	if err != nil {
+		return nil, err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return nil, errors.Errorf("Message with id: %v doesn't exist", id)
+	}
+
+	_, err = psql.Delete("message_revisions").Where(sq.Eq{"message_id": id}).RunWith(tx).Exec()
+	if err != nil {
+		return nil, err
+	}
+
+	// there's nothing left to pin
+	_, err = psql.Delete("pins").Where(sq.Eq{"message_id": id}).RunWith(tx).Exec()
+	if err != nil {
+		return nil, err
 	}
 
-	_, err = psql.Delete("channels").Where(sq.Eq{"id": id}).RunWith(d).Exec()
+	attachments, err := deleteAttachments(tx, sq.Eq{"message_id": id})
 	if err != nil {
 		return nil, err
 	}
 
-	return channel, nil
+	return attachments, tx.Commit()
 }
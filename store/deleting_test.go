@@ -0,0 +1,63 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tmitchel/sidebar"
+)
+
+func TestDeleteUserKeepsMessages(t *testing.T) {
+	d := testDB(t)
+	defer d.Close()
+
+	if _, err := d.CreateWorkspace(&sidebar.Workspace{ID: "w1", DisplayName: "w1", Token: "t1"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, uid := range []string{"u1", "u2"} {
+		if _, err := d.CreateUser(&sidebar.User{ID: uid, DisplayName: uid, Email: uid + "@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.AddUserToWorkspace(uid, "w1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := d.CreateChannel(&sidebar.Channel{ID: "c1", Name: "c1", Slug: "c1"}, "w1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, uid := range []string{"u1", "u2"} {
+		if err := d.AddUserToChannel(uid, "c1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now()
+	for _, m := range []*sidebar.ChatMessage{
+		{ID: "m1", Channel: "c1", FromUser: "u1", Content: "from u1", CreatedAt: now},
+		{ID: "m2", Channel: "c1", FromUser: "u2", Content: "from u2", CreatedAt: now},
+	} {
+		if _, err := d.CreateMessage(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := d.DeleteUser("u1"); err != nil {
+		t.Fatalf("deleting a channel member: %v", err)
+	}
+
+	if err := d.UserInChannel("u1", "c1"); err == nil {
+		t.Fatal("deleted user is still in the channel")
+	}
+
+	msgs, err := d.GetMessagesInChannel("c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	authors := make(map[string]string)
+	for _, m := range msgs {
+		authors[m.ID] = m.FromUser
+	}
+	if len(msgs) != 2 || authors["m1"] != "" || authors["m2"] != "u2" {
+		t.Fatalf("authors after delete %v, want m1 without an author and m2 from u2", authors)
+	}
+}
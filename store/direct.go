@@ -0,0 +1,105 @@
+package store
+
+import (
+	sq "github.com/Masterminds/squirrel"
+	"github.com/pkg/errors"
+	"github.com/tmitchel/sidebar"
+)
+
+// Directs provides methods for finding the direct channel used by a set
+// of users. Members are the users' sorted ids separated by commas.
+type Directs interface {
+	GetDirectChannel(string, string) (string, error)
+	CreateDirectChannel(*sidebar.Channel, string, string, []string) error
+	ConvertDirectChannel(*sidebar.Channel) error
+}
+
+// GetDirectChannel returns the id of the direct channel for the members
+// in the workspace.
+func (d *database) GetDirectChannel(wid, members string) (string, error) {
+	var cid string
+	err := psql.Select("channel_id").From("direct_channels").
+		Where(sq.Eq{"workspace_id": wid, "members": members}).
+		RunWith(d).QueryRow().Scan(&cid)
+	if err != nil {
+		return "", err
+	}
+
+	return cid, nil
+}
+
+// CreateDirectChannel creates the channel in the workspace, adds the users
+// to it, and keys it by members. Nothing is created if the members already
+// have a direct channel.
+func (d *database) CreateDirectChannel(c *sidebar.Channel, wid, members string, uids []string) error {
+	tx, err := d.Begin()
+	if err != nil {
+		return errors.Wrap(err, "Error starting transaction")
+	}
+	defer tx.Rollback()
+
+	_, err = psql.Insert("channels").
+		Columns("id", "workspace_id", "display_name", "slug", "details", "display_image", "is_direct").
+		Values(c.ID, wid, c.Name, c.Slug, c.Details, c.Image, true).
+		RunWith(tx).Exec()
+	if err != nil {
+		return conflict(err, "Channel "+c.Slug)
+	}
+
+	_, err = psql.Insert("direct_channels").
+		Columns("workspace_id", "members", "channel_id").
+		Values(wid, members, c.ID).
+		RunWith(tx).Exec()
+	if err != nil {
+		return conflict(err, "Direct channel")
+	}
+
+	_, err = psql.Insert("workspaces_channels").
+		Columns("workspace_id", "channel_id").
+		Values(wid, c.ID).
+		RunWith(tx).Exec()
+	if err != nil {
+		return err
+	}
+
+	for _, uid := range uids {
+		_, err := psql.Insert("users_channels").
+			Columns("user_id", "channel_id").
+			Values(uid, c.ID).
+			RunWith(tx).Exec()
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ConvertDirectChannel turns a direct channel into a private channel with
+// the channel's name and slug. Its members are kept, but opening a direct
+// conversation with them will start a new channel.
+func (d *database) ConvertDirectChannel(c *sidebar.Channel) error {
+	tx, err := d.Begin()
+	if err != nil {
+		return errors.Wrap(err, "Error starting transaction")
+	}
+	defer tx.Rollback()
+
+	_, err = psql.Update("channels").
+		Set("display_name", c.Name).
+		Set("slug", c.Slug).
+		Set("is_direct", false).
+		Set("is_private", true).
+		Where(sq.Eq{"id": c.ID}).
+		RunWith(tx).Exec()
+	if err != nil {
+		return conflict(err, "Channel "+c.Slug)
+	}
+
+	_, err = psql.Delete("direct_channels").Where(sq.Eq{"channel_id": c.ID}).RunWith(tx).Exec()
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -2,6 +2,7 @@ package store
 
 import (
 	"database/sql"
+	"time"
 
 	sq "github.com/Masterminds/squirrel"
 	"github.com/pkg/errors"
@@ -17,11 +18,14 @@ type Getter interface {
 	GetDefaultWorkspace() (*sidebar.Workspace, error)
 	GetWorkspaceToken(string) (string, error)
 	GetWorkspaceExists(string) error
+	GetWorkspaceForChannel(string) (string, error)
 	GetUser(string) (*sidebar.User, error)
+	GetUserInWorkspace(string, string) (*sidebar.User, error)
 	GetChannel(string) (*sidebar.Channel, error)
 	GetMessage(string) (*sidebar.ChatMessage, error)
+	GetMessageRevisions(string) ([]*sidebar.MessageRevision, error)
 
-	GetUsers() ([]*sidebar.User, error)
+	GetUsers(string) ([]*sidebar.User, error)
 	GetChannels() ([]*sidebar.Channel, error)
 	GetMessages() ([]*sidebar.ChatMessage, error)
 
@@ -29,6 +33,7 @@ type Getter interface {
 	GetChannelsForUser(string) ([]*sidebar.Channel, error)
 
 	GetMessagesInChannel(string) ([]*sidebar.ChatMessage, error)
+	GetThread(string) ([]*sidebar.ChatMessage, error)
 	GetMessagesFromUser(string) ([]*sidebar.ChatMessage, error)
 	GetMessagesToUser(string) ([]*sidebar.ChatMessage, error)
 }
@@ -119,12 +124,25 @@ func (d *database) GetWorkspaceExists(wid string) error {
 	return nil
 }
 
+// GetWorkspaceForChannel returns the id of the workspace the channel
+// belongs to.
+func (d *database) GetWorkspaceForChannel(cid string) (string, error) {
+	var wid string
+	err := psql.Select("workspace_id").From("workspaces_channels").
+		Where(sq.Eq{"channel_id": cid}).RunWith(d).QueryRow().
+		Scan(&wid)
+	if err != nil {
+		return "", err
+	}
+	return wid, nil
+}
+
 // GetUser returns the user with the given id.
 func (d *database) GetUser(id string) (*sidebar.User, error) {
 	var u sidebar.User
-	err := psql.Select("id", "display_name", "email", "password", "profile_image").
+	err := psql.Select("id", "display_name", "email", "password", "profile_image", "is_bot").
 		From("users").Where(sq.Eq{"id": id}).RunWith(d).QueryRow().
-		Scan(&u.ID, &u.DisplayName, &u.Email, &u.Password, &u.ProfileImg)
+		Scan(&u.ID, &u.DisplayName, &u.Email, &u.Password, &u.ProfileImg, &u.IsBot)
 	if err != nil {
 		return nil, err
 	}
@@ -132,11 +150,52 @@ func (d *database) GetUser(id string) (*sidebar.User, error) {
 	return &u, nil
 }
 
-// GetUsersInChannel returns all users that are members of the given channel.
+// GetUserInWorkspace returns the user with the given id using their
+// display name in the workspace.
+func (d *database) GetUserInWorkspace(uid, wid string) (*sidebar.User, error) {
+	return scanUser(psql.Select(userColumns...).
+		From("users u").Join("workspaces_users wu ON ( wu.user_id = u.id )").
+		Where(sq.Eq{"u.id": uid, "wu.workspace_id": wid}).RunWith(d).QueryRow())
+}
+
+// userColumns are selected by queries that return users along with their
+// profile in a workspace, joined as wu, and are read by scanUser.
+var userColumns = []string{
+	"u.id", "COALESCE(wu.display_name, u.display_name)", "u.email", "u.password", "u.profile_image", "u.is_bot",
+	"COALESCE(wu.title, '')", "COALESCE(wu.pronouns, '')", "COALESCE(wu.time_zone, '')",
+	"COALESCE(wu.status_emoji, '')", "COALESCE(wu.status_text, '')", "wu.status_expires_at",
+}
+
+// scanUser reads a row selected with userColumns. Statuses that have
+// expired but haven't been cleared yet are left out.
+func scanUser(row sq.RowScanner) (*sidebar.User, error) {
+	var u sidebar.User
+	var emoji, text string
+	var expires sql.NullTime
+	err := row.Scan(&u.ID, &u.DisplayName, &u.Email, &u.Password, &u.ProfileImg, &u.IsBot,
+		&u.Title, &u.Pronouns, &u.TimeZone, &emoji, &text, &expires)
+	if err != nil {
+		return nil, err
+	}
+
+	if (emoji != "" || text != "") && (!expires.Valid || expires.Time.After(time.Now())) {
+		u.Status = &sidebar.Status{Emoji: emoji, Text: text}
+		if expires.Valid {
+			u.Status.ExpiresAt = &expires.Time
+		}
+	}
+
+	return &u, nil
+}
+
+// GetUsersInChannel returns all users that are members of the given channel
+// using their profiles in the channel's workspace.
 func (d *database) GetUsersInChannel(id string) ([]*sidebar.User, error) {
 	var users []*sidebar.User
-	rows, err := psql.Select("id", "display_name", "email", "password", "profile_image").
-		From("users").Join("users_channels uc ON ( uc.user_id = id )").
+	rows, err := psql.Select(userColumns...).
+		From("users u").Join("users_channels uc ON ( uc.user_id = u.id )").
+		Join("channels ch ON ( ch.id = uc.channel_id )").
+		LeftJoin("workspaces_users wu ON ( wu.user_id = u.id AND wu.workspace_id = ch.workspace_id )").
 		Where(sq.Eq{"uc.channel_id": id}).RunWith(d).Query()
 	if err != nil {
 		return nil, err
@@ -144,12 +203,11 @@ func (d *database) GetUsersInChannel(id string) ([]*sidebar.User, error) {
 	defer rows.Close()
 
 	for rows.Next() {
-		var u sidebar.User
-		err := rows.Scan(&u.ID, &u.DisplayName, &u.Email, &u.Password, &u.ProfileImg)
+		u, err := scanUser(rows)
 		if err != nil {
 			continue
 		}
-		users = append(users, &u)
+		users = append(users, u)
 	}
 
 	return users, nil
@@ -157,12 +215,9 @@ func (d *database) GetUsersInChannel(id string) ([]*sidebar.User, error) {
 
 // GetChannelsForUser returns all channels the given user is a member of.
 func (d *database) GetChannelsForUser(id string) ([]*sidebar.Channel, error) {
-	var parent sql.NullString
 	var channels []*sidebar.Channel
-	rows, err := psql.Select("ch.id", "ch.display_name", "ch.details", "ch.display_image", "ch.is_sidebar", "sb.parent_id", "ch.is_direct", "ch.resolved").
-		From("channels as ch").
+	rows, err := selectChannels().
 		Join("users_channels uc ON ( uc.channel_id = ch.id )").
-		JoinClause("FULL JOIN sidebars sb ON (sb.id = ch.id)").
 		Where(sq.Eq{"uc.user_id": id}).RunWith(d).Query()
 	if err != nil {
 		return nil, err
@@ -170,17 +225,12 @@ func (d *database) GetChannelsForUser(id string) ([]*sidebar.Channel, error) {
 	defer rows.Close()
 
 	for rows.Next() {
-		var c sidebar.Channel
-		err := rows.Scan(&c.ID, &c.Name, &c.Details, &c.Image, &c.IsSidebar, &parent, &c.Direct, &c.Resolved)
+		c, err := scanChannel(rows)
 		if err != nil {
 			continue
 		}
 
-		if parent.Valid {
-			c.Parent = parent.String
-		}
-
-		channels = append(channels, &c)
+		channels = append(channels, c)
 	}
 
 	return channels, nil
@@ -188,12 +238,30 @@ func (d *database) GetChannelsForUser(id string) ([]*sidebar.Channel, error) {
 
 // GetChannel returns the channel with the given id.
 func (d *database) GetChannel(id string) (*sidebar.Channel, error) {
-	var parent sql.NullString
-	var c sidebar.Channel
-	err := psql.Select("ch.id", "ch.display_name", "ch.details", "ch.display_image", "ch.is_sidebar", "sb.parent_id", "ch.is_direct", "ch.resolved").
+	return scanChannel(selectChannels().Where(sq.Eq{"ch.id": id}).RunWith(d).QueryRow())
+}
+
+// channelColumns are selected by queries that return full channels
+// and are read by scanChannel.
+var channelColumns = []string{
+	"ch.id", "ch.display_name", "ch.slug", "ch.details", "ch.topic", "ch.purpose", "ch.display_image", "ch.is_sidebar", "sb.parent_id",
+	"ch.is_direct", "ch.is_private", "ch.resolved", "ch.archived_at", "ch.delete_after",
+}
+
+// selectChannels starts a query for full channels.
+func selectChannels() sq.SelectBuilder {
+	return psql.Select(channelColumns...).
 		From("channels as ch").
-		JoinClause("FULL JOIN sidebars sb ON (sb.id = ch.id)").Where(sq.Eq{"ch.id": id}).RunWith(d).QueryRow().
-		Scan(&c.ID, &c.Name, &c.Details, &c.Image, &c.IsSidebar, &parent, &c.Direct, &c.Resolved)
+		JoinClause("FULL JOIN sidebars sb ON (sb.id = ch.id)")
+}
+
+// scanChannel reads a row selected with channelColumns.
+func scanChannel(row sq.RowScanner) (*sidebar.Channel, error) {
+	var c sidebar.Channel
+	var parent sql.NullString
+	var archived, deleteAfter sql.NullTime
+	err := row.Scan(&c.ID, &c.Name, &c.Slug, &c.Details, &c.Topic, &c.Purpose, &c.Image, &c.IsSidebar, &parent,
+		&c.Direct, &c.Private, &c.Resolved, &archived, &deleteAfter)
 	if err != nil {
 		return nil, err
 	}
@@ -202,49 +270,162 @@ func (d *database) GetChannel(id string) (*sidebar.Channel, error) {
 		c.Parent = parent.String
 	}
 
+	if archived.Valid {
+		c.ArchivedAt = &archived.Time
+	}
+
+	if deleteAfter.Valid {
+		c.DeleteAfter = &deleteAfter.Time
+	}
+
 	return &c, nil
 }
 
-// GetMessage returns the message with the given id.
-func (d *database) GetMessage(id string) (*sidebar.ChatMessage, error) {
+// messageColumns are selected by queries that return full messages
+// and are read by scanMessage.
+var messageColumns = []string{
+	"ms.id", "ms.event", "ms.content", "ms.html", "cm.channel_id", "COALESCE(um.user_from_id, '')", "um.user_to_id",
+	"ms.created_at", "ms.edited_at", "ms.deleted_at", "ms.reply_to",
+}
+
+// selectMessages starts a query for full messages. Extra columns are
+// selected after messageColumns.
+func selectMessages(extra ...string) sq.SelectBuilder {
+	return psql.Select(append(messageColumns, extra...)...).
+		From("messages as ms").
+		Join("channels_messages cm ON ( cm.message_id = ms.id )").
+		Join("users_messages um ON ( um.message_id = ms.id )")
+}
+
+// scanMessage reads a row selected with messageColumns followed by
+// any extra columns.
+func scanMessage(row sq.RowScanner, extra ...interface{}) (*sidebar.ChatMessage, error) {
 	var m sidebar.ChatMessage
-	err := psql.Select("ms.id", "ms.event", "ms.content", "cm.channel_id", "um.user_from_id", "um.user_to_id").From("messages as ms").
-		Join("channels_messages cm ON (cm.message_id = ms.id)").
-		Join("users_messages um ON ( um.message_id = ms.id )").
-		RunWith(d).QueryRow().
-		Scan(&m.ID, &m.Event, &m.Content, &m.Channel, &m.FromUser, &m.ToUser)
-	if err != nil {
+	var edited, deleted sql.NullTime
+	var replyTo sql.NullString
+	dest := append([]interface{}{
+		&m.ID, &m.Event, &m.Content, &m.HTML, &m.Channel, &m.FromUser, &m.ToUser,
+		&m.CreatedAt, &edited, &deleted, &replyTo,
+	}, extra...)
+	if err := row.Scan(dest...); err != nil {
 		return nil, err
 	}
 
+	if edited.Valid {
+		m.EditedAt = &edited.Time
+	}
+
+	if deleted.Valid {
+		m.DeletedAt = &deleted.Time
+	}
+
+	if replyTo.Valid {
+		m.ReplyTo = replyTo.String
+	}
+
 	return &m, nil
 }
 
-// GetMessageInChannel returns all messages sent in the given channel.
+// GetMessage returns the message with the given id.
+func (d *database) GetMessage(id string) (*sidebar.ChatMessage, error) {
+	return scanMessage(selectMessages().Where(sq.Eq{"ms.id": id}).RunWith(d).QueryRow())
+}
+
+// GetMessageInChannel returns the messages sent in the given channel that
+// aren't replies. Messages that have replies include a summary of their
+// thread.
 func (d *database) GetMessagesInChannel(id string) ([]*sidebar.ChatMessage, error) {
 	var messages []*sidebar.ChatMessage
-	rows, err := psql.Select("id", "content", "event", "cm.channel_id", "um.user_from_id", "um.user_to_id").From("messages").
-		Join("channels_messages cm ON ( cm.message_id = id )").
-		Join("users_messages um ON ( um.message_id = id )").
-		Where(sq.Eq{"cm.channel_id": id}).
+	rows, err := selectMessages("COALESCE(th.reply_count, 0)", "th.last_reply_at",
+		"(SELECT COALESCE(rum.user_from_id, '') FROM messages rms JOIN users_messages rum ON ( rum.message_id = rms.id ) "+
+			"WHERE rms.reply_to = ms.id AND rms.deleted_at IS NULL ORDER BY rms.created_at DESC LIMIT 1)").
+		LeftJoin("(SELECT reply_to, COUNT(*) AS reply_count, MAX(created_at) AS last_reply_at FROM messages " +
+			"WHERE reply_to IS NOT NULL AND deleted_at IS NULL GROUP BY reply_to) th ON ( th.reply_to = ms.id )").
+		Where(sq.Eq{"cm.channel_id": id, "ms.reply_to": nil}).
+		OrderBy("ms.created_at").
 		RunWith(d).Query()
 	if err != nil {
 		return nil, errors.New("Unable to find any messages")
 	}
+	defer rows.Close()
 
 	for rows.Next() {
-		var m sidebar.ChatMessage
-		err := rows.Scan(&m.ID, &m.Content, &m.Event, &m.Channel, &m.FromUser, &m.ToUser)
+		var count int
+		var lastAt sql.NullTime
+		var lastFrom sql.NullString
+		m, err := scanMessage(rows, &count, &lastAt, &lastFrom)
 		if err != nil {
 			return nil, errors.New("Error scanning for message")
 		}
 
-		messages = append(messages, &m)
+		if count > 0 {
+			m.Thread = &sidebar.Thread{
+				ReplyCount:    count,
+				LastReplyAt:   lastAt.Time,
+				LastReplyFrom: lastFrom.String,
+			}
+		}
+
+		messages = append(messages, m)
 	}
 
 	return messages, nil
 }
 
+// GetThread returns the root message followed by all of its replies,
+// oldest first.
+func (d *database) GetThread(id string) ([]*sidebar.ChatMessage, error) {
+	var messages []*sidebar.ChatMessage
+	rows, err := selectMessages().
+		Where(sq.Or{sq.Eq{"ms.id": id}, sq.Eq{"ms.reply_to": id}}).
+		OrderBy("ms.reply_to IS NOT NULL", "ms.created_at").
+		RunWith(d).Query()
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to find thread")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error scanning for message")
+		}
+
+		messages = append(messages, m)
+	}
+
+	if len(messages) == 0 {
+		return nil, errors.Errorf("Message with id: %v doesn't exist", id)
+	}
+
+	return messages, nil
+}
+
+// GetMessageRevisions returns the previous versions of the message,
+// oldest first.
+func (d *database) GetMessageRevisions(id string) ([]*sidebar.MessageRevision, error) {
+	rows, err := psql.Select("message_id", "content", "created_at").From("message_revisions").
+		Where(sq.Eq{"message_id": id}).
+		OrderBy("created_at").
+		RunWith(d).Query()
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to find any revisions")
+	}
+	defer rows.Close()
+
+	var revisions []*sidebar.MessageRevision
+	for rows.Next() {
+		var r sidebar.MessageRevision
+		if err := rows.Scan(&r.MessageID, &r.Content, &r.CreatedAt); err != nil {
+			return nil, errors.Wrap(err, "Error scanning revisions")
+		}
+
+		revisions = append(revisions, &r)
+	}
+
+	return revisions, nil
+}
+
 // GetMessagesFromUser returns all messages sent by the given user.
 func (d *database) GetMessagesFromUser(id string) ([]*sidebar.ChatMessage, error) {
 	var messages []*sidebar.ChatMessage
@@ -293,23 +474,24 @@ func (d *database) GetMessagesToUser(id string) ([]*sidebar.ChatMessage, error)
 	return messages, nil
 }
 
-// GetUsers returns all users saved in the database.
-func (d *database) GetUsers() ([]*sidebar.User, error) {
+// GetUsers returns all users in the workspace using their profiles in it.
+func (d *database) GetUsers(wid string) ([]*sidebar.User, error) {
 	var users []*sidebar.User
-	rows, err := psql.Select("id", "email", "display_name", "password", "profile_image").From("users").
-		RunWith(d).Query()
+	rows, err := psql.Select(userColumns...).
+		From("users u").Join("workspaces_users wu ON ( wu.user_id = u.id )").
+		Where(sq.Eq{"wu.workspace_id": wid}).RunWith(d).Query()
 	if err != nil {
 		return nil, errors.New("Unable to find any users")
 	}
+	defer rows.Close()
 
 	for rows.Next() {
-		var u sidebar.User
-		err := rows.Scan(&u.ID, &u.Email, &u.DisplayName, &u.Password, &u.ProfileImg)
+		u, err := scanUser(rows)
 		if err != nil {
 			return nil, errors.New("Error scanning users")
 		}
 
-		users = append(users, &u)
+		users = append(users, u)
 	}
 
 	return users, nil
@@ -317,28 +499,19 @@ func (d *database) GetUsers() ([]*sidebar.User, error) {
 
 // GetChannels returns all channels saved in the database.
 func (d *database) GetChannels() ([]*sidebar.Channel, error) {
-	var parent sql.NullString
 	var channels []*sidebar.Channel
-	rows, err := psql.Select("ch.id", "ch.display_name", "ch.details", "ch.display_image", "ch.is_sidebar", "sb.parent_id", "ch.is_direct", "ch.resolved").
-		From("channels as ch").
-		JoinClause("FULL JOIN sidebars sb ON (sb.id = ch.id)").
-		RunWith(d).Query()
+	rows, err := selectChannels().RunWith(d).Query()
 	if err != nil {
 		return nil, errors.Errorf("Unable to find any channels %v", err)
 	}
 
 	for rows.Next() {
-		var c sidebar.Channel
-		err := rows.Scan(&c.ID, &c.Name, &c.Details, &c.Image, &c.IsSidebar, &parent, &c.Direct, &c.Resolved)
+		c, err := scanChannel(rows)
 		if err != nil {
 			return nil, errors.Errorf("Error scanning channels %v", err)
 		}
 
-		if parent.Valid {
-			c.Parent = parent.String
-		}
-
-		channels = append(channels, &c)
+		channels = append(channels, c)
 	}
 
 	return channels, nil
@@ -347,7 +520,7 @@ func (d *database) GetChannels() ([]*sidebar.Channel, error) {
 // GetMessages returns all messages saved in the database.
 func (d *database) GetMessages() ([]*sidebar.ChatMessage, error) {
 	var messages []*sidebar.ChatMessage
-	rows, err := psql.Select("ms.id", "ms.event", "ms.content", "um.user_to_id", "um.user_from_id", "cm.channel_id").
+	rows, err := psql.Select("ms.id", "ms.event", "ms.content", "um.user_to_id", "COALESCE(um.user_from_id, '')", "cm.channel_id").
 		From("messages as ms").
 		Join("users_messages um ON (um.message_id = id)").
 		Join("channels_messages cm ON (cm.message_id = id)").
@@ -0,0 +1,86 @@
+package store
+
+import (
+	sq "github.com/Masterminds/squirrel"
+	"github.com/pkg/errors"
+	"github.com/tmitchel/sidebar"
+)
+
+// ReadMarkers provides methods for tracking how far each user has
+// read in their channels.
+type ReadMarkers interface {
+	UpdateReadMarker(*sidebar.ReadMarker) (*sidebar.ReadMarker, error)
+	GetUnreadCounts(string) (map[string]int, map[string]int, error)
+}
+
+// UpdateReadMarker moves the user's read marker in the channel forward
+// and marks mentions up to the marker as read. Markers never move back,
+// so the marker that is stored after the update is returned.
+func (d *database) UpdateReadMarker(m *sidebar.ReadMarker) (*sidebar.ReadMarker, error) {
+	_, err := psql.Insert("read_markers").
+		Columns("user_id", "channel_id", "message_id", "read_at").
+		Values(m.UserID, m.Channel, m.MessageID, m.ReadAt).
+		Suffix("ON CONFLICT (user_id, channel_id) DO UPDATE SET message_id = EXCLUDED.message_id, read_at = EXCLUDED.read_at " +
+			"WHERE read_markers.read_at < EXCLUDED.read_at").
+		RunWith(d).Exec()
+	if err != nil {
+		return nil, err
+	}
+
+	stored := sidebar.ReadMarker{UserID: m.UserID, Channel: m.Channel}
+	err = psql.Select("message_id", "read_at").From("read_markers").
+		Where(sq.Eq{"user_id": m.UserID, "channel_id": m.Channel}).
+		RunWith(d).QueryRow().Scan(&stored.MessageID, &stored.ReadAt)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = psql.Update("mentions").
+		Set("read", true).
+		Where(sq.Eq{"user_id": m.UserID, "read": false}).
+		Where("message_id IN (SELECT message_id FROM channels_messages WHERE channel_id = ? AND created_at <= ?)", m.Channel, stored.ReadAt).
+		RunWith(d).Exec()
+	if err != nil {
+		return nil, err
+	}
+
+	return &stored, nil
+}
+
+// GetUnreadCounts returns the number of unread messages and unread
+// mentions in each of the user's channels, keyed by channel id. The
+// user's own messages and deleted messages aren't counted.
+func (d *database) GetUnreadCounts(uid string) (map[string]int, map[string]int, error) {
+	rows, err := psql.Select("uc.channel_id").
+		Column("(SELECT COUNT(*) FROM channels_messages cm " +
+			"JOIN messages ms ON ( ms.id = cm.message_id ) " +
+			"JOIN users_messages um ON ( um.message_id = cm.message_id ) " +
+			"WHERE cm.channel_id = uc.channel_id AND cm.created_at > COALESCE(rm.read_at, '-infinity') " +
+			"AND ms.deleted_at IS NULL AND um.user_from_id IS DISTINCT FROM uc.user_id)").
+		Column("(SELECT COUNT(*) FROM mentions mn " +
+			"JOIN channels_messages cm ON ( cm.message_id = mn.message_id ) " +
+			"WHERE cm.channel_id = uc.channel_id AND mn.user_id = uc.user_id AND NOT mn.read)").
+		From("users_channels uc").
+		LeftJoin("read_markers rm ON ( rm.user_id = uc.user_id AND rm.channel_id = uc.channel_id )").
+		Where(sq.Eq{"uc.user_id": uid}).
+		RunWith(d).Query()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Unable to count unread messages")
+	}
+	defer rows.Close()
+
+	unread := make(map[string]int)
+	mentions := make(map[string]int)
+	for rows.Next() {
+		var cid string
+		var messages, mentioned int
+		if err := rows.Scan(&cid, &messages, &mentioned); err != nil {
+			return nil, nil, errors.Wrap(err, "Error scanning unread counts")
+		}
+
+		unread[cid] = messages
+		mentions[cid] = mentioned
+	}
+
+	return unread, mentions, nil
+}
@@ -0,0 +1,72 @@
+package store
+
+import (
+	sq "github.com/Masterminds/squirrel"
+	"github.com/pkg/errors"
+	"github.com/tmitchel/sidebar"
+)
+
+// Mentioner provides methods for storing and reading the users
+// mentioned in messages.
+type Mentioner interface {
+	CreateMentions([]*sidebar.Mention) error
+	GetUnreadMentions(string, string) ([]*sidebar.Mention, error)
+	MarkMentionsRead(string, string) error
+}
+
+// CreateMentions stores the mentions, ignoring users that were already
+// mentioned in the same message.
+func (d *database) CreateMentions(mentions []*sidebar.Mention) error {
+	if len(mentions) == 0 {
+		return nil
+	}
+
+	query := psql.Insert("mentions").Columns("message_id", "user_id", "kind")
+	for _, m := range mentions {
+		query = query.Values(m.MessageID, m.UserID, m.Kind)
+	}
+
+	_, err := query.Suffix("ON CONFLICT DO NOTHING").RunWith(d).Exec()
+	return err
+}
+
+// GetUnreadMentions returns the user's unread mentions in every channel
+// of the workspace, newest first.
+func (d *database) GetUnreadMentions(uid, wid string) ([]*sidebar.Mention, error) {
+	rows, err := selectMessages("mn.user_id", "mn.kind").
+		Join("mentions mn ON ( mn.message_id = ms.id )").
+		Join("workspaces_channels wc ON ( wc.channel_id = cm.channel_id )").
+		Where(sq.Eq{"mn.user_id": uid, "mn.read": false, "wc.workspace_id": wid, "ms.deleted_at": nil}).
+		OrderBy("ms.created_at DESC").
+		RunWith(d).Query()
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to find mentions")
+	}
+	defer rows.Close()
+
+	var mentions []*sidebar.Mention
+	for rows.Next() {
+		var mention sidebar.Mention
+		m, err := scanMessage(rows, &mention.UserID, &mention.Kind)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error scanning mentions")
+		}
+
+		mention.MessageID = m.ID
+		mention.Message = m
+		mentions = append(mentions, &mention)
+	}
+
+	return mentions, nil
+}
+
+// MarkMentionsRead marks all of the user's mentions in the channel
+// as read.
+func (d *database) MarkMentionsRead(uid, cid string) error {
+	_, err := psql.Update("mentions").
+		Set("read", true).
+		Where(sq.Eq{"user_id": uid, "read": false}).
+		Where("message_id IN (SELECT message_id FROM channels_messages WHERE channel_id = ?)", cid).
+		RunWith(d).Exec()
+	return err
+}
@@ -0,0 +1,157 @@
+package store
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/pkg/errors"
+	"github.com/tmitchel/sidebar"
+)
+
+// Notifications provides methods for storing the alerts each user wants.
+type Notifications interface {
+	GetNotificationPrefs([]string, string) (map[string]*sidebar.NotificationPrefs, error)
+	UpdateNotificationPrefs(*sidebar.NotificationPrefs) error
+}
+
+// GetNotificationPrefs returns the notification preferences for each of
+// the users in the workspace, keyed by user id. Users that haven't set
+// any preferences get an empty set.
+func (d *database) GetNotificationPrefs(uids []string, wid string) (map[string]*sidebar.NotificationPrefs, error) {
+	prefs := make(map[string]*sidebar.NotificationPrefs, len(uids))
+	for _, uid := range uids {
+		prefs[uid] = &sidebar.NotificationPrefs{
+			UserID:      uid,
+			WorkspaceID: wid,
+			Channels:    make(map[string]string),
+		}
+	}
+
+	if len(uids) == 0 {
+		return prefs, nil
+	}
+
+	rows, err := psql.Select("user_id", "level").From("notification_defaults").
+		Where(sq.Eq{"user_id": uids, "workspace_id": wid}).
+		RunWith(d).Query()
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to find notification defaults")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var uid, level string
+		if err := rows.Scan(&uid, &level); err != nil {
+			return nil, errors.Wrap(err, "Error scanning notification defaults")
+		}
+		prefs[uid].Default = level
+	}
+
+	rows, err = psql.Select("cn.user_id", "cn.channel_id", "cn.level").From("channel_notifications cn").
+		Join("workspaces_channels wc ON ( wc.channel_id = cn.channel_id )").
+		Where(sq.Eq{"cn.user_id": uids, "wc.workspace_id": wid}).
+		RunWith(d).Query()
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to find channel notifications")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var uid, cid, level string
+		if err := rows.Scan(&uid, &cid, &level); err != nil {
+			return nil, errors.Wrap(err, "Error scanning channel notifications")
+		}
+		prefs[uid].Channels[cid] = level
+	}
+
+	rows, err = psql.Select("user_id", "start_time", "end_time", "timezone", "days").From("do_not_disturb").
+		Where(sq.Eq{"user_id": uids}).
+		RunWith(d).Query()
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to find do not disturb schedules")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var uid, days string
+		var dnd sidebar.DoNotDisturb
+		if err := rows.Scan(&uid, &dnd.Start, &dnd.End, &dnd.Timezone, &days); err != nil {
+			return nil, errors.Wrap(err, "Error scanning do not disturb schedules")
+		}
+
+		for _, day := range strings.Split(days, ",") {
+			if n, err := strconv.Atoi(day); err == nil {
+				dnd.Days = append(dnd.Days, time.Weekday(n))
+			}
+		}
+		prefs[uid].DoNotDisturb = &dnd
+	}
+
+	return prefs, nil
+}
+
+// UpdateNotificationPrefs replaces the user's default level and channel
+// levels for the workspace along with their do not disturb schedule.
+func (d *database) UpdateNotificationPrefs(p *sidebar.NotificationPrefs) error {
+	tx, err := d.Begin()
+	if err != nil {
+		return errors.Wrap(err, "Error starting transaction")
+	}
+	defer tx.Rollback()
+
+	if p.Default == "" {
+		_, err = psql.Delete("notification_defaults").
+			Where(sq.Eq{"user_id": p.UserID, "workspace_id": p.WorkspaceID}).
+			RunWith(tx).Exec()
+	} else {
+		_, err = psql.Insert("notification_defaults").
+			Columns("user_id", "workspace_id", "level").Values(p.UserID, p.WorkspaceID, p.Default).
+			Suffix("ON CONFLICT (user_id, workspace_id) DO UPDATE SET level = EXCLUDED.level").
+			RunWith(tx).Exec()
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = psql.Delete("channel_notifications").
+		Where(sq.Eq{"user_id": p.UserID}).
+		Where("channel_id IN (SELECT channel_id FROM workspaces_channels WHERE workspace_id = ?)", p.WorkspaceID).
+		RunWith(tx).Exec()
+	if err != nil {
+		return err
+	}
+
+	if len(p.Channels) > 0 {
+		query := psql.Insert("channel_notifications").Columns("user_id", "channel_id", "level")
+		for cid, level := range p.Channels {
+			query = query.Values(p.UserID, cid, level)
+		}
+
+		if _, err := query.RunWith(tx).Exec(); err != nil {
+			return err
+		}
+	}
+
+	if p.DoNotDisturb == nil {
+		_, err = psql.Delete("do_not_disturb").Where(sq.Eq{"user_id": p.UserID}).RunWith(tx).Exec()
+	} else {
+		days := make([]string, len(p.DoNotDisturb.Days))
+		for i, day := range p.DoNotDisturb.Days {
+			days[i] = strconv.Itoa(int(day))
+		}
+
+		_, err = psql.Insert("do_not_disturb").
+			Columns("user_id", "start_time", "end_time", "timezone", "days").
+			Values(p.UserID, p.DoNotDisturb.Start, p.DoNotDisturb.End, p.DoNotDisturb.Timezone, strings.Join(days, ",")).
+			Suffix("ON CONFLICT (user_id) DO UPDATE SET start_time = EXCLUDED.start_time, end_time = EXCLUDED.end_time, " +
+				"timezone = EXCLUDED.timezone, days = EXCLUDED.days").
+			RunWith(tx).Exec()
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
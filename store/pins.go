@@ -0,0 +1,198 @@
+package store
+
+import (
+	"database/sql"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/pkg/errors"
+	"github.com/tmitchel/sidebar"
+)
+
+// Pinner provides methods for storing the pinned messages and bookmarks
+// in a channel. New pins and bookmarks go at the end of the list.
+type Pinner interface {
+	CreatePin(*sidebar.Pin) error
+	GetPin(string) (*sidebar.Pin, error)
+	DeletePin(string) error
+	GetPins(string) ([]*sidebar.Pin, error)
+	ReorderPins(string, []string) error
+
+	CreateBookmark(*sidebar.Bookmark) error
+	GetBookmark(string) (*sidebar.Bookmark, error)
+	DeleteBookmark(string) error
+	GetBookmarks(string) ([]*sidebar.Bookmark, error)
+	ReorderBookmarks(string, []string) error
+}
+
+// nextPosition is the position after the last one in the channel.
+func nextPosition(table, cid string) sq.Sqlizer {
+	return sq.Expr("(SELECT COALESCE(MAX(position), -1) + 1 FROM "+table+" WHERE channel_id = ?)", cid)
+}
+
+// CreatePin pins the message unless it's already pinned.
+func (d *database) CreatePin(p *sidebar.Pin) error {
+	res, err := psql.Insert("pins").
+		Columns("message_id", "channel_id", "pinned_by", "position", "created_at").
+		Values(p.MessageID, p.Channel, p.PinnedBy, nextPosition("pins", p.Channel), p.CreatedAt).
+		Suffix("ON CONFLICT (message_id) DO NOTHING").
+		RunWith(d).Exec()
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return errors.Errorf("Message %v is already pinned", p.MessageID)
+	}
+
+	return nil
+}
+
+// GetPin returns the pin for the message.
+func (d *database) GetPin(mid string) (*sidebar.Pin, error) {
+	var p sidebar.Pin
+	err := psql.Select("message_id", "channel_id", "COALESCE(pinned_by, '')", "position", "created_at").
+		From("pins").Where(sq.Eq{"message_id": mid}).
+		RunWith(d).QueryRow().
+		Scan(&p.MessageID, &p.Channel, &p.PinnedBy, &p.Position, &p.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// DeletePin unpins the message.
+func (d *database) DeletePin(mid string) error {
+	_, err := psql.Delete("pins").Where(sq.Eq{"message_id": mid}).RunWith(d).Exec()
+	return err
+}
+
+// GetPins returns the channel's pins in order along with the messages.
+func (d *database) GetPins(cid string) ([]*sidebar.Pin, error) {
+	rows, err := selectMessages("COALESCE(p.pinned_by, '')", "p.position", "p.created_at").
+		Join("pins p ON ( p.message_id = ms.id )").
+		Where(sq.Eq{"p.channel_id": cid}).
+		OrderBy("p.position").
+		RunWith(d).Query()
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to find pins")
+	}
+	defer rows.Close()
+
+	pins := []*sidebar.Pin{}
+	for rows.Next() {
+		var p sidebar.Pin
+		m, err := scanMessage(rows, &p.PinnedBy, &p.Position, &p.CreatedAt)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error scanning pins")
+		}
+
+		p.Channel = cid
+		p.MessageID = m.ID
+		p.Message = m
+		pins = append(pins, &p)
+	}
+
+	return pins, nil
+}
+
+// ReorderPins puts the channel's pins in the order of the message ids.
+func (d *database) ReorderPins(cid string, mids []string) error {
+	return d.reorder("pins", "message_id", cid, mids)
+}
+
+// CreateBookmark adds the bookmark to the end of the channel's list.
+func (d *database) CreateBookmark(b *sidebar.Bookmark) error {
+	var url, mid interface{}
+	if b.URL != "" {
+		url = b.URL
+	}
+	if b.MessageID != "" {
+		mid = b.MessageID
+	}
+
+	_, err := psql.Insert("bookmarks").
+		Columns("id", "channel_id", "title", "url", "message_id", "created_by", "position", "created_at").
+		Values(b.ID, b.Channel, b.Title, url, mid, b.CreatedBy, nextPosition("bookmarks", b.Channel), b.CreatedAt).
+		RunWith(d).Exec()
+	return err
+}
+
+var bookmarkColumns = []string{
+	"id", "channel_id", "title", "url", "message_id", "COALESCE(created_by, '')", "position", "created_at",
+}
+
+func scanBookmark(row sq.RowScanner) (*sidebar.Bookmark, error) {
+	var b sidebar.Bookmark
+	var url, mid sql.NullString
+	err := row.Scan(&b.ID, &b.Channel, &b.Title, &url, &mid, &b.CreatedBy, &b.Position, &b.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	b.URL = url.String
+	b.MessageID = mid.String
+	return &b, nil
+}
+
+// GetBookmark returns the bookmark with the given id.
+func (d *database) GetBookmark(id string) (*sidebar.Bookmark, error) {
+	return scanBookmark(psql.Select(bookmarkColumns...).From("bookmarks").
+		Where(sq.Eq{"id": id}).RunWith(d).QueryRow())
+}
+
+// DeleteBookmark removes the bookmark.
+func (d *database) DeleteBookmark(id string) error {
+	_, err := psql.Delete("bookmarks").Where(sq.Eq{"id": id}).RunWith(d).Exec()
+	return err
+}
+
+// GetBookmarks returns the channel's bookmarks in order.
+func (d *database) GetBookmarks(cid string) ([]*sidebar.Bookmark, error) {
+	rows, err := psql.Select(bookmarkColumns...).From("bookmarks").
+		Where(sq.Eq{"channel_id": cid}).
+		OrderBy("position").
+		RunWith(d).Query()
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to find bookmarks")
+	}
+	defer rows.Close()
+
+	bookmarks := []*sidebar.Bookmark{}
+	for rows.Next() {
+		b, err := scanBookmark(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error scanning bookmarks")
+		}
+		bookmarks = append(bookmarks, b)
+	}
+
+	return bookmarks, nil
+}
+
+// ReorderBookmarks puts the channel's bookmarks in the order of the ids.
+func (d *database) ReorderBookmarks(cid string, ids []string) error {
+	return d.reorder("bookmarks", "id", cid, ids)
+}
+
+// reorder sets the position of each row in the channel to its index in
+// ids. Every row in the channel should be included.
+func (d *database) reorder(table, column, cid string, ids []string) error {
+	tx, err := d.Begin()
+	if err != nil {
+		return errors.Wrap(err, "Error starting transaction")
+	}
+	defer tx.Rollback()
+
+	for i, id := range ids {
+		_, err := psql.Update(table).
+			Set("position", i).
+			Where(sq.Eq{"channel_id": cid, column: id}).
+			RunWith(tx).Exec()
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,168 @@
+package store
+
+import (
+	sq "github.com/Masterminds/squirrel"
+	"github.com/pkg/errors"
+	"github.com/tmitchel/sidebar"
+)
+
+// Previews provides methods for caching link previews, linking them to
+// messages, and storing which domains a workspace wants previews for.
+type Previews interface {
+	GetLinkPreview(string) (*sidebar.LinkPreview, error)
+	SaveLinkPreview(*sidebar.LinkPreview) error
+	SetMessagePreviews(string, []string) error
+	GetPreviewsForMessages([]string) (map[string][]*sidebar.LinkPreview, error)
+	GetUnfurlSettings(string) (*sidebar.UnfurlSettings, error)
+	UpdateUnfurlSettings(*sidebar.UnfurlSettings) error
+}
+
+// GetLinkPreview returns the cached preview for the URL.
+func (d *database) GetLinkPreview(url string) (*sidebar.LinkPreview, error) {
+	var p sidebar.LinkPreview
+	err := psql.Select("url", "title", "description", "image", "site_name", "fetched_at").
+		From("link_previews").Where(sq.Eq{"url": url}).
+		RunWith(d).QueryRow().
+		Scan(&p.URL, &p.Title, &p.Description, &p.Image, &p.SiteName, &p.FetchedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// SaveLinkPreview adds the preview to the cache, replacing any older
+// preview of the same URL.
+func (d *database) SaveLinkPreview(p *sidebar.LinkPreview) error {
+	_, err := psql.Insert("link_previews").
+		Columns("url", "title", "description", "image", "site_name", "fetched_at").
+		Values(p.URL, p.Title, p.Description, p.Image, p.SiteName, p.FetchedAt).
+		Suffix(`ON CONFLICT (url) DO UPDATE SET title = EXCLUDED.title, description = EXCLUDED.description,
+			image = EXCLUDED.image, site_name = EXCLUDED.site_name, fetched_at = EXCLUDED.fetched_at`).
+		RunWith(d).Exec()
+	return err
+}
+
+// SetMessagePreviews replaces the previews shown with the message. The
+// URLs must already be cached.
+func (d *database) SetMessagePreviews(mid string, urls []string) error {
+	tx, err := d.Begin()
+	if err != nil {
+		return errors.Wrap(err, "Error starting transaction")
+	}
+	defer tx.Rollback()
+
+	_, err = psql.Delete("message_previews").Where(sq.Eq{"message_id": mid}).RunWith(tx).Exec()
+	if err != nil {
+		return err
+	}
+
+	if len(urls) > 0 {
+		query := psql.Insert("message_previews").Columns("message_id", "url", "position")
+		for i, url := range urls {
+			query = query.Values(mid, url, i)
+		}
+
+		if _, err := query.RunWith(tx).Exec(); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetPreviewsForMessages returns the previews for each of the messages,
+// in the order the links appear, keyed by message id.
+func (d *database) GetPreviewsForMessages(mids []string) (map[string][]*sidebar.LinkPreview, error) {
+	previews := make(map[string][]*sidebar.LinkPreview)
+	if len(mids) == 0 {
+		return previews, nil
+	}
+
+	rows, err := psql.Select("mp.message_id", "lp.url", "lp.title", "lp.description", "lp.image", "lp.site_name", "lp.fetched_at").
+		From("message_previews mp").
+		Join("link_previews lp ON ( lp.url = mp.url )").
+		Where(sq.Eq{"mp.message_id": mids}).
+		OrderBy("mp.position").
+		RunWith(d).Query()
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to find link previews")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var mid string
+		var p sidebar.LinkPreview
+		if err := rows.Scan(&mid, &p.URL, &p.Title, &p.Description, &p.Image, &p.SiteName, &p.FetchedAt); err != nil {
+			return nil, errors.Wrap(err, "Error scanning link previews")
+		}
+
+		previews[mid] = append(previews[mid], &p)
+	}
+
+	return previews, nil
+}
+
+// GetUnfurlSettings returns the domains the workspace does and doesn't
+// want previews for.
+func (d *database) GetUnfurlSettings(wid string) (*sidebar.UnfurlSettings, error) {
+	rows, err := psql.Select("domain", "allow").From("unfurl_domains").
+		Where(sq.Eq{"workspace_id": wid}).
+		OrderBy("domain").
+		RunWith(d).Query()
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to find unfurl settings")
+	}
+	defer rows.Close()
+
+	s := sidebar.UnfurlSettings{
+		WorkspaceID: wid,
+		Allow:       []string{},
+		Deny:        []string{},
+	}
+	for rows.Next() {
+		var domain string
+		var allow bool
+		if err := rows.Scan(&domain, &allow); err != nil {
+			return nil, errors.Wrap(err, "Error scanning unfurl settings")
+		}
+
+		if allow {
+			s.Allow = append(s.Allow, domain)
+		} else {
+			s.Deny = append(s.Deny, domain)
+		}
+	}
+
+	return &s, nil
+}
+
+// UpdateUnfurlSettings replaces the workspace's domain lists.
+func (d *database) UpdateUnfurlSettings(s *sidebar.UnfurlSettings) error {
+	tx, err := d.Begin()
+	if err != nil {
+		return errors.Wrap(err, "Error starting transaction")
+	}
+	defer tx.Rollback()
+
+	_, err = psql.Delete("unfurl_domains").Where(sq.Eq{"workspace_id": s.WorkspaceID}).RunWith(tx).Exec()
+	if err != nil {
+		return err
+	}
+
+	if len(s.Allow)+len(s.Deny) > 0 {
+		query := psql.Insert("unfurl_domains").Columns("workspace_id", "domain", "allow")
+		for _, domain := range s.Allow {
+			query = query.Values(s.WorkspaceID, domain, true)
+		}
+		for _, domain := range s.Deny {
+			query = query.Values(s.WorkspaceID, domain, false)
+		}
+
+		if _, err := query.RunWith(tx).Exec(); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,214 @@
+package store
+
+import (
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/pkg/errors"
+	"github.com/tmitchel/sidebar"
+)
+
+// Profiles provides methods for users' profiles in a workspace and the
+// custom profile fields the workspace defines.
+type Profiles interface {
+	UpdateProfile(*sidebar.User, string, map[string]string) error
+	ClearExpiredStatuses(time.Time) ([]*sidebar.StatusUpdate, error)
+
+	GetProfileFields(string) ([]*sidebar.ProfileField, error)
+	GetProfileField(string) (*sidebar.ProfileField, error)
+	CreateProfileField(*sidebar.ProfileField) error
+	DeleteProfileField(string) error
+
+	GetProfileValues(string) (map[string]map[string]string, error)
+	GetUserProfileValues(string, string) (map[string]string, error)
+}
+
+// UpdateProfile saves the user's email and image, their display name,
+// title, pronouns, time zone, and status in the workspace, and the given
+// custom field values in one transaction. Empty values remove the field.
+func (d *database) UpdateProfile(u *sidebar.User, wid string, fields map[string]string) error {
+	tx, err := d.Begin()
+	if err != nil {
+		return errors.Wrap(err, "Error starting transaction")
+	}
+	defer tx.Rollback()
+
+	_, err = psql.Update("users").
+		Set("email", u.Email).
+		Set("profile_image", u.ProfileImg).
+		Where(sq.Eq{"id": u.ID}).
+		RunWith(tx).Exec()
+	if err != nil {
+		return conflict(err, "Email "+u.Email)
+	}
+
+	status := u.Status
+	if status == nil {
+		status = &sidebar.Status{}
+	}
+
+	_, err = psql.Update("workspaces_users").
+		Set("display_name", u.DisplayName).
+		Set("title", u.Title).
+		Set("pronouns", u.Pronouns).
+		Set("time_zone", u.TimeZone).
+		Set("status_emoji", status.Emoji).
+		Set("status_text", status.Text).
+		Set("status_expires_at", status.ExpiresAt).
+		Where(sq.Eq{"user_id": u.ID, "workspace_id": wid}).
+		RunWith(tx).Exec()
+	if err != nil {
+		return conflict(err, "Display name "+u.DisplayName)
+	}
+
+	for fid, value := range fields {
+		if value == "" {
+			_, err = psql.Delete("profile_values").
+				Where(sq.Eq{"field_id": fid, "user_id": u.ID}).
+				RunWith(tx).Exec()
+		} else {
+			_, err = psql.Insert("profile_values").
+				Columns("field_id", "user_id", "value").Values(fid, u.ID, value).
+				Suffix("ON CONFLICT (field_id, user_id) DO UPDATE SET value = EXCLUDED.value").
+				RunWith(tx).Exec()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ClearExpiredStatuses clears every status that expired by now and returns
+// the users whose status was cleared.
+func (d *database) ClearExpiredStatuses(now time.Time) ([]*sidebar.StatusUpdate, error) {
+	rows, err := psql.Update("workspaces_users").
+		Set("status_emoji", "").
+		Set("status_text", "").
+		Set("status_expires_at", nil).
+		Where(sq.LtOrEq{"status_expires_at": now}).
+		Suffix("RETURNING user_id, workspace_id").
+		RunWith(d).Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cleared []*sidebar.StatusUpdate
+	for rows.Next() {
+		var s sidebar.StatusUpdate
+		if err := rows.Scan(&s.UserID, &s.WorkspaceID); err != nil {
+			return nil, err
+		}
+		cleared = append(cleared, &s)
+	}
+
+	return cleared, rows.Err()
+}
+
+// GetProfileFields returns the workspace's custom profile fields, oldest
+// first.
+func (d *database) GetProfileFields(wid string) ([]*sidebar.ProfileField, error) {
+	rows, err := psql.Select("id", "workspace_id", "name", "created_at").From("profile_fields").
+		Where(sq.Eq{"workspace_id": wid}).
+		OrderBy("created_at", "id").
+		RunWith(d).Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var fields []*sidebar.ProfileField
+	for rows.Next() {
+		var f sidebar.ProfileField
+		if err := rows.Scan(&f.ID, &f.WorkspaceID, &f.Name, &f.CreatedAt); err != nil {
+			return nil, err
+		}
+		fields = append(fields, &f)
+	}
+
+	return fields, rows.Err()
+}
+
+// GetProfileField returns the custom profile field with the given id.
+func (d *database) GetProfileField(id string) (*sidebar.ProfileField, error) {
+	var f sidebar.ProfileField
+	err := psql.Select("id", "workspace_id", "name", "created_at").From("profile_fields").
+		Where(sq.Eq{"id": id}).RunWith(d).QueryRow().
+		Scan(&f.ID, &f.WorkspaceID, &f.Name, &f.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &f, nil
+}
+
+// CreateProfileField adds a custom profile field to the workspace. Field
+// names have to be unique in the workspace.
+func (d *database) CreateProfileField(f *sidebar.ProfileField) error {
+	_, err := psql.Insert("profile_fields").
+		Columns("id", "workspace_id", "name", "created_at").
+		Values(f.ID, f.WorkspaceID, f.Name, f.CreatedAt).
+		RunWith(d).Exec()
+	return conflict(err, "Profile field "+f.Name)
+}
+
+// DeleteProfileField removes the custom profile field and every user's
+// value for it.
+func (d *database) DeleteProfileField(id string) error {
+	_, err := psql.Delete("profile_fields").Where(sq.Eq{"id": id}).RunWith(d).Exec()
+	return err
+}
+
+// GetProfileValues returns the custom profile field values of the users in
+// the workspace, by user id and then field id.
+func (d *database) GetProfileValues(wid string) (map[string]map[string]string, error) {
+	rows, err := psql.Select("pv.user_id", "pv.field_id", "pv.value").From("profile_values pv").
+		Join("profile_fields pf ON ( pf.id = pv.field_id )").
+		Where(sq.Eq{"pf.workspace_id": wid}).
+		RunWith(d).Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := make(map[string]map[string]string)
+	for rows.Next() {
+		var uid, fid, value string
+		if err := rows.Scan(&uid, &fid, &value); err != nil {
+			return nil, err
+		}
+
+		if values[uid] == nil {
+			values[uid] = make(map[string]string)
+		}
+		values[uid][fid] = value
+	}
+
+	return values, rows.Err()
+}
+
+// GetUserProfileValues returns one user's custom profile field values in
+// the workspace, by field id.
+func (d *database) GetUserProfileValues(uid, wid string) (map[string]string, error) {
+	rows, err := psql.Select("pv.field_id", "pv.value").From("profile_values pv").
+		Join("profile_fields pf ON ( pf.id = pv.field_id )").
+		Where(sq.Eq{"pv.user_id": uid, "pf.workspace_id": wid}).
+		RunWith(d).Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := make(map[string]string)
+	for rows.Next() {
+		var fid, value string
+		if err := rows.Scan(&fid, &value); err != nil {
+			return nil, err
+		}
+		values[fid] = value
+	}
+
+	return values, rows.Err()
+}
@@ -0,0 +1,63 @@
+package store
+
+import (
+	sq "github.com/Masterminds/squirrel"
+	"github.com/pkg/errors"
+	"github.com/tmitchel/sidebar"
+)
+
+// Reacter provides methods for storing reactions to messages.
+type Reacter interface {
+	AddReaction(*sidebar.Reaction) error
+	RemoveReaction(*sidebar.Reaction) error
+	GetReactionsInChannel(string, string) (map[string][]*sidebar.ReactionCount, error)
+}
+
+// AddReaction adds the reaction if the user hasn't already reacted to
+// the message with the same emoji.
+func (d *database) AddReaction(r *sidebar.Reaction) error {
+	_, err := psql.Insert("reactions").
+		Columns("message_id", "user_id", "emoji").Values(r.MessageID, r.UserID, r.Emoji).
+		Suffix("ON CONFLICT DO NOTHING").
+		RunWith(d).Exec()
+	return err
+}
+
+// RemoveReaction removes the reaction if it exists.
+func (d *database) RemoveReaction(r *sidebar.Reaction) error {
+	_, err := psql.Delete("reactions").
+		Where(sq.Eq{"message_id": r.MessageID, "user_id": r.UserID, "emoji": r.Emoji}).
+		RunWith(d).Exec()
+	return err
+}
+
+// GetReactionsInChannel returns the reaction counts for every message in
+// the channel, keyed by message id. Me is set for reactions from the
+// given user. Emoji are in the order they were first used.
+func (d *database) GetReactionsInChannel(cid, uid string) (map[string][]*sidebar.ReactionCount, error) {
+	rows, err := psql.Select("r.message_id", "r.emoji", "COUNT(*)").
+		Column(sq.Expr("BOOL_OR(r.user_id = ?)", uid)).
+		From("reactions r").
+		Join("channels_messages cm ON ( cm.message_id = r.message_id )").
+		Where(sq.Eq{"cm.channel_id": cid}).
+		GroupBy("r.message_id", "r.emoji").
+		OrderBy("MIN(r.created_at)").
+		RunWith(d).Query()
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to find reactions")
+	}
+	defer rows.Close()
+
+	reactions := make(map[string][]*sidebar.ReactionCount)
+	for rows.Next() {
+		var mid string
+		var rc sidebar.ReactionCount
+		if err := rows.Scan(&mid, &rc.Emoji, &rc.Count, &rc.Me); err != nil {
+			return nil, errors.Wrap(err, "Error scanning reactions")
+		}
+
+		reactions[mid] = append(reactions[mid], &rc)
+	}
+
+	return reactions, nil
+}
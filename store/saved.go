@@ -0,0 +1,179 @@
+package store
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/pkg/errors"
+	"github.com/tmitchel/sidebar"
+)
+
+// most reminders claimed by one call to ClaimDueReminders
+const reminderBatch = 100
+
+// Saved provides methods for storing the messages users save for
+// later and the reminders they set.
+type Saved interface {
+	SaveMessage(*sidebar.SavedItem) error
+	UnsaveMessage(string, string) error
+	GetSavedMessages(string, string) ([]*sidebar.SavedItem, error)
+
+	CreateReminder(*sidebar.Reminder) error
+	GetReminder(string) (*sidebar.Reminder, error)
+	GetReminders(string, string) ([]*sidebar.Reminder, error)
+	DeleteReminder(string) error
+	ClaimDueReminders(time.Time, time.Duration) ([]*sidebar.Reminder, error)
+	PostponeReminder(string, time.Time) error
+}
+
+// SaveMessage saves the message for the user. Saving a message twice
+// keeps the first save.
+func (d *database) SaveMessage(s *sidebar.SavedItem) error {
+	_, err := psql.Insert("saved_messages").
+		Columns("user_id", "message_id", "created_at").
+		Values(s.UserID, s.MessageID, s.CreatedAt).
+		Suffix("ON CONFLICT (user_id, message_id) DO NOTHING").
+		RunWith(d).Exec()
+	return err
+}
+
+// UnsaveMessage removes the message from the user's saved messages.
+func (d *database) UnsaveMessage(uid, mid string) error {
+	_, err := psql.Delete("saved_messages").
+		Where(sq.Eq{"user_id": uid, "message_id": mid}).
+		RunWith(d).Exec()
+	return err
+}
+
+// GetSavedMessages returns the user's saved messages in the workspace,
+// most recently saved first. Deleted messages are left out.
+func (d *database) GetSavedMessages(uid, wid string) ([]*sidebar.SavedItem, error) {
+	rows, err := selectMessages("sm.created_at").
+		Join("saved_messages sm ON ( sm.message_id = ms.id )").
+		Join("workspaces_channels wc ON ( wc.channel_id = cm.channel_id )").
+		Where(sq.Eq{"sm.user_id": uid, "wc.workspace_id": wid, "ms.deleted_at": nil}).
+		OrderBy("sm.created_at DESC").
+		RunWith(d).Query()
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to find saved messages")
+	}
+	defer rows.Close()
+
+	saved := []*sidebar.SavedItem{}
+	for rows.Next() {
+		var s sidebar.SavedItem
+		m, err := scanMessage(rows, &s.CreatedAt)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error scanning saved messages")
+		}
+
+		s.UserID = uid
+		s.MessageID = m.ID
+		s.Message = m
+		saved = append(saved, &s)
+	}
+
+	return saved, nil
+}
+
+// CreateReminder stores a new reminder.
+func (d *database) CreateReminder(r *sidebar.Reminder) error {
+	var mid interface{}
+	if r.MessageID != "" {
+		mid = r.MessageID
+	}
+
+	_, err := psql.Insert("reminders").
+		Columns("id", "user_id", "workspace_id", "message_id", "note", "remind_at", "created_at").
+		Values(r.ID, r.UserID, r.WorkspaceID, mid, r.Note, r.RemindAt, r.CreatedAt).
+		RunWith(d).Exec()
+	return err
+}
+
+var reminderColumns = []string{
+	"id", "user_id", "workspace_id", "message_id", "note", "remind_at", "created_at",
+}
+
+func scanReminder(row sq.RowScanner) (*sidebar.Reminder, error) {
+	var r sidebar.Reminder
+	var mid sql.NullString
+	err := row.Scan(&r.ID, &r.UserID, &r.WorkspaceID, &mid, &r.Note, &r.RemindAt, &r.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	r.MessageID = mid.String
+	return &r, nil
+}
+
+func scanReminders(rows *sql.Rows) ([]*sidebar.Reminder, error) {
+	defer rows.Close()
+
+	reminders := []*sidebar.Reminder{}
+	for rows.Next() {
+		r, err := scanReminder(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error scanning reminders")
+		}
+		reminders = append(reminders, r)
+	}
+
+	return reminders, nil
+}
+
+// GetReminder returns the reminder with the given id.
+func (d *database) GetReminder(id string) (*sidebar.Reminder, error) {
+	return scanReminder(psql.Select(reminderColumns...).From("reminders").
+		Where(sq.Eq{"id": id}).RunWith(d).QueryRow())
+}
+
+// GetReminders returns the user's pending reminders in the workspace,
+// soonest first.
+func (d *database) GetReminders(uid, wid string) ([]*sidebar.Reminder, error) {
+	rows, err := psql.Select(reminderColumns...).From("reminders").
+		Where(sq.Eq{"user_id": uid, "workspace_id": wid}).
+		OrderBy("remind_at").
+		RunWith(d).Query()
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to find reminders")
+	}
+
+	return scanReminders(rows)
+}
+
+// DeleteReminder removes the reminder.
+func (d *database) DeleteReminder(id string) error {
+	_, err := psql.Delete("reminders").Where(sq.Eq{"id": id}).RunWith(d).Exec()
+	return err
+}
+
+// ClaimDueReminders returns reminders that are due by the given time and
+// claims them for the length of the lease. Rows claimed by another server
+// are skipped, and a reminder that wasn't delivered before its claim ran
+// out is returned again.
+func (d *database) ClaimDueReminders(now time.Time, lease time.Duration) ([]*sidebar.Reminder, error) {
+	rows, err := psql.Update("reminders").
+		Set("claimed_until", now.Add(lease)).
+		Where("id IN (SELECT id FROM reminders WHERE remind_at <= ? AND (claimed_until IS NULL OR claimed_until < ?) "+
+			"ORDER BY remind_at LIMIT ? FOR UPDATE SKIP LOCKED)", now, now, reminderBatch).
+		Suffix("RETURNING " + strings.Join(reminderColumns, ", ")).
+		RunWith(d).Query()
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to claim reminders")
+	}
+
+	return scanReminders(rows)
+}
+
+// PostponeReminder moves a claimed reminder to a later time and releases
+// the claim on it.
+func (d *database) PostponeReminder(id string, at time.Time) error {
+	_, err := psql.Update("reminders").
+		Set("remind_at", at).
+		Set("claimed_until", nil).
+		Where(sq.Eq{"id": id}).
+		RunWith(d).Exec()
+	return err
+}
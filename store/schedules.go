@@ -0,0 +1,146 @@
+package store
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/pkg/errors"
+	"github.com/tmitchel/sidebar"
+)
+
+// most scheduled messages claimed by one call to ClaimDueScheduledMessages
+const scheduleBatch = 100
+
+// Schedules provides methods for storing scheduled messages and making
+// sure each one is only sent by one server.
+type Schedules interface {
+	CreateScheduledMessage(*sidebar.ScheduledMessage) error
+	GetScheduledMessage(string) (*sidebar.ScheduledMessage, error)
+	GetScheduledMessages(string, string) ([]*sidebar.ScheduledMessage, error)
+	UpdateScheduledMessage(*sidebar.ScheduledMessage) error
+	DeleteScheduledMessage(string) error
+	ClaimDueScheduledMessages(time.Time, time.Duration) ([]*sidebar.ScheduledMessage, error)
+	FinishScheduledMessage(string, *time.Time) error
+}
+
+var scheduledColumns = []string{
+	"id", "channel_id", "workspace_id", "user_id", "content", "cron", "timezone", "send_at", "created_at",
+}
+
+func scanScheduledMessage(row sq.RowScanner) (*sidebar.ScheduledMessage, error) {
+	var m sidebar.ScheduledMessage
+	err := row.Scan(&m.ID, &m.Channel, &m.WorkspaceID, &m.UserID, &m.Content, &m.Cron, &m.Timezone, &m.SendAt, &m.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+func scanScheduledMessages(rows *sql.Rows) ([]*sidebar.ScheduledMessage, error) {
+	defer rows.Close()
+
+	messages := []*sidebar.ScheduledMessage{}
+	for rows.Next() {
+		m, err := scanScheduledMessage(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error scanning scheduled messages")
+		}
+		messages = append(messages, m)
+	}
+
+	return messages, nil
+}
+
+// CreateScheduledMessage stores a new scheduled message.
+func (d *database) CreateScheduledMessage(m *sidebar.ScheduledMessage) error {
+	_, err := psql.Insert("scheduled_messages").
+		Columns(scheduledColumns...).
+		Values(m.ID, m.Channel, m.WorkspaceID, m.UserID, m.Content, m.Cron, m.Timezone, m.SendAt, m.CreatedAt).
+		RunWith(d).Exec()
+	return err
+}
+
+// GetScheduledMessage returns the scheduled message with the given id.
+func (d *database) GetScheduledMessage(id string) (*sidebar.ScheduledMessage, error) {
+	return scanScheduledMessage(psql.Select(scheduledColumns...).From("scheduled_messages").
+		Where(sq.Eq{"id": id}).RunWith(d).QueryRow())
+}
+
+// GetScheduledMessages returns the user's pending scheduled messages in
+// the workspace, soonest first.
+func (d *database) GetScheduledMessages(uid, wid string) ([]*sidebar.ScheduledMessage, error) {
+	rows, err := psql.Select(scheduledColumns...).From("scheduled_messages").
+		Where(sq.Eq{"user_id": uid, "workspace_id": wid}).
+		OrderBy("send_at").
+		RunWith(d).Query()
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to find scheduled messages")
+	}
+
+	return scanScheduledMessages(rows)
+}
+
+// UpdateScheduledMessage replaces the content and schedule of a message.
+// Messages that are claimed by a server can't be changed until they're
+// finished.
+func (d *database) UpdateScheduledMessage(m *sidebar.ScheduledMessage) error {
+	res, err := psql.Update("scheduled_messages").
+		Set("content", m.Content).
+		Set("cron", m.Cron).
+		Set("timezone", m.Timezone).
+		Set("send_at", m.SendAt).
+		Where(sq.Eq{"id": m.ID}).
+		Where("(claimed_until IS NULL OR claimed_until < now())").
+		RunWith(d).Exec()
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return errors.Errorf("Scheduled message %v is being sent", m.ID)
+	}
+
+	return nil
+}
+
+// DeleteScheduledMessage removes the scheduled message.
+func (d *database) DeleteScheduledMessage(id string) error {
+	_, err := psql.Delete("scheduled_messages").Where(sq.Eq{"id": id}).RunWith(d).Exec()
+	return err
+}
+
+// ClaimDueScheduledMessages claims messages that are due by the given time
+// for the length of the lease. Rows being claimed by another server are
+// skipped, so each message is only returned to one server. Messages that
+// aren't finished before the lease ends can be claimed again.
+func (d *database) ClaimDueScheduledMessages(now time.Time, lease time.Duration) ([]*sidebar.ScheduledMessage, error) {
+	rows, err := psql.Update("scheduled_messages").
+		Set("claimed_until", now.Add(lease)).
+		Where("id IN (SELECT id FROM scheduled_messages WHERE send_at <= ? AND (claimed_until IS NULL OR claimed_until < ?) "+
+			"ORDER BY send_at LIMIT ? FOR UPDATE SKIP LOCKED)", now, now, scheduleBatch).
+		Suffix("RETURNING " + strings.Join(scheduledColumns, ", ")).
+		RunWith(d).Query()
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to claim scheduled messages")
+	}
+
+	return scanScheduledMessages(rows)
+}
+
+// FinishScheduledMessage releases a claimed message. Recurring messages
+// are moved to their next time and one-off messages are removed.
+func (d *database) FinishScheduledMessage(id string, next *time.Time) error {
+	if next == nil {
+		return d.DeleteScheduledMessage(id)
+	}
+
+	_, err := psql.Update("scheduled_messages").
+		Set("send_at", *next).
+		Set("claimed_until", nil).
+		Where(sq.Eq{"id": id}).
+		RunWith(d).Exec()
+	return err
+}
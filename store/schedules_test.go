@@ -0,0 +1,127 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tmitchel/sidebar"
+)
+
+func TestClaimDueScheduledMessages(t *testing.T) {
+	d := testDB(t)
+	defer d.Close()
+
+	if _, err := d.CreateWorkspace(&sidebar.Workspace{ID: "w1", DisplayName: "w1", Token: "t1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.CreateUser(&sidebar.User{ID: "u1", DisplayName: "u1", Email: "u1@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.CreateChannel(&sidebar.Channel{ID: "c1", Name: "c1", Slug: "c1"}, "w1"); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	const total = 50
+	for i := 0; i < total; i++ {
+		err := d.CreateScheduledMessage(&sidebar.ScheduledMessage{
+			ID:          fmt.Sprintf("m%v", i),
+			Channel:     "c1",
+			WorkspaceID: "w1",
+			UserID:      "u1",
+			Content:     "hello",
+			SendAt:      now.Add(-time.Minute),
+			CreatedAt:   now,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// each instance has its own connection pool, like separate servers
+	instances := make([]*database, 4)
+	for i := range instances {
+		db, err := sql.Open("postgres", os.Getenv("SIDEBAR_TEST_DB"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		instances[i] = &database{db}
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	seen := make(map[string]int)
+	for _, inst := range instances {
+		wg.Add(1)
+		go func(inst *database) {
+			defer wg.Done()
+			for {
+				claimed, err := inst.ClaimDueScheduledMessages(now, time.Minute)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if len(claimed) == 0 {
+					return
+				}
+
+				mu.Lock()
+				for _, m := range claimed {
+					seen[m.ID]++
+				}
+				mu.Unlock()
+			}
+		}(inst)
+	}
+	wg.Wait()
+
+	if len(seen) != total {
+		t.Fatalf("claimed %v messages, want %v", len(seen), total)
+	}
+	for id, n := range seen {
+		if n != 1 {
+			t.Errorf("message %v claimed %v times", id, n)
+		}
+	}
+
+	// nothing is handed out again while the lease holds
+	claimed, err := d.ClaimDueScheduledMessages(now.Add(30*time.Second), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 0 {
+		t.Fatalf("claimed %v messages during the lease", len(claimed))
+	}
+
+	// a finished message is gone, and the rest come back once the lease ends
+	if err := d.FinishScheduledMessage("m0", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	later := now.Add(2 * time.Minute)
+	var reclaimed int
+	for {
+		claimed, err := d.ClaimDueScheduledMessages(later, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(claimed) == 0 {
+			break
+		}
+		for _, m := range claimed {
+			if m.ID == "m0" {
+				t.Error("finished message claimed again")
+			}
+		}
+		reclaimed += len(claimed)
+	}
+
+	if reclaimed != total-1 {
+		t.Errorf("reclaimed %v messages after the lease, want %v", reclaimed, total-1)
+	}
+}
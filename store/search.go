@@ -0,0 +1,79 @@
+package store
+
+import (
+	sq "github.com/Masterminds/squirrel"
+	"github.com/pkg/errors"
+	"github.com/tmitchel/sidebar"
+)
+
+// markers wrapped around matching words by ts_headline. Control
+// characters won't show up in messages, so they can be swapped for
+// HTML tags after the snippet is escaped.
+const (
+	SearchMarkStart = "\x02"
+	SearchMarkEnd   = "\x03"
+)
+
+// Searcher provides full-text search over messages. Other backends need
+// to match words the same way Postgres' english text search does, ignoring
+// stop words and matching word stems.
+type Searcher interface {
+	SearchMessages(*sidebar.SearchQuery) ([]*sidebar.SearchResult, error)
+}
+
+// SearchMessages returns up to the limit of messages matching the query in
+// channels the user belongs to, best match first. Query text uses web search
+// syntax, e.g. quoted phrases, "or", and -excluded words.
+func (d *database) SearchMessages(q *sidebar.SearchQuery) ([]*sidebar.SearchResult, error) {
+	query := selectMessages(
+		"ts_rank(to_tsvector('english', ms.content), query) AS rank",
+		"ts_headline('english', ms.content, query, 'StartSel=\""+SearchMarkStart+"\", StopSel=\""+SearchMarkEnd+"\", MaxFragments=2, MinWords=5, MaxWords=20')",
+	).
+		JoinClause("CROSS JOIN websearch_to_tsquery('english', ?) query", q.Text).
+		Join("users_channels uc ON ( uc.channel_id = cm.channel_id )").
+		Join("workspaces_channels wc ON ( wc.channel_id = cm.channel_id )").
+		Join("channels ch ON ( ch.id = cm.channel_id )").
+		Where("to_tsvector('english', ms.content) @@ query").
+		Where(sq.Eq{"uc.user_id": q.UserID, "wc.workspace_id": q.WorkspaceID, "ms.deleted_at": nil})
+
+	if q.Channel != "" {
+		query = query.Where(sq.Eq{"cm.channel_id": q.Channel})
+	}
+	if q.Author != "" {
+		query = query.Where(sq.Eq{"um.user_from_id": q.Author})
+	}
+	if !q.Since.IsZero() {
+		query = query.Where(sq.GtOrEq{"ms.created_at": q.Since})
+	}
+	if !q.Until.IsZero() {
+		query = query.Where(sq.Lt{"ms.created_at": q.Until})
+	}
+	if q.SidebarsOnly {
+		query = query.Where(sq.Eq{"ch.is_sidebar": true})
+	}
+	if q.Resolved != nil {
+		query = query.Where(sq.Eq{"ch.is_sidebar": true, "ch.resolved": *q.Resolved})
+	}
+
+	rows, err := query.OrderBy("rank DESC", "ms.created_at DESC", "ms.id").
+		Limit(uint64(q.Limit)).Offset(uint64(q.Offset)).
+		RunWith(d).Query()
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to search messages")
+	}
+	defer rows.Close()
+
+	var results []*sidebar.SearchResult
+	for rows.Next() {
+		var r sidebar.SearchResult
+		m, err := scanMessage(rows, &r.Rank, &r.Snippet)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error scanning search results")
+		}
+
+		r.Message = m
+		results = append(results, &r)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,43 @@
+package store
+
+import (
+	"database/sql"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/tmitchel/sidebar"
+)
+
+// Settings provides methods for reading and changing the options
+// admins can set for their workspace.
+type Settings interface {
+	GetWorkspaceSettings(string) (*sidebar.WorkspaceSettings, error)
+	UpdateWorkspaceSettings(*sidebar.WorkspaceSettings) error
+}
+
+// GetWorkspaceSettings returns the settings for the workspace, or the
+// defaults if they've never been changed.
+func (d *database) GetWorkspaceSettings(wid string) (*sidebar.WorkspaceSettings, error) {
+	s := sidebar.WorkspaceSettings{
+		WorkspaceID:  wid,
+		EditWindow:   sidebar.DefaultEditWindow,
+		TopicChanges: sidebar.TopicsByMembers,
+	}
+
+	err := psql.Select("edit_window", "topic_changes").From("workspace_settings").
+		Where(sq.Eq{"workspace_id": wid}).RunWith(d).QueryRow().
+		Scan(&s.EditWindow, &s.TopicChanges)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// UpdateWorkspaceSettings replaces the settings for the workspace.
+func (d *database) UpdateWorkspaceSettings(s *sidebar.WorkspaceSettings) error {
+	_, err := psql.Insert("workspace_settings").
+		Columns("workspace_id", "edit_window", "topic_changes").Values(s.WorkspaceID, s.EditWindow, s.TopicChanges).
+		Suffix("ON CONFLICT (workspace_id) DO UPDATE SET edit_window = EXCLUDED.edit_window, topic_changes = EXCLUDED.topic_changes").
+		RunWith(d).Exec()
+	return err
+}
@@ -0,0 +1,155 @@
+package store
+
+import (
+	sq "github.com/Masterminds/squirrel"
+	"github.com/tmitchel/sidebar"
+)
+
+// SingleSignOner provides methods for storing OpenID Connect settings,
+// in-flight logins, and the identities linked to each user.
+type SingleSignOner interface {
+	GetSSOConfig(string) (*sidebar.SSOConfig, error)
+	UpdateSSOConfig(*sidebar.SSOConfig) error
+	GetWorkspaceForDomain(string) (string, error)
+
+	CreateSSOState(*sidebar.SSOState) error
+	TakeSSOState(string) (*sidebar.SSOState, error)
+
+	GetUserForIdentity(string, string) (string, error)
+	CreateIdentity(string, string, string) error
+}
+
+// GetSSOConfig returns the OpenID Connect settings for the given workspace
+// along with all email domains that map to it.
+func (d *database) GetSSOConfig(wid string) (*sidebar.SSOConfig, error) {
+	c := sidebar.SSOConfig{WorkspaceID: wid}
+	err := psql.Select("issuer", "client_id", "client_secret", "redirect_url").
+		From("workspace_sso").Where(sq.Eq{"workspace_id": wid}).RunWith(d).QueryRow().
+		Scan(&c.Issuer, &c.ClientID, &c.ClientSecret, &c.RedirectURL)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := psql.Select("domain").From("workspace_sso_domains").
+		Where(sq.Eq{"workspace_id": wid}).RunWith(d).Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var domain string
+		if err := rows.Scan(&domain); err != nil {
+			return nil, err
+		}
+		c.Domains = append(c.Domains, domain)
+	}
+
+	return &c, nil
+}
+
+// UpdateSSOConfig replaces the OpenID Connect settings and the email
+// domains for the workspace.
+func (d *database) UpdateSSOConfig(c *sidebar.SSOConfig) error {
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = psql.Insert("workspace_sso").
+		Columns("workspace_id", "issuer", "client_id", "client_secret", "redirect_url").
+		Values(c.WorkspaceID, c.Issuer, c.ClientID, c.ClientSecret, c.RedirectURL).
+		Suffix("ON CONFLICT (workspace_id) DO UPDATE SET issuer = EXCLUDED.issuer, client_id = EXCLUDED.client_id, " +
+			"client_secret = EXCLUDED.client_secret, redirect_url = EXCLUDED.redirect_url").
+		RunWith(tx).Exec()
+	if err != nil {
+		return err
+	}
+
+	_, err = psql.Delete("workspace_sso_domains").
+		Where(sq.Eq{"workspace_id": c.WorkspaceID}).
+		RunWith(tx).Exec()
+	if err != nil {
+		return err
+	}
+
+	for _, domain := range c.Domains {
+		_, err := psql.Insert("workspace_sso_domains").
+			Columns("workspace_id", "domain").Values(c.WorkspaceID, domain).
+			RunWith(tx).Exec()
+		if err != nil {
+			return conflict(err, "email domain "+domain)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetWorkspaceForDomain returns the id of the workspace that users with
+// an email address in the given domain belong to.
+func (d *database) GetWorkspaceForDomain(domain string) (string, error) {
+	var wid string
+	err := psql.Select("workspace_id").From("workspace_sso_domains").
+		Where(sq.Eq{"domain": domain}).RunWith(d).QueryRow().
+		Scan(&wid)
+	if err != nil {
+		return "", err
+	}
+	return wid, nil
+}
+
+// CreateSSOState saves the state, PKCE verifier, and nonce for a login
+// that has been sent to the identity provider.
+func (d *database) CreateSSOState(st *sidebar.SSOState) error {
+	var uid interface{}
+	if st.UserID != "" {
+		uid = st.UserID
+	}
+
+	_, err := psql.Insert("sso_states").
+		Columns("state", "workspace_id", "user_id", "verifier", "nonce").
+		Values(st.State, st.WorkspaceID, uid, st.Verifier, st.Nonce).
+		RunWith(d).Exec()
+	return err
+}
+
+// TakeSSOState removes the login with the given state and returns it.
+// Each state can only be taken once.
+func (d *database) TakeSSOState(state string) (*sidebar.SSOState, error) {
+	query, args, err := psql.Delete("sso_states").
+		Where(sq.Eq{"state": state}).
+		Suffix("RETURNING workspace_id, COALESCE(user_id, ''), verifier, nonce, created_at").
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	st := sidebar.SSOState{State: state}
+	err = d.QueryRow(query, args...).Scan(&st.WorkspaceID, &st.UserID, &st.Verifier, &st.Nonce, &st.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// GetUserForIdentity returns the id of the user linked to the subject
+// at the given issuer.
+func (d *database) GetUserForIdentity(issuer, subject string) (string, error) {
+	var uid string
+	err := psql.Select("user_id").From("user_identities").
+		Where(sq.Eq{"issuer": issuer, "subject": subject}).RunWith(d).QueryRow().
+		Scan(&uid)
+	if err != nil {
+		return "", err
+	}
+	return uid, nil
+}
+
+// CreateIdentity links the subject at the given issuer to a user.
+func (d *database) CreateIdentity(issuer, subject, uid string) error {
+	_, err := psql.Insert("user_identities").
+		Columns("issuer", "subject", "user_id").Values(issuer, subject, uid).
+		RunWith(d).Exec()
+	return err
+}
@@ -0,0 +1,142 @@
+package store
+
+import (
+	"strings"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/tmitchel/sidebar"
+)
+
+// TokenManager provides methods for storing API tokens and the bots
+// that use them.
+type TokenManager interface {
+	CreateToken(*sidebar.APIToken, string) error
+	GetToken(string) (*sidebar.APIToken, error)
+	GetTokenByHash(string) (*sidebar.APIToken, error)
+	GetTokensForUser(string, string) ([]*sidebar.APIToken, error)
+	RevokeToken(string) error
+
+	CreateBot(string, string, string) error
+	GetBotOwner(string) (string, string, error)
+	GetBots(string) ([]*sidebar.User, error)
+}
+
+// CreateToken saves the token's information along with the hash of
+// the raw token. The raw token itself is never stored.
+func (d *database) CreateToken(t *sidebar.APIToken, hash string) error {
+	_, err := psql.Insert("api_tokens").
+		Columns("id", "user_id", "workspace_id", "display_name", "token_hash", "scopes", "created_at").
+		Values(t.ID, t.UserID, t.WorkspaceID, t.Name, hash, strings.Join(t.Scopes, ","), t.CreatedAt).
+		RunWith(d).Exec()
+	return err
+}
+
+// GetToken returns the unrevoked token with the given id.
+func (d *database) GetToken(id string) (*sidebar.APIToken, error) {
+	return d.getToken(sq.Eq{"id": id, "revoked": false})
+}
+
+// GetTokenByHash returns the unrevoked token with the given hash.
+func (d *database) GetTokenByHash(hash string) (*sidebar.APIToken, error) {
+	return d.getToken(sq.Eq{"token_hash": hash, "revoked": false})
+}
+
+func (d *database) getToken(where sq.Eq) (*sidebar.APIToken, error) {
+	var t sidebar.APIToken
+	var scopes string
+	err := psql.Select("id", "user_id", "workspace_id", "display_name", "scopes", "created_at").
+		From("api_tokens").Where(where).RunWith(d).QueryRow().
+		Scan(&t.ID, &t.UserID, &t.WorkspaceID, &t.Name, &scopes, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	t.Scopes = splitScopes(scopes)
+	return &t, nil
+}
+
+// GetTokensForUser returns all unrevoked tokens belonging to the user in
+// the given workspace.
+func (d *database) GetTokensForUser(uid, wid string) ([]*sidebar.APIToken, error) {
+	rows, err := psql.Select("id", "user_id", "workspace_id", "display_name", "scopes", "created_at").
+		From("api_tokens").Where(sq.Eq{"user_id": uid, "workspace_id": wid, "revoked": false}).
+		OrderBy("created_at").RunWith(d).Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*sidebar.APIToken
+	for rows.Next() {
+		var t sidebar.APIToken
+		var scopes string
+		if err := rows.Scan(&t.ID, &t.UserID, &t.WorkspaceID, &t.Name, &scopes, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+
+		t.Scopes = splitScopes(scopes)
+		tokens = append(tokens, &t)
+	}
+
+	return tokens, nil
+}
+
+// RevokeToken marks the token as revoked so it can't be used again.
+func (d *database) RevokeToken(id string) error {
+	_, err := psql.Update("api_tokens").
+		Set("revoked", true).
+		Where(sq.Eq{"id": id}).
+		RunWith(d).Exec()
+	return err
+}
+
+// CreateBot records that the bot user belongs to the workspace and is
+// managed by the owner.
+func (d *database) CreateBot(uid, wid, owner string) error {
+	_, err := psql.Insert("bots").
+		Columns("user_id", "workspace_id", "owner_id").Values(uid, wid, owner).
+		RunWith(d).Exec()
+	return err
+}
+
+// GetBotOwner returns the owner and workspace of the given bot.
+func (d *database) GetBotOwner(uid string) (string, string, error) {
+	var owner, wid string
+	err := psql.Select("owner_id", "workspace_id").From("bots").
+		Where(sq.Eq{"user_id": uid}).RunWith(d).QueryRow().
+		Scan(&owner, &wid)
+	if err != nil {
+		return "", "", err
+	}
+	return owner, wid, nil
+}
+
+// GetBots returns all bot users belonging to the workspace.
+func (d *database) GetBots(wid string) ([]*sidebar.User, error) {
+	rows, err := psql.Select("u.id", "COALESCE(wu.display_name, u.display_name)", "u.email", "u.profile_image", "u.is_bot").
+		From("users u").Join("bots b ON ( b.user_id = u.id )").
+		LeftJoin("workspaces_users wu ON ( wu.user_id = u.id AND wu.workspace_id = b.workspace_id )").
+		Where(sq.Eq{"b.workspace_id": wid}).RunWith(d).Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*sidebar.User
+	for rows.Next() {
+		var u sidebar.User
+		if err := rows.Scan(&u.ID, &u.DisplayName, &u.Email, &u.ProfileImg, &u.IsBot); err != nil {
+			return nil, err
+		}
+		users = append(users, &u)
+	}
+
+	return users, nil
+}
+
+func splitScopes(scopes string) []string {
+	if scopes == "" {
+		return nil
+	}
+	return strings.Split(scopes, ",")
+}
@@ -0,0 +1,77 @@
+package store
+
+import (
+	sq "github.com/Masterminds/squirrel"
+	"github.com/pkg/errors"
+	"github.com/tmitchel/sidebar"
+)
+
+// Topics provides methods for changing a channel's topic and purpose
+// while keeping a history of the changes.
+type Topics interface {
+	ChangeChannelField(*sidebar.ChannelChange) error
+	GetChannelChanges(string) ([]*sidebar.ChannelChange, error)
+}
+
+// ChangeChannelField sets the channel's topic or purpose and records the
+// change.
+func (d *database) ChangeChannelField(c *sidebar.ChannelChange) error {
+	var column string
+	switch c.Field {
+	case sidebar.FieldTopic:
+		column = "topic"
+	case sidebar.FieldPurpose:
+		column = "purpose"
+	default:
+		return errors.Errorf("Unknown channel field %v", c.Field)
+	}
+
+	tx, err := d.Begin()
+	if err != nil {
+		return errors.Wrap(err, "Error starting transaction")
+	}
+	defer tx.Rollback()
+
+	_, err = psql.Update("channels").
+		Set(column, c.Value).
+		Where(sq.Eq{"id": c.Channel}).
+		RunWith(tx).Exec()
+	if err != nil {
+		return err
+	}
+
+	_, err = psql.Insert("channel_changes").
+		Columns("id", "channel_id", "field", "value", "changed_by", "changed_at").
+		Values(c.ID, c.Channel, c.Field, c.Value, c.ChangedBy, c.ChangedAt).
+		RunWith(tx).Exec()
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetChannelChanges returns every change to the channel's topic and
+// purpose, oldest first. Changes made by deleted users have no ChangedBy.
+func (d *database) GetChannelChanges(cid string) ([]*sidebar.ChannelChange, error) {
+	rows, err := psql.Select("id", "channel_id", "field", "value", "COALESCE(changed_by, '')", "changed_at").
+		From("channel_changes").
+		Where(sq.Eq{"channel_id": cid}).
+		OrderBy("changed_at", "id").
+		RunWith(d).Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []*sidebar.ChannelChange
+	for rows.Next() {
+		var c sidebar.ChannelChange
+		if err := rows.Scan(&c.ID, &c.Channel, &c.Field, &c.Value, &c.ChangedBy, &c.ChangedAt); err != nil {
+			return nil, err
+		}
+		changes = append(changes, &c)
+	}
+
+	return changes, rows.Err()
+}
@@ -1,7 +1,10 @@
 package store
 
 import (
+	"time"
+
 	sq "github.com/Masterminds/squirrel"
+	"github.com/pkg/errors"
 	"github.com/tmitchel/sidebar"
 )
 
@@ -11,6 +14,7 @@ type Updater interface {
 	UpdateUserInformation(*sidebar.User) error
 	UpdateChannelInformation(*sidebar.Channel) error
 	UpdateUserPassword(string, []byte) error
+	UpdateMessage(*sidebar.ChatMessage) error
 }
 
 // UpdateWorkspaceImage updates the image associated with the given
@@ -24,16 +28,16 @@ func (d *database) UpdateWorkspaceImage(id, img string) error {
 }
 
 // UpdateUserInformation updates all information for the user EXCEPT
-// the password. Pass the current value if you don't want to update a
+// the password and display name, which is part of the user's profile in
+// each workspace. Pass the current value if you don't want to update a
 // field.
 func (d *database) UpdateUserInformation(u *sidebar.User) error {
 	_, err := psql.Update("users").
-		Set("display_name", u.DisplayName).
 		Set("email", u.Email).
 		Set("profile_image", u.ProfileImg).
 		Where(sq.Eq{"id": u.ID}).
 		RunWith(d).Exec()
-	return err
+	return conflict(err, "Email "+u.Email)
 }
 
 // UpdateUserPassword sets the password for the given user to a new value.
@@ -50,9 +54,48 @@ func (d *database) UpdateUserPassword(id string, password []byte) error {
 func (d *database) UpdateChannelInformation(c *sidebar.Channel) error {
 	_, err := psql.Update("channels").
 		Set("display_name", c.Name).
+		Set("slug", c.Slug).
 		Set("details", c.Details).
 		Set("display_image", c.Image).
 		Where(sq.Eq{"id": c.ID}).
 		RunWith(d).Exec()
-	return err
+	return conflict(err, "Channel "+c.Slug)
+}
+
+// UpdateMessage saves the message's current content as a revision then
+// replaces it with the new content and edit time.
+func (d *database) UpdateMessage(m *sidebar.ChatMessage) error {
+	tx, err := d.Begin()
+	if err != nil {
+		return errors.Wrap(err, "Error starting transaction")
+	}
+	defer tx.Rollback()
+
+	var content string
+	var created time.Time
+	err = psql.Select("content", "COALESCE(edited_at, created_at)").From("messages").
+		Where(sq.Eq{"id": m.ID}).Suffix("FOR UPDATE").
+		RunWith(tx).QueryRow().Scan(&content, &created)
+	if err != nil {
+		return err
+	}
+
+	_, err = psql.Insert("message_revisions").
+		Columns("message_id", "content", "created_at").Values(m.ID, content, created).
+		RunWith(tx).Exec()
+	if err != nil {
+		return err
+	}
+
+	_, err = psql.Update("messages").
+		Set("content", m.Content).
+		Set("html", m.HTML).
+		Set("edited_at", m.EditedAt).
+		Where(sq.Eq{"id": m.ID}).
+		RunWith(tx).Exec()
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
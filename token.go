@@ -0,0 +1,37 @@
+package sidebar
+
+import "time"
+
+// TokenPrefix starts every API token so they can be told apart
+// from access JWTs.
+const TokenPrefix = "sbt_"
+
+// scopes that can be granted to an API token
+const (
+	ScopeReadMessages   = "messages:read"
+	ScopePostMessages   = "messages:write"
+	ScopeManageChannels = "channels:manage"
+)
+
+// APIToken is a long-lived credential for scripts, integrations,
+// and bots. The raw Token is only available when the token is
+// first created.
+type APIToken struct {
+	ID          string    `json:"id"`
+	UserID      string    `json:"user_id"`
+	WorkspaceID string    `json:"workspace_id"`
+	Name        string    `json:"name"`
+	Scopes      []string  `json:"scopes"`
+	Token       string    `json:"token,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// HasScope checks if the token has been granted the scope.
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
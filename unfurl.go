@@ -0,0 +1,58 @@
+package sidebar
+
+import (
+	"strings"
+	"time"
+)
+
+// LinkPreview is the title, description, and image a page gives for
+// itself through OpenGraph tags or oEmbed. Previews are cached by URL
+// and shared by every message that links to the page.
+type LinkPreview struct {
+	URL         string    `json:"url"`
+	Title       string    `json:"title,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Image       string    `json:"image,omitempty"`
+	SiteName    string    `json:"site_name,omitempty"`
+	FetchedAt   time.Time `json:"fetched_at"`
+}
+
+// Empty checks if the page didn't give anything worth showing.
+func (p *LinkPreview) Empty() bool {
+	return p.Title == "" && p.Description == ""
+}
+
+// UnfurlSettings are the domains a workspace does or doesn't want
+// previews for. Domains include their subdomains. If Allow has any
+// domains, only links to those are previewed, and Deny always wins.
+type UnfurlSettings struct {
+	WorkspaceID string   `json:"workspace_id"`
+	Allow       []string `json:"allow"`
+	Deny        []string `json:"deny"`
+}
+
+// Allowed checks if links to the host can be previewed.
+func (s *UnfurlSettings) Allowed(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, d := range s.Deny {
+		if inDomain(host, d) {
+			return false
+		}
+	}
+
+	if len(s.Allow) == 0 {
+		return true
+	}
+
+	for _, d := range s.Allow {
+		if inDomain(host, d) {
+			return true
+		}
+	}
+	return false
+}
+
+func inDomain(host, domain string) bool {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
@@ -1,11 +1,67 @@
 package sidebar
 
+import "time"
+
+// MaxProfileFields is the most custom profile fields a workspace can have.
+const MaxProfileFields = 20
+
+// MaxProfileLength is the most characters a profile field can have.
+const MaxProfileLength = 100
+
 // User represents a basic user of sidebar. They can be members
-// of multiple channels, sidebars, etc.
+// of multiple channels, sidebars, etc. The display name and profile
+// are the user's profile in the current workspace. Fields holds the
+// workspace's custom profile fields by id.
 type User struct {
 	ID          string `json:"id"`
 	DisplayName string `json:"display_name"`
 	Email       string `json:"email"`
 	Password    []byte `json:"-"`
 	ProfileImg  string `json:"profile_image"`
+	IsBot       bool   `json:"is_bot"`
+
+	Title    string            `json:"title"`
+	Pronouns string            `json:"pronouns"`
+	TimeZone string            `json:"time_zone"`
+	Status   *Status           `json:"status,omitempty"`
+	Fields   map[string]string `json:"fields,omitempty"`
+}
+
+// Status is an emoji and short note shown next to a user's name. It's
+// cleared once ExpiresAt passes, if it's set.
+type Status struct {
+	Emoji     string     `json:"emoji"`
+	Text      string     `json:"text"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// StatusUpdate is sent over the Websocket connection when a user's
+// status changes. A nil Status means it was cleared.
+type StatusUpdate struct {
+	UserID      string  `json:"user_id"`
+	WorkspaceID string  `json:"workspace_id"`
+	Status      *Status `json:"status"`
+}
+
+// UserUpdate changes some of a user's account and their profile in a
+// workspace. Fields left nil aren't changed. A Status without an emoji
+// or text clears the status, and an empty value removes a custom field.
+type UserUpdate struct {
+	ID          string            `json:"id"`
+	DisplayName *string           `json:"display_name"`
+	Email       *string           `json:"email"`
+	ProfileImg  *string           `json:"profile_image"`
+	Title       *string           `json:"title"`
+	Pronouns    *string           `json:"pronouns"`
+	TimeZone    *string           `json:"time_zone"`
+	Status      *Status           `json:"status"`
+	Fields      map[string]string `json:"fields"`
+}
+
+// ProfileField is a custom profile field defined by a workspace's admins.
+type ProfileField struct {
+	ID          string    `json:"id"`
+	WorkspaceID string    `json:"workspace_id"`
+	Name        string    `json:"name"`
+	CreatedAt   time.Time `json:"created_at"`
 }
@@ -8,3 +8,30 @@ type Workspace struct {
 	DisplayName string `json:"display_name"`
 	DisplayImg  string `json:"display_image"`
 }
+
+// roles a user can have within a workspace
+const (
+	RoleMember = 1
+	RoleAdmin  = 2
+)
+
+// DefaultEditWindow is how long, in seconds, authors can edit their
+// messages in workspaces that haven't changed the setting.
+const DefaultEditWindow = 15 * 60
+
+// who can change channel topics and purposes
+const (
+	TopicsByMembers = "members"
+	TopicsByAdmins  = "admins"
+	TopicsByAnyone  = "anyone"
+)
+
+// WorkspaceSettings holds the options admins can change for their
+// workspace. An EditWindow of 0 lets messages be edited at any time.
+// TopicChanges is one of the TopicsBy options and defaults to members
+// of the channel.
+type WorkspaceSettings struct {
+	WorkspaceID  string `json:"workspace_id"`
+	EditWindow   int    `json:"edit_window"`
+	TopicChanges string `json:"topic_changes"`
+}